@@ -181,6 +181,7 @@ func scanVulnerabilities(
 		}
 	}
 	// check for FreeScout integrations
+	var freeScoutIntg *fleet.FreeScoutIntegration
 	for _, f := range appConfig.Integrations.Freescout {
 		if f.EnableSoftwareVulnerabilities {
 			if vulnAutomationEnabled != "" {
@@ -188,6 +189,7 @@ func scanVulnerabilities(
 				errHandler(ctx, logger, "more than one automation enabled", err)
 			}
 			vulnAutomationEnabled = "freescout"
+			freeScoutIntg = f
 			break
 		}
 	}
@@ -281,6 +283,7 @@ func scanVulnerabilities(
 				ctx,
 				ds,
 				kitlog.With(logger, "freescout", "vulnerabilities"),
+				freeScoutIntg,
 				recentV,
 				matchingMeta,
 			); err != nil {
@@ -641,6 +644,16 @@ func triggerFailingPoliciesAutomation(
 		return fmt.Errorf("parsing appConfig.ServerSettings.ServerURL: %w", err)
 	}
 
+	// failing policies to report to FreeScout grouped per team, when batching
+	// is enabled, keyed by team ID (empty for global policies).
+	type freeScoutBatch struct {
+		teamID   *uint
+		policies []*fleet.Policy
+		hosts    map[uint][]fleet.PolicySetHost
+		minHosts int
+	}
+	freeScoutBatches := make(map[string]*freeScoutBatch)
+
 	err = policies.TriggerFailingPoliciesAutomation(ctx, ds, logger, failingPoliciesSet, func(policy *fleet.Policy, cfg policies.FailingPolicyAutomationConfig) error {
 		switch cfg.AutomationType {
 		case policies.FailingPolicyWebhook:
@@ -676,7 +689,33 @@ func triggerFailingPoliciesAutomation(
 			if err != nil {
 				return ctxerr.Wrapf(ctx, err, "listing hosts for failing policies set %d", policy.ID)
 			}
-			if err := worker.QueueFreeScoutFailingPolicyJob(ctx, ds, logger, policy, hosts); err != nil {
+			if cfg.DigestInterval > 0 {
+				// the digest groups the failing policies, so it takes precedence
+				// over batching.
+				if err := worker.QueueFreeScoutFailingPolicyDigestEvent(ctx, ds, logger, policy, hosts, cfg.MinFailingHosts, cfg.DigestInterval); err != nil {
+					return err
+				}
+				if err := failingPoliciesSet.RemoveHosts(policy.ID, hosts); err != nil {
+					return ctxerr.Wrapf(ctx, err, "removing %d hosts from failing policies set %d", len(hosts), policy.ID)
+				}
+				return nil
+			}
+			if cfg.BatchFailingPolicies {
+				// queued (and hosts removed from the set) once all policies are processed
+				var key string
+				if policy.TeamID != nil {
+					key = fmt.Sprint(*policy.TeamID)
+				}
+				batch := freeScoutBatches[key]
+				if batch == nil {
+					batch = &freeScoutBatch{teamID: policy.TeamID, hosts: make(map[uint][]fleet.PolicySetHost), minHosts: cfg.MinFailingHosts}
+					freeScoutBatches[key] = batch
+				}
+				batch.policies = append(batch.policies, policy)
+				batch.hosts[policy.ID] = hosts
+				return nil
+			}
+			if err := worker.QueueFreeScoutFailingPolicyJob(ctx, ds, logger, policy, hosts, cfg.MinFailingHosts, cfg.HostIDsOnly); err != nil {
 				return err
 			}
 			if err := failingPoliciesSet.RemoveHosts(policy.ID, hosts); err != nil {
@@ -689,6 +728,17 @@ func triggerFailingPoliciesAutomation(
 		return fmt.Errorf("triggering failing policies automation: %w", err)
 	}
 
+	for _, batch := range freeScoutBatches {
+		if err := worker.QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, batch.teamID, batch.policies, batch.hosts, batch.minHosts); err != nil {
+			return ctxerr.Wrapf(ctx, err, "queueing batched failing policies job for %d policies", len(batch.policies))
+		}
+		for _, policy := range batch.policies {
+			if err := failingPoliciesSet.RemoveHosts(policy.ID, batch.hosts[policy.ID]); err != nil {
+				return fmt.Errorf("removing %d hosts from failing policies set %d: %w", len(batch.hosts[policy.ID]), policy.ID, err)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -702,6 +752,7 @@ func newWorkerIntegrationsSchedule(
 	bootstrapPackageStore fleet.MDMBootstrapPackageStore,
 	vppInstaller fleet.AppleMDMVPPInstaller,
 	androidModule android.Service,
+	freeScoutConfig config.FreeScoutConfig,
 ) (*schedule.Schedule, error) {
 	const (
 		name = string(fleet.CronWorkerIntegrations)
@@ -732,10 +783,15 @@ func newWorkerIntegrationsSchedule(
 		Log:           logger,
 		NewClientFunc: newZendeskClient,
 	}
+	if err := worker.ValidateFreeScoutNoIntegrationBehavior(freeScoutConfig.NoIntegrationBehavior); err != nil {
+		return nil, err
+	}
 	freescout := &worker.FreeScout{
-		Datastore:     ds,
-		Log:           logger,
-		NewClientFunc: newFreeScoutClient,
+		Datastore:             ds,
+		Log:                   logger,
+		NewClientFunc:         newFreeScoutClient,
+		NoIntegrationBehavior: freeScoutConfig.NoIntegrationBehavior,
+		NoIntegrationRetries:  freeScoutConfig.NoIntegrationRetries,
 	}
 	var (
 		depSvc *apple_mdm.DEPService
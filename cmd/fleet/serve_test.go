@@ -438,6 +438,7 @@ func TestScanVulnerabilities(t *testing.T) {
   "epss_probability": 0.0089,
   "cvss_score": 5.4,
   "cisa_known_exploit": false,
+  "severity": "medium",
   "hosts_affected": [
     {
       "id": 1,
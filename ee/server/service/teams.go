@@ -270,6 +270,7 @@ func (svc *Service) ModifyTeam(ctx context.Context, teamID uint, payload fleet.T
 				return nil, fleet.NewInvalidArgumentError("integrations", err.Error())
 			}
 
+			fleet.SetTeamFreeScoutGenerations(team.Config.Integrations.Freescout, payload.Integrations.Freescout)
 			team.Config.Integrations.Jira = payload.Integrations.Jira
 			team.Config.Integrations.Zendesk = payload.Integrations.Zendesk
 			team.Config.Integrations.Freescout = payload.Integrations.Freescout
@@ -1978,6 +1979,7 @@ func (svc *Service) modifyDefaultTeamConfig(ctx context.Context, payload fleet.T
 		}
 
 		// Always update integrations when provided (even if empty arrays to clear them)
+		fleet.SetTeamFreeScoutGenerations(config.Integrations.Freescout, payload.Integrations.Freescout)
 		config.Integrations.Jira = payload.Integrations.Jira
 		config.Integrations.Zendesk = payload.Integrations.Zendesk
 		config.Integrations.Freescout = payload.Integrations.Freescout
@@ -656,6 +656,7 @@ type FleetConfig struct {
 	Prometheus                 PrometheusConfig
 	MDM                        MDMConfig
 	Calendar                   CalendarConfig
+	FreeScout                  FreeScoutConfig `yaml:"freescout"`
 	Partnerships               PartnershipsConfig
 	MicrosoftCompliancePartner MicrosoftCompliancePartnerConfig `yaml:"microsoft_compliance_partner"`
 
@@ -786,6 +787,15 @@ func (c AndroidAgentConfig) Validate(initFatal func(err error, msg string)) {
 	}
 }
 
+// FreeScoutConfig defines configs related to the FreeScout integration jobs.
+type FreeScoutConfig struct {
+	// NoIntegrationBehavior is what is done with a job whose integration is
+	// no longer enabled: "skip", "log" or "requeue", see
+	// worker.FreeScout.NoIntegrationBehavior.
+	NoIntegrationBehavior string `yaml:"no_integration_behavior"`
+	NoIntegrationRetries  int    `yaml:"no_integration_retries"`
+}
+
 type CalendarConfig struct {
 	Periodicity time.Duration
 	// Hide alwaysReloadEvent from YAML config
@@ -1548,6 +1558,12 @@ func (man Manager) addConfigs() {
 		"How much time to wait between processing calendar integration.",
 	)
 
+	// FreeScout integration
+	man.addConfigString("freescout.no_integration_behavior", "skip",
+		"What to do with a FreeScout job whose integration is no longer enabled: skip, log or requeue")
+	man.addConfigInt("freescout.no_integration_retries", 3,
+		"Number of times a FreeScout job whose integration is no longer enabled is re-queued with the requeue behavior")
+
 	// Partnerships
 	man.addConfigBool("partnerships.enable_secureframe", false, "Point transparency URL at Secureframe landing page")
 
@@ -1858,6 +1874,10 @@ func (man Manager) LoadConfig() FleetConfig {
 		Calendar: CalendarConfig{
 			Periodicity: man.getConfigDuration("calendar.periodicity"),
 		},
+		FreeScout: FreeScoutConfig{
+			NoIntegrationBehavior: man.getConfigString("freescout.no_integration_behavior"),
+			NoIntegrationRetries:  man.getConfigInt("freescout.no_integration_retries"),
+		},
 		Partnerships: PartnershipsConfig{
 			EnableSecureframe: man.getConfigBool("partnerships.enable_secureframe"),
 			EnablePrimo:       man.getConfigBool("partnerships.enable_primo"),
@@ -0,0 +1,22 @@
+// Package correlation provides a context key for storing a correlation ID
+// that ties together the log lines emitted while processing a single unit of
+// work (e.g. a worker job and all of its retries).
+package correlation
+
+import "context"
+
+type key int
+
+const correlationIDKey key = 0
+
+// NewContext returns a new context.Context with the provided correlation ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// FromContext returns the correlation ID from the context, if present. The
+// second return value indicates whether a non-empty ID was found.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey).(string)
+	return id, ok && id != ""
+}
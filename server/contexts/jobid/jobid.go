@@ -0,0 +1,22 @@
+// Package jobid provides a context key for storing the ID of the worker job
+// being processed, so that the code it calls (e.g. the clients of external
+// services) can report which job triggered it.
+package jobid
+
+import "context"
+
+type key int
+
+const jobIDKey key = 0
+
+// NewContext returns a new context.Context with the provided job ID.
+func NewContext(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, jobIDKey, id)
+}
+
+// FromContext returns the job ID from the context, if present. The second
+// return value indicates whether a non-zero ID was found.
+func FromContext(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(jobIDKey).(uint)
+	return id, ok && id != 0
+}
@@ -3211,6 +3211,69 @@ WHERE id IN (?)`
 	return hosts, nil
 }
 
+func (ds *Datastore) HostIssuesByHostIDs(ctx context.Context, hostIDs []uint) (map[uint]fleet.HostIssues, error) {
+	const batchSize = 10000
+
+	issues := make(map[uint]fleet.HostIssues)
+	for start := 0; start < len(hostIDs); start += batchSize {
+		end := min(start+batchSize, len(hostIDs))
+		stmt, args, err := sqlx.In(`
+SELECT
+	host_id,
+	failing_policies_count,
+	COALESCE(critical_vulnerabilities_count, 0) AS critical_vulnerabilities_count,
+	total_issues_count
+FROM host_issues
+WHERE host_id IN (?)`, hostIDs[start:end])
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "building query to select host issues")
+		}
+
+		var rows []struct {
+			HostID uint `db:"host_id"`
+			fleet.HostIssues
+		}
+		if err := sqlx.SelectContext(ctx, ds.reader(ctx), &rows, stmt, args...); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "select host issues")
+		}
+		for _, r := range rows {
+			issues[r.HostID] = r.HostIssues
+		}
+	}
+	return issues, nil
+}
+
+func (ds *Datastore) FilterHostIDsByTeamsOrLabels(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error) {
+	if len(hostIDs) == 0 || (len(teamIDs) == 0 && len(labelIDs) == 0) {
+		return nil, nil
+	}
+
+	var (
+		conds []string
+		args  = []interface{}{hostIDs}
+	)
+	if len(teamIDs) > 0 {
+		conds = append(conds, `COALESCE(h.team_id, 0) IN (?)`)
+		args = append(args, teamIDs)
+	}
+	if len(labelIDs) > 0 {
+		conds = append(conds, `EXISTS (SELECT 1 FROM label_membership lm WHERE lm.host_id = h.id AND lm.label_id IN (?))`)
+		args = append(args, labelIDs)
+	}
+
+	stmt := fmt.Sprintf(`SELECT h.id FROM hosts h WHERE h.id IN (?) AND (%s) ORDER BY h.id`, strings.Join(conds, " OR "))
+	stmt, args, err := sqlx.In(stmt, args...)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query to filter hosts by teams or labels")
+	}
+
+	var ids []uint
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &ids, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "filter hosts by teams or labels")
+	}
+	return ids, nil
+}
+
 func (ds *Datastore) HostByIdentifier(ctx context.Context, identifier string) (*fleet.Host, error) {
 	stmt := `
     SELECT
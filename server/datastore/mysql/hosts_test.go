@@ -177,6 +177,8 @@ func TestHosts(t *testing.T) {
 		{"ListHostsLiteByUUIDs", testHostsListHostsLiteByUUIDs},
 		{"GetMatchingHostSerials", testGetMatchingHostSerials},
 		{"ListHostsLiteByIDs", testHostsListHostsLiteByIDs},
+		{"FilterHostIDsByTeamsOrLabels", testHostsFilterHostIDsByTeamsOrLabels},
+		{"HostIssuesByHostIDs", testHostsHostIssuesByHostIDs},
 		{"ListHostsWithPagination", testListHostsWithPagination},
 		{"HostHealth", testHostHealth},
 		{"GetHostOrbitInfo", testGetHostOrbitInfo},
@@ -11189,6 +11191,85 @@ func testHostsListHostsLiteByIDs(t *testing.T, ds *Datastore) {
 	}
 }
 
+func testHostsFilterHostIDsByTeamsOrLabels(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	hosts := make([]*fleet.Host, 4)
+	for i := range hosts {
+		h, err := ds.NewHost(ctx, &fleet.Host{
+			DetailUpdatedAt: time.Now(),
+			LabelUpdatedAt:  time.Now(),
+			PolicyUpdatedAt: time.Now(),
+			SeenTime:        time.Now(),
+			OsqueryHostID:   ptr.String(fmt.Sprintf("filter-host%d", i)),
+			NodeKey:         ptr.String(fmt.Sprintf("filter-%d", i)),
+			UUID:            fmt.Sprintf("filter-%d", i),
+			Hostname:        fmt.Sprintf("filter.%d.local", i),
+		})
+		require.NoError(t, err)
+		hosts[i] = h
+	}
+	allIDs := []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID, hosts[3].ID}
+
+	team1, err := ds.NewTeam(ctx, &fleet.Team{Name: "filter team1"})
+	require.NoError(t, err)
+	team2, err := ds.NewTeam(ctx, &fleet.Team{Name: "filter team2"})
+	require.NoError(t, err)
+	require.NoError(t, ds.AddHostsToTeam(ctx, fleet.NewAddHostsToTeamParams(&team1.ID, []uint{hosts[0].ID})))
+	require.NoError(t, ds.AddHostsToTeam(ctx, fleet.NewAddHostsToTeamParams(&team2.ID, []uint{hosts[1].ID, hosts[2].ID})))
+
+	label, err := ds.NewLabel(ctx, &fleet.Label{Name: "filter label", Query: "select 1"})
+	require.NoError(t, err)
+	require.NoError(t, ds.RecordLabelQueryExecutions(ctx, hosts[2], map[uint]*bool{label.ID: ptr.Bool(true)}, time.Now(), false))
+	require.NoError(t, ds.RecordLabelQueryExecutions(ctx, hosts[3], map[uint]*bool{label.ID: ptr.Bool(true)}, time.Now(), false))
+
+	cases := []struct {
+		desc     string
+		hostIDs  []uint
+		teamIDs  []uint
+		labelIDs []uint
+		wantIDs  []uint
+	}{
+		{"no hosts", nil, []uint{team1.ID}, nil, nil},
+		{"no filters", allIDs, nil, nil, nil},
+		{"single team", allIDs, []uint{team1.ID}, nil, []uint{hosts[0].ID}},
+		{"multiple teams", allIDs, []uint{team1.ID, team2.ID}, nil, []uint{hosts[0].ID, hosts[1].ID, hosts[2].ID}},
+		{"no team", allIDs, []uint{0}, nil, []uint{hosts[3].ID}},
+		{"label", allIDs, nil, []uint{label.ID}, []uint{hosts[2].ID, hosts[3].ID}},
+		{"team or label", allIDs, []uint{team1.ID}, []uint{label.ID}, []uint{hosts[0].ID, hosts[2].ID, hosts[3].ID}},
+		{"subset of hosts", []uint{hosts[1].ID, hosts[3].ID}, []uint{team2.ID}, nil, []uint{hosts[1].ID}},
+		{"unknown team", allIDs, []uint{team2.ID + 1000}, nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			gotIDs, err := ds.FilterHostIDsByTeamsOrLabels(ctx, c.hostIDs, c.teamIDs, c.labelIDs)
+			require.NoError(t, err)
+			require.ElementsMatch(t, c.wantIDs, gotIDs)
+		})
+	}
+}
+
+func testHostsHostIssuesByHostIDs(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	issues, err := ds.HostIssuesByHostIDs(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO host_issues (host_id, failing_policies_count, critical_vulnerabilities_count, total_issues_count)
+			VALUES (1, 2, 3, 5), (2, 1, NULL, 1), (3, 4, 0, 4)`)
+		return err
+	})
+
+	issues, err = ds.HostIssuesByHostIDs(ctx, []uint{1, 2, 4})
+	require.NoError(t, err)
+	require.Equal(t, map[uint]fleet.HostIssues{
+		1: {FailingPoliciesCount: 2, CriticalVulnerabilitiesCount: ptr.T(uint64(3)), TotalIssuesCount: 5},
+		2: {FailingPoliciesCount: 1, CriticalVulnerabilitiesCount: ptr.T(uint64(0)), TotalIssuesCount: 1},
+	}, issues)
+}
+
 func testListHostsWithPagination(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 
@@ -2,7 +2,11 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
@@ -162,3 +166,250 @@ func (ds *Datastore) GetJob(ctx context.Context, jobID uint) (*fleet.Job, error)
 
 	return job, nil
 }
+
+func (ds *Datastore) InsertFreeScoutDigestEvents(ctx context.Context, teamID uint, args []json.RawMessage) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	var wasEmpty bool
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		// lock the team's pending events so that only one of concurrent
+		// inserts in an empty digest sees it empty.
+		var ids []uint
+		if err := sqlx.SelectContext(ctx, tx, &ids, `SELECT id FROM freescout_digest_events WHERE team_id = ? LIMIT 1 FOR UPDATE`, teamID); err != nil {
+			return ctxerr.Wrap(ctx, err, "check pending freescout digest events")
+		}
+		wasEmpty = len(ids) == 0
+
+		values := strings.TrimSuffix(strings.Repeat("(?, ?),", len(args)), ",")
+		stmtArgs := make([]interface{}, 0, 2*len(args))
+		for _, a := range args {
+			stmtArgs = append(stmtArgs, teamID, a)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO freescout_digest_events (team_id, args) VALUES `+values, stmtArgs...); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert freescout digest events")
+		}
+		return nil
+	})
+	return wasEmpty, err
+}
+
+func (ds *Datastore) ListFreeScoutDigestEvents(ctx context.Context, teamID uint, limit int) ([]*fleet.FreeScoutDigestEvent, error) {
+	const stmt = `
+SELECT
+    id, team_id, args, created_at
+FROM
+    freescout_digest_events
+WHERE
+    team_id = ?
+ORDER BY
+    id ASC
+LIMIT ?
+`
+	var events []*fleet.FreeScoutDigestEvent
+	if err := sqlx.SelectContext(ctx, ds.writer(ctx), &events, stmt, teamID, limit); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list freescout digest events")
+	}
+	return events, nil
+}
+
+func (ds *Datastore) DeleteFreeScoutDigestEvents(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	stmt, args, err := sqlx.In(`DELETE FROM freescout_digest_events WHERE id IN (?)`, ids)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "build delete freescout digest events query")
+	}
+	if _, err := ds.writer(ctx).ExecContext(ctx, stmt, args...); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete freescout digest events")
+	}
+	return nil
+}
+
+func (ds *Datastore) GetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string) (int, error) {
+	var count int
+	err := sqlx.GetContext(ctx, ds.writer(ctx), &count, `SELECT hosts_count FROM freescout_vuln_reports WHERE cve = ?`, cve)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ctxerr.Wrap(ctx, notFound("FreeScoutVulnReport").WithName(cve))
+		}
+		return 0, ctxerr.Wrap(ctx, err, "get freescout vuln reported hosts count")
+	}
+	return count, nil
+}
+
+func (ds *Datastore) SetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string, count int) error {
+	const stmt = `
+INSERT INTO freescout_vuln_reports (cve, hosts_count)
+VALUES (?, ?)
+ON DUPLICATE KEY UPDATE hosts_count = VALUES(hosts_count)
+`
+	if _, err := ds.writer(ctx).ExecContext(ctx, stmt, cve, count); err != nil {
+		return ctxerr.Wrap(ctx, err, "set freescout vuln reported hosts count")
+	}
+	return nil
+}
+
+// freeScoutReportHostsBatchSize is the number of host IDs of a FreeScout
+// report inserted or looked up by a single statement.
+const freeScoutReportHostsBatchSize = 5000
+
+// checkFreeScoutReportExists returns a NotFoundError if nothing was reported
+// under key.
+func (ds *Datastore) checkFreeScoutReportExists(ctx context.Context, key string) error {
+	var found int
+	err := sqlx.GetContext(ctx, ds.writer(ctx), &found, `SELECT 1 FROM freescout_reports WHERE report_key = ?`, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ctxerr.Wrap(ctx, notFound("FreeScoutReport").WithName(key))
+		}
+		return ctxerr.Wrap(ctx, err, "get freescout report")
+	}
+	return nil
+}
+
+func (ds *Datastore) CountFreeScoutReportedHosts(ctx context.Context, key string, hostIDs []uint) (int, error) {
+	if err := ds.checkFreeScoutReportExists(ctx, key); err != nil {
+		return 0, err
+	}
+
+	var count int
+	for start := 0; start < len(hostIDs); start += freeScoutReportHostsBatchSize {
+		end := min(start+freeScoutReportHostsBatchSize, len(hostIDs))
+		stmt, args, err := sqlx.In(`SELECT COUNT(*) FROM freescout_report_hosts WHERE report_key = ? AND host_id IN (?)`, key, hostIDs[start:end])
+		if err != nil {
+			return 0, ctxerr.Wrap(ctx, err, "build count freescout reported hosts query")
+		}
+		var n int
+		if err := sqlx.GetContext(ctx, ds.writer(ctx), &n, stmt, args...); err != nil {
+			return 0, ctxerr.Wrap(ctx, err, "count freescout reported hosts")
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func (ds *Datastore) GetFreeScoutReport(ctx context.Context, key string) (*fleet.FreeScoutReport, error) {
+	const stmt = `
+SELECT
+    report_key, reported_at, hosts_fingerprint
+FROM
+    freescout_reports
+WHERE
+    report_key = ?
+`
+	var report fleet.FreeScoutReport
+	if err := sqlx.GetContext(ctx, ds.writer(ctx), &report, stmt, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("FreeScoutReport").WithName(key))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get freescout report")
+	}
+	return &report, nil
+}
+
+// resetFreeScoutReportDB upserts the report and deletes the hosts of the
+// previous report under its key.
+func resetFreeScoutReportDB(ctx context.Context, tx sqlx.ExtContext, report *fleet.FreeScoutReport) error {
+	const upsertStmt = `
+INSERT INTO freescout_reports (report_key, reported_at, hosts_fingerprint)
+VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE
+    reported_at = VALUES(reported_at),
+    hosts_fingerprint = VALUES(hosts_fingerprint)
+`
+	if _, err := tx.ExecContext(ctx, upsertStmt, report.Key, report.ReportedAt, report.HostsFingerprint); err != nil {
+		return ctxerr.Wrap(ctx, err, "upsert freescout report")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM freescout_report_hosts WHERE report_key = ?`, report.Key); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete freescout reported hosts")
+	}
+	return nil
+}
+
+func (ds *Datastore) SetFreeScoutReport(ctx context.Context, report *fleet.FreeScoutReport, hostIDs []uint) error {
+	key := report.Key
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if err := resetFreeScoutReportDB(ctx, tx, report); err != nil {
+			return err
+		}
+
+		for start := 0; start < len(hostIDs); start += freeScoutReportHostsBatchSize {
+			batch := hostIDs[start:min(start+freeScoutReportHostsBatchSize, len(hostIDs))]
+			values := strings.TrimSuffix(strings.Repeat("(?, ?),", len(batch)), ",")
+			args := make([]interface{}, 0, 2*len(batch))
+			for _, id := range batch {
+				args = append(args, key, id)
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO freescout_report_hosts (report_key, host_id) VALUES `+values, args...); err != nil {
+				return ctxerr.Wrap(ctx, err, "insert freescout reported hosts")
+			}
+		}
+		return nil
+	})
+}
+
+func (ds *Datastore) CountFreeScoutReportedHostsBySoftwareIDs(ctx context.Context, key string, softwareIDs []uint) (int, error) {
+	if err := ds.checkFreeScoutReportExists(ctx, key); err != nil {
+		return 0, err
+	}
+	if len(softwareIDs) == 0 {
+		return 0, nil
+	}
+
+	const stmt = `
+SELECT
+    COUNT(*)
+FROM
+    freescout_report_hosts frh
+WHERE
+    frh.report_key = ? AND
+    frh.host_id IN (
+        SELECT hs.host_id
+        FROM host_software hs
+            INNER JOIN hosts h ON h.id = hs.host_id
+        WHERE hs.software_id IN (?)
+    )
+`
+	query, args, err := sqlx.In(stmt, key, softwareIDs)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "build count freescout reported hosts by software query")
+	}
+	var count int
+	if err := sqlx.GetContext(ctx, ds.writer(ctx), &count, query, args...); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "count freescout reported hosts by software")
+	}
+	return count, nil
+}
+
+func (ds *Datastore) SetFreeScoutReportBySoftwareIDs(ctx context.Context, report *fleet.FreeScoutReport, softwareIDs []uint) error {
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if err := resetFreeScoutReportDB(ctx, tx, report); err != nil {
+			return err
+		}
+		if len(softwareIDs) == 0 {
+			return nil
+		}
+
+		const insertStmt = `
+INSERT INTO freescout_report_hosts (report_key, host_id)
+SELECT DISTINCT
+    ?, hs.host_id
+FROM
+    host_software hs
+    INNER JOIN hosts h ON h.id = hs.host_id
+WHERE
+    hs.software_id IN (?)
+`
+		query, args, err := sqlx.In(insertStmt, report.Key, softwareIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "build insert freescout reported hosts by software query")
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "insert freescout reported hosts by software")
+		}
+		return nil
+	})
+}
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -23,6 +24,9 @@ func TestJobs(t *testing.T) {
 		{"QueueAndProcessJobs", testQueueAndProcessJobs},
 		{"QueueAndProcessJobs", testQueueAndProcessFilteredJobs},
 		{"CleanupWorkerJobs", testCleanupWorkerJobs},
+		{"FreeScoutDigestEvents", testFreeScoutDigestEvents},
+		{"FreeScoutVulnReportedHostsCount", testFreeScoutVulnReportedHostsCount},
+		{"FreeScoutReports", testFreeScoutReports},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -205,3 +209,129 @@ func testCleanupWorkerJobs(t *testing.T, ds *Datastore) {
 	require.Len(t, jobs, 1)
 	require.Equal(t, queuedJobs[0].ID, jobs[0].ID)
 }
+
+func testFreeScoutDigestEvents(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	events, err := ds.ListFreeScoutDigestEvents(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	// the first insert in a team's digest reports it as empty
+	wasEmpty, err := ds.InsertFreeScoutDigestEvents(ctx, 0, []json.RawMessage{
+		json.RawMessage(`{"vulnerability":{"cve":"CVE-0001"}}`),
+		json.RawMessage(`{"vulnerability":{"cve":"CVE-0002"}}`),
+	})
+	require.NoError(t, err)
+	require.True(t, wasEmpty)
+
+	wasEmpty, err = ds.InsertFreeScoutDigestEvents(ctx, 0, []json.RawMessage{json.RawMessage(`{"vulnerability":{"cve":"CVE-0003"}}`)})
+	require.NoError(t, err)
+	require.False(t, wasEmpty)
+
+	// digests are per team
+	wasEmpty, err = ds.InsertFreeScoutDigestEvents(ctx, 1, []json.RawMessage{json.RawMessage(`{"failing_policy":{"policy_id":1,"team_id":1}}`)})
+	require.NoError(t, err)
+	require.True(t, wasEmpty)
+
+	events, err = ds.ListFreeScoutDigestEvents(ctx, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.JSONEq(t, `{"vulnerability":{"cve":"CVE-0001"}}`, string(events[0].Args))
+	require.JSONEq(t, `{"vulnerability":{"cve":"CVE-0002"}}`, string(events[1].Args))
+	require.EqualValues(t, 0, events[0].TeamID)
+	require.False(t, events[0].CreatedAt.IsZero())
+
+	err = ds.DeleteFreeScoutDigestEvents(ctx, []uint{events[0].ID, events[1].ID})
+	require.NoError(t, err)
+
+	events, err = ds.ListFreeScoutDigestEvents(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.JSONEq(t, `{"vulnerability":{"cve":"CVE-0003"}}`, string(events[0].Args))
+
+	events, err = ds.ListFreeScoutDigestEvents(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.EqualValues(t, 1, events[0].TeamID)
+
+	// deleting nothing is a no-op
+	require.NoError(t, ds.DeleteFreeScoutDigestEvents(ctx, nil))
+}
+
+func testFreeScoutVulnReportedHostsCount(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetFreeScoutVulnReportedHostsCount(ctx, "CVE-0001")
+	require.True(t, fleet.IsNotFound(err))
+
+	require.NoError(t, ds.SetFreeScoutVulnReportedHostsCount(ctx, "CVE-0001", 50))
+	require.NoError(t, ds.SetFreeScoutVulnReportedHostsCount(ctx, "CVE-0002", 3))
+	count, err := ds.GetFreeScoutVulnReportedHostsCount(ctx, "CVE-0001")
+	require.NoError(t, err)
+	require.Equal(t, 50, count)
+
+	// the count is replaced by the next report
+	require.NoError(t, ds.SetFreeScoutVulnReportedHostsCount(ctx, "CVE-0001", 42))
+	count, err = ds.GetFreeScoutVulnReportedHostsCount(ctx, "CVE-0001")
+	require.NoError(t, err)
+	require.Equal(t, 42, count)
+	count, err = ds.GetFreeScoutVulnReportedHostsCount(ctx, "CVE-0002")
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func testFreeScoutReports(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+	reportedAt := time.Now().UTC().Truncate(time.Microsecond)
+	setReport := func(key string, hostIDs []uint) {
+		report := &fleet.FreeScoutReport{Key: key, ReportedAt: reportedAt, HostsFingerprint: fmt.Sprintf("%d hosts", len(hostIDs))}
+		require.NoError(t, ds.SetFreeScoutReport(ctx, report, hostIDs))
+	}
+
+	_, err := ds.GetFreeScoutReport(ctx, "vuln:CVE-0001")
+	require.True(t, fleet.IsNotFound(err))
+	_, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0001", []uint{1})
+	require.True(t, fleet.IsNotFound(err))
+
+	setReport("vuln:CVE-0001", []uint{1, 2, 3})
+	setReport("vuln:CVE-0002", []uint{4})
+	report, err := ds.GetFreeScoutReport(ctx, "vuln:CVE-0001")
+	require.NoError(t, err)
+	require.Equal(t, "vuln:CVE-0001", report.Key)
+	require.True(t, reportedAt.Equal(report.ReportedAt))
+	require.Equal(t, "3 hosts", report.HostsFingerprint)
+	count, err := ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0001", []uint{2, 3, 4, 5})
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// the report and its hosts are replaced by the next report
+	reportedAt = reportedAt.Add(time.Hour)
+	setReport("vuln:CVE-0001", []uint{3, 5})
+	report, err = ds.GetFreeScoutReport(ctx, "vuln:CVE-0001")
+	require.NoError(t, err)
+	require.True(t, reportedAt.Equal(report.ReportedAt))
+	require.Equal(t, "2 hosts", report.HostsFingerprint)
+	count, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0001", []uint{1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	count, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0002", []uint{1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// a report without hosts is still a report
+	setReport("vuln:CVE-0001", nil)
+	count, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0001", []uint{3, 5})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// more hosts than a batch
+	hostIDs := make([]uint, 0, freeScoutReportHostsBatchSize+10)
+	for i := 1; i <= freeScoutReportHostsBatchSize+10; i++ {
+		hostIDs = append(hostIDs, uint(i))
+	}
+	setReport("vuln:CVE-0003", hostIDs)
+	count, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0003", append(hostIDs, uint(len(hostIDs)+1)))
+	require.NoError(t, err)
+	require.Equal(t, len(hostIDs), count)
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20261016103215, Down_20261016103215)
+}
+
+func Up_20261016103215(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	ALTER TABLE cve_meta
+	ADD COLUMN cvss_version VARCHAR(8) COLLATE utf8mb4_unicode_ci DEFAULT NULL AFTER cvss_score
+	`)
+	if err != nil {
+		return errors.Wrap(err, "add cvss_version column to cve_meta")
+	}
+	return nil
+}
+
+func Down_20261016103215(tx *sql.Tx) error {
+	return nil
+}
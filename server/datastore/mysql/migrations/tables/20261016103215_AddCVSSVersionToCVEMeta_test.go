@@ -0,0 +1,32 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20261016103215(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Insert cve_meta record (before migration, no version column)
+	_, err := db.Exec(`INSERT INTO cve_meta (cve, cvss_score) VALUES (?, ?)`, "CVE-2022-0001", 7.5)
+	require.NoError(t, err)
+
+	// Apply current migration
+	applyNext(t, db)
+
+	// Verify the column exists and is NULL for existing rows
+	var version *string
+	err = db.Get(&version, `SELECT cvss_version FROM cve_meta WHERE cve = ?`, "CVE-2022-0001")
+	require.NoError(t, err)
+	require.Nil(t, version, "existing row should have NULL cvss_version")
+
+	// Verify we can insert new rows with the version
+	_, err = db.Exec(`INSERT INTO cve_meta (cve, cvss_score, cvss_version) VALUES (?, ?, ?)`, "CVE-2022-0002", 9.8, "3.1")
+	require.NoError(t, err)
+	err = db.Get(&version, `SELECT cvss_version FROM cve_meta WHERE cve = ?`, "CVE-2022-0002")
+	require.NoError(t, err)
+	require.NotNil(t, version)
+	require.Equal(t, "3.1", *version)
+}
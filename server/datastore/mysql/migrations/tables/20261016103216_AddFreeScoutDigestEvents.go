@@ -0,0 +1,35 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20261016103216, Down_20261016103216)
+}
+
+func Up_20261016103216(tx *sql.Tx) error {
+	// team_id is 0 for the events of the global scope (vulnerabilities and
+	// global policies).
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS freescout_digest_events (
+			id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+			team_id INT UNSIGNED NOT NULL DEFAULT 0,
+			args JSON NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (id),
+			KEY idx_freescout_digest_events_team_id (team_id, id)
+		) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "create freescout_digest_events table")
+	}
+	return nil
+}
+
+func Down_20261016103216(tx *sql.Tx) error {
+	return nil
+}
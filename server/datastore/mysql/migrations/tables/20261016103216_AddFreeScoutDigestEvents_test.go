@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20261016103216(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO freescout_digest_events (args) VALUES (?)`, `{"vulnerability": {"cve": "CVE-2022-0001"}}`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO freescout_digest_events (team_id, args) VALUES (?, ?)`, 1, `{"failing_policy": {"policy_id": 1}}`)
+	require.NoError(t, err)
+
+	var teamIDs []uint
+	err = db.Select(&teamIDs, `SELECT team_id FROM freescout_digest_events ORDER BY id`)
+	require.NoError(t, err)
+	require.Equal(t, []uint{0, 1}, teamIDs)
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20261016103217, Down_20261016103217)
+}
+
+func Up_20261016103217(tx *sql.Tx) error {
+	// hosts_count is the number of affected hosts of the CVE in its last
+	// FreeScout report, to render the trend in the next report.
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS freescout_vuln_reports (
+			cve VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+			hosts_count INT UNSIGNED NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (cve)
+		) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "create freescout_vuln_reports table")
+	}
+	return nil
+}
+
+func Down_20261016103217(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20261016103217(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO freescout_vuln_reports (cve, hosts_count) VALUES (?, ?)`, "CVE-2022-0001", 42)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO freescout_vuln_reports (cve, hosts_count) VALUES (?, ?)`, "CVE-2022-0001", 50)
+	require.Error(t, err)
+
+	var count uint
+	err = db.Get(&count, `SELECT hosts_count FROM freescout_vuln_reports WHERE cve = ?`, "CVE-2022-0001")
+	require.NoError(t, err)
+	require.EqualValues(t, 42, count)
+}
@@ -0,0 +1,46 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20261016103218, Down_20261016103218)
+}
+
+func Up_20261016103218(tx *sql.Tx) error {
+	// freescout_reports are the last FreeScout reports of the CVEs and failing
+	// policies, keyed by the report key of the FreeScout worker, and
+	// freescout_report_hosts the IDs of their hosts, to split the hosts of the
+	// next report between the previously reported and the newly affected ones.
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS freescout_reports (
+			report_key VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (report_key)
+		) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "create freescout_reports table")
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS freescout_report_hosts (
+			report_key VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+			host_id INT UNSIGNED NOT NULL,
+
+			PRIMARY KEY (report_key, host_id)
+		) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "create freescout_report_hosts table")
+	}
+	return nil
+}
+
+func Down_20261016103218(tx *sql.Tx) error {
+	return nil
+}
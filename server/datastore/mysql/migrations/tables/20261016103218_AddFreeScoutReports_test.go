@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20261016103218(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	// Apply current migration
+	applyNext(t, db)
+
+	_, err := db.Exec(`INSERT INTO freescout_reports (report_key) VALUES (?)`, "vuln:CVE-2022-0001")
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO freescout_reports (report_key) VALUES (?)`, "vuln:CVE-2022-0001")
+	require.Error(t, err)
+
+	_, err = db.Exec(`INSERT INTO freescout_report_hosts (report_key, host_id) VALUES (?, ?), (?, ?)`,
+		"vuln:CVE-2022-0001", 1, "vuln:CVE-2022-0001", 2)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO freescout_report_hosts (report_key, host_id) VALUES (?, ?)`, "vuln:CVE-2022-0001", 1)
+	require.Error(t, err)
+
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM freescout_report_hosts WHERE report_key = ?`, "vuln:CVE-2022-0001")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
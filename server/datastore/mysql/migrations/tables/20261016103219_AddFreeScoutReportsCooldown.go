@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20261016103219, Down_20261016103219)
+}
+
+func Up_20261016103219(tx *sql.Tx) error {
+	// reported_at and hosts_fingerprint are the time of the last FreeScout
+	// report and the fingerprint of its set of hosts, to skip the reports
+	// made within the cooldown of the integration with unchanged hosts.
+	_, err := tx.Exec(`
+		ALTER TABLE freescout_reports
+			ADD COLUMN reported_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			ADD COLUMN hosts_fingerprint CHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		return errors.Wrap(err, "add reported_at and hosts_fingerprint to freescout_reports")
+	}
+	return nil
+}
+
+func Down_20261016103219(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package tables
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp_20261016103219(t *testing.T) {
+	db := applyUpToPrev(t)
+
+	_, err := db.Exec(`INSERT INTO freescout_reports (report_key) VALUES (?)`, "vuln:CVE-2022-0001")
+	require.NoError(t, err)
+
+	// Apply current migration
+	applyNext(t, db)
+
+	// the existing reports get the defaults
+	var report struct {
+		ReportedAt       time.Time `db:"reported_at"`
+		HostsFingerprint string    `db:"hosts_fingerprint"`
+	}
+	err = db.Get(&report, `SELECT reported_at, hosts_fingerprint FROM freescout_reports WHERE report_key = ?`, "vuln:CVE-2022-0001")
+	require.NoError(t, err)
+	require.False(t, report.ReportedAt.IsZero())
+	require.Empty(t, report.HostsFingerprint)
+
+	reportedAt := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	_, err = db.Exec(`INSERT INTO freescout_reports (report_key, reported_at, hosts_fingerprint) VALUES (?, ?, ?)`,
+		"vuln:CVE-2022-0002", reportedAt, "abc")
+	require.NoError(t, err)
+	err = db.Get(&report, `SELECT reported_at, hosts_fingerprint FROM freescout_reports WHERE report_key = ?`, "vuln:CVE-2022-0002")
+	require.NoError(t, err)
+	require.True(t, reportedAt.Equal(report.ReportedAt))
+	require.Equal(t, "abc", report.HostsFingerprint)
+}
@@ -2774,9 +2774,12 @@ func (ds *Datastore) HostVulnSummariesBySoftwareIDs(ctx context.Context, softwar
 			h.id,
 			h.hostname,
 			if(h.computer_name = '', h.hostname, h.computer_name) display_name,
-			COALESCE(hsip.installed_path, '') AS software_installed_path
+			COALESCE(hsip.installed_path, '') AS software_installed_path,
+			COALESCE(s.name, '') AS software_name,
+			COALESCE(s.version, '') AS software_version
 		FROM hosts h
 				INNER JOIN host_software hs ON h.id = hs.host_id AND hs.software_id IN (?)
+				LEFT JOIN software s ON s.id = hs.software_id
 				LEFT JOIN host_software_installed_paths hsip ON hs.host_id = hsip.host_id AND hs.software_id = hsip.software_id
 		ORDER BY h.id`
 
@@ -2790,6 +2793,8 @@ func (ds *Datastore) HostVulnSummariesBySoftwareIDs(ctx context.Context, softwar
 		HostName    string `db:"hostname"`
 		DisplayName string `db:"display_name"`
 		SPath       string `db:"software_installed_path"`
+		SName       string `db:"software_name"`
+		SVersion    string `db:"software_version"`
 	}
 	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &qR, stmt, args...); err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "selecting hosts by softwareIDs")
@@ -2803,6 +2808,7 @@ func (ds *Datastore) HostVulnSummariesBySoftwareIDs(ctx context.Context, softwar
 
 		if ok {
 			result[i].AddSoftwareInstalledPath(r.SPath)
+			result[i].AddSoftware(r.SName, r.SVersion, r.SPath)
 			continue
 		}
 
@@ -2812,6 +2818,7 @@ func (ds *Datastore) HostVulnSummariesBySoftwareIDs(ctx context.Context, softwar
 			DisplayName: r.DisplayName,
 		}
 		mapped.AddSoftwareInstalledPath(r.SPath)
+		mapped.AddSoftware(r.SName, r.SVersion, r.SPath)
 		result = append(result, mapped)
 
 		lookup[r.HostID] = len(result) - 1
@@ -2820,6 +2827,101 @@ func (ds *Datastore) HostVulnSummariesBySoftwareIDs(ctx context.Context, softwar
 	return result, nil
 }
 
+func (ds *Datastore) HostVulnSummariesBySoftwareIDsPage(ctx context.Context, softwareIDs []uint, afterHostID uint, limit int) ([]fleet.HostVulnerabilitySummary, error) {
+	if len(softwareIDs) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	// the limit applies to hosts, not to rows, as a host may have the software
+	// installed in more than one path.
+	stmt := `
+		SELECT DISTINCT
+			h.id,
+			h.hostname,
+			if(h.computer_name = '', h.hostname, h.computer_name) display_name,
+			COALESCE(hsip.installed_path, '') AS software_installed_path,
+			COALESCE(s.name, '') AS software_name,
+			COALESCE(s.version, '') AS software_version
+		FROM (
+			SELECT DISTINCT hs.host_id
+			FROM host_software hs
+				INNER JOIN hosts h ON h.id = hs.host_id
+			WHERE hs.software_id IN (?) AND hs.host_id > ?
+			ORDER BY hs.host_id
+			LIMIT ?
+		) page
+				INNER JOIN hosts h ON h.id = page.host_id
+				INNER JOIN host_software hs ON h.id = hs.host_id AND hs.software_id IN (?)
+				LEFT JOIN software s ON s.id = hs.software_id
+				LEFT JOIN host_software_installed_paths hsip ON hs.host_id = hsip.host_id AND hs.software_id = hsip.software_id
+		ORDER BY h.id`
+
+	stmt, args, err := sqlx.In(stmt, softwareIDs, afterHostID, limit, softwareIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query args")
+	}
+
+	var qR []struct {
+		HostID      uint   `db:"id"`
+		HostName    string `db:"hostname"`
+		DisplayName string `db:"display_name"`
+		SPath       string `db:"software_installed_path"`
+		SName       string `db:"software_name"`
+		SVersion    string `db:"software_version"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &qR, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "selecting page of hosts by softwareIDs")
+	}
+
+	var result []fleet.HostVulnerabilitySummary
+	lookup := make(map[uint]int)
+
+	for _, r := range qR {
+		i, ok := lookup[r.HostID]
+
+		if ok {
+			result[i].AddSoftwareInstalledPath(r.SPath)
+			result[i].AddSoftware(r.SName, r.SVersion, r.SPath)
+			continue
+		}
+
+		mapped := fleet.HostVulnerabilitySummary{
+			ID:          r.HostID,
+			Hostname:    r.HostName,
+			DisplayName: r.DisplayName,
+		}
+		mapped.AddSoftwareInstalledPath(r.SPath)
+		mapped.AddSoftware(r.SName, r.SVersion, r.SPath)
+		result = append(result, mapped)
+
+		lookup[r.HostID] = len(result) - 1
+	}
+
+	return result, nil
+}
+
+func (ds *Datastore) CountHostVulnSummariesBySoftwareIDs(ctx context.Context, softwareIDs []uint) (int, error) {
+	if len(softwareIDs) == 0 {
+		return 0, nil
+	}
+
+	stmt := `
+		SELECT COUNT(DISTINCT hs.host_id)
+		FROM host_software hs
+			INNER JOIN hosts h ON h.id = hs.host_id
+		WHERE hs.software_id IN (?)`
+
+	stmt, args, err := sqlx.In(stmt, softwareIDs)
+	if err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "building query args")
+	}
+	var count int
+	if err := sqlx.GetContext(ctx, ds.reader(ctx), &count, stmt, args...); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "counting hosts by softwareIDs")
+	}
+	return count, nil
+}
+
 // Deprecated: ** DEPRECATED **
 func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
 	stmt := `
@@ -2827,10 +2929,13 @@ func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVu
 				(h.id),
 				h.hostname,
 				if(h.computer_name = '', h.hostname, h.computer_name) display_name,
-				COALESCE(hsip.installed_path, '') AS software_installed_path
+				COALESCE(hsip.installed_path, '') AS software_installed_path,
+				COALESCE(s.name, '') AS software_name,
+				COALESCE(s.version, '') AS software_version
 		FROM hosts h
 			INNER JOIN host_software hs ON h.id = hs.host_id
 			INNER JOIN software_cve scv ON scv.software_id = hs.software_id
+			LEFT JOIN software s ON s.id = hs.software_id
 			LEFT JOIN host_software_installed_paths hsip ON hs.host_id = hsip.host_id AND hs.software_id = hsip.software_id
 		WHERE scv.cve = ?
 		ORDER BY h.id`
@@ -2840,6 +2945,8 @@ func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVu
 		HostName    string `db:"hostname"`
 		DisplayName string `db:"display_name"`
 		SPath       string `db:"software_installed_path"`
+		SName       string `db:"software_name"`
+		SVersion    string `db:"software_version"`
 	}
 	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &qR, stmt, cve); err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "selecting hosts by softwareIDs")
@@ -2853,6 +2960,7 @@ func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVu
 
 		if ok {
 			result[i].AddSoftwareInstalledPath(r.SPath)
+			result[i].AddSoftware(r.SName, r.SVersion, r.SPath)
 			continue
 		}
 
@@ -2862,6 +2970,7 @@ func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVu
 			DisplayName: r.DisplayName,
 		}
 		mapped.AddSoftwareInstalledPath(r.SPath)
+		mapped.AddSoftware(r.SName, r.SVersion, r.SPath)
 		result = append(result, mapped)
 
 		lookup[r.HostID] = len(result) - 1
@@ -2870,12 +2979,49 @@ func (ds *Datastore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVu
 	return result, nil
 }
 
+func (ds *Datastore) CVEDetectedAtByHostIDs(ctx context.Context, cve string, hostIDs []uint) (map[uint]time.Time, error) {
+	if len(hostIDs) == 0 {
+		return map[uint]time.Time{}, nil
+	}
+
+	stmt := `
+		SELECT
+			hs.host_id,
+			MIN(scv.created_at) AS detected_at
+		FROM host_software hs
+			INNER JOIN software_cve scv ON scv.software_id = hs.software_id
+		WHERE scv.cve = ? AND hs.host_id IN (?)
+		GROUP BY hs.host_id`
+
+	stmt, args, err := sqlx.In(stmt, cve, hostIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building query args")
+	}
+
+	var qR []struct {
+		HostID     uint       `db:"host_id"`
+		DetectedAt *time.Time `db:"detected_at"`
+	}
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &qR, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "selecting cve detected at by host IDs")
+	}
+
+	result := make(map[uint]time.Time, len(qR))
+	for _, r := range qR {
+		if r.DetectedAt != nil {
+			result[r.HostID] = *r.DetectedAt
+		}
+	}
+	return result, nil
+}
+
 func (ds *Datastore) InsertCVEMeta(ctx context.Context, cveMeta []fleet.CVEMeta) error {
 	query := `
-INSERT INTO cve_meta (cve, cvss_score, epss_probability, cisa_known_exploit, published, description)
+INSERT INTO cve_meta (cve, cvss_score, cvss_version, epss_probability, cisa_known_exploit, published, description)
 VALUES %s
 ON DUPLICATE KEY UPDATE
     cvss_score = VALUES(cvss_score),
+    cvss_version = VALUES(cvss_version),
     epss_probability = VALUES(epss_probability),
     cisa_known_exploit = VALUES(cisa_known_exploit),
     published = VALUES(published),
@@ -2891,10 +3037,10 @@ ON DUPLICATE KEY UPDATE
 
 		batch := cveMeta[i:end]
 
-		valuesFrag := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?), ", len(batch)), ", ")
+		valuesFrag := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?), ", len(batch)), ", ")
 		var args []interface{}
 		for _, meta := range batch {
-			args = append(args, meta.CVE, meta.CVSSScore, meta.EPSSProbability, meta.CISAKnownExploit, meta.Published, meta.Description)
+			args = append(args, meta.CVE, meta.CVSSScore, meta.CVSSVersion, meta.EPSSProbability, meta.CISAKnownExploit, meta.Published, meta.Description)
 		}
 
 		query := fmt.Sprintf(query, valuesFrag)
@@ -3050,6 +3196,7 @@ func (ds *Datastore) ListCVEs(ctx context.Context, maxAge time.Duration) ([]flee
 		Select(
 			goqu.C("cve"),
 			goqu.C("cvss_score"),
+			goqu.C("cvss_version"),
 			goqu.C("epss_probability"),
 			goqu.C("cisa_known_exploit"),
 			goqu.C("published"),
@@ -3069,6 +3216,29 @@ func (ds *Datastore) ListCVEs(ctx context.Context, maxAge time.Duration) ([]flee
 	return result, nil
 }
 
+func (ds *Datastore) GetCVEMeta(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+	stmt := `
+		SELECT
+			cve,
+			cvss_score,
+			cvss_version,
+			epss_probability,
+			cisa_known_exploit,
+			published,
+			description
+		FROM cve_meta
+		WHERE cve = ?`
+
+	var meta fleet.CVEMeta
+	if err := sqlx.GetContext(ctx, ds.reader(ctx), &meta, stmt, cve); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ctxerr.Wrap(ctx, notFound("CVEMeta").WithName(cve))
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get cve meta")
+	}
+	return &meta, nil
+}
+
 type hostSoftware struct {
 	fleet.HostSoftwareWithInstaller
 
@@ -48,7 +48,9 @@ func TestSoftware(t *testing.T) {
 		{"LoadHostsPopulateSoftware", testLoadHostSoftwarePopulateSoftwareInstalledPath},
 		{"DeleteSoftwareVulnerabilities", testDeleteSoftwareVulnerabilities},
 		{"HostsByCVE", testHostsByCVE},
+		{"CVEDetectedAtByHostIDs", testCVEDetectedAtByHostIDs},
 		{"HostVulnSummariesBySoftwareIDs", testHostVulnSummariesBySoftwareIDs},
+		{"HostVulnSummariesBySoftwareIDsPage", testHostVulnSummariesBySoftwareIDsPage},
 		{"UpdateHostSoftware", testUpdateHostSoftware},
 		{"UpdateHostSoftwareDeadlock", testUpdateHostSoftwareDeadlock},
 		{"UpdateHostSoftwareUpdatesSoftware", testUpdateHostSoftwareUpdatesSoftware},
@@ -64,6 +66,7 @@ func TestSoftware(t *testing.T) {
 		{"ListSoftwareVulnerabilitiesByHostIDsSource", testListSoftwareVulnerabilitiesByHostIDsSource},
 		{"InsertSoftwareVulnerability", testInsertSoftwareVulnerability},
 		{"ListCVEs", testListCVEs},
+		{"GetCVEMeta", testGetCVEMeta},
 		{"ListSoftwareForVulnDetection", testListSoftwareForVulnDetection},
 		{"AllSoftwareIterator", testAllSoftwareIterator},
 		{"AllSoftwareIteratorForCustomLinuxImages", testSoftwareIteratorForLinuxKernelCustomImages},
@@ -1605,6 +1608,9 @@ func testHostsByCVE(t *testing.T, ds *Datastore) {
 			SoftwareInstalledPaths: []string{
 				"/some/path/foo.chrome",
 			},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "foo.chrome", Version: "0.0.3", InstalledPath: "/some/path/foo.chrome"},
+			},
 		}, {
 			ID:          2,
 			Hostname:    "host2",
@@ -1612,6 +1618,9 @@ func testHostsByCVE(t *testing.T, ds *Datastore) {
 			SoftwareInstalledPaths: []string{
 				"/some/path/foo.chrome",
 			},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "foo.chrome", Version: "0.0.3", InstalledPath: "/some/path/foo.chrome"},
+			},
 		},
 	})
 
@@ -1622,6 +1631,46 @@ func testHostsByCVE(t *testing.T, ds *Datastore) {
 	require.Equal(t, hosts[0].Hostname, "host2")
 }
 
+func testCVEDetectedAtByHostIDs(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	detectedAt, err := ds.CVEDetectedAtByHostIDs(ctx, "CVE-2022-0001", nil)
+	require.NoError(t, err)
+	require.Empty(t, detectedAt)
+
+	insertVulnSoftwareForTest(t, ds)
+
+	hosts, err := ds.HostsByCVE(ctx, "CVE-2022-0001")
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	host1, host2 := hosts[0].ID, hosts[1].ID
+
+	published := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	ExecAdhocSQL(t, ds, func(q sqlx.ExtContext) error {
+		_, err := q.ExecContext(ctx, `UPDATE software_cve SET created_at = ? WHERE cve = 'CVE-2022-0001'`, published)
+		return err
+	})
+
+	// CVE of foo chrome 0.0.3, both hosts have it
+	detectedAt, err = ds.CVEDetectedAtByHostIDs(ctx, "CVE-2022-0001", []uint{host1, host2})
+	require.NoError(t, err)
+	require.Len(t, detectedAt, 2)
+	require.True(t, published.Equal(detectedAt[host1]))
+	require.True(t, published.Equal(detectedAt[host2]))
+
+	// CVE of bar.rpm 0.0.3, only host 2 has it
+	detectedAt, err = ds.CVEDetectedAtByHostIDs(ctx, "CVE-2022-0002", []uint{host1, host2})
+	require.NoError(t, err)
+	require.Len(t, detectedAt, 1)
+	require.Contains(t, detectedAt, host2)
+
+	// only the requested hosts are returned
+	detectedAt, err = ds.CVEDetectedAtByHostIDs(ctx, "CVE-2022-0001", []uint{host2})
+	require.NoError(t, err)
+	require.Len(t, detectedAt, 1)
+	require.Contains(t, detectedAt, host2)
+}
+
 func testHostVulnSummariesBySoftwareIDs(t *testing.T, ds *Datastore) {
 	ctx := context.Background()
 
@@ -1659,11 +1708,17 @@ func testHostVulnSummariesBySoftwareIDs(t *testing.T, ds *Datastore) {
 			Hostname:               "host1",
 			DisplayName:            "computer1",
 			SoftwareInstalledPaths: []string{"/some/path/foo.chrome"},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "foo.chrome", Version: "0.0.3", InstalledPath: "/some/path/foo.chrome"},
+			},
 		}, {
 			ID:                     2,
 			Hostname:               "host2",
 			DisplayName:            "host2",
 			SoftwareInstalledPaths: []string{"/some/path/foo.chrome"},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "foo.chrome", Version: "0.0.3", InstalledPath: "/some/path/foo.chrome"},
+			},
 		},
 	})
 
@@ -1675,6 +1730,9 @@ func testHostVulnSummariesBySoftwareIDs(t *testing.T, ds *Datastore) {
 			Hostname:               "host2",
 			DisplayName:            "host2",
 			SoftwareInstalledPaths: []string{"/some/path/bar.rpm"},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "bar.rpm", Version: "0.0.3", InstalledPath: "/some/path/bar.rpm"},
+			},
 		},
 	})
 
@@ -1686,6 +1744,90 @@ func testHostVulnSummariesBySoftwareIDs(t *testing.T, ds *Datastore) {
 	require.Equal(t, hosts[1].Hostname, "host2")
 	require.ElementsMatch(t, hosts[0].SoftwareInstalledPaths, []string{"/some/path/foo.rpm", "/some/path/foo.chrome"})
 	require.ElementsMatch(t, hosts[1].SoftwareInstalledPaths, []string{"/some/path/bar.rpm", "/some/path/foo.chrome"})
+	require.ElementsMatch(t, hosts[1].Software, []fleet.HostVulnerableSoftware{
+		{Name: "bar.rpm", Version: "0.0.3", InstalledPath: "/some/path/bar.rpm"},
+		{Name: "foo.chrome", Version: "0.0.3", InstalledPath: "/some/path/foo.chrome"},
+	})
+}
+
+func testHostVulnSummariesBySoftwareIDsPage(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	hosts, err := ds.HostVulnSummariesBySoftwareIDsPage(ctx, []uint{0}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, hosts, 0)
+
+	insertVulnSoftwareForTest(t, ds)
+
+	allSoftware, _, err := ds.ListSoftware(ctx, fleet.SoftwareListOptions{})
+	require.NoError(t, err)
+
+	var softwareIDs []uint
+	var fooRPMID uint
+	for _, s := range allSoftware {
+		switch s.GenerateCPE {
+		case "cpe_foo_rpm", "cpe_foo_chrome_3", "cpe_bar_rpm":
+			softwareIDs = append(softwareIDs, s.ID)
+		}
+		if s.GenerateCPE == "cpe_foo_rpm" {
+			fooRPMID = s.ID
+		}
+	}
+	require.Len(t, softwareIDs, 3)
+
+	// the limit applies to hosts, all paths of a host are returned
+	hosts, err = ds.HostVulnSummariesBySoftwareIDsPage(ctx, softwareIDs, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, "host1", hosts[0].Hostname)
+	require.ElementsMatch(t, hosts[0].SoftwareInstalledPaths, []string{"/some/path/foo.rpm", "/some/path/foo.chrome"})
+
+	hosts, err = ds.HostVulnSummariesBySoftwareIDsPage(ctx, softwareIDs, hosts[0].ID, 1)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	require.Equal(t, "host2", hosts[0].Hostname)
+	require.ElementsMatch(t, hosts[0].SoftwareInstalledPaths, []string{"/some/path/bar.rpm", "/some/path/foo.chrome"})
+
+	hosts, err = ds.HostVulnSummariesBySoftwareIDsPage(ctx, softwareIDs, hosts[0].ID, 1)
+	require.NoError(t, err)
+	require.Empty(t, hosts)
+
+	// pages return the same hosts as the unpaged method
+	all, err := ds.HostVulnSummariesBySoftwareIDs(ctx, softwareIDs)
+	require.NoError(t, err)
+	hosts, err = ds.HostVulnSummariesBySoftwareIDsPage(ctx, softwareIDs, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, hosts, len(all))
+	for i := range all {
+		require.Equal(t, all[i].ID, hosts[i].ID)
+		require.ElementsMatch(t, all[i].SoftwareInstalledPaths, hosts[i].SoftwareInstalledPaths)
+		require.ElementsMatch(t, all[i].Software, hosts[i].Software)
+	}
+
+	// the hosts are counted once whatever their number of software
+	count, err := ds.CountHostVulnSummariesBySoftwareIDs(ctx, softwareIDs)
+	require.NoError(t, err)
+	require.Equal(t, len(all), count)
+	count, err = ds.CountHostVulnSummariesBySoftwareIDs(ctx, []uint{0})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// the hosts of a FreeScout report can be recorded and counted by software
+	_, err = ds.CountFreeScoutReportedHostsBySoftwareIDs(ctx, "vuln:CVE-0001", softwareIDs)
+	require.True(t, fleet.IsNotFound(err))
+	report := &fleet.FreeScoutReport{Key: "vuln:CVE-0001", ReportedAt: time.Now().UTC()}
+	require.NoError(t, ds.SetFreeScoutReportBySoftwareIDs(ctx, report, []uint{fooRPMID}))
+	count, err = ds.CountFreeScoutReportedHostsBySoftwareIDs(ctx, "vuln:CVE-0001", softwareIDs)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	count, err = ds.CountFreeScoutReportedHosts(ctx, "vuln:CVE-0001", []uint{all[0].ID, all[1].ID})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, ds.SetFreeScoutReportBySoftwareIDs(ctx, report, softwareIDs))
+	count, err = ds.CountFreeScoutReportedHostsBySoftwareIDs(ctx, "vuln:CVE-0001", softwareIDs)
+	require.NoError(t, err)
+	require.Equal(t, len(all), count)
 }
 
 // testUpdateHostSoftwareUpdatesSoftware tests that uninstalling applications
@@ -2669,6 +2811,39 @@ func testListCVEs(t *testing.T, ds *Datastore) {
 	require.ElementsMatch(t, expected, actual)
 }
 
+func testGetCVEMeta(t *testing.T, ds *Datastore) {
+	ctx := context.Background()
+
+	_, err := ds.GetCVEMeta(ctx, "cve-1")
+	require.True(t, fleet.IsNotFound(err))
+
+	published := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = ds.InsertCVEMeta(ctx, []fleet.CVEMeta{
+		{CVE: "cve-1", CVSSScore: ptr.Float64(7.5), CVSSVersion: ptr.String("3.1"), EPSSProbability: ptr.Float64(0.5), CISAKnownExploit: ptr.Bool(true), Published: &published, Description: "cve-1 description"},
+		{CVE: "cve-2"},
+	})
+	require.NoError(t, err)
+
+	meta, err := ds.GetCVEMeta(ctx, "cve-1")
+	require.NoError(t, err)
+	require.Equal(t, "cve-1", meta.CVE)
+	require.Equal(t, ptr.Float64(7.5), meta.CVSSScore)
+	require.Equal(t, ptr.String("3.1"), meta.CVSSVersion)
+	require.Equal(t, ptr.Float64(0.5), meta.EPSSProbability)
+	require.Equal(t, ptr.Bool(true), meta.CISAKnownExploit)
+	require.NotNil(t, meta.Published)
+	require.True(t, published.Equal(*meta.Published))
+	require.Equal(t, "cve-1 description", meta.Description)
+
+	meta, err = ds.GetCVEMeta(ctx, "cve-2")
+	require.NoError(t, err)
+	require.Nil(t, meta.CVSSScore)
+	require.Nil(t, meta.CVSSVersion)
+	require.Nil(t, meta.EPSSProbability)
+	require.Nil(t, meta.CISAKnownExploit)
+	require.Nil(t, meta.Published)
+}
+
 func testListSoftwareForVulnDetection(t *testing.T, ds *Datastore) {
 	t.Run("returns software without CPE entries", func(t *testing.T) {
 		ctx := context.Background()
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"reflect"
 	"regexp"
+	"slices"
 	"sort"
 	"time"
 
@@ -774,6 +775,25 @@ func (c *AppConfig) Copy() *AppConfig {
 		clone.Integrations.Freescout = make([]*FreeScoutIntegration, len(c.Integrations.Freescout))
 		for i, f := range c.Integrations.Freescout {
 			freescout := *f
+			freescout.CVEAllowlist = slices.Clone(f.CVEAllowlist)
+			freescout.CVEDenylist = slices.Clone(f.CVEDenylist)
+			freescout.SeverityMailboxes = maps.Clone(f.SeverityMailboxes)
+			freescout.SeverityAssignees = maps.Clone(f.SeverityAssignees)
+			freescout.Headers = maps.Clone(f.Headers)
+			freescout.PriorityTags = maps.Clone(f.PriorityTags)
+			freescout.MailboxRateLimits = maps.Clone(f.MailboxRateLimits)
+			freescout.CustomFields = maps.Clone(f.CustomFields)
+			freescout.VulnHostTeamIDs = slices.Clone(f.VulnHostTeamIDs)
+			freescout.VulnHostLabelIDs = slices.Clone(f.VulnHostLabelIDs)
+			freescout.CVEReferences = slices.Clone(f.CVEReferences)
+			if f.PolicyAssignees != nil {
+				pa := *f.PolicyAssignees
+				freescout.PolicyAssignees = &pa
+			}
+			if f.Templates != nil {
+				templates := *f.Templates
+				freescout.Templates = &templates
+			}
 			clone.Integrations.Freescout[i] = &freescout
 		}
 	}
@@ -321,6 +321,79 @@ func TestFeaturesCopy(t *testing.T) {
 	})
 }
 
+func TestAppConfigCopyFreeScout(t *testing.T) {
+	t.Run("reference fields", func(t *testing.T) {
+		newIntegration := func() *FreeScoutIntegration {
+			return &FreeScoutIntegration{
+				PolicyAssignees:   &FreeScoutPolicyAssignees{Critical: 1, Normal: 2},
+				CVEAllowlist:      []string{"CVE-2024-0001"},
+				CVEDenylist:       []string{"CVE-2024-0002"},
+				SeverityMailboxes: map[string]int64{"critical": 1},
+				SeverityAssignees: map[string]int64{"critical": 2},
+				MailboxRateLimits: map[int64]FreeScoutRateLimit{1: {Conversations: 10}},
+				PriorityTags:      map[string]string{FreeScoutPriorityHigh: "urgent"},
+				CustomFields:      map[int64]string{1: "value"},
+				Templates:         &FreeScoutTemplates{VulnSummary: "vuln"},
+				Headers:           map[string]string{"X-Header": "value"},
+				CVEReferences:     []FreeScoutCVEReference{{Label: "ref", URLTemplate: "https://example.com/{{ .CVE }}"}},
+				VulnHostTeamIDs:   []uint{1},
+				VulnHostLabelIDs:  []uint{2},
+			}
+		}
+		original := newIntegration()
+
+		// every reference-typed field is set, so that new ones must be added
+		// here and deep-copied.
+		v := reflect.ValueOf(original).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			switch f := v.Field(i); f.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Pointer:
+				require.False(t, f.IsNil(), v.Type().Field(i).Name)
+			}
+		}
+
+		c := &AppConfig{Integrations: Integrations{Freescout: []*FreeScoutIntegration{original}}}
+		clone := c.Copy().Integrations.Freescout[0]
+		require.NotSame(t, original, clone)
+		require.Equal(t, original, clone)
+
+		clone.PolicyAssignees.Critical = 10
+		clone.CVEAllowlist[0] = "changed"
+		clone.CVEDenylist[0] = "changed"
+		clone.SeverityMailboxes["critical"] = 10
+		clone.SeverityAssignees["critical"] = 10
+		clone.MailboxRateLimits[1] = FreeScoutRateLimit{Conversations: 100}
+		clone.PriorityTags[FreeScoutPriorityHigh] = "changed"
+		clone.CustomFields[1] = "changed"
+		clone.Templates.VulnSummary = "changed"
+		clone.Headers["X-Header"] = "changed"
+		clone.CVEReferences[0].Label = "changed"
+		clone.VulnHostTeamIDs[0] = 10
+		clone.VulnHostLabelIDs[0] = 10
+		require.Equal(t, newIntegration(), original)
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		c := &AppConfig{Integrations: Integrations{Freescout: []*FreeScoutIntegration{{
+			Templates: &FreeScoutTemplates{VulnSummary: "vuln"},
+		}}}}
+		clone := c.Copy()
+		require.NotSame(t, c.Integrations.Freescout[0].Templates, clone.Integrations.Freescout[0].Templates)
+		clone.Integrations.Freescout[0].Templates.VulnSummary = "changed"
+		require.Equal(t, "vuln", c.Integrations.Freescout[0].Templates.VulnSummary)
+	})
+
+	t.Run("policy assignees", func(t *testing.T) {
+		c := &AppConfig{Integrations: Integrations{Freescout: []*FreeScoutIntegration{{
+			PolicyAssignees: &FreeScoutPolicyAssignees{Critical: 1, Normal: 2},
+		}}}}
+		clone := c.Copy()
+		require.NotSame(t, c.Integrations.Freescout[0].PolicyAssignees, clone.Integrations.Freescout[0].PolicyAssignees)
+		clone.Integrations.Freescout[0].PolicyAssignees.Critical = 3
+		require.EqualValues(t, 1, c.Integrations.Freescout[0].PolicyAssignees.Critical)
+	})
+}
+
 func TestMDMUrl(t *testing.T) {
 	cases := []struct {
 		name      string
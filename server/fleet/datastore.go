@@ -668,8 +668,21 @@ type Datastore interface {
 	// returns a list of all hosts that have at least one software suceptible to the provided CVE.
 	// Includes the path were the software was installed.
 	HostsByCVE(ctx context.Context, cve string) ([]HostVulnerabilitySummary, error)
+	// CVEDetectedAtByHostIDs returns, keyed by host ID, the earliest time the CVE was detected on
+	// the software of each of the provided hosts. Hosts without software susceptible to the CVE are
+	// not included.
+	CVEDetectedAtByHostIDs(ctx context.Context, cve string, hostIDs []uint) (map[uint]time.Time, error)
+	// FilterHostIDsByTeamsOrLabels returns the subset of the provided host IDs that belong to one of
+	// the teams or are members of one of the labels. Team ID 0 matches hosts without a team.
+	FilterHostIDsByTeamsOrLabels(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error)
+	// HostIssuesByHostIDs returns the issues (failing policies and critical vulnerabilities counts)
+	// of the provided hosts, keyed by host ID. Hosts without recorded issues are not included.
+	HostIssuesByHostIDs(ctx context.Context, hostIDs []uint) (map[uint]HostIssues, error)
 	InsertCVEMeta(ctx context.Context, cveMeta []CVEMeta) error
 	ListCVEs(ctx context.Context, maxAge time.Duration) ([]CVEMeta, error)
+	// GetCVEMeta returns the metadata of the CVE, or a not found error if
+	// there is none.
+	GetCVEMeta(ctx context.Context, cve string) (*CVEMeta, error)
 
 	ListHostSoftware(ctx context.Context, host *Host, opts HostSoftwareTitleListOptions) ([]*HostSoftwareWithInstaller, *PaginationMetadata, error)
 
@@ -1150,6 +1163,43 @@ type Datastore interface {
 	// GetJob returns a job from the database
 	GetJob(ctx context.Context, jobID uint) (*Job, error)
 
+	// InsertFreeScoutDigestEvents adds the events, as the args of the
+	// FreeScout jobs that would have reported them, to the pending digest of
+	// the team (0 for the global scope). It returns true if the team had no
+	// pending event before the insert.
+	InsertFreeScoutDigestEvents(ctx context.Context, teamID uint, args []json.RawMessage) (bool, error)
+
+	// ListFreeScoutDigestEvents returns up to limit pending digest events of
+	// the team, in the order they were inserted.
+	ListFreeScoutDigestEvents(ctx context.Context, teamID uint, limit int) ([]*FreeScoutDigestEvent, error)
+
+	// DeleteFreeScoutDigestEvents deletes the digest events with the provided
+	// IDs, once they have been reported.
+	DeleteFreeScoutDigestEvents(ctx context.Context, ids []uint) error
+
+	// GetFreeScoutVulnReportedHostsCount returns the number of affected hosts
+	// of the CVE in its last FreeScout report. It returns a NotFoundError if
+	// the CVE was never reported.
+	GetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string) (int, error)
+
+	// SetFreeScoutVulnReportedHostsCount records the number of affected hosts
+	// of the CVE in its last FreeScout report.
+	SetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string, count int) error
+
+	// CountFreeScoutReportedHosts returns the number of hostIDs that are among
+	// the hosts of the last FreeScout report under key, a report key of the
+	// FreeScout worker. It returns a NotFoundError if nothing was reported
+	// under that key.
+	CountFreeScoutReportedHosts(ctx context.Context, key string, hostIDs []uint) (int, error)
+
+	// GetFreeScoutReport returns the last FreeScout report under key. It
+	// returns a NotFoundError if nothing was reported under that key.
+	GetFreeScoutReport(ctx context.Context, key string) (*FreeScoutReport, error)
+
+	// SetFreeScoutReport records the report, along with hostIDs, which must be
+	// unique, as its hosts, as the last FreeScout report under its key.
+	SetFreeScoutReport(ctx context.Context, report *FreeScoutReport, hostIDs []uint) error
+
 	///////////////////////////////////////////////////////////////////////////////
 	// Debug
 
@@ -2733,6 +2783,33 @@ type Cloner interface {
 	Clone() (Cloner, error)
 }
 
+// HostVulnSummariesPager is optionally implemented by datastores that can
+// return the hosts affected by vulnerable software one page at a time, and
+// count them or record them as the hosts of a FreeScout report without
+// returning them, so that callers don't need to load all of them in memory.
+// The MySQL Datastore satisfies this interface.
+type HostVulnSummariesPager interface {
+	// HostVulnSummariesBySoftwareIDsPage returns up to limit hosts with an ID
+	// greater than afterHostID that have at least one of the specified software
+	// installed, ordered by host ID. Includes the paths were the software was
+	// installed.
+	HostVulnSummariesBySoftwareIDsPage(ctx context.Context, softwareIDs []uint, afterHostID uint, limit int) ([]HostVulnerabilitySummary, error)
+
+	// CountHostVulnSummariesBySoftwareIDs returns the number of hosts that
+	// have at least one of the specified software installed.
+	CountHostVulnSummariesBySoftwareIDs(ctx context.Context, softwareIDs []uint) (int, error)
+
+	// CountFreeScoutReportedHostsBySoftwareIDs is like
+	// Datastore.CountFreeScoutReportedHosts for the hosts that have at least
+	// one of the specified software installed.
+	CountFreeScoutReportedHostsBySoftwareIDs(ctx context.Context, key string, softwareIDs []uint) (int, error)
+
+	// SetFreeScoutReportBySoftwareIDs is like Datastore.SetFreeScoutReport
+	// with the hosts that have at least one of the specified software
+	// installed as the hosts of the report.
+	SetFreeScoutReportBySoftwareIDs(ctx context.Context, report *FreeScoutReport, softwareIDs []uint) error
+}
+
 const (
 	// Default batch size to use for ScheduledQueryIDsByName.
 	DefaultScheduledQueryIDsByNameBatchSize = 1000
@@ -1347,6 +1347,16 @@ type HostVulnerabilitySummary struct {
 	DisplayName string `json:"display_name" db:"display_name"`
 	// SoftwareInstalledPaths paths of vulnerable software installed on the host.
 	SoftwareInstalledPaths []string `json:"software_installed_paths,omitempty" db:"software_installed_paths"`
+	// Software the vulnerable software installed on the host, with one entry per installed path.
+	Software []HostVulnerableSoftware `json:"software,omitempty" db:"-"`
+}
+
+// HostVulnerableSoftware is a vulnerable software installed on a host, InstalledPath is empty
+// if the path is unknown.
+type HostVulnerableSoftware struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	InstalledPath string `json:"installed_path,omitempty"`
 }
 
 func (hvs *HostVulnerabilitySummary) AddSoftwareInstalledPath(p string) {
@@ -1355,6 +1365,14 @@ func (hvs *HostVulnerabilitySummary) AddSoftwareInstalledPath(p string) {
 	}
 }
 
+// AddSoftware adds a vulnerable software installed at path p, it is ignored if its name is
+// unknown.
+func (hvs *HostVulnerabilitySummary) AddSoftware(name, version, p string) {
+	if name != "" {
+		hvs.Software = append(hvs.Software, HostVulnerableSoftware{Name: name, Version: version, InstalledPath: p})
+	}
+}
+
 type OSVersions struct {
 	CountsUpdatedAt time.Time   `json:"counts_updated_at"`
 	OSVersions      []OSVersion `json:"os_versions"`
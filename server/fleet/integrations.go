@@ -4,9 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/mail"
 	"net/url"
+	"path"
+	"reflect"
 	"strconv"
 	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/optjson"
 	"github.com/fleetdm/fleet/v4/server/service/externalsvc"
@@ -55,6 +61,10 @@ func (ti TeamIntegrations) Copy() TeamIntegrations {
 		for i, f := range ti.Freescout {
 			if f != nil {
 				freescoutCopy := *f
+				if f.Templates != nil {
+					templatesCopy := *f.Templates
+					freescoutCopy.Templates = &templatesCopy
+				}
 				result.Freescout[i] = &freescoutCopy
 			}
 		}
@@ -122,6 +132,23 @@ func (ti TeamIntegrations) MatchWithIntegrations(globalIntgs Integrations) (Inte
 			continue
 		}
 		intg.EnableFailingPolicies = tmFreeScout.EnableFailingPolicies
+		if tmFreeScout.MinFailingPolicyHosts > 0 {
+			intg.MinFailingPolicyHosts = tmFreeScout.MinFailingPolicyHosts
+		}
+		intg.Templates = tmFreeScout.Templates.layeredOver(intg.Templates)
+		if locale := strings.TrimSpace(tmFreeScout.Locale); locale != "" {
+			intg.Locale = locale
+		}
+		if email := strings.TrimSpace(tmFreeScout.CustomerEmail); email != "" {
+			// the team's explicit email takes precedence over the template
+			// and the failing policies email.
+			intg.CustomerEmail = email
+			intg.TeamCustomerEmailTemplate = ""
+			intg.FailingPoliciesCustomerEmail = ""
+		}
+		// both generations only increase, so that their sum changes with
+		// either the global or the team's settings.
+		intg.Generation += tmFreeScout.Generation
 		result.Freescout = append(result.Freescout, &intg)
 	}
 
@@ -158,6 +185,14 @@ func (ti TeamIntegrations) Validate() error {
 			return fmt.Errorf("duplicate FreeScout integration for url %s and mailbox ID %v", f.URL, f.MailboxID)
 		}
 		freescout[key] = f
+		if err := f.Templates.validate(); err != nil {
+			return fmt.Errorf("FreeScout integration for url %s and mailbox ID %v: %w", f.URL, f.MailboxID, err)
+		}
+		if email := strings.TrimSpace(f.CustomerEmail); email != "" {
+			if err := validateFreeScoutEmail(email); err != nil {
+				return fmt.Errorf("FreeScout integration for url %s and mailbox ID %v: %w", f.URL, f.MailboxID, err)
+			}
+		}
 	}
 	return nil
 }
@@ -194,6 +229,125 @@ type TeamFreeScoutIntegration struct {
 	URL                   string `json:"url"`
 	MailboxID             int64  `json:"mailbox_id"`
 	EnableFailingPolicies bool   `json:"enable_failing_policies"`
+	// MinFailingPolicyHosts overrides the minimum number of failing hosts of
+	// the global integration for the team's policies, if greater than 0.
+	MinFailingPolicyHosts int `json:"min_failing_policy_hosts,omitempty"`
+	// Templates override the templates of the global integration for the
+	// team's conversations, those that are empty are inherited.
+	Templates *FreeScoutTemplates `json:"templates,omitempty"`
+	// CustomerEmail overrides the customer email of the global integration
+	// for the team's conversations, e.g. with the team's distribution list.
+	// The global TeamCustomerEmailTemplate is not used if it is set.
+	CustomerEmail string `json:"customer_email,omitempty"`
+	// Locale overrides the locale of the global integration for the team's
+	// conversations, if set.
+	Locale string `json:"locale,omitempty"`
+	// Generation is incremented by Fleet each time the team's settings of the
+	// integration are modified, like FreeScoutIntegration.Generation. It is
+	// managed by Fleet, the value set in requests is ignored.
+	Generation int64 `json:"generation"`
+}
+
+// FreeScoutCustomerEmailTplArgs are the arguments with which
+// FreeScoutIntegration.TeamCustomerEmailTemplate is rendered.
+type FreeScoutCustomerEmailTplArgs struct {
+	TeamID   uint
+	TeamName string
+}
+
+// TeamCustomerEmail returns the customer email of the conversations of the
+// team: the rendered TeamCustomerEmailTemplate if it is set, otherwise
+// CustomerEmail. It returns an error if the template fails to render or does
+// not render a valid email address.
+func (f FreeScoutIntegration) TeamCustomerEmail(teamID uint, teamName string) (string, error) {
+	if f.TeamCustomerEmailTemplate == "" {
+		return f.CustomerEmail, nil
+	}
+	tpl, err := template.New("").Option("missingkey=error").Parse(f.TeamCustomerEmailTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid team customer email template: %w", err)
+	}
+	var b strings.Builder
+	if err := tpl.Execute(&b, FreeScoutCustomerEmailTplArgs{TeamID: teamID, TeamName: teamName}); err != nil {
+		return "", fmt.Errorf("render team customer email template: %w", err)
+	}
+	email := strings.TrimSpace(b.String())
+	if err := validateFreeScoutEmail(email); err != nil {
+		return "", fmt.Errorf("team customer email template: %w", err)
+	}
+	return email, nil
+}
+
+// validateFreeScoutEmail returns an error if email is not a bare email
+// address.
+func validateFreeScoutEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid customer email %q", email)
+	}
+	return nil
+}
+
+// FreeScoutTemplates are the Go templates overriding the built-in ones used
+// to render the failing policy and vulnerability conversations, those that
+// are empty use the built-in template. They are rendered with the same
+// arguments as the built-in templates, and only apply to the conversations of
+// a single policy or CVE, not to batched policies nor digests.
+//
+// The summary templates have access to the severity band of the CVE
+// (.Severity, e.g. "critical"), the name of the policy's team (.TeamName,
+// "Global" for a global policy) and the number of hosts (.HostsCount). As a
+// custom subject may change between reports, e.g. with the number of hosts,
+// VulnSummary requires FreeScoutIntegration.IdentifierTags so that the
+// existing conversation of the CVE is still found by its tag. VulnSummary is
+// only used from the global integration, as the vulnerabilities are not
+// reported per team.
+type FreeScoutTemplates struct {
+	FailingPolicySummary     string `json:"failing_policy_summary,omitempty"`
+	FailingPolicyDescription string `json:"failing_policy_description,omitempty"`
+	VulnSummary              string `json:"vuln_summary,omitempty"`
+}
+
+// layeredOver returns the templates resulting of t overriding the non-empty
+// templates of base. Either of them may be nil.
+func (t *FreeScoutTemplates) layeredOver(base *FreeScoutTemplates) *FreeScoutTemplates {
+	if t == nil {
+		return base
+	}
+	var res FreeScoutTemplates
+	if base != nil {
+		res = *base
+	}
+	if t.FailingPolicySummary != "" {
+		res.FailingPolicySummary = t.FailingPolicySummary
+	}
+	if t.FailingPolicyDescription != "" {
+		res.FailingPolicyDescription = t.FailingPolicyDescription
+	}
+	if t.VulnSummary != "" {
+		res.VulnSummary = t.VulnSummary
+	}
+	return &res
+}
+
+// validate checks the syntax of the templates. The functions they use are
+// not checked, as they are only known to the worker that renders them.
+func (t *FreeScoutTemplates) validate() error {
+	if t == nil {
+		return nil
+	}
+	for name, text := range map[string]string{
+		"failing policy summary":     t.FailingPolicySummary,
+		"failing policy description": t.FailingPolicyDescription,
+		"vuln summary":               t.VulnSummary,
+	} {
+		tree := parse.New(name)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(text, "", "", make(map[string]*parse.Tree)); err != nil {
+			return fmt.Errorf("invalid %s template: %w", name, err)
+		}
+	}
+	return nil
 }
 
 // UniqueKey returns the unique key of this integration.
@@ -201,6 +355,23 @@ func (f TeamFreeScoutIntegration) UniqueKey() string {
 	return f.URL + "\n" + strconv.FormatInt(f.MailboxID, 10)
 }
 
+// SetTeamFreeScoutGenerations sets the generation of the new team FreeScout
+// integrations: that of the original integration with the same key if it is
+// unchanged, incremented otherwise.
+func SetTeamFreeScoutGenerations(oriIntgs, newIntgs []*TeamFreeScoutIntegration) {
+	oriIndexed := make(map[string]TeamFreeScoutIntegration, len(oriIntgs))
+	for _, intg := range oriIntgs {
+		oriIndexed[intg.UniqueKey()] = *intg
+	}
+	for _, new := range newIntgs {
+		old, exists := oriIndexed[new.UniqueKey()]
+		new.Generation = old.Generation
+		if !exists || !reflect.DeepEqual(old, *new) {
+			new.Generation = old.Generation + 1
+		}
+	}
+}
+
 type TeamGoogleCalendarIntegration struct {
 	Enable     bool   `json:"enable_calendar_events"`
 	WebhookURL string `json:"webhook_url"`
@@ -431,6 +602,27 @@ func makeTestZendeskRequest(ctx context.Context, intg *ZendeskIntegration) error
 	return nil
 }
 
+// FreeScoutPolicyAssignees are the FreeScout users to whom the failing policy
+// conversations are assigned depending on the criticality of the policies. A
+// conversation reporting several policies is critical if one of them is.
+type FreeScoutPolicyAssignees struct {
+	// Critical is the assignee of the critical policies, they are left
+	// unassigned if it is 0.
+	Critical int64 `json:"critical"`
+	// Normal is the assignee of the other policies, they are left unassigned
+	// if it is 0.
+	Normal int64 `json:"normal"`
+}
+
+// AssignTo returns the assignee of the policies of that criticality, 0 if
+// they are left unassigned.
+func (a FreeScoutPolicyAssignees) AssignTo(critical bool) int64 {
+	if critical {
+		return a.Critical
+	}
+	return a.Normal
+}
+
 // FreeScoutIntegration configures an instance of an integration with the FreeScout system.
 type FreeScoutIntegration struct {
 	URL                           string `json:"url"`
@@ -440,8 +632,727 @@ type FreeScoutIntegration struct {
 	AssignTo                      int64  `json:"assign_to"`
 	EnableFailingPolicies         bool   `json:"enable_failing_policies"`
 	EnableSoftwareVulnerabilities bool   `json:"enable_software_vulnerabilities"`
+	// TeamCustomerEmailTemplate is a Go template rendering the customer email
+	// of the team conversations, e.g. "team-{{ .TeamID }}@example.com", for the
+	// teams that do not set their own customer email. It is rendered with the
+	// FreeScoutCustomerEmailTplArgs of the team. CustomerEmail is used if it
+	// is empty.
+	TeamCustomerEmailTemplate string `json:"team_customer_email_template,omitempty"`
+	// VulnCustomerEmail and FailingPoliciesCustomerEmail override
+	// CustomerEmail for the vulnerability and the failing policy
+	// conversations respectively, e.g. to route them to the security and the
+	// IT teams. VulnAssignTo and FailingPoliciesAssignTo likewise override
+	// AssignTo. The customer email of a team takes precedence over
+	// FailingPoliciesCustomerEmail for the team's conversations.
+	VulnCustomerEmail            string `json:"vuln_customer_email,omitempty"`
+	VulnAssignTo                 int64  `json:"vuln_assign_to,omitempty"`
+	FailingPoliciesCustomerEmail string `json:"failing_policies_customer_email,omitempty"`
+	FailingPoliciesAssignTo      int64  `json:"failing_policies_assign_to,omitempty"`
+	// PolicyAssignees assigns the failing policy conversations depending on
+	// the criticality of the policies, e.g. the critical ones to a senior
+	// engineer. It takes precedence over FailingPoliciesAssignTo and
+	// AssignTo when set.
+	PolicyAssignees *FreeScoutPolicyAssignees `json:"policy_assignees,omitempty"`
+	// FolderID is the FreeScout folder in which the created conversations
+	// are placed, e.g. a triage folder. The mailbox's default folder is used
+	// if it is 0.
+	FolderID int64 `json:"folder_id,omitempty"`
+	// AuthMode is how the API token is sent to FreeScout, "apikey" (the
+	// default) in the X-FreeScout-API-Key header, or "bearer" in the
+	// Authorization header.
+	AuthMode string `json:"auth_mode,omitempty"`
+	// BatchFailingPolicies groups all failing policies of a team that are
+	// processed in the same run in a single conversation, instead of creating
+	// one conversation per policy.
+	BatchFailingPolicies bool `json:"batch_failing_policies"`
+	// FailingPoliciesHostIDsOnly stores only the IDs of the failing hosts in
+	// the queued failing policy jobs, instead of their names, which are
+	// fetched when the job is processed. It keeps the jobs of the policies
+	// failing on many hosts small, and their names up to date. It does not
+	// apply to the batched failing policies nor to the digests.
+	FailingPoliciesHostIDsOnly bool `json:"failing_policies_host_ids_only"`
+	// DigestInterval, if set, reports the new vulnerabilities and failing
+	// policies in a single digest conversation per team every interval (e.g.
+	// "24h"), instead of creating one conversation per event. The events are
+	// stored until the digest is created.
+	DigestInterval Duration `json:"digest_interval"`
+	// AllowEmptyConversations creates the conversations whose summary or
+	// description renders empty or as whitespace only. Such a job fails by
+	// default, as it is most likely caused by a misconfigured template
+	// override.
+	AllowEmptyConversations bool `json:"allow_empty_conversations"`
+	// TeamNameInSummary prefixes the summary of failing policy conversations
+	// with the name of the policy's team, or "Global" for global policies, so
+	// that policies with the same name in different teams are reported in
+	// different conversations.
+	TeamNameInSummary bool `json:"team_name_in_summary"`
+	// HostLinkLabel is the host identifier used as text of the host links in
+	// the conversations, one of the FreeScoutHostLinkLabel* values. Defaults
+	// to the host's display name if empty.
+	HostLinkLabel string `json:"host_link_label,omitempty"`
+	// HostsRenderStyle is how the affected hosts are rendered in the
+	// vulnerability and failing policy conversations, one of the
+	// FreeScoutHostsRenderStyle* values. Defaults to a bulleted list if empty.
+	// The table style takes precedence over CompactHostPaths.
+	HostsRenderStyle string `json:"hosts_render_style,omitempty"`
+	// CompactHostPaths groups the hosts that have the same vulnerable software
+	// installed paths under a single listing of those paths in vulnerability
+	// conversations, instead of listing the paths of each host.
+	CompactHostPaths bool `json:"compact_host_paths"`
+	// VulnSummaryHeader renders a compact block of the key facts of the CVE
+	// (CVSS score, EPSS probability, known exploit and number of hosts) at
+	// the top of the vulnerability conversations, for quick triage.
+	VulnSummaryHeader bool `json:"vuln_summary_header"`
+	// VulnSoftwareNames lists the name and version of the vulnerable software
+	// under each host of the vulnerability conversations, along with its
+	// installed path, instead of only the paths. Hosts for which the software
+	// is unknown are still listed with their paths only.
+	VulnSoftwareNames bool `json:"vuln_software_names"`
+	// VulnHideSoftwareInstructions omits the instructions to view the affected
+	// software and more affected hosts in Fleet at the bottom of the
+	// vulnerability conversations.
+	VulnHideSoftwareInstructions bool `json:"vuln_hide_software_instructions"`
+	// TruncationNotice renders a "Showing 50 of N hosts" line with a link to
+	// all the affected hosts in Fleet below the host lists of the
+	// vulnerability and failing policy conversations that do not list all
+	// the hosts, see VulnHostsPathTemplate.
+	TruncationNotice bool `json:"truncation_notice"`
+	// VulnHostsTrend renders the number of affected hosts of a CVE in its last
+	// report next to the current number in the vulnerability conversations,
+	// with an up or down indicator, e.g. "42 (was 50 last report) ▼". The
+	// number of hosts of each report is stored by CVE.
+	VulnHostsTrend bool `json:"vuln_hosts_trend"`
+	// EPSSLabelMode renders a qualitative exploit likelihood label ("Very
+	// High", "High", "Moderate" or "Low") derived from the EPSS probability of
+	// the CVE, one of the FreeScoutEPSSLabel* values: after the probability or
+	// instead of it. No label is rendered if empty. EPSSThresholds are the
+	// minimum probabilities of the labels.
+	EPSSLabelMode  string                  `json:"epss_label_mode,omitempty"`
+	EPSSThresholds FreeScoutEPSSThresholds `json:"epss_thresholds"`
+	// EPSSRenderThreshold and CVSSRenderThreshold omit the EPSS probability
+	// and the CVSS score lines of the vulnerability conversations when they
+	// are below those thresholds, e.g. 0.01 to only render the probabilities
+	// of at least 1%. The CVEs below them are still reported, only those
+	// lines are omitted. All values are rendered if they are 0.
+	EPSSRenderThreshold float64 `json:"epss_render_threshold,omitempty"`
+	CVSSRenderThreshold float64 `json:"cvss_render_threshold,omitempty"`
+	// HostRiskWeights lists the hosts of the vulnerability conversations by
+	// decreasing risk score, so that the hosts listed when there are more than
+	// fit in a conversation are the riskiest ones. The hosts are listed in no
+	// particular order if no weight is set.
+	HostRiskWeights FreeScoutHostRiskWeights `json:"host_risk_weights"`
+	// AttachCVEMetadata attaches the metadata of the CVE (CVSS score, EPSS
+	// probability, etc.) as a JSON file to the vulnerability conversations,
+	// for downstream automations. MaxAttachmentBytes caps the size of the
+	// attachments, 1 MiB by default.
+	AttachCVEMetadata  bool `json:"attach_cve_metadata"`
+	MaxAttachmentBytes int  `json:"max_attachment_bytes,omitempty"`
+	// CVEAllowlist, if not empty, restricts the vulnerability conversations to
+	// the CVEs matching one of its entries. CVEDenylist prevents conversations
+	// for the CVEs matching one of its entries, and takes precedence over the
+	// allowlist. Entries are CVE IDs or glob patterns such as "CVE-2019-*".
+	CVEAllowlist []string `json:"cve_allowlist,omitempty"`
+	CVEDenylist  []string `json:"cve_denylist,omitempty"`
+	// SeverityMailboxes maps CVSS severity bands ("critical", "high",
+	// "medium", "low", "none" or "unknown") to the mailbox in which the
+	// vulnerability conversations of that band are created. MailboxID is used
+	// for the bands that are not mapped.
+	SeverityMailboxes map[string]int64 `json:"severity_mailboxes,omitempty"`
+	// SeverityAssignees maps CVSS severity bands, as SeverityMailboxes, to
+	// the user to whom the vulnerability conversations of that band are
+	// assigned, e.g. the critical and high ones to the security on-call, 0
+	// leaving them unassigned. The "unknown" band is the assignee of the CVEs
+	// without a CVSS score. It takes precedence over VulnAssignTo and AssignTo
+	// for the bands that are mapped.
+	SeverityAssignees map[string]int64 `json:"severity_assignees,omitempty"`
+	// MailboxRateLimits limits the rate at which conversations are created or
+	// appended to in each mailbox, keyed by mailbox ID, so that a burst of
+	// conversations in one mailbox does not exhaust the limits of another.
+	// MailboxRateLimit is the limit of the mailboxes that are not listed, no
+	// limit applies if it is unset. The jobs wait for the limit rather than
+	// fail.
+	MailboxRateLimit  FreeScoutRateLimit           `json:"mailbox_rate_limit"`
+	MailboxRateLimits map[int64]FreeScoutRateLimit `json:"mailbox_rate_limits,omitempty"`
+	// PriorityTags maps the priority of vulnerability conversations, one of
+	// the FreeScoutPriority* values, to the tag added to them when they are
+	// created. The priority is high for the CVEs in CISA's known exploited
+	// vulnerabilities catalog and normal otherwise. No tag is added for the
+	// priorities that are not mapped. Tags require the FreeScout Tags module.
+	PriorityTags map[string]string `json:"priority_tags,omitempty"`
+	// IdentifierTags tags the conversations of a single CVE or policy with a
+	// machine-readable identifier, e.g. "fleet-cve:CVE-2024-1234", by which
+	// the existing conversation is searched first, so that it is still found
+	// after a change of its subject (e.g. of the templates or of the number of
+	// hosts). The conversations without the tag are still found by their
+	// subject. Tags require the FreeScout Tags module.
+	IdentifierTags bool `json:"identifier_tags"`
+	// VulnResolvedAction is what is done with the existing conversation of a
+	// CVE that no longer affects any host when its job is processed, one of
+	// the FreeScoutVulnResolved* values: a message stating that it is no
+	// longer detected is appended to it, and it is also closed with
+	// FreeScoutVulnResolvedClose. The job is skipped if it is empty. The
+	// conversation is found by its identifier tag, so IdentifierTags is
+	// required, and it is closed on behalf of the NoteUserID user, or else of
+	// the AssignTo user.
+	VulnResolvedAction string `json:"vuln_resolved_action,omitempty"`
+	// OrgNameLocation annotates the conversations with the organization name
+	// of the Fleet instance, for mailboxes shared by several Fleet tenants. It
+	// is one of the FreeScoutOrgNameIn* values: a prefix of the subject, a tag
+	// (requires the FreeScout Tags module) or the custom field identified by
+	// OrgNameCustomFieldID (requires the Custom Fields module). Conversations
+	// are not annotated if it is empty. As existing conversations are found
+	// by subject, a change to the subject prefix starts new conversations.
+	OrgNameLocation      string `json:"org_name_location,omitempty"`
+	OrgNameCustomFieldID int64  `json:"org_name_custom_field_id,omitempty"`
+	// CustomFields maps the ID of a custom field (requires the Custom Fields
+	// module) to the value it is set to on the newly created conversations.
+	// Values are Go templates rendered with the same arguments as the
+	// conversation's templates, e.g. "{{ .CVE }}" for vulnerabilities, so a
+	// static value is a template without actions. The fields rendered empty
+	// are not set.
+	CustomFields map[int64]string `json:"custom_fields,omitempty"`
+	// SearchStatus and SearchState are the status and state of the existing
+	// conversation to which a message is appended instead of creating a new
+	// conversation. They default to "active" and "published" respectively.
+	SearchStatus string `json:"search_status,omitempty"`
+	SearchState  string `json:"search_state,omitempty"`
+	// SearchSortField and SearchSortOrder order the existing conversations
+	// searched for, the message being appended to the first one. They default
+	// to "updatedAt" and "asc", i.e. the least recently updated conversation;
+	// set SearchSortOrder to "desc" to append to the most recently active one.
+	SearchSortField string `json:"search_sort_field,omitempty"`
+	SearchSortOrder string `json:"search_sort_order,omitempty"`
+	// SearchDecodeRetries is the number of times the search for an existing
+	// conversation is retried when FreeScout returns a malformed body, e.g.
+	// during a deployment. SearchDecodeErrorPolicy then either fails the job
+	// ("fail", the default) or creates a new conversation, possibly a
+	// duplicate ("create").
+	SearchDecodeRetries     int    `json:"search_decode_retries,omitempty"`
+	SearchDecodeErrorPolicy string `json:"search_decode_error_policy,omitempty"`
+	// AppendNotFoundPolicy applies when the existing conversation is deleted
+	// between the search and the appending of the message: it either fails
+	// the job ("fail", the default) or creates a new conversation ("create").
+	AppendNotFoundPolicy string `json:"append_not_found_policy,omitempty"`
+	// SearchIndexRetries is the number of times the search for an existing
+	// conversation is retried, waiting SearchIndexDelay (1s by default) before
+	// each retry, when it does not find a conversation that this Fleet
+	// instance created with the same subject in the last few minutes. It
+	// prevents duplicates when FreeScout's search index lags behind the
+	// creation of the conversations.
+	SearchIndexRetries int      `json:"search_index_retries,omitempty"`
+	SearchIndexDelay   Duration `json:"search_index_delay"`
+	// RetryAfterBlockThreshold is the longest Retry-After of a FreeScout rate
+	// limit response for which the request is retried while the job waits
+	// (10s by default). The job is re-queued to run after a longer one
+	// instead, freeing its worker.
+	RetryAfterBlockThreshold Duration `json:"retry_after_block_threshold"`
+	// TransportRetries is the number of times a FreeScout request is retried
+	// when it fails with a transient network error, e.g. a timeout, a reset
+	// connection or a temporary DNS failure, but not an unknown host name. The
+	// requests that create conversations or threads are only retried if they
+	// did not reach FreeScout, so that they are never made twice. The requests
+	// are not retried if it is 0, the default.
+	TransportRetries int `json:"transport_retries,omitempty"`
+	// ReassignOnAppend assigns an existing conversation to AssignTo when it is
+	// updated with a new message, instead of keeping its current assignee.
+	ReassignOnAppend bool `json:"reassign_on_append"`
+	// ConversationType is the FreeScout type of the created conversations,
+	// "email" (the default), "phone" or "chat". Conversations of a type other
+	// than email do not send email notifications to the customer.
+	ConversationType string `json:"conversation_type,omitempty"`
+	// Imported creates the conversations and the messages appended to them
+	// as imported in FreeScout, which does not send email notifications to
+	// the customer for them.
+	Imported bool `json:"imported"`
+	// InitialThreadType and AppendThreadType are the FreeScout types of the
+	// first message of a created conversation and of the messages appended to
+	// an existing one, "customer" (the default) or "note". Notes are internal,
+	// e.g. to triage an alert before notifying the customer with the
+	// follow-ups, and are created on behalf of the NoteUserID user. If both
+	// are notes, CustomerEmail is optional and the conversations are created
+	// without a customer if it is empty.
+	InitialThreadType string `json:"initial_thread_type,omitempty"`
+	AppendThreadType  string `json:"append_thread_type,omitempty"`
+	NoteUserID        int64  `json:"note_user_id,omitempty"`
+	// FromName is the display name of the customer of the created
+	// conversations, e.g. "Fleet Security", shown by FreeScout as their
+	// sender. The customer's name is left unchanged if empty.
+	FromName string `json:"from_name,omitempty"`
+	// ConversationSource labels the source of the created conversations,
+	// e.g. "Fleet Security", to tell them apart from the conversations
+	// started by people in FreeScout's reports. No source is set if empty.
+	ConversationSource string `json:"conversation_source,omitempty"`
+	// Templates override the built-in templates of the conversations, they
+	// can be overridden by team.
+	Templates *FreeScoutTemplates `json:"templates,omitempty"`
+	// Locale is the language in which the static text of the built-in
+	// vulnerability, failing policy and digest templates is rendered, e.g.
+	// "fr" or "de-CH", it can be overridden by team. English is used if it is
+	// empty or if there is no translation for it.
+	Locale string `json:"locale,omitempty"`
+	// MaxDescriptionBytes is the maximum size of a conversation's description,
+	// hosts and paths are omitted from larger descriptions until they fit. A
+	// default close to FreeScout's limit is used if it is 0.
+	MaxDescriptionBytes int `json:"max_description_bytes,omitempty"`
+	// MaxSubjectLength is the maximum number of characters of a conversation's
+	// subject, longer subjects are truncated with an ellipsis. FreeScout's
+	// limit is used if it is 0.
+	MaxSubjectLength int `json:"max_subject_length,omitempty"`
+	// Headers are additional HTTP headers sent on every request to FreeScout,
+	// e.g. as required by an API gateway in front of it.
+	Headers map[string]string `json:"headers,omitempty"`
+	// JobIDHeader, if set, is the HTTP header in which the ID of the worker
+	// job that triggered a request is sent to FreeScout, for end-to-end
+	// tracing.
+	JobIDHeader string `json:"job_id_header,omitempty"`
+	// DumpPayloads logs the JSON body of the requests creating conversations
+	// and threads at the debug level, with the API token redacted, to
+	// diagnose the payloads rejected by FreeScout. RedactCustomerEmailInDumps
+	// also redacts the customer email from the logged bodies.
+	DumpPayloads               bool `json:"dump_payloads"`
+	RedactCustomerEmailInDumps bool `json:"redact_customer_email_in_dumps"`
+	// VerifyTokenScopes checks that the API token can read the mailbox and
+	// read and create conversations in it before a client is first used, so
+	// that a token with insufficient scopes fails with a clear error instead
+	// of on its first conversation.
+	VerifyTokenScopes bool `json:"verify_token_scopes"`
+	// MailboxTypeCheck checks that the type of the mailbox is compatible with
+	// ConversationType before a client is first used, e.g. that an "email"
+	// conversation is not created in a chat mailbox: "warn" logs a warning
+	// and "fail" fails the jobs and the integration test. It is not checked
+	// if empty.
+	MailboxTypeCheck string `json:"mailbox_type_check,omitempty"`
+	// MaxIdleConns, MaxIdleConnsPerHost, MaxConnsPerHost and IdleConnTimeout
+	// tune the connection pool used to make requests to FreeScout, to match
+	// its capacity. Defaults are used for those that are 0, MaxConnsPerHost
+	// being unlimited by default. A change replaces the cached clients, like
+	// a change of the other settings.
+	MaxIdleConns        int      `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     int      `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout     Duration `json:"idle_conn_timeout"`
+	// VulnJobsSpreadWindow spreads the vulnerability jobs queued after a scan
+	// over that window instead of running them all at once, according to
+	// VulnJobsSpreadDistribution (one of the FreeScoutSpread* values, evenly
+	// spaced if empty).
+	VulnJobsSpreadWindow       Duration `json:"vuln_jobs_spread_window"`
+	VulnJobsSpreadDistribution string   `json:"vuln_jobs_spread_distribution,omitempty"`
+	// ExternalFleetURL is the Fleet URL used for the links in the
+	// conversations, for when agents reach Fleet through a different URL than
+	// the server URL. The server URL is used if it is empty.
+	ExternalFleetURL string `json:"external_fleet_url,omitempty"`
+	// HostPathTemplate and PolicyHostsPathTemplate are Go templates of the
+	// paths, appended to the Fleet URL, of the links to a host and to the
+	// hosts failing a policy, for deployments with a customized routing, e.g.
+	// behind a path-prefixed reverse proxy. The host path is rendered with the
+	// host's ID, e.g. "/fleet/hosts/{{ .ID }}", and the policy hosts path with
+	// the policy's PolicyID and TeamID (nil for global policies). The built-in
+	// paths are used if empty. VulnHostsPathTemplate is the path of the link
+	// to the hosts affected by a CVE of the TruncationNotice, rendered with
+	// the CVE.
+	HostPathTemplate        string `json:"host_path_template,omitempty"`
+	PolicyHostsPathTemplate string `json:"policy_hosts_path_template,omitempty"`
+	VulnHostsPathTemplate   string `json:"vuln_hosts_path_template,omitempty"`
+	// CVEReferences are the additional links to the CVE, e.g. to the vendor
+	// advisories or an internal wiki, rendered after the link to the CVE in
+	// NVD in a "References" section of the vulnerability conversations. Only
+	// the NVD link is rendered if empty.
+	CVEReferences []FreeScoutCVEReference `json:"cve_references,omitempty"`
+	// DetectedAfterPublished and DetectedLookback restrict the hosts reported
+	// in vulnerability conversations to those on which the CVE was detected
+	// after it was published, and within that duration before the job runs,
+	// respectively. The latest of the two cutoffs applies, the hosts are not
+	// filtered if neither is set.
+	DetectedAfterPublished bool     `json:"detected_after_published"`
+	DetectedLookback       Duration `json:"detected_lookback"`
+	// RequireCVSSV3 only creates vulnerability conversations for the CVEs
+	// that have a CVSS v3 score, as scores of other versions are not
+	// comparable.
+	RequireCVSSV3 bool `json:"require_cvss_v3"`
+	// MinFailingPolicyHosts is the minimum number of hosts a policy must be
+	// failing on to be reported, policies failing on fewer hosts are skipped.
+	// Teams can override it.
+	MinFailingPolicyHosts int `json:"min_failing_policy_hosts,omitempty"`
+	// ReportCooldown prevents reporting again a CVE or a failing policy with
+	// the same affected hosts as its last report, if that report was made
+	// less than that duration ago. It is disabled if 0.
+	ReportCooldown Duration `json:"report_cooldown"`
+	// JobDeadline is the maximum time spent processing a single job, across
+	// all of its requests to FreeScout, after which the job fails. A default
+	// of a few minutes is used if it is 0.
+	JobDeadline Duration `json:"job_deadline"`
+	// MaxConcurrentJobs is the maximum number of jobs processed concurrently
+	// against the FreeScout instance at URL, shared by all the integrations
+	// with that URL, e.g. the tenants of a shared instance. The smallest limit
+	// of those integrations applies to all of them. A default of a few jobs
+	// is used if it is 0.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs,omitempty"`
+	// VulnHostTeamIDs and VulnHostLabelIDs restrict the hosts reported in
+	// vulnerability conversations to those of one of the teams (0 being no
+	// team) or members of one of the labels. No conversation is created for a
+	// CVE if none of its hosts is in scope. All hosts are reported if both are
+	// empty.
+	VulnHostTeamIDs  []uint `json:"vuln_host_team_ids,omitempty"`
+	VulnHostLabelIDs []uint `json:"vuln_host_label_ids,omitempty"`
+	// Paused temporarily stops the creation of conversations without having
+	// to remove the integration's configuration. The jobs processed while it
+	// is paused are skipped, and creation resumes when it is cleared.
+	Paused bool `json:"paused"`
+	// Drain discards the queued jobs of the integration types that are
+	// disabled, e.g. after a misconfiguration flooded the queue: they are
+	// marked as processed without creating any conversation, along with the
+	// pending digest events, so that the backlog is not reported once they
+	// are enabled again. Without it, the queued jobs of a disabled
+	// integration type are handled as configured by the worker, which may
+	// re-queue them.
+	Drain bool `json:"drain"`
+	// Generation is incremented by Fleet each time the integration's
+	// configuration is modified, so that the jobs can detect that it changed
+	// while they were processed. It is managed by Fleet, the value set in
+	// requests is ignored. The integration of a team has the sum of this
+	// generation and of TeamFreeScoutIntegration.Generation.
+	Generation int64 `json:"generation"`
+	// QuietHours defers the vulnerability conversations that are not critical
+	// to the end of a daily window, e.g. overnight.
+	QuietHours FreeScoutQuietHours `json:"quiet_hours"`
+}
+
+// TransportOptions returns the options of the FreeScout client's transport
+// as configured by the integration.
+func (f FreeScoutIntegration) TransportOptions() externalsvc.FreeScoutTransportOptions {
+	return externalsvc.FreeScoutTransportOptions{
+		MaxIdleConns:        f.MaxIdleConns,
+		MaxIdleConnsPerHost: f.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     f.MaxConnsPerHost,
+		IdleConnTimeout:     f.IdleConnTimeout.Duration,
+	}
+}
+
+// AllowsCVE returns true if vulnerability conversations can be created for
+// the CVE as configured by the integration's CVEAllowlist and CVEDenylist. A
+// CVE that matches the denylist is never allowed, even if it also matches the
+// allowlist. If the allowlist is empty, all CVEs that are not denied are
+// allowed.
+func (f FreeScoutIntegration) AllowsCVE(cve string) bool {
+	if matchesCVEPattern(f.CVEDenylist, cve) {
+		return false
+	}
+	return len(f.CVEAllowlist) == 0 || matchesCVEPattern(f.CVEAllowlist, cve)
+}
+
+// matchesCVEPattern returns true if the CVE matches one of the patterns, CVE
+// IDs being case-insensitive. Invalid patterns never match, they are rejected
+// when the integration is validated.
+func matchesCVEPattern(patterns []string, cve string) bool {
+	cve = strings.ToUpper(cve)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(strings.TrimSpace(pattern)), cve); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// The supported values of FreeScoutIntegration.VulnJobsSpreadDistribution.
+const (
+	FreeScoutSpreadEven   = "even"
+	FreeScoutSpreadRandom = "random"
+)
+
+// The priorities of vulnerability conversations, see
+// FreeScoutIntegration.PriorityTags.
+const (
+	FreeScoutPriorityHigh   = "high"
+	FreeScoutPriorityNormal = "normal"
+)
+
+// The supported values of FreeScoutIntegration.OrgNameLocation.
+const (
+	FreeScoutOrgNameInSubject     = "subject"
+	FreeScoutOrgNameInTag         = "tag"
+	FreeScoutOrgNameInCustomField = "custom_field"
+)
+
+// FreeScoutRateLimit is a maximum number of FreeScout conversations per
+// interval, see FreeScoutIntegration.MailboxRateLimits.
+type FreeScoutRateLimit struct {
+	// Conversations is the maximum number of conversations in Interval, the
+	// conversations are not limited if it is 0.
+	Conversations int `json:"conversations,omitempty"`
+	// Interval defaults to a minute if it is 0.
+	Interval Duration `json:"interval"`
 }
 
+// MailboxRateLimitOf returns the rate limit of the conversations in the
+// mailbox.
+func (f FreeScoutIntegration) MailboxRateLimitOf(mailboxID int64) FreeScoutRateLimit {
+	if limit, ok := f.MailboxRateLimits[mailboxID]; ok {
+		return limit
+	}
+	return f.MailboxRateLimit
+}
+
+func (l FreeScoutRateLimit) validate() error {
+	if l.Conversations < 0 || l.Interval.Duration < 0 {
+		return errors.New("rate limit must not be negative")
+	}
+	return nil
+}
+
+// The supported values of FreeScoutIntegration.EPSSLabelMode.
+const (
+	FreeScoutEPSSLabelAppend  = "append"
+	FreeScoutEPSSLabelReplace = "replace"
+)
+
+// FreeScoutCVEReference is a link to a CVE in the vulnerability
+// conversations. URLTemplate is a Go template of the URL rendered with the
+// CVE, e.g. "https://github.com/advisories?query={{ urlquery .CVE }}".
+type FreeScoutCVEReference struct {
+	Label       string `json:"label"`
+	URLTemplate string `json:"url_template"`
+}
+
+// FreeScoutCVEReferenceTplArgs are the arguments with which
+// FreeScoutCVEReference.URLTemplate is rendered.
+type FreeScoutCVEReferenceTplArgs struct {
+	CVE string
+}
+
+// URL renders the URL of the reference for the CVE. It returns an error if
+// the template fails to render or does not render an absolute HTTP(S) URL.
+func (r FreeScoutCVEReference) URL(cve string) (string, error) {
+	tpl, err := template.New("").Option("missingkey=error").Parse(r.URLTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid CVE reference %q URL template: %w", r.Label, err)
+	}
+	var b strings.Builder
+	if err := tpl.Execute(&b, FreeScoutCVEReferenceTplArgs{CVE: cve}); err != nil {
+		return "", fmt.Errorf("render CVE reference %q URL template: %w", r.Label, err)
+	}
+	rendered := strings.TrimSpace(b.String())
+	u, err := url.Parse(rendered)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("CVE reference %q URL template must render an absolute HTTP(S) URL, got %q", r.Label, rendered)
+	}
+	return rendered, nil
+}
+
+// validate checks that the reference has a label and that its URL template
+// renders a valid URL.
+func (r FreeScoutCVEReference) validate() error {
+	if strings.TrimSpace(r.Label) == "" {
+		return errors.New("CVE reference label is required")
+	}
+	_, err := r.URL("CVE-2024-1234")
+	return err
+}
+
+// FreeScoutEPSSThresholds are the minimum EPSS probabilities, between 0 and 1,
+// of the exploit likelihood labels of FreeScoutIntegration.EPSSLabelMode, the
+// probabilities below Moderate being labeled "Low". The defaults are used for
+// the thresholds that are 0.
+type FreeScoutEPSSThresholds struct {
+	VeryHigh float64 `json:"very_high,omitempty"`
+	High     float64 `json:"high,omitempty"`
+	Moderate float64 `json:"moderate,omitempty"`
+}
+
+// Default values of FreeScoutEPSSThresholds.
+const (
+	defaultFreeScoutEPSSVeryHigh = 0.5
+	defaultFreeScoutEPSSHigh     = 0.1
+	defaultFreeScoutEPSSModerate = 0.01
+)
+
+// withDefaults returns the thresholds with the defaults applied.
+func (t FreeScoutEPSSThresholds) withDefaults() FreeScoutEPSSThresholds {
+	if t.VeryHigh == 0 {
+		t.VeryHigh = defaultFreeScoutEPSSVeryHigh
+	}
+	if t.High == 0 {
+		t.High = defaultFreeScoutEPSSHigh
+	}
+	if t.Moderate == 0 {
+		t.Moderate = defaultFreeScoutEPSSModerate
+	}
+	return t
+}
+
+func (t FreeScoutEPSSThresholds) validate() error {
+	for _, v := range []float64{t.VeryHigh, t.High, t.Moderate} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("EPSS threshold %v must be between 0 and 1", v)
+		}
+	}
+	t = t.withDefaults()
+	if t.VeryHigh < t.High || t.High < t.Moderate {
+		return fmt.Errorf("EPSS thresholds must be in decreasing order, got very high %v, high %v and moderate %v", t.VeryHigh, t.High, t.Moderate)
+	}
+	return nil
+}
+
+// Label returns the exploit likelihood label of the EPSS probability p.
+func (t FreeScoutEPSSThresholds) Label(p float64) string {
+	t = t.withDefaults()
+	switch {
+	case p >= t.VeryHigh:
+		return "Very High"
+	case p >= t.High:
+		return "High"
+	case p >= t.Moderate:
+		return "Moderate"
+	default:
+		return "Low"
+	}
+}
+
+// FreeScoutHostRiskWeights are the weights of the risk score of a host
+// affected by a CVE, which is the sum of each of its counts multiplied by its
+// weight: the number of critical vulnerabilities and of failing policies of
+// the host, and the number of installations of the CVE's vulnerable software
+// on the host.
+type FreeScoutHostRiskWeights struct {
+	CriticalVulnerabilities float64 `json:"critical_vulnerabilities,omitempty"`
+	FailingPolicies         float64 `json:"failing_policies,omitempty"`
+	VulnerableSoftware      float64 `json:"vulnerable_software,omitempty"`
+}
+
+// Enabled returns true if any of the weights is set.
+func (w FreeScoutHostRiskWeights) Enabled() bool {
+	return w != FreeScoutHostRiskWeights{}
+}
+
+func (w FreeScoutHostRiskWeights) validate() error {
+	for _, v := range []float64{w.CriticalVulnerabilities, w.FailingPolicies, w.VulnerableSoftware} {
+		if v < 0 {
+			return fmt.Errorf("host risk weight %v must not be negative", v)
+		}
+	}
+	return nil
+}
+
+// FreeScoutQuietHours is a daily window, from Start to End in the Timezone
+// (UTC if empty), during which the vulnerability jobs are deferred to the end
+// of the window. Start and End are in the "HH:MM" format, the window wraps
+// to the next day if End is before Start, and it is disabled if both are
+// empty. The critical vulnerabilities bypass it: those with a CVSS score of
+// at least BypassCVSSScore (9.0 if 0) and, unless DeferKnownExploited is set,
+// those in CISA's known exploited vulnerabilities catalog.
+type FreeScoutQuietHours struct {
+	Start               string  `json:"start,omitempty"`
+	End                 string  `json:"end,omitempty"`
+	Timezone            string  `json:"timezone,omitempty"`
+	BypassCVSSScore     float64 `json:"bypass_cvss_score,omitempty"`
+	DeferKnownExploited bool    `json:"defer_known_exploited"`
+}
+
+// defaultFreeScoutQuietHoursBypassCVSSScore is the minimum CVSS score of the
+// vulnerabilities that bypass the quiet hours, i.e. the critical ones.
+const defaultFreeScoutQuietHoursBypassCVSSScore = 9.0
+
+// Enabled returns true if the quiet hours are configured.
+func (q FreeScoutQuietHours) Enabled() bool {
+	return q.Start != "" || q.End != ""
+}
+
+func (q FreeScoutQuietHours) validate() error {
+	if !q.Enabled() {
+		return nil
+	}
+	start, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return fmt.Errorf("invalid quiet hours start %q: must be in the HH:MM format", q.Start)
+	}
+	end, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return fmt.Errorf("invalid quiet hours end %q: must be in the HH:MM format", q.End)
+	}
+	if start.Equal(end) {
+		return errors.New("quiet hours start and end must be different")
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("invalid quiet hours timezone %q: %w", q.Timezone, err)
+	}
+	if q.BypassCVSSScore < 0 || q.BypassCVSSScore > 10 {
+		return fmt.Errorf("quiet hours bypass CVSS score %v must be between 0 and 10", q.BypassCVSSScore)
+	}
+	return nil
+}
+
+// Bypass returns true if the vulnerability with the CVSS score and the CISA
+// known exploit flag, either of which may be nil if unknown, is critical
+// enough to bypass the quiet hours.
+func (q FreeScoutQuietHours) Bypass(cvssScore *float64, knownExploit *bool) bool {
+	if knownExploit != nil && *knownExploit && !q.DeferKnownExploited {
+		return true
+	}
+	threshold := q.BypassCVSSScore
+	if threshold == 0 {
+		threshold = defaultFreeScoutQuietHoursBypassCVSSScore
+	}
+	return cvssScore != nil && *cvssScore >= threshold
+}
+
+// WindowEnd returns the end of the quiet hours window that now is in, and
+// false if it is not in the window or if the quiet hours are disabled.
+func (q FreeScoutQuietHours) WindowEnd(now time.Time) (time.Time, bool, error) {
+	if !q.Enabled() {
+		return time.Time{}, false, nil
+	}
+	startHM, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("parse quiet hours start: %w", err)
+	}
+	endHM, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("parse quiet hours end: %w", err)
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("load quiet hours timezone: %w", err)
+	}
+
+	now = now.In(loc)
+	y, m, d := now.Date()
+	start := time.Date(y, m, d, startHM.Hour(), startHM.Minute(), 0, 0, loc)
+	end := time.Date(y, m, d, endHM.Hour(), endHM.Minute(), 0, 0, loc)
+	if start.Before(end) {
+		if !now.Before(start) && now.Before(end) {
+			return end, true, nil
+		}
+		return time.Time{}, false, nil
+	}
+	// the window wraps to the next day.
+	if now.Before(end) {
+		return end, true, nil
+	}
+	if !now.Before(start) {
+		return end.AddDate(0, 0, 1), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// The supported values of FreeScoutIntegration.HostLinkLabel.
+const (
+	FreeScoutHostLinkLabelDisplayName = "display_name"
+	FreeScoutHostLinkLabelHostname    = "hostname"
+	FreeScoutHostLinkLabelSerial      = "serial"
+	FreeScoutHostLinkLabelUUID        = "uuid"
+)
+
+// The supported values of FreeScoutIntegration.VulnResolvedAction.
+const (
+	FreeScoutVulnResolvedNote  = "note"
+	FreeScoutVulnResolvedClose = "close"
+)
+
+// The supported values of FreeScoutIntegration.HostsRenderStyle.
+const (
+	FreeScoutHostsRenderStyleList  = "list"
+	FreeScoutHostsRenderStyleTable = "table"
+)
+
 func (f FreeScoutIntegration) uniqueKey() string {
 	return f.URL + "\n" + strconv.FormatInt(f.MailboxID, 10)
 }
@@ -481,8 +1392,11 @@ func ValidateFreeScoutIntegrations(ctx context.Context, oriFreeScoutIntgsIndexed
 		newIndexed[key] = new
 
 		// check if existing integration is being edited
-		if old, ok := oriFreeScoutIntgsIndexed[key]; ok {
-			if old == *new {
+		old, exists := oriFreeScoutIntgsIndexed[key]
+		if exists {
+			// the generation is managed by Fleet, keep the stored one.
+			new.Generation = old.Generation
+			if reflect.DeepEqual(old, *new) {
 				// no further validation for unchanged integration
 				continue
 			}
@@ -498,6 +1412,9 @@ func ValidateFreeScoutIntegrations(ctx context.Context, oriFreeScoutIntgsIndexed
 		if err := makeTestFreeScoutRequest(ctx, new); err != nil {
 			return nil, fmt.Errorf("FreeScout integration at index %d: %w", i, err)
 		}
+		if !exists || !reflect.DeepEqual(old, *new) {
+			new.Generation = old.Generation + 1
+		}
 	}
 
 	// collect any deleted integration
@@ -518,20 +1435,257 @@ func makeTestFreeScoutRequest(ctx context.Context, intg *FreeScoutIntegration) e
 	if intg.MailboxID <= 0 {
 		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: mailbox ID must be greater than 0")}
 	}
-	if intg.CustomerEmail == "" {
-		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: customer email is required")}
+	// only the conversations of customer threads require a customer email,
+	// those of notes are created without a customer if it is not set.
+	notesOnly := intg.InitialThreadType == externalsvc.FreeScoutThreadTypeNote && intg.AppendThreadType == externalsvc.FreeScoutThreadTypeNote
+	if intg.CustomerEmail == "" && !notesOnly {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: customer email is required for customer threads")}
+	}
+	intg.VulnCustomerEmail = strings.TrimSpace(intg.VulnCustomerEmail)
+	intg.FailingPoliciesCustomerEmail = strings.TrimSpace(intg.FailingPoliciesCustomerEmail)
+	for _, email := range []string{intg.VulnCustomerEmail, intg.FailingPoliciesCustomerEmail} {
+		if email == "" {
+			continue
+		}
+		if err := validateFreeScoutEmail(email); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+		}
+	}
+	if intg.VulnAssignTo < 0 || intg.FailingPoliciesAssignTo < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: assignees must not be negative")}
+	}
+	if pa := intg.PolicyAssignees; pa != nil && (pa.Critical < 0 || pa.Normal < 0) {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: policy assignees must not be negative")}
+	}
+	if intg.FolderID < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: folder ID must not be negative")}
+	}
+	switch intg.HostLinkLabel {
+	case "", FreeScoutHostLinkLabelDisplayName, FreeScoutHostLinkLabelHostname,
+		FreeScoutHostLinkLabelSerial, FreeScoutHostLinkLabelUUID:
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported host link label %q", intg.HostLinkLabel)}
+	}
+	switch intg.HostsRenderStyle {
+	case "", FreeScoutHostsRenderStyleList, FreeScoutHostsRenderStyleTable:
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported hosts render style %q", intg.HostsRenderStyle)}
+	}
+	switch intg.VulnResolvedAction {
+	case "":
+	case FreeScoutVulnResolvedNote, FreeScoutVulnResolvedClose:
+		if !intg.IdentifierTags {
+			return IntegrationTestError{Err: errors.New("FreeScout integration request failed: identifier tags are required for the vulnerability resolved action")}
+		}
+		if intg.VulnResolvedAction == FreeScoutVulnResolvedClose && intg.NoteUserID <= 0 && intg.AssignTo <= 0 {
+			return IntegrationTestError{Err: errors.New("FreeScout integration request failed: a note user or an assignee is required to close resolved conversations")}
+		}
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported vulnerability resolved action %q", intg.VulnResolvedAction)}
+	}
+	switch intg.EPSSLabelMode {
+	case "", FreeScoutEPSSLabelAppend, FreeScoutEPSSLabelReplace:
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported EPSS label mode %q", intg.EPSSLabelMode)}
+	}
+	if err := intg.EPSSThresholds.validate(); err != nil {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+	}
+	if intg.EPSSRenderThreshold < 0 || intg.EPSSRenderThreshold > 1 {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: EPSS render threshold %v must be between 0 and 1", intg.EPSSRenderThreshold)}
+	}
+	if intg.CVSSRenderThreshold < 0 || intg.CVSSRenderThreshold > 10 {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: CVSS render threshold %v must be between 0 and 10", intg.CVSSRenderThreshold)}
+	}
+	if err := intg.HostRiskWeights.validate(); err != nil {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+	}
+	if intg.MaxConcurrentJobs < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: max concurrent jobs must not be negative")}
+	}
+	if err := intg.QuietHours.validate(); err != nil {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+	}
+	switch intg.VulnJobsSpreadDistribution {
+	case "", FreeScoutSpreadEven, FreeScoutSpreadRandom:
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported vulnerability jobs spread distribution %q", intg.VulnJobsSpreadDistribution)}
+	}
+	if intg.VulnJobsSpreadWindow.Duration < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: vulnerability jobs spread window must not be negative")}
+	}
+	if intg.ExternalFleetURL != "" {
+		u, err := url.Parse(intg.ExternalFleetURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: invalid external Fleet URL %q", intg.ExternalFleetURL)}
+		}
+	}
+	if intg.MinFailingPolicyHosts < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: minimum failing policy hosts must not be negative")}
+	}
+	if intg.DetectedLookback.Duration < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: detected lookback must not be negative")}
+	}
+	if intg.ReportCooldown.Duration < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: report cooldown must not be negative")}
+	}
+	if intg.JobDeadline.Duration < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: job deadline must not be negative")}
+	}
+	if intg.DigestInterval.Duration < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: digest interval must not be negative")}
+	}
+	if intg.MaxDescriptionBytes < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: max description bytes must not be negative")}
+	}
+	if intg.MaxSubjectLength < 0 {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: max subject length must not be negative")}
+	}
+	for severity, mailboxID := range intg.SeverityMailboxes {
+		switch severity {
+		case "critical", "high", "medium", "low", "none", "unknown":
+		default:
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported severity %q in severity mailboxes", severity)}
+		}
+		if mailboxID <= 0 {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: mailbox ID for severity %q must be greater than 0", severity)}
+		}
+	}
+	for severity, userID := range intg.SeverityAssignees {
+		switch severity {
+		case "critical", "high", "medium", "low", "none", "unknown":
+		default:
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported severity %q in severity assignees", severity)}
+		}
+		if userID < 0 {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: assignee for severity %q must not be negative", severity)}
+		}
+	}
+	if err := intg.MailboxRateLimit.validate(); err != nil {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: mailbox %w", err)}
+	}
+	for mailboxID, limit := range intg.MailboxRateLimits {
+		if mailboxID <= 0 {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: invalid mailbox ID %d in mailbox rate limits", mailboxID)}
+		}
+		if err := limit.validate(); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: mailbox %d %w", mailboxID, err)}
+		}
+	}
+	for priority, tag := range intg.PriorityTags {
+		switch priority {
+		case FreeScoutPriorityHigh, FreeScoutPriorityNormal:
+		default:
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported priority %q in priority tags", priority)}
+		}
+		if strings.TrimSpace(tag) == "" {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: tag for priority %q is required", priority)}
+		}
+	}
+	switch intg.OrgNameLocation {
+	case "", FreeScoutOrgNameInSubject, FreeScoutOrgNameInTag:
+	case FreeScoutOrgNameInCustomField:
+		if intg.OrgNameCustomFieldID <= 0 {
+			return IntegrationTestError{Err: errors.New("FreeScout integration request failed: org name custom field ID must be greater than 0")}
+		}
+	default:
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: unsupported org name location %q", intg.OrgNameLocation)}
+	}
+	if err := intg.Templates.validate(); err != nil {
+		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+	}
+	if intg.Templates != nil && intg.Templates.VulnSummary != "" && !intg.IdentifierTags {
+		return IntegrationTestError{Err: errors.New("FreeScout integration request failed: identifier tags are required for the vuln summary template")}
+	}
+	for name, text := range map[string]string{
+		"host path":         intg.HostPathTemplate,
+		"policy hosts path": intg.PolicyHostsPathTemplate,
+		"vuln hosts path":   intg.VulnHostsPathTemplate,
+	} {
+		tree := parse.New(name)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(text, "", "", make(map[string]*parse.Tree)); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: invalid %s template: %w", name, err)}
+		}
+	}
+	for _, ref := range intg.CVEReferences {
+		if err := ref.validate(); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+		}
+	}
+	for fieldID, text := range intg.CustomFields {
+		if fieldID <= 0 {
+			return IntegrationTestError{Err: errors.New("FreeScout integration request failed: custom field ID must be greater than 0")}
+		}
+		if intg.OrgNameLocation == FreeScoutOrgNameInCustomField && fieldID == intg.OrgNameCustomFieldID {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: custom field %d is already set to the org name", fieldID)}
+		}
+		tree := parse.New("custom field")
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(text, "", "", make(map[string]*parse.Tree)); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: invalid custom field %d template: %w", fieldID, err)}
+		}
+	}
+	if intg.TeamCustomerEmailTemplate != "" {
+		// render it with an example team to check that it yields an email.
+		if _, err := intg.TeamCustomerEmail(1, "Example"); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+		}
+	}
+	for _, pattern := range append(append([]string(nil), intg.CVEAllowlist...), intg.CVEDenylist...) {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: invalid CVE pattern %q", pattern)}
+		}
 	}
 	client, err := externalsvc.NewFreeScoutClient(&externalsvc.FreeScoutOptions{
-		URL:           intg.URL,
-		APIToken:      intg.APIToken,
-		MailboxID:     intg.MailboxID,
-		CustomerEmail: intg.CustomerEmail,
-		AssignTo:      intg.AssignTo,
+		URL:                      intg.URL,
+		APIToken:                 intg.APIToken,
+		AuthMode:                 intg.AuthMode,
+		MailboxID:                intg.MailboxID,
+		CustomerEmail:            intg.CustomerEmail,
+		AssignTo:                 intg.AssignTo,
+		SearchStatus:             intg.SearchStatus,
+		SearchState:              intg.SearchState,
+		SearchSortField:          intg.SearchSortField,
+		SearchSortOrder:          intg.SearchSortOrder,
+		SearchDecodeRetries:      intg.SearchDecodeRetries,
+		SearchDecodeErrorPolicy:  intg.SearchDecodeErrorPolicy,
+		AppendNotFoundPolicy:     intg.AppendNotFoundPolicy,
+		SearchIndexRetries:       intg.SearchIndexRetries,
+		SearchIndexDelay:         intg.SearchIndexDelay.Duration,
+		RetryAfterBlockThreshold: intg.RetryAfterBlockThreshold.Duration,
+		TransportRetries:         intg.TransportRetries,
+		MaxAttachmentBytes:       intg.MaxAttachmentBytes,
+		ConversationType:         intg.ConversationType,
+		ReassignOnAppend:         intg.ReassignOnAppend,
+		Imported:                 intg.Imported,
+		InitialThreadType:        intg.InitialThreadType,
+		AppendThreadType:         intg.AppendThreadType,
+		NoteUserID:               intg.NoteUserID,
+		Source:                   intg.ConversationSource,
+		FromName:                 intg.FromName,
+		FolderID:                 intg.FolderID,
+		Headers:                  intg.Headers,
+		JobIDHeader:              intg.JobIDHeader,
+		DumpPayloads:             intg.DumpPayloads,
+		RedactCustomerEmail:      intg.RedactCustomerEmailInDumps,
+		MailboxTypeCheck:         intg.MailboxTypeCheck,
+		Transport:                intg.TransportOptions(),
 	})
 	if err != nil {
 		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
 	}
-	if _, err := client.CreateFreeScoutConversation(ctx, "Fleet integration test", "This is a test conversation from Fleet."); err != nil {
+	if intg.VerifyTokenScopes {
+		if err := client.CheckScopes(ctx); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+		}
+	}
+	if intg.MailboxTypeCheck == externalsvc.FreeScoutMailboxTypeCheckFail {
+		if err := client.CheckMailboxType(ctx); err != nil {
+			return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
+		}
+	}
+	if _, _, err := client.CreateFreeScoutConversation(ctx, "Fleet integration test", "This is a test conversation from Fleet."); err != nil {
 		return IntegrationTestError{Err: fmt.Errorf("FreeScout integration request failed: %w", err)}
 	}
 	return nil
@@ -0,0 +1,163 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamFreeScoutTemplates(t *testing.T) {
+	global := []*FreeScoutIntegration{{
+		URL: "https://freescout.example.com", MailboxID: 1,
+		Templates: &FreeScoutTemplates{FailingPolicySummary: "global summary", FailingPolicyDescription: "global description"},
+	}}
+
+	tmIntgs := TeamIntegrations{Freescout: []*TeamFreeScoutIntegration{
+		{URL: "https://freescout.example.com", MailboxID: 1, Templates: &FreeScoutTemplates{FailingPolicyDescription: "{{ md .PolicyName }}"}},
+	}}
+	require.NoError(t, tmIntgs.Validate())
+
+	// the team's templates are layered over the global ones
+	intgs, err := tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.Len(t, intgs.Freescout, 1)
+	require.Equal(t, &FreeScoutTemplates{FailingPolicySummary: "global summary", FailingPolicyDescription: "{{ md .PolicyName }}"}, intgs.Freescout[0].Templates)
+	require.Equal(t, "global description", global[0].Templates.FailingPolicyDescription)
+
+	// a team without templates inherits the global ones
+	tmIntgs.Freescout[0].Templates = nil
+	intgs, err = tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.Equal(t, global[0].Templates, intgs.Freescout[0].Templates)
+
+	// the templates' syntax is validated
+	tmIntgs.Freescout[0].Templates = &FreeScoutTemplates{FailingPolicyDescription: "{{ if .PolicyCritical }}"}
+	require.ErrorContains(t, tmIntgs.Validate(), "invalid failing policy description template")
+	tmIntgs.Freescout[0].Templates = &FreeScoutTemplates{VulnSummary: "{{ .CVE "}
+	require.ErrorContains(t, tmIntgs.Validate(), "invalid vuln summary template")
+
+	// the global vuln summary is inherited
+	global[0].Templates.VulnSummary = "[{{ .Severity }}] {{ .CVE }}"
+	tmIntgs.Freescout[0].Templates = &FreeScoutTemplates{FailingPolicySummary: "team summary"}
+	intgs, err = tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.Equal(t, &FreeScoutTemplates{FailingPolicySummary: "team summary", FailingPolicyDescription: "global description", VulnSummary: "[{{ .Severity }}] {{ .CVE }}"}, intgs.Freescout[0].Templates)
+}
+
+func TestTeamFreeScoutLocale(t *testing.T) {
+	global := []*FreeScoutIntegration{{URL: "https://freescout.example.com", MailboxID: 1, Locale: "fr"}}
+	tmIntgs := TeamIntegrations{Freescout: []*TeamFreeScoutIntegration{
+		{URL: "https://freescout.example.com", MailboxID: 1},
+	}}
+
+	// inherited from the global integration
+	intgs, err := tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.Equal(t, "fr", intgs.Freescout[0].Locale)
+
+	tmIntgs.Freescout[0].Locale = " de "
+	intgs, err = tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.Equal(t, "de", intgs.Freescout[0].Locale)
+	require.Equal(t, "fr", global[0].Locale)
+}
+
+func TestTeamFreeScoutCustomerEmail(t *testing.T) {
+	global := []*FreeScoutIntegration{{
+		URL: "https://freescout.example.com", MailboxID: 1,
+		CustomerEmail:             "fleet@example.com",
+		TeamCustomerEmailTemplate: "team-{{ .TeamID }}@support.example.com",
+	}}
+
+	// the template renders the team's email
+	email, err := global[0].TeamCustomerEmail(3, "Workstations")
+	require.NoError(t, err)
+	require.Equal(t, "team-3@support.example.com", email)
+
+	// a team without an explicit email keeps the template
+	tmIntgs := TeamIntegrations{Freescout: []*TeamFreeScoutIntegration{
+		{URL: "https://freescout.example.com", MailboxID: 1},
+	}}
+	require.NoError(t, tmIntgs.Validate())
+	intgs, err := tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	email, err = intgs.Freescout[0].TeamCustomerEmail(3, "Workstations")
+	require.NoError(t, err)
+	require.Equal(t, "team-3@support.example.com", email)
+
+	// an explicit team email takes precedence over the template
+	tmIntgs.Freescout[0].CustomerEmail = " workstations@example.com "
+	require.NoError(t, tmIntgs.Validate())
+	intgs, err = tmIntgs.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	email, err = intgs.Freescout[0].TeamCustomerEmail(3, "Workstations")
+	require.NoError(t, err)
+	require.Equal(t, "workstations@example.com", email)
+	require.Equal(t, "team-{{ .TeamID }}@support.example.com", global[0].TeamCustomerEmailTemplate)
+
+	// without a template, the global email is used
+	email, err = FreeScoutIntegration{CustomerEmail: "fleet@example.com"}.TeamCustomerEmail(3, "Workstations")
+	require.NoError(t, err)
+	require.Equal(t, "fleet@example.com", email)
+
+	// invalid emails are rejected
+	tmIntgs.Freescout[0].CustomerEmail = "Workstations <workstations@example.com>"
+	require.ErrorContains(t, tmIntgs.Validate(), "invalid customer email")
+	_, err = FreeScoutIntegration{TeamCustomerEmailTemplate: "{{ .TeamName }}@example.com"}.TeamCustomerEmail(3, "Work Stations")
+	require.ErrorContains(t, err, `invalid customer email "Work Stations@example.com"`)
+	_, err = FreeScoutIntegration{TeamCustomerEmailTemplate: "{{ .Team }}@example.com"}.TeamCustomerEmail(3, "Workstations")
+	require.ErrorContains(t, err, "render team customer email template")
+	_, err = FreeScoutIntegration{TeamCustomerEmailTemplate: "{{ .TeamID"}.TeamCustomerEmail(3, "Workstations")
+	require.ErrorContains(t, err, "invalid team customer email template")
+}
+
+func TestTeamFreeScoutGeneration(t *testing.T) {
+	global := []*FreeScoutIntegration{{URL: "https://freescout.example.com", MailboxID: 1, Generation: 3}}
+
+	// a new team integration starts at the first generation, the value of
+	// the request being ignored
+	tmIntgs := []*TeamFreeScoutIntegration{{URL: "https://freescout.example.com", MailboxID: 1, Generation: 42}}
+	SetTeamFreeScoutGenerations(nil, tmIntgs)
+	require.EqualValues(t, 1, tmIntgs[0].Generation)
+
+	// the integration of the team has the sum of the generations
+	intgs, err := TeamIntegrations{Freescout: tmIntgs}.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, intgs.Freescout[0].Generation)
+	require.EqualValues(t, 3, global[0].Generation)
+
+	// unchanged, the generation is kept
+	unchanged := []*TeamFreeScoutIntegration{{URL: "https://freescout.example.com", MailboxID: 1}}
+	SetTeamFreeScoutGenerations(tmIntgs, unchanged)
+	require.EqualValues(t, 1, unchanged[0].Generation)
+
+	// modified, it is incremented and so is that of the integration of the team
+	modified := []*TeamFreeScoutIntegration{{URL: "https://freescout.example.com", MailboxID: 1, Locale: "fr"}}
+	SetTeamFreeScoutGenerations(unchanged, modified)
+	require.EqualValues(t, 2, modified[0].Generation)
+	intgs, err = TeamIntegrations{Freescout: modified}.MatchWithIntegrations(Integrations{Freescout: global})
+	require.NoError(t, err)
+	require.EqualValues(t, 5, intgs.Freescout[0].Generation)
+}
+
+func TestFreeScoutCVEReference(t *testing.T) {
+	ref := FreeScoutCVEReference{Label: "GHSA", URLTemplate: "https://github.com/advisories?query={{ urlquery .CVE }}"}
+	require.NoError(t, ref.validate())
+	u, err := ref.URL("CVE-2024-1234")
+	require.NoError(t, err)
+	require.Equal(t, "https://github.com/advisories?query=CVE-2024-1234", u)
+
+	cases := []struct {
+		ref     FreeScoutCVEReference
+		wantErr string
+	}{
+		{FreeScoutCVEReference{URLTemplate: "https://example.com/{{ .CVE }}"}, "CVE reference label is required"},
+		{FreeScoutCVEReference{Label: "bad", URLTemplate: "https://example.com/{{ .CVE "}, `invalid CVE reference "bad" URL template`},
+		{FreeScoutCVEReference{Label: "missing", URLTemplate: "https://example.com/{{ .ID }}"}, `render CVE reference "missing" URL template`},
+		{FreeScoutCVEReference{Label: "relative", URLTemplate: "/advisories/{{ .CVE }}"}, "must render an absolute HTTP(S) URL"},
+		{FreeScoutCVEReference{Label: "scheme", URLTemplate: "javascript:alert('{{ .CVE }}')"}, "must render an absolute HTTP(S) URL"},
+	}
+	for _, c := range cases {
+		require.ErrorContains(t, c.ref.validate(), c.wantErr, c.ref.Label)
+	}
+}
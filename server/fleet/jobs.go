@@ -31,3 +31,28 @@ type Job struct {
 	Error     string           `json:"error" db:"error"`
 	NotBefore time.Time        `json:"not_before" db:"not_before"`
 }
+
+// FreeScoutDigestEvent is an event waiting to be reported in the next
+// FreeScout digest conversation of its team, when the FreeScout integration
+// is configured with a digest interval. Args are the args of the FreeScout
+// job that would have reported the event on its own.
+type FreeScoutDigestEvent struct {
+	ID uint `db:"id"`
+	// TeamID is 0 for the events of the global scope.
+	TeamID    uint            `db:"team_id"`
+	Args      json.RawMessage `db:"args"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// FreeScoutReport is the last FreeScout report of a CVE or failing policy,
+// keyed by the report key of the FreeScout worker. The IDs of its hosts are
+// stored along with it, see Datastore.CountFreeScoutReportedHosts.
+type FreeScoutReport struct {
+	Key string `db:"report_key"`
+	// ReportedAt is the time of the report, for the report cooldown of the
+	// integration.
+	ReportedAt time.Time `db:"reported_at"`
+	// HostsFingerprint identifies the set of reported hosts, to tell if the
+	// hosts changed since the report.
+	HostsFingerprint string `db:"hosts_fingerprint"`
+}
@@ -27,6 +27,8 @@ type CVEMeta struct {
 	// takes into account several different metrics.
 	// See https://nvd.nist.gov/vuln-metrics/cvss.
 	CVSSScore *float64 `db:"cvss_score" json:"cvss_score,omitempty"`
+	// CVSSVersion is the version of the CVSS specification of CVSSScore, e.g. "3.1".
+	CVSSVersion *string `db:"cvss_version" json:"cvss_version,omitempty"`
 	// EPSSProbability is the Exploit Prediction Scoring System (EPSS) score. It is the probability
 	// that a software vulnerability will be exploited in the next 30 days.
 	// See https://www.first.org/epss/.
@@ -517,10 +517,18 @@ type HostVulnSummariesBySoftwareIDsFunc func(ctx context.Context, softwareIDs []
 
 type HostsByCVEFunc func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error)
 
+type CVEDetectedAtByHostIDsFunc func(ctx context.Context, cve string, hostIDs []uint) (map[uint]time.Time, error)
+
+type FilterHostIDsByTeamsOrLabelsFunc func(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error)
+
+type HostIssuesByHostIDsFunc func(ctx context.Context, hostIDs []uint) (map[uint]fleet.HostIssues, error)
+
 type InsertCVEMetaFunc func(ctx context.Context, cveMeta []fleet.CVEMeta) error
 
 type ListCVEsFunc func(ctx context.Context, maxAge time.Duration) ([]fleet.CVEMeta, error)
 
+type GetCVEMetaFunc func(ctx context.Context, cve string) (*fleet.CVEMeta, error)
+
 type ListHostSoftwareFunc func(ctx context.Context, host *fleet.Host, opts fleet.HostSoftwareTitleListOptions) ([]*fleet.HostSoftwareWithInstaller, *fleet.PaginationMetadata, error)
 
 type IsSoftwareInstallerLabelScopedFunc func(ctx context.Context, installerID uint, hostID uint) (bool, error)
@@ -843,6 +851,22 @@ type CleanupWorkerJobsFunc func(ctx context.Context, failedSince time.Duration,
 
 type GetJobFunc func(ctx context.Context, jobID uint) (*fleet.Job, error)
 
+type InsertFreeScoutDigestEventsFunc func(ctx context.Context, teamID uint, args []json.RawMessage) (bool, error)
+
+type ListFreeScoutDigestEventsFunc func(ctx context.Context, teamID uint, limit int) ([]*fleet.FreeScoutDigestEvent, error)
+
+type DeleteFreeScoutDigestEventsFunc func(ctx context.Context, ids []uint) error
+
+type GetFreeScoutVulnReportedHostsCountFunc func(ctx context.Context, cve string) (int, error)
+
+type SetFreeScoutVulnReportedHostsCountFunc func(ctx context.Context, cve string, count int) error
+
+type CountFreeScoutReportedHostsFunc func(ctx context.Context, key string, hostIDs []uint) (int, error)
+
+type GetFreeScoutReportFunc func(ctx context.Context, key string) (*fleet.FreeScoutReport, error)
+
+type SetFreeScoutReportFunc func(ctx context.Context, report *fleet.FreeScoutReport, hostIDs []uint) error
+
 type InnoDBStatusFunc func(ctx context.Context) (string, error)
 
 type ProcessListFunc func(ctx context.Context) ([]fleet.MySQLProcess, error)
@@ -2491,12 +2515,24 @@ type DataStore struct {
 	HostsByCVEFunc        HostsByCVEFunc
 	HostsByCVEFuncInvoked bool
 
+	CVEDetectedAtByHostIDsFunc        CVEDetectedAtByHostIDsFunc
+	CVEDetectedAtByHostIDsFuncInvoked bool
+
+	FilterHostIDsByTeamsOrLabelsFunc        FilterHostIDsByTeamsOrLabelsFunc
+	FilterHostIDsByTeamsOrLabelsFuncInvoked bool
+
+	HostIssuesByHostIDsFunc        HostIssuesByHostIDsFunc
+	HostIssuesByHostIDsFuncInvoked bool
+
 	InsertCVEMetaFunc        InsertCVEMetaFunc
 	InsertCVEMetaFuncInvoked bool
 
 	ListCVEsFunc        ListCVEsFunc
 	ListCVEsFuncInvoked bool
 
+	GetCVEMetaFunc        GetCVEMetaFunc
+	GetCVEMetaFuncInvoked bool
+
 	ListHostSoftwareFunc        ListHostSoftwareFunc
 	ListHostSoftwareFuncInvoked bool
 
@@ -2980,6 +3016,30 @@ type DataStore struct {
 	GetJobFunc        GetJobFunc
 	GetJobFuncInvoked bool
 
+	InsertFreeScoutDigestEventsFunc        InsertFreeScoutDigestEventsFunc
+	InsertFreeScoutDigestEventsFuncInvoked bool
+
+	ListFreeScoutDigestEventsFunc        ListFreeScoutDigestEventsFunc
+	ListFreeScoutDigestEventsFuncInvoked bool
+
+	DeleteFreeScoutDigestEventsFunc        DeleteFreeScoutDigestEventsFunc
+	DeleteFreeScoutDigestEventsFuncInvoked bool
+
+	GetFreeScoutVulnReportedHostsCountFunc        GetFreeScoutVulnReportedHostsCountFunc
+	GetFreeScoutVulnReportedHostsCountFuncInvoked bool
+
+	SetFreeScoutVulnReportedHostsCountFunc        SetFreeScoutVulnReportedHostsCountFunc
+	SetFreeScoutVulnReportedHostsCountFuncInvoked bool
+
+	CountFreeScoutReportedHostsFunc        CountFreeScoutReportedHostsFunc
+	CountFreeScoutReportedHostsFuncInvoked bool
+
+	GetFreeScoutReportFunc        GetFreeScoutReportFunc
+	GetFreeScoutReportFuncInvoked bool
+
+	SetFreeScoutReportFunc        SetFreeScoutReportFunc
+	SetFreeScoutReportFuncInvoked bool
+
 	InnoDBStatusFunc        InnoDBStatusFunc
 	InnoDBStatusFuncInvoked bool
 
@@ -6071,6 +6131,27 @@ func (s *DataStore) HostsByCVE(ctx context.Context, cve string) ([]fleet.HostVul
 	return s.HostsByCVEFunc(ctx, cve)
 }
 
+func (s *DataStore) CVEDetectedAtByHostIDs(ctx context.Context, cve string, hostIDs []uint) (map[uint]time.Time, error) {
+	s.mu.Lock()
+	s.CVEDetectedAtByHostIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.CVEDetectedAtByHostIDsFunc(ctx, cve, hostIDs)
+}
+
+func (s *DataStore) FilterHostIDsByTeamsOrLabels(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error) {
+	s.mu.Lock()
+	s.FilterHostIDsByTeamsOrLabelsFuncInvoked = true
+	s.mu.Unlock()
+	return s.FilterHostIDsByTeamsOrLabelsFunc(ctx, hostIDs, teamIDs, labelIDs)
+}
+
+func (s *DataStore) HostIssuesByHostIDs(ctx context.Context, hostIDs []uint) (map[uint]fleet.HostIssues, error) {
+	s.mu.Lock()
+	s.HostIssuesByHostIDsFuncInvoked = true
+	s.mu.Unlock()
+	return s.HostIssuesByHostIDsFunc(ctx, hostIDs)
+}
+
 func (s *DataStore) InsertCVEMeta(ctx context.Context, cveMeta []fleet.CVEMeta) error {
 	s.mu.Lock()
 	s.InsertCVEMetaFuncInvoked = true
@@ -6085,6 +6166,13 @@ func (s *DataStore) ListCVEs(ctx context.Context, maxAge time.Duration) ([]fleet
 	return s.ListCVEsFunc(ctx, maxAge)
 }
 
+func (s *DataStore) GetCVEMeta(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+	s.mu.Lock()
+	s.GetCVEMetaFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetCVEMetaFunc(ctx, cve)
+}
+
 func (s *DataStore) ListHostSoftware(ctx context.Context, host *fleet.Host, opts fleet.HostSoftwareTitleListOptions) ([]*fleet.HostSoftwareWithInstaller, *fleet.PaginationMetadata, error) {
 	s.mu.Lock()
 	s.ListHostSoftwareFuncInvoked = true
@@ -7212,6 +7300,62 @@ func (s *DataStore) GetJob(ctx context.Context, jobID uint) (*fleet.Job, error)
 	return s.GetJobFunc(ctx, jobID)
 }
 
+func (s *DataStore) InsertFreeScoutDigestEvents(ctx context.Context, teamID uint, args []json.RawMessage) (bool, error) {
+	s.mu.Lock()
+	s.InsertFreeScoutDigestEventsFuncInvoked = true
+	s.mu.Unlock()
+	return s.InsertFreeScoutDigestEventsFunc(ctx, teamID, args)
+}
+
+func (s *DataStore) ListFreeScoutDigestEvents(ctx context.Context, teamID uint, limit int) ([]*fleet.FreeScoutDigestEvent, error) {
+	s.mu.Lock()
+	s.ListFreeScoutDigestEventsFuncInvoked = true
+	s.mu.Unlock()
+	return s.ListFreeScoutDigestEventsFunc(ctx, teamID, limit)
+}
+
+func (s *DataStore) DeleteFreeScoutDigestEvents(ctx context.Context, ids []uint) error {
+	s.mu.Lock()
+	s.DeleteFreeScoutDigestEventsFuncInvoked = true
+	s.mu.Unlock()
+	return s.DeleteFreeScoutDigestEventsFunc(ctx, ids)
+}
+
+func (s *DataStore) GetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string) (int, error) {
+	s.mu.Lock()
+	s.GetFreeScoutVulnReportedHostsCountFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetFreeScoutVulnReportedHostsCountFunc(ctx, cve)
+}
+
+func (s *DataStore) SetFreeScoutVulnReportedHostsCount(ctx context.Context, cve string, count int) error {
+	s.mu.Lock()
+	s.SetFreeScoutVulnReportedHostsCountFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetFreeScoutVulnReportedHostsCountFunc(ctx, cve, count)
+}
+
+func (s *DataStore) CountFreeScoutReportedHosts(ctx context.Context, key string, hostIDs []uint) (int, error) {
+	s.mu.Lock()
+	s.CountFreeScoutReportedHostsFuncInvoked = true
+	s.mu.Unlock()
+	return s.CountFreeScoutReportedHostsFunc(ctx, key, hostIDs)
+}
+
+func (s *DataStore) GetFreeScoutReport(ctx context.Context, key string) (*fleet.FreeScoutReport, error) {
+	s.mu.Lock()
+	s.GetFreeScoutReportFuncInvoked = true
+	s.mu.Unlock()
+	return s.GetFreeScoutReportFunc(ctx, key)
+}
+
+func (s *DataStore) SetFreeScoutReport(ctx context.Context, report *fleet.FreeScoutReport, hostIDs []uint) error {
+	s.mu.Lock()
+	s.SetFreeScoutReportFuncInvoked = true
+	s.mu.Unlock()
+	return s.SetFreeScoutReportFunc(ctx, report, hostIDs)
+}
+
 func (s *DataStore) InnoDBStatus(ctx context.Context) (string, error) {
 	s.mu.Lock()
 	s.InnoDBStatusFuncInvoked = true
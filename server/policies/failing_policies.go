@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
@@ -33,6 +34,20 @@ type FailingPolicyAutomationConfig struct {
 	PolicyIDs      map[uint]bool
 	WebhookURL     *url.URL // for webhook automation type only
 	HostBatchSize  int      // for webhook automation type only
+
+	// BatchFailingPolicies is true if the failing policies should be grouped
+	// per team in a single job (for freescout automation type only).
+	BatchFailingPolicies bool
+	// MinFailingHosts is the minimum number of failing hosts for a policy to
+	// be reported (for freescout automation type only).
+	MinFailingHosts int
+	// DigestInterval is the interval of the digest in which the failing
+	// policies are reported, or 0 if they are reported as they fail (for
+	// freescout automation type only).
+	DigestInterval time.Duration
+	// HostIDsOnly is true if only the IDs of the failing hosts should be
+	// stored in the queued jobs (for freescout automation type only).
+	HostIDsOnly bool
 }
 
 // TriggerFailingPoliciesAutomation triggers an automation for failing
@@ -196,6 +211,18 @@ func buildFailingPolicyAutomationConfig(webhookSettings fleet.FailingPoliciesWeb
 		cfg.HostBatchSize = webhookSettings.HostBatchSize
 	}
 
+	if automation == FailingPolicyFreeScout {
+		for _, f := range intgs.Freescout {
+			if f.EnableFailingPolicies {
+				cfg.BatchFailingPolicies = f.BatchFailingPolicies
+				cfg.MinFailingHosts = f.MinFailingPolicyHosts
+				cfg.DigestInterval = f.DigestInterval.Duration
+				cfg.HostIDsOnly = f.FailingPoliciesHostIDsOnly
+				break
+			}
+		}
+	}
+
 	return cfg, nil
 }
 
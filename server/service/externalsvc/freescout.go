@@ -3,22 +3,66 @@ package externalsvc
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/fleetdm/fleet/v4/pkg/fleethttp"
+	"github.com/fleetdm/fleet/v4/server/contexts/correlation"
+	"github.com/fleetdm/fleet/v4/server/contexts/jobid"
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 )
 
+// ErrFreeScoutConversationIDNotFound is returned when FreeScout reports that a
+// conversation was created, but its ID could not be determined from the
+// response nor by searching for it.
+var ErrFreeScoutConversationIDNotFound = errors.New("freescout conversation created but its ID could not be determined")
+
+// ErrFreeScoutResourceIDMissing is returned by ParseResourceID when the
+// response has neither a Resource-ID nor a Location header.
+var ErrFreeScoutResourceIDMissing = errors.New("freescout response has no Resource-ID nor Location header")
+
+// ErrFreeScoutMaintenance is returned, wrapping the status error, when
+// FreeScout responds with the 503 HTML page it serves while it is upgraded.
+var ErrFreeScoutMaintenance = errors.New("freescout is in maintenance mode")
+
+// HTTPDoer executes HTTP requests. It is implemented by *http.Client, and can
+// be used to wrap it with middleware, e.g. to record the requests in tests.
+type HTTPDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
 // FreeScout is a FreeScout client to be used to make requests to the FreeScout external service.
 type FreeScout struct {
-	client *http.Client
+	client HTTPDoer
 	opts   FreeScoutOptions
+
+	// recentMu protects recent, the time at which the conversations created
+	// by the client were created, keyed by subject. It is only maintained if
+	// SearchIndexRetries is set.
+	recentMu sync.Mutex
+	recent   map[string]time.Time
+
+	// usersMu protects users, the users last listed by ListUsers, and
+	// usersAt, the time at which they were listed. They are only maintained
+	// if UsersCacheTTL is set.
+	usersMu sync.Mutex
+	users   []FreeScoutUser
+	usersAt time.Time
 }
 
 // FreeScoutOptions defines the options to configure a FreeScout client.
@@ -28,6 +72,221 @@ type FreeScoutOptions struct {
 	MailboxID     int64
 	CustomerEmail string
 	AssignTo      int64
+
+	// FolderID is the folder in which the created conversations are placed,
+	// e.g. a triage folder whose workflows route them. It is not sent if 0,
+	// and is ignored by the FreeScout versions that do not support setting it
+	// on creation.
+	FolderID int64
+
+	// AuthMode is how APIToken is sent to FreeScout, one of the
+	// FreeScoutAuthMode* values: in the X-FreeScout-API-Key header (the
+	// default) or as a bearer token in the Authorization header.
+	AuthMode string
+
+	// SearchStatus and SearchState are the status and state used to search
+	// for an existing conversation to which the message is appended instead
+	// of creating a new conversation. They default to "active" and
+	// "published" respectively.
+	SearchStatus string
+	SearchState  string
+
+	// SearchSortField and SearchSortOrder order the conversations searched
+	// for, the first one being the one to which the message is appended. They
+	// default to "updatedAt" and "asc", which picks the least recently updated
+	// conversation; "desc" picks the most recently active one instead.
+	SearchSortField string
+	SearchSortOrder string
+
+	// SearchDecodeRetries is the number of times the search for an existing
+	// conversation is retried when its response body cannot be decoded, e.g.
+	// when it is truncated during a FreeScout deployment. Once the retries
+	// are exhausted, SearchDecodeErrorPolicy applies: one of the
+	// FreeScoutDecodeError* values, failing the request by default.
+	SearchDecodeRetries     int
+	SearchDecodeErrorPolicy string
+
+	// AppendNotFoundPolicy applies when appending a message to the existing
+	// conversation that was found fails with a 404, e.g. because it was
+	// deleted since the search: one of the FreeScoutAppendNotFound* values,
+	// failing the request by default.
+	AppendNotFoundPolicy string
+
+	// SearchIndexRetries is the number of times the search for an existing
+	// conversation is retried when it finds none although the client created
+	// a conversation with that subject less than freeScoutRecentSubjectTTL
+	// ago, as FreeScout's search index may lag behind the creation. It waits
+	// SearchIndexDelay (1 second by default) before each retry. Only the
+	// conversations created by the same client are known, so it does not
+	// cover the conversations created by other Fleet instances.
+	SearchIndexRetries int
+	SearchIndexDelay   time.Duration
+
+	// RetryAfterBlockThreshold is the longest Retry-After of a 429 response
+	// for which the client waits and retries the request, up to a few times.
+	// A longer one fails the request with a *FreeScoutRetryAfterError, for
+	// the caller to retry it later rather than blocking until then. It
+	// defaults to 10 seconds.
+	RetryAfterBlockThreshold time.Duration
+
+	// TransportRetries is the number of times a request is retried, waiting
+	// the same backoff as the other external services between attempts, when
+	// it fails with a transient transport error: a timeout, a refused or reset
+	// connection, or a temporary DNS failure. A DNS name that does not exist
+	// is not retried. The requests other than GET, which are not idempotent,
+	// are only retried if they failed before reaching FreeScout, e.g. on a
+	// refused connection. The requests are not retried if it is 0, the
+	// default.
+	TransportRetries int
+
+	// UsersCacheTTL is how long the users listed by ListUsers are cached, to
+	// avoid listing them again e.g. for every conversation that is assigned.
+	// They are not cached if it is 0, the default.
+	UsersCacheTTL time.Duration
+
+	// ReassignOnAppend assigns an existing conversation to AssignTo when a
+	// message is appended to it, instead of keeping its current assignee.
+	ReassignOnAppend bool
+
+	// ConversationType is the type of the created conversations, and of the
+	// existing conversations searched for. It defaults to "email", other
+	// types do not send email notifications to the customer.
+	ConversationType string
+
+	// Imported creates the conversations and threads as imported, so that
+	// FreeScout does not send notifications to the customer for them.
+	Imported bool
+
+	// InitialThreadType and AppendThreadType are the types of the first
+	// thread of a created conversation and of the threads appended to an
+	// existing one, one of the FreeScoutThreadType* values. They default to a
+	// customer thread, from CustomerEmail. A note is internal, it does not
+	// notify the customer and is created on behalf of the NoteUserID user,
+	// which is then required. CustomerEmail is required for customer threads;
+	// if it is not set and both types are notes, the conversations are
+	// created without a customer.
+	InitialThreadType string
+	AppendThreadType  string
+	NoteUserID        int64
+
+	// FromName is the display name of the customer on whose behalf the
+	// conversations and customer threads are created, e.g. "Fleet Security",
+	// shown by FreeScout as their sender instead of CustomerEmail. FreeScout
+	// has no sender name field, so it is sent as the customer's first name.
+	// It is not sent if empty, keeping the name FreeScout already has for the
+	// customer.
+	FromName string
+
+	// Source is the label of the source (or channel) of the created
+	// conversations, e.g. "Fleet Security", to distinguish them from the
+	// conversations started by people in FreeScout's reports. It is not sent
+	// if empty, and is ignored by the FreeScout versions that do not record
+	// it.
+	Source string
+
+	// Headers are additional HTTP headers set on every request, e.g. as
+	// required by an API gateway in front of FreeScout. They cannot override
+	// the authentication and content type headers set by the client.
+	Headers map[string]string
+
+	// JobIDHeader is the name of the HTTP header in which the ID of the worker
+	// job that makes a request is sent, if the context has one, to trace the
+	// request in FreeScout or a gateway in front of it. It is not sent if
+	// empty.
+	JobIDHeader string
+
+	// DumpPayloads logs the JSON body of the requests creating conversations
+	// and threads, to diagnose the payloads rejected by FreeScout (e.g. with a
+	// 422). It is off by default, as the bodies may be large and contain
+	// sensitive data, and is logged at the debug level, the lowest available.
+	// Any occurrence of APIToken in the body is redacted, and of
+	// CustomerEmail too if RedactCustomerEmail is set.
+	DumpPayloads        bool
+	RedactCustomerEmail bool
+
+	// MaxAttachmentBytes is the maximum size of the content of an attachment,
+	// before its base64 encoding. It defaults to
+	// defaultFreeScoutMaxAttachmentBytes.
+	MaxAttachmentBytes int
+
+	// VerifyScopes requests that the API token scopes are verified with
+	// CheckScopes before the client is first used. The client itself does not
+	// act on it, it is for the callers that create and cache clients.
+	VerifyScopes bool
+
+	// MailboxTypeCheck requests that the type of the mailbox is checked with
+	// CheckMailboxType before the client is first used, to catch a mailbox
+	// that cannot hold conversations of ConversationType: one of the
+	// FreeScoutMailboxTypeCheck* values, it is not checked if empty. Like
+	// VerifyScopes, the client itself does not act on it.
+	MailboxTypeCheck string
+
+	// Transport tunes the connection pool of the client's HTTP transport. A
+	// client does not match a configuration with other settings, so that
+	// the callers that cache clients create a new one when they change.
+	Transport FreeScoutTransportOptions
+
+	// HTTPClient is the optional HTTP client used to make the requests, e.g.
+	// to wrap the default client with middleware. It defaults to a Fleet HTTP
+	// client using the Transport settings, which are ignored otherwise. It is
+	// not considered when checking if a client matches a configuration.
+	HTTPClient HTTPDoer
+
+	// Logger is used to log the requests made to FreeScout, along with the
+	// correlation ID of the context, if any. It is not considered when
+	// checking if a client matches a configuration.
+	Logger kitlog.Logger
+}
+
+// FreeScoutTransportOptions defines the connection pool settings of the HTTP
+// transport of a FreeScout client. The defaults are used for the settings
+// that are 0.
+type FreeScoutTransportOptions struct {
+	// MaxIdleConns is the maximum number of idle connections kept open, it
+	// defaults to 100.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept open
+	// to the FreeScout server, it defaults to 10.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost is the maximum number of connections to the FreeScout
+	// server, including those in use, unlimited by default.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open, it
+	// defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+}
+
+// Default values of FreeScoutTransportOptions. Go's default of 2 idle
+// connections per host forces concurrent jobs to open new connections to the
+// FreeScout server, hence the higher default.
+const (
+	defaultFreeScoutMaxIdleConns        = 100
+	defaultFreeScoutMaxIdleConnsPerHost = 10
+	defaultFreeScoutIdleConnTimeout     = 90 * time.Second
+)
+
+// newFreeScoutTransport returns the HTTP transport configured with the
+// options, or an error if they are invalid.
+func newFreeScoutTransport(opts FreeScoutTransportOptions) (*http.Transport, error) {
+	if opts.MaxIdleConns < 0 || opts.MaxIdleConnsPerHost < 0 || opts.MaxConnsPerHost < 0 || opts.IdleConnTimeout < 0 {
+		return nil, errors.New("invalid FreeScout transport options, must not be negative")
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = defaultFreeScoutMaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost == 0 {
+		opts.MaxIdleConnsPerHost = defaultFreeScoutMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = defaultFreeScoutIdleConnTimeout
+	}
+
+	tr := fleethttp.NewTransport()
+	tr.MaxIdleConns = opts.MaxIdleConns
+	tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	tr.MaxConnsPerHost = opts.MaxConnsPerHost
+	tr.IdleConnTimeout = opts.IdleConnTimeout
+	return tr, nil
 }
 
 // NewFreeScoutClient returns a FreeScout client to use to make requests to the FreeScout external service.
@@ -46,48 +305,468 @@ func NewFreeScoutClient(opts *FreeScoutOptions) (*FreeScout, error) {
 		return nil, errors.New("invalid FreeScout URL")
 	}
 
-	cleaned := *opts
-	cleaned.URL = strings.TrimRight(opts.URL, "/")
+	cleaned := normalizeFreeScoutOptions(*opts)
+	if !slices.Contains(freeScoutConversationStatuses, cleaned.SearchStatus) {
+		return nil, fmt.Errorf("invalid FreeScout search status %q, must be one of %v", cleaned.SearchStatus, freeScoutConversationStatuses)
+	}
+	if !slices.Contains(freeScoutConversationStates, cleaned.SearchState) {
+		return nil, fmt.Errorf("invalid FreeScout search state %q, must be one of %v", cleaned.SearchState, freeScoutConversationStates)
+	}
+	if !slices.Contains(freeScoutSortFields, cleaned.SearchSortField) {
+		return nil, fmt.Errorf("invalid FreeScout search sort field %q, must be one of %v", cleaned.SearchSortField, freeScoutSortFields)
+	}
+	if !slices.Contains(freeScoutSortOrders, cleaned.SearchSortOrder) {
+		return nil, fmt.Errorf("invalid FreeScout search sort order %q, must be one of %v", cleaned.SearchSortOrder, freeScoutSortOrders)
+	}
+	if !slices.Contains(freeScoutDecodeErrorPolicies, cleaned.SearchDecodeErrorPolicy) {
+		return nil, fmt.Errorf("invalid FreeScout search decode error policy %q, must be one of %v", cleaned.SearchDecodeErrorPolicy, freeScoutDecodeErrorPolicies)
+	}
+	if !slices.Contains(freeScoutAppendNotFoundPolicies, cleaned.AppendNotFoundPolicy) {
+		return nil, fmt.Errorf("invalid FreeScout append not found policy %q, must be one of %v", cleaned.AppendNotFoundPolicy, freeScoutAppendNotFoundPolicies)
+	}
+	if cleaned.SearchDecodeRetries < 0 {
+		return nil, errors.New("FreeScout search decode retries must not be negative")
+	}
+	if cleaned.SearchIndexRetries < 0 || cleaned.SearchIndexDelay < 0 {
+		return nil, errors.New("FreeScout search index retries and delay must not be negative")
+	}
+	if cleaned.MaxAttachmentBytes < 0 {
+		return nil, errors.New("FreeScout max attachment bytes must not be negative")
+	}
+	if cleaned.FolderID < 0 {
+		return nil, errors.New("FreeScout folder ID must not be negative")
+	}
+	if cleaned.RetryAfterBlockThreshold < 0 {
+		return nil, errors.New("FreeScout retry after block threshold must not be negative")
+	}
+	if cleaned.TransportRetries < 0 {
+		return nil, errors.New("FreeScout transport retries must not be negative")
+	}
+	if cleaned.UsersCacheTTL < 0 {
+		return nil, errors.New("FreeScout users cache TTL must not be negative")
+	}
+	if cleaned.MailboxTypeCheck != "" && !slices.Contains(freeScoutMailboxTypeChecks, cleaned.MailboxTypeCheck) {
+		return nil, fmt.Errorf("invalid FreeScout mailbox type check %q, must be one of %v", cleaned.MailboxTypeCheck, freeScoutMailboxTypeChecks)
+	}
+	if !slices.Contains(freeScoutConversationTypes, cleaned.ConversationType) {
+		return nil, fmt.Errorf("invalid FreeScout conversation type %q, must be one of %v", cleaned.ConversationType, freeScoutConversationTypes)
+	}
+	for _, threadType := range []string{cleaned.InitialThreadType, cleaned.AppendThreadType} {
+		if !slices.Contains(freeScoutThreadTypes, threadType) {
+			return nil, fmt.Errorf("invalid FreeScout thread type %q, must be one of %v", threadType, freeScoutThreadTypes)
+		}
+		if threadType == FreeScoutThreadTypeNote && cleaned.NoteUserID <= 0 {
+			return nil, errors.New("FreeScout note user ID is required for note threads")
+		}
+	}
+	if !slices.Contains(freeScoutAuthModes, cleaned.AuthMode) {
+		return nil, fmt.Errorf("invalid FreeScout auth mode %q, must be one of %v", cleaned.AuthMode, freeScoutAuthModes)
+	}
+	reserved := freeScoutReservedHeaders
+	if cleaned.AuthMode == FreeScoutAuthModeBearer {
+		reserved = append(slices.Clone(reserved), "Authorization")
+	}
+	for name := range cleaned.Headers {
+		if slices.Contains(reserved, http.CanonicalHeaderKey(name)) {
+			return nil, fmt.Errorf("FreeScout header %q cannot be overridden", name)
+		}
+	}
+	if cleaned.JobIDHeader != "" && slices.Contains(reserved, http.CanonicalHeaderKey(cleaned.JobIDHeader)) {
+		return nil, fmt.Errorf("FreeScout header %q cannot be used for the job ID", cleaned.JobIDHeader)
+	}
+	cleaned.Headers = maps.Clone(cleaned.Headers)
+	if cleaned.Logger == nil {
+		cleaned.Logger = kitlog.NewNopLogger()
+	}
+	tr, err := newFreeScoutTransport(cleaned.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFreeScoutCustomerEmail(cleaned); err != nil {
+		return nil, err
+	}
 
+	client := cleaned.HTTPClient
+	if client == nil {
+		httpClient := fleethttp.NewClient()
+		httpClient.Transport = tr
+		client = httpClient
+	}
 	return &FreeScout{
-		client: fleethttp.NewClient(),
+		client: client,
 		opts:   cleaned,
 	}, nil
 }
 
+// validateFreeScoutCustomerEmail validates the customer email of the options
+// against their thread types: it must be a plain email address if set, and
+// is required if either thread type is a customer thread. Without it, the
+// conversations of note threads are created without a customer.
+func validateFreeScoutCustomerEmail(opts FreeScoutOptions) error {
+	if opts.CustomerEmail != "" {
+		addr, err := mail.ParseAddress(opts.CustomerEmail)
+		if err != nil || addr.Address != opts.CustomerEmail {
+			return fmt.Errorf("invalid FreeScout customer email %q", opts.CustomerEmail)
+		}
+		return nil
+	}
+	if opts.InitialThreadType == FreeScoutThreadTypeCustomer || opts.AppendThreadType == FreeScoutThreadTypeCustomer {
+		return errors.New("FreeScout customer email is required for customer threads")
+	}
+	return nil
+}
+
+// freeScoutReservedHeaders are the headers set by the client on every request
+// that cannot be overridden by FreeScoutOptions.Headers. The Authorization
+// header is also reserved in the bearer auth mode.
+var freeScoutReservedHeaders = []string{http.CanonicalHeaderKey("X-FreeScout-API-Key"), "Content-Type"}
+
+// The supported values of FreeScoutOptions.SearchDecodeErrorPolicy, a
+// tradeoff between failing to report and creating duplicate conversations.
+const (
+	// FreeScoutDecodeErrorFail fails the request, the job being retried.
+	FreeScoutDecodeErrorFail = "fail"
+	// FreeScoutDecodeErrorCreate proceeds as if no existing conversation was
+	// found, creating a new conversation which may be a duplicate.
+	FreeScoutDecodeErrorCreate = "create"
+)
+
+// The supported values of FreeScoutOptions.AppendNotFoundPolicy.
+const (
+	// FreeScoutAppendNotFoundFail fails the request, the job being retried.
+	FreeScoutAppendNotFoundFail = "fail"
+	// FreeScoutAppendNotFoundCreate creates a new conversation with the
+	// message instead.
+	FreeScoutAppendNotFoundCreate = "create"
+)
+
+// The supported values of FreeScoutOptions.MailboxTypeCheck.
+const (
+	// FreeScoutMailboxTypeCheckWarn logs a warning if the mailbox type is
+	// incompatible with the conversation type, and uses the client anyway.
+	FreeScoutMailboxTypeCheckWarn = "warn"
+	// FreeScoutMailboxTypeCheckFail fails the request if the mailbox type is
+	// incompatible with the conversation type.
+	FreeScoutMailboxTypeCheckFail = "fail"
+)
+
+// The supported values of FreeScoutOptions.AuthMode.
+const (
+	FreeScoutAuthModeAPIKey = "apikey"
+	FreeScoutAuthModeBearer = "bearer"
+)
+
+// The supported values of FreeScoutOptions.InitialThreadType and
+// AppendThreadType.
+const (
+	FreeScoutThreadTypeCustomer = "customer"
+	FreeScoutThreadTypeNote     = "note"
+)
+
+// The conversation statuses, states and types supported by the FreeScout API.
+var (
+	freeScoutConversationStatuses   = []string{"active", "pending", "closed", "spam"}
+	freeScoutConversationStates     = []string{"draft", "published", "deleted"}
+	freeScoutConversationTypes      = []string{"email", "phone", "chat"}
+	freeScoutThreadTypes            = []string{FreeScoutThreadTypeCustomer, FreeScoutThreadTypeNote}
+	freeScoutAuthModes              = []string{FreeScoutAuthModeAPIKey, FreeScoutAuthModeBearer}
+	freeScoutSortFields             = []string{"createdAt", "mailboxId", "number", "subject", "updatedAt", "waitingSince"}
+	freeScoutSortOrders             = []string{"asc", "desc"}
+	freeScoutDecodeErrorPolicies    = []string{FreeScoutDecodeErrorFail, FreeScoutDecodeErrorCreate}
+	freeScoutAppendNotFoundPolicies = []string{FreeScoutAppendNotFoundFail, FreeScoutAppendNotFoundCreate}
+	freeScoutMailboxTypeChecks      = []string{FreeScoutMailboxTypeCheckWarn, FreeScoutMailboxTypeCheckFail}
+)
+
+// normalizeFreeScoutOptions returns a copy of opts with the URL cleaned up and
+// the defaults applied.
+func normalizeFreeScoutOptions(opts FreeScoutOptions) FreeScoutOptions {
+	opts.URL = strings.TrimRight(opts.URL, "/")
+	if opts.SearchStatus == "" {
+		opts.SearchStatus = "active"
+	}
+	if opts.SearchState == "" {
+		opts.SearchState = "published"
+	}
+	if opts.SearchSortField == "" {
+		opts.SearchSortField = "updatedAt"
+	}
+	if opts.SearchSortOrder == "" {
+		opts.SearchSortOrder = "asc"
+	}
+	if opts.SearchDecodeErrorPolicy == "" {
+		opts.SearchDecodeErrorPolicy = FreeScoutDecodeErrorFail
+	}
+	if opts.AppendNotFoundPolicy == "" {
+		opts.AppendNotFoundPolicy = FreeScoutAppendNotFoundFail
+	}
+	if opts.SearchIndexRetries > 0 && opts.SearchIndexDelay == 0 {
+		opts.SearchIndexDelay = defaultFreeScoutSearchIndexDelay
+	}
+	if opts.ConversationType == "" {
+		opts.ConversationType = "email"
+	}
+	if opts.RetryAfterBlockThreshold == 0 {
+		opts.RetryAfterBlockThreshold = maxWaitForRetryAfter
+	}
+	if opts.MaxAttachmentBytes == 0 {
+		opts.MaxAttachmentBytes = defaultFreeScoutMaxAttachmentBytes
+	}
+	if opts.InitialThreadType == "" {
+		opts.InitialThreadType = FreeScoutThreadTypeCustomer
+	}
+	if opts.AppendThreadType == "" {
+		opts.AppendThreadType = FreeScoutThreadTypeCustomer
+	}
+	if opts.AuthMode == "" {
+		opts.AuthMode = FreeScoutAuthModeAPIKey
+	}
+	return opts
+}
+
 type freeScoutCustomer struct {
-	Email string `json:"email"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName,omitempty"`
 }
 
 type freeScoutThread struct {
-	Text     string             `json:"text"`
-	Type     string             `json:"type"`
-	Customer *freeScoutCustomer `json:"customer,omitempty"`
+	Text        string                `json:"text"`
+	Type        string                `json:"type"`
+	Customer    *freeScoutCustomer    `json:"customer,omitempty"`
+	User        int64                 `json:"user,omitempty"`
+	Attachments []freeScoutAttachment `json:"attachments,omitempty"`
 }
 
 type freeScoutThreadPayload struct {
-	Type     string             `json:"type"`
-	Text     string             `json:"text"`
-	Customer *freeScoutCustomer `json:"customer,omitempty"`
-	Imported bool               `json:"imported"`
-	Status   string             `json:"status,omitempty"`
+	Type        string                `json:"type"`
+	Text        string                `json:"text"`
+	Customer    *freeScoutCustomer    `json:"customer,omitempty"`
+	User        int64                 `json:"user,omitempty"`
+	Imported    bool                  `json:"imported"`
+	Status      string                `json:"status,omitempty"`
+	Attachments []freeScoutAttachment `json:"attachments,omitempty"`
+}
+
+// freeScoutAttachment is an attachment of a thread payload, its data is the
+// base64 encoded content.
+type freeScoutAttachment struct {
+	FileName string `json:"fileName"`
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// FreeScoutAttachment is a file attached to the thread of a conversation,
+// sent inline with its content.
+type FreeScoutAttachment struct {
+	FileName string
+	MimeType string
+	Content  []byte
+}
+
+// defaultFreeScoutMaxAttachmentBytes is the default of
+// FreeScoutOptions.MaxAttachmentBytes.
+const defaultFreeScoutMaxAttachmentBytes = 1 << 20
+
+// attachmentsPayload returns the payload of the attachments, or an error if
+// one of them is invalid or exceeds MaxAttachmentBytes.
+func (f *FreeScout) attachmentsPayload(attachments []FreeScoutAttachment) ([]freeScoutAttachment, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	payload := make([]freeScoutAttachment, 0, len(attachments))
+	for _, a := range attachments {
+		if a.FileName == "" || a.MimeType == "" {
+			return nil, errors.New("FreeScout attachment requires a file name and a MIME type")
+		}
+		if len(a.Content) > f.opts.MaxAttachmentBytes {
+			return nil, fmt.Errorf("FreeScout attachment %q is %d bytes, exceeds the maximum of %d bytes", a.FileName, len(a.Content), f.opts.MaxAttachmentBytes)
+		}
+		payload = append(payload, freeScoutAttachment{
+			FileName: a.FileName,
+			MimeType: a.MimeType,
+			Data:     base64.StdEncoding.EncodeToString(a.Content),
+		})
+	}
+	return payload, nil
 }
 
 type freeScoutConversationPayload struct {
 	Type      string             `json:"type"`
 	MailboxID int64              `json:"mailboxId"`
 	Subject   string             `json:"subject"`
-	Customer  *freeScoutCustomer `json:"customer"`
+	Customer  *freeScoutCustomer `json:"customer,omitempty"`
 	Threads   []freeScoutThread  `json:"threads"`
 	Imported  bool               `json:"imported"`
 	AssignTo  *int64             `json:"assignTo,omitempty"`
+	FolderID  int64              `json:"folderId,omitempty"`
 	Status    string             `json:"status,omitempty"`
+	Source    string             `json:"source,omitempty"`
+}
+
+type freeScoutConversationUpdatePayload struct {
+	ByUser   int64 `json:"byUser"`
+	AssignTo int64 `json:"assignTo"`
+}
+
+type freeScoutConversationStatusPayload struct {
+	ByUser int64  `json:"byUser"`
+	Status string `json:"status"`
+}
+
+type freeScoutConversationTagsPayload struct {
+	Tags []string `json:"tags"`
+}
+
+type freeScoutCustomField struct {
+	ID    int64  `json:"id"`
+	Value string `json:"value"`
 }
 
-type freeScoutConversationsResponse struct {
-	Embedded struct {
-		Conversations []freeScoutConversation `json:"conversations"`
-	} `json:"_embedded"`
+type freeScoutConversationCustomFieldsPayload struct {
+	CustomFields []freeScoutCustomField `json:"customFields"`
+}
+
+// The shapes of the conversation lists returned by the supported FreeScout
+// versions, as detected by decodeFreeScoutConversations.
+const (
+	// freeScoutShapeEmbedded is the HAL shape of the API & Webhooks module:
+	// {"_embedded": {"conversations": [...]}, "_page": {...}}.
+	freeScoutShapeEmbedded = "embedded"
+	// freeScoutShapeTopLevel is the shape of older versions of the module,
+	// without the HAL wrapper: {"conversations": [...], "page": {...}}.
+	freeScoutShapeTopLevel = "top-level"
+	// freeScoutShapePaginator is Laravel's paginator shape, returned by
+	// some API gateways: {"data": [...], "current_page": 1, ...}.
+	freeScoutShapePaginator = "paginator"
+	// freeScoutShapeArray is a bare array of conversations, without
+	// pagination metadata.
+	freeScoutShapeArray = "array"
+)
+
+// freeScoutLaravelPage is the pagination metadata of Laravel's paginator.
+type freeScoutLaravelPage struct {
+	CurrentPage int `json:"current_page"`
+	PerPage     int `json:"per_page"`
+	Total       int `json:"total"`
+	LastPage    int `json:"last_page"`
+}
+
+// errFreeScoutUnknownShape is returned by decodeFreeScoutConversations when
+// the response is valid JSON but in none of the known shapes.
+var errFreeScoutUnknownShape = errors.New("unknown freescout conversations response shape")
+
+// freeScoutDecodeError is the error returned by ListConversations when the
+// response body cannot be read or decoded, e.g. because it is truncated.
+type freeScoutDecodeError struct {
+	err error
+}
+
+func (e *freeScoutDecodeError) Error() string {
+	return fmt.Sprintf("decode freescout conversations: %v", e.err)
+}
+
+func (e *freeScoutDecodeError) Unwrap() error {
+	return e.err
+}
+
+// decodeFreeScoutConversations decodes a list of conversations in any of the
+// known shapes, and returns the name of the detected shape. The shape is
+// detected from the keys of the response's top-level object, so that a
+// response in an unknown shape is reported instead of decoded as an empty
+// list.
+func decodeFreeScoutConversations(body []byte) ([]freeScoutConversation, FreeScoutPage, string, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var conversations []freeScoutConversation
+		if err := json.Unmarshal(trimmed, &conversations); err != nil {
+			return nil, FreeScoutPage{}, "", err
+		}
+		return conversations, FreeScoutPage{}, freeScoutShapeArray, nil
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, FreeScoutPage{}, "", err
+	}
+
+	switch {
+	case keys["_embedded"] != nil:
+		var payload struct {
+			Embedded struct {
+				Conversations *[]freeScoutConversation `json:"conversations"`
+			} `json:"_embedded"`
+			Page FreeScoutPage `json:"_page"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, FreeScoutPage{}, "", err
+		}
+		if payload.Embedded.Conversations == nil {
+			// e.g. another resource embedded, the mailbox is not as expected.
+			return nil, FreeScoutPage{}, "", errFreeScoutUnknownShape
+		}
+		return *payload.Embedded.Conversations, payload.Page, freeScoutShapeEmbedded, nil
+
+	case keys["conversations"] != nil:
+		var payload struct {
+			Conversations []freeScoutConversation `json:"conversations"`
+			Page          *FreeScoutPage          `json:"page"`
+			HALPage       *FreeScoutPage          `json:"_page"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, FreeScoutPage{}, "", err
+		}
+		var page FreeScoutPage
+		switch {
+		case payload.Page != nil:
+			page = *payload.Page
+		case payload.HALPage != nil:
+			page = *payload.HALPage
+		}
+		return payload.Conversations, page, freeScoutShapeTopLevel, nil
+
+	case keys["data"] != nil:
+		var payload struct {
+			Data []freeScoutConversation `json:"data"`
+			freeScoutLaravelPage
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, FreeScoutPage{}, "", err
+		}
+		page := FreeScoutPage{
+			Number:        payload.CurrentPage,
+			Size:          payload.PerPage,
+			TotalElements: payload.Total,
+			TotalPages:    payload.LastPage,
+		}
+		return payload.Data, page, freeScoutShapePaginator, nil
+	}
+	return nil, FreeScoutPage{}, "", errFreeScoutUnknownShape
+}
+
+// freeScoutBodySnippet returns the start of a response body, to log it
+// without flooding the logs.
+func freeScoutBodySnippet(body []byte) string {
+	const maxLen = 256
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxLen {
+		s = s[:maxLen] + "..."
+	}
+	return s
+}
+
+// FreeScoutPage is the pagination metadata of a FreeScout list response.
+type FreeScoutPage struct {
+	// Number is the number of the page, starting at 1.
+	Number int `json:"number"`
+	// Size is the maximum number of items in a page.
+	Size int `json:"size"`
+	// TotalElements is the number of items across all pages.
+	TotalElements int `json:"totalElements"`
+	// TotalPages is the number of pages.
+	TotalPages int `json:"totalPages"`
+}
+
+// HasNext returns true if there are pages after this one.
+func (p FreeScoutPage) HasNext() bool {
+	return p.Number < p.TotalPages
 }
 
 type freeScoutConversation struct {
@@ -95,40 +774,86 @@ type freeScoutConversation struct {
 }
 
 // CreateFreeScoutConversation creates a conversation on the FreeScout server targeted by the FreeScout client.
-// It returns the created conversation ID or an error.
-func (f *FreeScout) CreateFreeScoutConversation(ctx context.Context, subject, message string) (int64, error) {
+// If a matching conversation already exists, the message is appended to it as a new thread instead. The optional
+// attachments are attached to the thread of the message. If the matching conversation is deleted before the
+// message is appended, AppendNotFoundPolicy applies. It returns the ID of the conversation, whether it was
+// created (true) or appended to (false), or an error.
+func (f *FreeScout) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...FreeScoutAttachment) (id int64, created bool, err error) {
+	// the attachments are checked before any request is made.
+	attachmentsPayload, err := f.attachmentsPayload(attachments)
+	if err != nil {
+		return 0, false, err
+	}
+
 	existingID, err := f.findExistingConversationID(ctx, subject)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 	if existingID > 0 {
-		if err := f.createFreeScoutThread(ctx, existingID, message); err != nil {
-			return 0, err
+		err := f.createFreeScoutThread(ctx, existingID, message, attachmentsPayload)
+		var statusErr *freeScoutStatusError
+		switch {
+		case err == nil:
+			if assignTo := f.assignTo(ctx); f.opts.ReassignOnAppend && assignTo > 0 {
+				if err := f.AssignConversation(ctx, existingID, assignTo); err != nil {
+					return 0, false, fmt.Errorf("reassign conversation %d: %w", existingID, err)
+				}
+			}
+			return existingID, false, nil
+		case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound &&
+			f.opts.AppendNotFoundPolicy == FreeScoutAppendNotFoundCreate:
+			// the conversation was deleted since it was found.
+			level.Warn(f.logger(ctx)).Log("msg", "existing freescout conversation not found, creating a new conversation", "conversation_id", existingID, "err", err)
+		default:
+			return 0, false, err
 		}
-		return existingID, nil
 	}
 
+	id, err = f.createConversation(ctx, subject, message, attachmentsPayload)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// FindFreeScoutConversation returns the ID of the existing conversation to
+// which CreateFreeScoutConversation would append a message with the subject
+// and the context, or 0 if there is none. If subject is empty, the
+// conversation is only searched by the identifier tag of the context.
+func (f *FreeScout) FindFreeScoutConversation(ctx context.Context, subject string) (int64, error) {
+	return f.findExistingConversationID(ctx, subject)
+}
+
+// AppendFreeScoutThread appends the message to the conversation as a new
+// thread of the AppendThreadType type.
+func (f *FreeScout) AppendFreeScoutThread(ctx context.Context, conversationID int64, message string) error {
+	return f.createFreeScoutThread(ctx, conversationID, message, nil)
+}
+
+// createConversation creates a new conversation in the configured mailbox,
+// for the configured customer, and returns its ID.
+func (f *FreeScout) createConversation(ctx context.Context, subject, message string, attachments []freeScoutAttachment) (int64, error) {
+	customer, user := f.threadAuthor(f.opts.InitialThreadType)
 	payload := freeScoutConversationPayload{
-		Type:      "email",
+		Type:      f.opts.ConversationType,
 		MailboxID: f.opts.MailboxID,
 		Subject:   subject,
-		Customer: &freeScoutCustomer{
-			Email: f.opts.CustomerEmail,
-		},
+		Customer:  f.customer(),
 		Threads: []freeScoutThread{
 			{
-				Text: message,
-				Type: "customer",
-				Customer: &freeScoutCustomer{
-					Email: f.opts.CustomerEmail,
-				},
+				Text:        message,
+				Type:        f.opts.InitialThreadType,
+				Customer:    customer,
+				User:        user,
+				Attachments: attachments,
 			},
 		},
-		Imported: false,
+		Imported: f.opts.Imported,
+		FolderID: f.opts.FolderID,
 		Status:   "active",
+		Source:   f.opts.Source,
 	}
-	if f.opts.AssignTo > 0 {
-		assignTo := f.opts.AssignTo
+	if assignTo := f.assignTo(ctx); assignTo > 0 {
 		payload.AssignTo = &assignTo
 	}
 
@@ -142,83 +867,552 @@ func (f *FreeScout) CreateFreeScoutConversation(ctx context.Context, subject, me
 	if err != nil {
 		return 0, err
 	}
-	req.Header.Set("X-FreeScout-API-Key", f.opts.APIToken)
-	req.Header.Set("Content-Type", "application/json")
+	f.dumpPayload(req, body)
 
-	resp, err := f.client.Do(req)
+	resp, err := f.do(req)
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
+	f.recordCreatedSubject(subject)
 
-	if resp.StatusCode/100 != 2 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("freescout request failed: status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	id, err := ParseResourceID(resp.Header)
+	if err == nil {
+		return id, nil
 	}
-
-	resourceID := resp.Header.Get("Resource-ID")
-	if resourceID == "" {
-		return 0, nil
+	if !errors.Is(err, ErrFreeScoutResourceIDMissing) {
+		return 0, fmt.Errorf("freescout conversation created: %w", err)
 	}
-	id, err := strconv.ParseInt(resourceID, 10, 64)
+
+	// some FreeScout versions (or proxies in front of it) do not return the
+	// ID of the created conversation, look it up by its subject instead.
+	level.Debug(f.logger(ctx)).Log("msg", "freescout conversation ID missing from response, searching for it")
+	id, err = f.findConversationID(ctx, subject, "")
 	if err != nil {
-		return 0, nil
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, ErrFreeScoutConversationIDNotFound
 	}
 	return id, nil
 }
 
+// FreeScoutTestConversation is a conversation created to check that the
+// FreeScout integration works.
+type FreeScoutTestConversation struct {
+	ID int64
+	// URL is the URL of the conversation in the FreeScout web interface.
+	URL string
+	// Deleted is true if the conversation was deleted after its creation.
+	Deleted bool
+}
+
+// FreeScoutTestConversationSubject is the subject of the conversations created
+// by SendTestConversation.
+const FreeScoutTestConversationSubject = "[TEST] Fleet FreeScout integration check"
+
+// SendTestConversation creates a new conversation with the test subject and
+// the provided message, exercising the same create path (mailbox, customer,
+// assignee) as CreateFreeScoutConversation but never appending to an existing
+// conversation. If cleanup is true, the conversation is deleted once created.
+func (f *FreeScout) SendTestConversation(ctx context.Context, message string, cleanup bool) (*FreeScoutTestConversation, error) {
+	id, err := f.createConversation(ctx, FreeScoutTestConversationSubject, message, nil)
+	if err != nil {
+		return nil, err
+	}
+	conv := &FreeScoutTestConversation{
+		ID:  id,
+		URL: fmt.Sprintf("%s/conversation/%d", f.opts.URL, id),
+	}
+	if cleanup {
+		if err := f.deleteConversation(ctx, id); err != nil {
+			return conv, fmt.Errorf("delete test conversation %d: %w", id, err)
+		}
+		conv.Deleted = true
+	}
+	return conv, nil
+}
+
+func (f *FreeScout) deleteConversation(ctx context.Context, conversationID int64) error {
+	endpoint := fmt.Sprintf("%s/api/conversations/%d", f.opts.URL, conversationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ParseResourceID returns the ID of the resource created by the request whose
+// response has the header, as reported by the Resource-ID header or else as
+// the last segment of the Location header. It returns
+// ErrFreeScoutResourceIDMissing if the response has neither header, or an
+// error if none of them holds a valid ID.
+func ParseResourceID(header http.Header) (int64, error) {
+	rawID := strings.TrimSpace(header.Get("Resource-ID"))
+	loc := strings.TrimRight(strings.TrimSpace(header.Get("Location")), "/")
+	if rawID == "" && loc == "" {
+		return 0, ErrFreeScoutResourceIDMissing
+	}
+	if id, err := strconv.ParseInt(rawID, 10, 64); err == nil && id > 0 {
+		return id, nil
+	}
+	if loc != "" {
+		if id, err := strconv.ParseInt(loc[strings.LastIndex(loc, "/")+1:], 10, 64); err == nil && id > 0 {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid freescout resource ID, Resource-ID %q, Location %q", rawID, loc)
+}
+
+// findExistingConversationID returns the ID of the conversation to which the
+// message is appended, or 0 if there is none: the conversation with the
+// identifier tag of the context if it has one (see
+// WithFreeScoutConversationTag), otherwise the conversation with the subject
+// if it is not empty.
 func (f *FreeScout) findExistingConversationID(ctx context.Context, subject string) (int64, error) {
-	params := url.Values{
-		"embed":         []string{"threads"},
-		"mailboxId":     []string{strconv.FormatInt(f.opts.MailboxID, 10)},
-		"status":        []string{"active"},
-		"state":         []string{"published"},
-		"type":          []string{"email"},
-		"customerEmail": []string{f.opts.CustomerEmail},
-		"subject":       []string{subject},
-		"sortField":     []string{"updatedAt"},
-		"sortOrder":     []string{"asc"},
-		"page":          []string{"1"},
-		"pageSize":      []string{"1"},
-	}
-	endpoint := fmt.Sprintf("%s/api/conversations?%s", f.opts.URL, params.Encode())
+	if tag, ok := FreeScoutConversationTagFromContext(ctx); ok && tag != "" {
+		id, err := f.findConversationID(ctx, "", tag)
+		if err != nil || id > 0 {
+			return id, err
+		}
+		// the conversations created before the tag was attached are only
+		// found by their subject.
+	}
+	if subject == "" {
+		return 0, nil
+	}
+	return f.findConversationID(ctx, subject, "")
+}
+
+// findConversationID returns the ID of the first conversation with the subject
+// or the tag, or 0 if there is none. A response that cannot be decoded is
+// handled as configured by SearchDecodeRetries and SearchDecodeErrorPolicy,
+// and a search by subject that finds none of the conversations recently
+// created by the client is retried as configured by SearchIndexRetries. A
+// search by tag is not retried, as the tag is only attached once the
+// conversation is created.
+func (f *FreeScout) findConversationID(ctx context.Context, subject, tag string) (int64, error) {
+	indexAttempt := 0
+	for attempt := 0; ; attempt++ {
+		ids, _, err := f.listConversations(ctx, f.conversationsURL(subject, tag, 1, 1))
+		var decodeErr *freeScoutDecodeError
+		if errors.As(err, &decodeErr) {
+			if attempt < f.opts.SearchDecodeRetries {
+				level.Warn(f.logger(ctx)).Log("msg", "malformed freescout search response, retrying", "attempt", attempt+1, "err", err)
+				continue
+			}
+			if f.opts.SearchDecodeErrorPolicy == FreeScoutDecodeErrorCreate {
+				level.Warn(f.logger(ctx)).Log("msg", "malformed freescout search response, creating a new conversation", "err", err)
+				return 0, nil
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(ids) > 0 {
+			return ids[0], nil
+		}
+		if tag != "" || indexAttempt >= f.opts.SearchIndexRetries || !f.recentlyCreated(subject) {
+			return 0, nil
+		}
+		indexAttempt++
+		level.Debug(f.logger(ctx)).Log("msg", "recently created freescout conversation not found, retrying search", "attempt", indexAttempt, "delay", f.opts.SearchIndexDelay)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(f.opts.SearchIndexDelay):
+		}
+	}
+}
+
+// defaultFreeScoutSearchIndexDelay is the default of
+// FreeScoutOptions.SearchIndexDelay.
+const defaultFreeScoutSearchIndexDelay = time.Second
+
+// freeScoutRecentSubjectTTL is how long the subject of a conversation created
+// by the client is remembered, i.e. the maximum lag of FreeScout's search
+// index for which the search is retried.
+const freeScoutRecentSubjectTTL = 5 * time.Minute
+
+// recordCreatedSubject remembers that the client created a conversation with
+// the subject, if SearchIndexRetries is set. The subjects older than
+// freeScoutRecentSubjectTTL are forgotten.
+func (f *FreeScout) recordCreatedSubject(subject string) {
+	if f.opts.SearchIndexRetries <= 0 {
+		return
+	}
+	f.recentMu.Lock()
+	defer f.recentMu.Unlock()
+
+	now := time.Now()
+	for s, at := range f.recent {
+		if now.Sub(at) > freeScoutRecentSubjectTTL {
+			delete(f.recent, s)
+		}
+	}
+	if f.recent == nil {
+		f.recent = make(map[string]time.Time)
+	}
+	f.recent[subject] = now
+}
+
+// recentlyCreated returns true if the client created a conversation with the
+// subject less than freeScoutRecentSubjectTTL ago.
+func (f *FreeScout) recentlyCreated(subject string) bool {
+	f.recentMu.Lock()
+	defer f.recentMu.Unlock()
+
+	at, ok := f.recent[subject]
+	return ok && time.Since(at) <= freeScoutRecentSubjectTTL
+}
+
+// ListConversations returns the IDs of a page of the conversations matching
+// the client's mailbox, customer and search filters, in the search sort order
+// (least recently updated first by default), along with the pagination
+// metadata of the response. If subject is
+// not empty, only the conversations with that subject are listed. Pages start
+// at 1.
+func (f *FreeScout) ListConversations(ctx context.Context, subject string, page, pageSize int) ([]int64, FreeScoutPage, error) {
+	return f.listConversations(ctx, f.conversationsURL(subject, "", page, pageSize))
+}
+
+// listConversations returns the IDs of the conversations listed by the
+// request to the endpoint, see conversationsURL.
+func (f *FreeScout) listConversations(ctx context.Context, endpoint string) ([]int64, FreeScoutPage, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
-		return 0, err
+		return nil, FreeScoutPage{}, err
 	}
-	req.Header.Set("X-FreeScout-API-Key", f.opts.APIToken)
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := f.client.Do(req)
+	resp, err := f.do(req)
 	if err != nil {
-		return 0, err
+		return nil, FreeScoutPage{}, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode/100 != 2 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("freescout request failed: status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, FreeScoutPage{}, &freeScoutDecodeError{err: err}
+	}
+	conversations, pageInfo, shape, err := decodeFreeScoutConversations(body)
+	if err != nil {
+		if errors.Is(err, errFreeScoutUnknownShape) {
+			// do not fail the request, as it only prevents finding an existing
+			// conversation, but make the likely misconfiguration visible.
+			level.Warn(f.logger(ctx)).Log("msg", "unrecognized freescout conversations response, no conversation found", "body", freeScoutBodySnippet(body))
+			return nil, FreeScoutPage{}, nil
+		}
+		return nil, FreeScoutPage{}, &freeScoutDecodeError{err: err}
+	}
+	level.Debug(f.logger(ctx)).Log("msg", "decoded freescout conversations", "shape", shape, "count", len(conversations))
+
+	ids := make([]int64, 0, len(conversations))
+	for _, c := range conversations {
+		ids = append(ids, c.ID)
+	}
+	return ids, pageInfo, nil
+}
+
+// DedupSearchURL returns the URL of the request made to search for an existing
+// conversation with the subject, to which a new message is appended instead of
+// creating a new conversation. It is meant for troubleshooting, e.g. to run
+// the search manually: it has no side effects and the API token, sent in a
+// header, is not part of it.
+func (f *FreeScout) DedupSearchURL(subject string) string {
+	return f.conversationsURL(subject, "", 1, 1)
+}
+
+// conversationsURL returns the URL of the request listing the conversations
+// with the subject and the tag, or all conversations if they are empty.
+func (f *FreeScout) conversationsURL(subject, tag string, page, pageSize int) string {
+	params := url.Values{
+		"embed":     []string{"threads"},
+		"mailboxId": []string{strconv.FormatInt(f.opts.MailboxID, 10)},
+		"status":    []string{f.opts.SearchStatus},
+		"state":     []string{f.opts.SearchState},
+		"type":      []string{f.opts.ConversationType},
+		"sortField": []string{f.opts.SearchSortField},
+		"sortOrder": []string{f.opts.SearchSortOrder},
+		"page":      []string{strconv.Itoa(page)},
+		"pageSize":  []string{strconv.Itoa(pageSize)},
+	}
+	if f.opts.CustomerEmail != "" {
+		params.Set("customerEmail", f.opts.CustomerEmail)
+	}
+	if subject != "" {
+		params.Set("subject", subject)
+	}
+	if tag != "" {
+		params.Set("tag", tag)
+	}
+	return fmt.Sprintf("%s/api/conversations?%s", f.opts.URL, params.Encode())
+}
+
+// FreeScoutUser is a user of FreeScout, to whom conversations can be
+// assigned.
+type FreeScoutUser struct {
+	ID    int64
+	Name  string
+	Email string
+}
+
+type freeScoutUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+}
+
+// freeScoutUsersPageSize is the number of users listed per request by
+// ListUsers.
+const freeScoutUsersPageSize = 100
+
+// ListUsers returns the users of FreeScout, following the pages of the
+// response until the last one. The users are cached for UsersCacheTTL, if
+// set, so that they are listed at most once per TTL.
+func (f *FreeScout) ListUsers(ctx context.Context) ([]FreeScoutUser, error) {
+	if f.opts.UsersCacheTTL > 0 {
+		f.usersMu.Lock()
+		defer f.usersMu.Unlock()
+		if f.users != nil && time.Since(f.usersAt) <= f.opts.UsersCacheTTL {
+			return slices.Clone(f.users), nil
+		}
+	}
+
+	users := []FreeScoutUser{}
+	for page := 1; ; page++ {
+		list, pageInfo, err := f.listUsers(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, list...)
+		// an empty page ends the listing even if the metadata is off, so that
+		// it cannot loop forever.
+		if len(list) == 0 || !pageInfo.HasNext() {
+			break
+		}
+	}
+
+	if f.opts.UsersCacheTTL > 0 {
+		f.users, f.usersAt = users, time.Now()
+		return slices.Clone(users), nil
 	}
+	return users, nil
+}
 
-	var payload freeScoutConversationsResponse
+// listUsers returns a page of the users of FreeScout, along with the
+// pagination metadata of the response. Pages start at 1.
+func (f *FreeScout) listUsers(ctx context.Context, page int) ([]FreeScoutUser, FreeScoutPage, error) {
+	params := url.Values{
+		"page":     []string{strconv.Itoa(page)},
+		"pageSize": []string{strconv.Itoa(freeScoutUsersPageSize)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/users?%s", f.opts.URL, params.Encode()), nil)
+	if err != nil {
+		return nil, FreeScoutPage{}, err
+	}
+	resp, err := f.do(req)
+	if err != nil {
+		return nil, FreeScoutPage{}, fmt.Errorf("list users page %d: %w", page, err)
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		Embedded struct {
+			Users []freeScoutUser `json:"users"`
+		} `json:"_embedded"`
+		Page FreeScoutPage `json:"_page"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, err
+		return nil, FreeScoutPage{}, fmt.Errorf("decode users page %d: %w", page, err)
 	}
-	if len(payload.Embedded.Conversations) == 0 {
-		return 0, nil
+	users := make([]FreeScoutUser, 0, len(payload.Embedded.Users))
+	for _, u := range payload.Embedded.Users {
+		users = append(users, FreeScoutUser{
+			ID:    u.ID,
+			Name:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			Email: u.Email,
+		})
+	}
+	return users, payload.Page, nil
+}
+
+// AssignConversation assigns the conversation to the user. The update is made
+// on behalf of that same user, as FreeScout requires one.
+func (f *FreeScout) AssignConversation(ctx context.Context, conversationID, userID int64) error {
+	body, err := json.Marshal(freeScoutConversationUpdatePayload{
+		ByUser:   userID,
+		AssignTo: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/conversations/%d", f.opts.URL, conversationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CloseConversation closes the conversation. The update is made on behalf of
+// the user, as FreeScout requires one.
+func (f *FreeScout) CloseConversation(ctx context.Context, conversationID, byUser int64) error {
+	body, err := json.Marshal(freeScoutConversationStatusPayload{
+		ByUser: byUser,
+		Status: "closed",
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/conversations/%d", f.opts.URL, conversationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// TagConversation replaces the tags of the conversation with the provided
+// ones. It requires the FreeScout Tags module.
+func (f *FreeScout) TagConversation(ctx context.Context, conversationID int64, tags []string) error {
+	body, err := json.Marshal(freeScoutConversationTagsPayload{Tags: tags})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/conversations/%d/tags", f.opts.URL, conversationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SetConversationCustomField sets the value of the custom field of the
+// conversation, the other custom fields are left unchanged. It requires the
+// FreeScout Custom Fields module.
+func (f *FreeScout) SetConversationCustomField(ctx context.Context, conversationID, fieldID int64, value string) error {
+	body, err := json.Marshal(freeScoutConversationCustomFieldsPayload{
+		CustomFields: []freeScoutCustomField{{ID: fieldID, Value: value}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/conversations/%d/custom_fields", f.opts.URL, conversationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return err
 	}
-	return payload.Embedded.Conversations[0].ID, nil
+	resp.Body.Close()
+	return nil
 }
 
-func (f *FreeScout) createFreeScoutThread(ctx context.Context, conversationID int64, message string) error {
+// threadAuthor returns the author of a thread of the type: the customer of a
+// customer thread, or the ID of the user of a note.
+func (f *FreeScout) threadAuthor(threadType string) (*freeScoutCustomer, int64) {
+	if threadType == FreeScoutThreadTypeNote {
+		return nil, f.opts.NoteUserID
+	}
+	return f.customer(), 0
+}
+
+type freeScoutAssignToKey struct{}
+
+// WithFreeScoutAssignTo returns a context that overrides
+// FreeScoutOptions.AssignTo for the conversations created, or reassigned with
+// ReassignOnAppend, with that context, e.g. to assign a job's conversation
+// depending on its content. A userID of 0 leaves them unassigned.
+func WithFreeScoutAssignTo(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, freeScoutAssignToKey{}, userID)
+}
+
+// FreeScoutAssignToFromContext returns the assignee set by
+// WithFreeScoutAssignTo, and false if the context has none.
+func FreeScoutAssignToFromContext(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(freeScoutAssignToKey{}).(int64)
+	return userID, ok
+}
+
+type freeScoutConversationTagKey struct{}
+
+// WithFreeScoutConversationTag returns a context with which
+// CreateFreeScoutConversation searches for the existing conversation by the
+// identifier tag, e.g. "fleet-cve:CVE-2024-1234", so that it is found whatever
+// its subject, falling back to the subject for the conversations without the
+// tag. The tag is not attached to the created conversations, see
+// TagConversation.
+func WithFreeScoutConversationTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, freeScoutConversationTagKey{}, tag)
+}
+
+// FreeScoutConversationTagFromContext returns the identifier tag set by
+// WithFreeScoutConversationTag, and false if the context has none.
+func FreeScoutConversationTagFromContext(ctx context.Context) (string, bool) {
+	tag, ok := ctx.Value(freeScoutConversationTagKey{}).(string)
+	return tag, ok
+}
+
+// assignTo returns the user to whom the conversations are assigned, the one
+// of the context if any, otherwise AssignTo.
+func (f *FreeScout) assignTo(ctx context.Context) int64 {
+	if userID, ok := FreeScoutAssignToFromContext(ctx); ok {
+		return userID
+	}
+	return f.opts.AssignTo
+}
+
+// customer returns the customer of the conversations and customer threads,
+// or nil if CustomerEmail is not set, in which case all threads are notes.
+func (f *FreeScout) customer() *freeScoutCustomer {
+	if f.opts.CustomerEmail == "" {
+		return nil
+	}
+	return &freeScoutCustomer{Email: f.opts.CustomerEmail, FirstName: f.opts.FromName}
+}
+
+func (f *FreeScout) createFreeScoutThread(ctx context.Context, conversationID int64, message string, attachments []freeScoutAttachment) error {
+	customer, user := f.threadAuthor(f.opts.AppendThreadType)
 	payload := freeScoutThreadPayload{
-		Type: "customer",
-		Text: message,
-		Customer: &freeScoutCustomer{
-			Email: f.opts.CustomerEmail,
-		},
-		Imported: false,
-		Status:   "active",
+		Type:        f.opts.AppendThreadType,
+		Text:        message,
+		Customer:    customer,
+		User:        user,
+		Imported:    f.opts.Imported,
+		Status:      "active",
+		Attachments: attachments,
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -230,24 +1424,477 @@ func (f *FreeScout) createFreeScoutThread(ctx context.Context, conversationID in
 	if err != nil {
 		return err
 	}
-	req.Header.Set("X-FreeScout-API-Key", f.opts.APIToken)
-	req.Header.Set("Content-Type", "application/json")
+	f.dumpPayload(req, body)
 
-	resp, err := f.client.Do(req)
+	resp, err := f.do(req)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
+	return nil
+}
+
+// do sets the custom and authentication headers on the request, sends it to FreeScout and
+// returns the response if it has a 2xx status code. Otherwise, it returns an
+// error that includes the response body. The caller is responsible for closing
+// the body of a successful response.
+func (f *FreeScout) do(req *http.Request) (*http.Response, error) {
+	for name, value := range f.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if f.opts.AuthMode == FreeScoutAuthModeBearer {
+		req.Header.Set("Authorization", "Bearer "+f.opts.APIToken)
+	} else {
+		req.Header.Set("X-FreeScout-API-Key", f.opts.APIToken)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id, ok := jobid.FromContext(req.Context()); ok && f.opts.JobIDHeader != "" {
+		req.Header.Set(f.opts.JobIDHeader, strconv.FormatUint(uint64(id), 10))
+	}
+
+	logger := f.logger(req.Context())
+	level.Debug(logger).Log("msg", "sending freescout request", "method", req.Method, "path", req.URL.Path)
+
+	for attempt := 0; ; attempt++ {
+		resp, err := f.client.Do(req)
+		if err != nil {
+			level.Debug(logger).Log("msg", "freescout request error", "method", req.Method, "path", req.URL.Path, "err", err)
+			// the other requests than GET are not idempotent, e.g. a conversation
+			// would be created twice, so they are only retried if they never
+			// reached FreeScout.
+			retryable := isTransientFreeScoutTransportError(err) &&
+				(req.Method == http.MethodGet || isUnsentFreeScoutRequestError(err))
+			if attempt >= f.opts.TransportRetries || !retryable ||
+				req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+				return nil, withFreeScoutJobID(req.Context(), err)
+			}
+
+			level.Debug(logger).Log("msg", "transient freescout transport error, retrying request", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1)
+			if err := waitFreeScoutRetry(req, retryBackoff); err != nil {
+				return nil, withFreeScoutJobID(req.Context(), err)
+			}
+			continue
+		}
+		level.Debug(logger).Log("msg", "received freescout response", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode)
 
-	if resp.StatusCode/100 != 2 {
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("freescout request failed: status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		resp.Body.Close()
+		statusErr := &freeScoutStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+
+		if isFreeScoutMaintenance(resp, respBody) {
+			return nil, withFreeScoutJobID(req.Context(), fmt.Errorf("%w: %w", ErrFreeScoutMaintenance, statusErr))
+		}
+
+		after, ok := freeScoutRetryAfter(resp, time.Now())
+		if !ok {
+			return nil, withFreeScoutJobID(req.Context(), statusErr)
+		}
+		if after > f.opts.RetryAfterBlockThreshold || attempt >= maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return nil, withFreeScoutJobID(req.Context(), &FreeScoutRetryAfterError{RetryAfter: after, Err: statusErr})
+		}
+
+		level.Debug(logger).Log("msg", "freescout rate limit, retrying request", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "retry_after", after)
+		if err := waitFreeScoutRetry(req, after); err != nil {
+			return nil, withFreeScoutJobID(req.Context(), err)
+		}
+	}
+}
+
+// waitFreeScoutRetry waits for the delay before the request is retried, and
+// rewinds its body. It returns the error of the request's context if it is
+// done before the delay.
+func waitFreeScoutRetry(req *http.Request, delay time.Duration) error {
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-time.After(delay):
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+	}
+	return nil
+}
+
+// isTransientFreeScoutTransportError returns true if the error of a request
+// that got no response is worth retrying: a timeout, a refused, reset or
+// aborted connection, or a temporary DNS failure. A DNS name that is not
+// found (NXDOMAIN) is a permanent error.
+func isTransientFreeScoutTransportError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound && (dnsErr.IsTemporary || dnsErr.IsTimeout)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isUnsentFreeScoutRequestError returns true if the error of a request
+// happened before it reached FreeScout: a failure to dial, such as a refused
+// connection or a DNS failure, as opposed to a timeout or a reset connection
+// after the request was sent.
+func isUnsentFreeScoutRequestError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// freeScoutRetryAfter returns the delay requested by the Retry-After header
+// of a 429 response at now, in seconds or as a date, and false if the
+// response is not a 429 or has no valid Retry-After.
+func freeScoutRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	raw := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if raw == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return max(time.Duration(secs)*time.Second, 0), true
+	}
+	if at, err := http.ParseTime(raw); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// isFreeScoutMaintenance returns true if the response is the maintenance
+// page of FreeScout: a 503 with an HTML body that mentions maintenance.
+func isFreeScoutMaintenance(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	trimmed := bytes.TrimSpace(body)
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") && !bytes.HasPrefix(trimmed, []byte("<")) {
+		return false
+	}
+	lower := bytes.ToLower(trimmed)
+	return bytes.Contains(lower, []byte("maintenance")) || bytes.Contains(lower, []byte("be right back"))
+}
+
+// redactedFreeScoutValue replaces the redacted values in the dumped payloads.
+const redactedFreeScoutValue = "[REDACTED]"
+
+// dumpPayload logs the body of the request if the client is configured to
+// dump the payloads, with the API token and optionally the customer email
+// redacted.
+func (f *FreeScout) dumpPayload(req *http.Request, body []byte) {
+	if !f.opts.DumpPayloads {
+		return
+	}
+	secrets := []string{f.opts.APIToken}
+	if f.opts.RedactCustomerEmail {
+		secrets = append(secrets, f.opts.CustomerEmail)
+	}
+	level.Debug(f.logger(req.Context())).Log("msg", "freescout request payload", "method", req.Method, "path", req.URL.Path,
+		"payload", string(redactFreeScoutPayload(body, secrets...)))
+}
+
+// redactFreeScoutPayload returns a copy of the JSON body with every occurrence
+// of the secrets replaced by redactedFreeScoutValue, whether they appear as is
+// or JSON-escaped (e.g. a "<" escaped as "\u003c" by json.Marshal). Empty
+// secrets are ignored.
+func redactFreeScoutPayload(body []byte, secrets ...string) []byte {
+	redacted := bytes.Clone(body)
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		escaped, err := json.Marshal(secret)
+		if err != nil {
+			continue
+		}
+		for _, form := range []string{string(escaped[1 : len(escaped)-1]), secret} {
+			redacted = bytes.ReplaceAll(redacted, []byte(form), []byte(redactedFreeScoutValue))
+		}
+	}
+	return redacted
+}
+
+// withFreeScoutJobID wraps the error of a request with the ID of the worker
+// job that made it, if the context has one.
+func withFreeScoutJobID(ctx context.Context, err error) error {
+	if id, ok := jobid.FromContext(ctx); ok {
+		return fmt.Errorf("job %d: %w", id, err)
+	}
+	return err
+}
+
+// freeScoutStatusError is the error returned by do for a response that does
+// not have a 2xx status code.
+type freeScoutStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *freeScoutStatusError) Error() string {
+	return fmt.Sprintf("freescout request failed: status %d: %s", e.StatusCode, e.Body)
+}
+
+// FreeScoutRetryAfterError is the error returned for a 429 response whose
+// Retry-After exceeds FreeScoutOptions.RetryAfterBlockThreshold, or once the
+// retries of a shorter one are exhausted. The request can be made again after
+// RetryAfter.
+type FreeScoutRetryAfterError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *FreeScoutRetryAfterError) Error() string {
+	return fmt.Sprintf("freescout rate limit, retry after %s: %v", e.RetryAfter, e.Err)
+}
+
+func (e *FreeScoutRetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// The token scopes verified by CheckScopes.
+const (
+	FreeScoutScopeReadMailbox         = "read mailbox"
+	FreeScoutScopeReadConversations   = "read conversations"
+	FreeScoutScopeCreateConversations = "create conversations"
+)
+
+// FreeScoutMissingScopesError is the error returned by CheckScopes when the
+// API token lacks some of the permissions required by the integration.
+type FreeScoutMissingScopesError struct {
+	MailboxID int64
+	Scopes    []string
+}
+
+func (e *FreeScoutMissingScopesError) Error() string {
+	return fmt.Sprintf("freescout API token is missing scopes for mailbox %d: %s", e.MailboxID, strings.Join(e.Scopes, ", "))
+}
+
+// CheckScopes verifies that the API token can read the configured mailbox and
+// read and create conversations in it. No conversation is created: the create
+// permission is checked with an empty payload, which FreeScout rejects with a
+// validation error if the token is allowed to create conversations. It
+// returns a *FreeScoutMissingScopesError listing the missing scopes, or
+// another error if a check could not be made.
+func (f *FreeScout) CheckScopes(ctx context.Context) error {
+	var missing []string
+
+	ok, err := f.canReadMailbox(ctx)
+	if err != nil {
+		return fmt.Errorf("check %s scope: %w", FreeScoutScopeReadMailbox, err)
+	}
+	if !ok {
+		missing = append(missing, FreeScoutScopeReadMailbox)
+	}
+
+	params := url.Values{
+		"mailboxId": []string{strconv.FormatInt(f.opts.MailboxID, 10)},
+		"pageSize":  []string{"1"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/conversations?%s", f.opts.URL, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	if ok, err = f.probeScope(req); err != nil {
+		return fmt.Errorf("check %s scope: %w", FreeScoutScopeReadConversations, err)
+	}
+	if !ok {
+		missing = append(missing, FreeScoutScopeReadConversations)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, f.opts.URL+"/api/conversations", strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	if ok, err = f.probeScope(req); err != nil {
+		return fmt.Errorf("check %s scope: %w", FreeScoutScopeCreateConversations, err)
+	}
+	if !ok {
+		missing = append(missing, FreeScoutScopeCreateConversations)
 	}
 
+	if len(missing) > 0 {
+		return &FreeScoutMissingScopesError{MailboxID: f.opts.MailboxID, Scopes: missing}
+	}
 	return nil
 }
 
+// canReadMailbox returns true if the configured mailbox is in the list of
+// mailboxes visible with the API token.
+func (f *FreeScout) canReadMailbox(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.opts.URL+"/api/mailboxes", nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := f.do(req)
+	if err != nil {
+		if isFreeScoutForbidden(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var mailboxes struct {
+		Embedded struct {
+			Mailboxes []struct {
+				ID int64 `json:"id"`
+			} `json:"mailboxes"`
+		} `json:"_embedded"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mailboxes); err != nil {
+		return false, fmt.Errorf("decode mailboxes: %w", err)
+	}
+	for _, m := range mailboxes.Embedded.Mailboxes {
+		if m.ID == f.opts.MailboxID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FreeScoutMailboxTypeError is the error returned by CheckMailboxType when the
+// configured mailbox cannot hold conversations of the configured type.
+type FreeScoutMailboxTypeError struct {
+	MailboxID        int64
+	MailboxType      string
+	ConversationType string
+}
+
+func (e *FreeScoutMailboxTypeError) Error() string {
+	return fmt.Sprintf("freescout mailbox %d of type %q does not support conversations of type %q", e.MailboxID, e.MailboxType, e.ConversationType)
+}
+
+// CheckMailboxType verifies that the configured mailbox can hold conversations
+// of the configured type. FreeScout's own mailboxes are email mailboxes, which
+// hold conversations of any type, while the mailboxes added by modules (e.g.
+// for a chat widget) report their type and only hold conversations of that
+// type. It returns a *FreeScoutMailboxTypeError if the types are
+// incompatible, or another error if the mailbox could not be read.
+func (f *FreeScout) CheckMailboxType(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/mailboxes/%d", f.opts.URL, f.opts.MailboxID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.do(req)
+	if err != nil {
+		return fmt.Errorf("get mailbox %d: %w", f.opts.MailboxID, err)
+	}
+	defer resp.Body.Close()
+
+	var mailbox struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mailbox); err != nil {
+		return fmt.Errorf("decode mailbox %d: %w", f.opts.MailboxID, err)
+	}
+	mailboxType := strings.ToLower(strings.TrimSpace(mailbox.Type))
+	if mailboxType == "" || mailboxType == "email" || mailboxType == f.opts.ConversationType {
+		return nil
+	}
+	return &FreeScoutMailboxTypeError{MailboxID: f.opts.MailboxID, MailboxType: mailboxType, ConversationType: f.opts.ConversationType}
+}
+
+// probeScope sends the request and returns false if it was rejected for lack
+// of permission, or true if it was authorized, even if it then failed
+// validation.
+func (f *FreeScout) probeScope(req *http.Request) (bool, error) {
+	resp, err := f.do(req)
+	if err != nil {
+		var statusErr *freeScoutStatusError
+		switch {
+		case isFreeScoutForbidden(err):
+			return false, nil
+		case errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnprocessableEntity):
+			return true, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// isFreeScoutForbidden returns true if err is a response rejected because of
+// the API token.
+func isFreeScoutForbidden(err error) bool {
+	var statusErr *freeScoutStatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden)
+}
+
+// logger returns the client's logger, decorated with the correlation ID and
+// the worker job ID of the context if there are.
+func (f *FreeScout) logger(ctx context.Context) kitlog.Logger {
+	logger := f.opts.Logger
+	if id, ok := correlation.FromContext(ctx); ok {
+		logger = kitlog.With(logger, "correlation_id", id)
+	}
+	if id, ok := jobid.FromContext(ctx); ok {
+		logger = kitlog.With(logger, "job_id", id)
+	}
+	return logger
+}
+
+// CloseIdleConnections closes the idle connections of the client's transport,
+// it should be called when the client is not going to be used anymore. It is
+// a no-op if the HTTP client provided in the options does not support it.
+func (f *FreeScout) CloseIdleConnections() {
+	if closer, ok := f.client.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
 // FreeScoutConfigMatches returns true if the FreeScout client has been configured using those same options.
+// The options are normalized the same way as when the client is created, so that e.g. a trailing slash in
+// the URL or an unset default does not cause a mismatch, while any change to the credentials (such as a
+// rotated API token) does. The options are compared field by field, so that a field added to
+// FreeScoutOptions, e.g. of a func type that cannot be compared, must be added here explicitly (which
+// TestFreeScoutConfigMatchesEachField enforces); no headers and an empty map of headers match. The
+// Transport settings are compared too, as they only apply to new clients, while HTTPClient and Logger,
+// which do not change the requests made, are not.
 func (f *FreeScout) FreeScoutConfigMatches(opts *FreeScoutOptions) bool {
-	return f.opts == *opts
+	cur, other := f.opts, normalizeFreeScoutOptions(*opts)
+	return cur.URL == other.URL &&
+		cur.APIToken == other.APIToken &&
+		cur.MailboxID == other.MailboxID &&
+		cur.CustomerEmail == other.CustomerEmail &&
+		cur.AssignTo == other.AssignTo &&
+		cur.FolderID == other.FolderID &&
+		cur.AuthMode == other.AuthMode &&
+		cur.SearchStatus == other.SearchStatus &&
+		cur.SearchState == other.SearchState &&
+		cur.SearchSortField == other.SearchSortField &&
+		cur.SearchSortOrder == other.SearchSortOrder &&
+		cur.SearchDecodeRetries == other.SearchDecodeRetries &&
+		cur.SearchDecodeErrorPolicy == other.SearchDecodeErrorPolicy &&
+		cur.AppendNotFoundPolicy == other.AppendNotFoundPolicy &&
+		cur.SearchIndexRetries == other.SearchIndexRetries &&
+		cur.SearchIndexDelay == other.SearchIndexDelay &&
+		cur.RetryAfterBlockThreshold == other.RetryAfterBlockThreshold &&
+		cur.TransportRetries == other.TransportRetries &&
+		cur.UsersCacheTTL == other.UsersCacheTTL &&
+		cur.ReassignOnAppend == other.ReassignOnAppend &&
+		cur.ConversationType == other.ConversationType &&
+		cur.Imported == other.Imported &&
+		cur.InitialThreadType == other.InitialThreadType &&
+		cur.AppendThreadType == other.AppendThreadType &&
+		cur.NoteUserID == other.NoteUserID &&
+		cur.FromName == other.FromName &&
+		cur.Source == other.Source &&
+		maps.Equal(cur.Headers, other.Headers) &&
+		cur.JobIDHeader == other.JobIDHeader &&
+		cur.DumpPayloads == other.DumpPayloads &&
+		cur.RedactCustomerEmail == other.RedactCustomerEmail &&
+		cur.MaxAttachmentBytes == other.MaxAttachmentBytes &&
+		cur.VerifyScopes == other.VerifyScopes &&
+		cur.MailboxTypeCheck == other.MailboxTypeCheck &&
+		cur.Transport == other.Transport
 }
@@ -0,0 +1,2497 @@
+package externalsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/jobid"
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeScoutConfigMatches(t *testing.T) {
+	opts := FreeScoutOptions{
+		URL:           "https://freescout.example.com/",
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	}
+	client, err := NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+
+	// same options, with or without the trailing slash
+	require.True(t, client.FreeScoutConfigMatches(&opts))
+	same := opts
+	same.URL = "https://freescout.example.com"
+	require.True(t, client.FreeScoutConfigMatches(&same))
+
+	// rotated API token
+	rotated := opts
+	rotated.APIToken = "new-token"
+	require.False(t, client.FreeScoutConfigMatches(&rotated))
+
+	// other mailbox
+	other := opts
+	other.MailboxID = 2
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// explicit default search filters
+	defaults := opts
+	defaults.SearchStatus, defaults.SearchState = "active", "published"
+	require.True(t, client.FreeScoutConfigMatches(&defaults))
+
+	// other search filters
+	other = opts
+	other.SearchStatus = "closed"
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// explicit default and other conversation type
+	defaults.ConversationType = "email"
+	require.True(t, client.FreeScoutConfigMatches(&defaults))
+	other = opts
+	other.ConversationType = "phone"
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// imported conversations
+	other = opts
+	other.Imported = true
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// other folder
+	other = opts
+	other.FolderID = 3
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// explicit default and other auth mode
+	defaults.AuthMode = FreeScoutAuthModeAPIKey
+	require.True(t, client.FreeScoutConfigMatches(&defaults))
+	other = opts
+	other.AuthMode = FreeScoutAuthModeBearer
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// other connection pool settings, applied by a new client
+	other = opts
+	other.Transport.MaxConnsPerHost = 5
+	require.False(t, client.FreeScoutConfigMatches(&other))
+
+	// custom headers
+	withHeaders := opts
+	withHeaders.Headers = map[string]string{"X-Gateway-Key": "key"}
+	require.False(t, client.FreeScoutConfigMatches(&withHeaders))
+	client, err = NewFreeScoutClient(&withHeaders)
+	require.NoError(t, err)
+	require.True(t, client.FreeScoutConfigMatches(&withHeaders))
+	other = withHeaders
+	other.Headers = map[string]string{"X-Gateway-Key": "rotated"}
+	require.False(t, client.FreeScoutConfigMatches(&other))
+	require.False(t, client.FreeScoutConfigMatches(&opts))
+}
+
+func TestFreeScoutConfigMatchesEachField(t *testing.T) {
+	opts := FreeScoutOptions{
+		URL:           "https://freescout.example.com",
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	}
+	client, err := NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+
+	// the options that do not change the requests made are ignored
+	ignored := map[string]bool{"Logger": true, "HTTPClient": true}
+
+	// every other field causes a mismatch when changed, whatever its type,
+	// so that a field added to the options cannot be forgotten.
+	typ := reflect.TypeOf(opts)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		other := opts
+		v := reflect.ValueOf(&other).Elem().Field(i)
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(v.String() + "-changed")
+		case reflect.Bool:
+			v.SetBool(!v.Bool())
+		case reflect.Int, reflect.Int64:
+			v.SetInt(v.Int() + 42)
+		case reflect.Map:
+			m := reflect.MakeMap(field.Type)
+			m.SetMapIndex(reflect.New(field.Type.Key()).Elem(), reflect.New(field.Type.Elem()).Elem())
+			v.Set(m)
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(field.Type, 1, 1))
+		case reflect.Pointer:
+			v.Set(reflect.New(field.Type.Elem()))
+		case reflect.Struct:
+			// set the first field of the nested options
+			f := v.Field(0)
+			require.Equal(t, reflect.Int, f.Kind(), field.Name)
+			f.SetInt(f.Int() + 42)
+		case reflect.Interface:
+			impls := map[string]any{"Logger": kitlog.NewNopLogger(), "HTTPClient": http.DefaultClient}
+			require.Contains(t, impls, field.Name, "add an implementation of the field to the test")
+			v.Set(reflect.ValueOf(impls[field.Name]))
+		case reflect.Func:
+			// funcs cannot be compared, a func field must be ignored or compared explicitly
+			v.Set(reflect.MakeFunc(field.Type, func(args []reflect.Value) []reflect.Value {
+				results := make([]reflect.Value, field.Type.NumOut())
+				for i := range results {
+					results[i] = reflect.Zero(field.Type.Out(i))
+				}
+				return results
+			}))
+		default:
+			t.Fatalf("unsupported kind %s of field %s, add it to the test", v.Kind(), field.Name)
+		}
+		require.Equal(t, ignored[field.Name], client.FreeScoutConfigMatches(&other), field.Name)
+	}
+
+	// no headers and an empty map of headers match
+	other := opts
+	other.Headers = map[string]string{}
+	require.True(t, client.FreeScoutConfigMatches(&other))
+}
+
+func TestFreeScoutCustomHeaders(t *testing.T) {
+	var mu sync.Mutex
+	headers := make(map[string]http.Header)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.Method+" "+r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if r.URL.Query().Get("subject") == "existing" {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", "7")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	opts := &FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+		Headers:       map[string]string{"X-Gateway-Key": "gateway", "X-Tenant": "acme"},
+	}
+	client, err := NewFreeScoutClient(opts)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, _, err = client.CreateFreeScoutConversation(ctx, "new", "message")
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "existing", "message")
+	require.NoError(t, err)
+
+	require.Len(t, headers, 3)
+	for _, key := range []string{"GET /api/conversations", "POST /api/conversations", "POST /api/conversations/9/threads"} {
+		h := headers[key]
+		require.NotNil(t, h, key)
+		require.Equal(t, "gateway", h.Get("X-Gateway-Key"), key)
+		require.Equal(t, "acme", h.Get("X-Tenant"), key)
+		require.Equal(t, "token", h.Get("X-FreeScout-API-Key"), key)
+	}
+
+	// the authentication and content type headers cannot be overridden
+	for _, name := range []string{"X-FreeScout-API-Key", "x-freescout-api-key", "Content-Type"} {
+		opts.Headers = map[string]string{name: "value"}
+		_, err = NewFreeScoutClient(opts)
+		require.ErrorContains(t, err, "cannot be overridden")
+	}
+}
+
+func TestFreeScoutAuthMode(t *testing.T) {
+	var mu sync.Mutex
+	headers := make(map[string]http.Header)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers[r.Method+" "+r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if r.URL.Query().Get("subject") == "existing" {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", "7")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	cases := []struct {
+		mode          string
+		apiKey        string
+		authorization string
+	}{
+		{"", "token", ""},
+		{FreeScoutAuthModeAPIKey, "token", ""},
+		{FreeScoutAuthModeBearer, "", "Bearer token"},
+	}
+	for _, c := range cases {
+		t.Run(c.mode, func(t *testing.T) {
+			clear(headers)
+			client, err := NewFreeScoutClient(&FreeScoutOptions{
+				URL:           srv.URL,
+				APIToken:      "token",
+				AuthMode:      c.mode,
+				MailboxID:     1,
+				CustomerEmail: "fleet@example.com",
+			})
+			require.NoError(t, err)
+
+			_, _, err = client.CreateFreeScoutConversation(ctx, "new", "message")
+			require.NoError(t, err)
+			_, _, err = client.CreateFreeScoutConversation(ctx, "existing", "message")
+			require.NoError(t, err)
+
+			require.Len(t, headers, 3)
+			for key, h := range headers {
+				require.Equal(t, c.apiKey, h.Get("X-FreeScout-API-Key"), key)
+				require.Equal(t, c.authorization, h.Get("Authorization"), key)
+			}
+		})
+	}
+
+	// the Authorization header is reserved only in the bearer mode
+	opts := &FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+		Headers:       map[string]string{"authorization": "Basic gateway"},
+	}
+	_, err := NewFreeScoutClient(opts)
+	require.NoError(t, err)
+	opts.AuthMode = FreeScoutAuthModeBearer
+	_, err = NewFreeScoutClient(opts)
+	require.ErrorContains(t, err, "cannot be overridden")
+
+	opts.AuthMode = "oauth"
+	opts.Headers = nil
+	_, err = NewFreeScoutClient(opts)
+	require.ErrorContains(t, err, "invalid FreeScout auth mode")
+}
+
+func TestFreeScoutSearchFilters(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":1}]}}`))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		status, state         string
+		wantStatus, wantState string
+	}{
+		{"", "", "active", "published"},
+		{"closed", "", "closed", "published"},
+		{"", "draft", "active", "draft"},
+		{"pending", "deleted", "pending", "deleted"},
+	}
+	for _, c := range cases {
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:           srv.URL,
+			MailboxID:     1,
+			CustomerEmail: "fleet@example.com",
+			SearchStatus:  c.status,
+			SearchState:   c.state,
+		})
+		require.NoError(t, err)
+
+		id, err := client.findExistingConversationID(context.Background(), "subject")
+		require.NoError(t, err)
+		require.EqualValues(t, 1, id)
+		require.Equal(t, c.wantStatus, query.Get("status"))
+		require.Equal(t, c.wantState, query.Get("state"))
+		require.Equal(t, "subject", query.Get("subject"))
+	}
+
+	// invalid values are rejected
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, SearchStatus: "archived"})
+	require.ErrorContains(t, err, "invalid FreeScout search status")
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, SearchState: "hidden"})
+	require.ErrorContains(t, err, "invalid FreeScout search state")
+}
+
+func TestFreeScoutConversationType(t *testing.T) {
+	var searchType, payloadType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			searchType = r.URL.Query().Get("type")
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var payload freeScoutConversationPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			payloadType = payload.Type
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		convType string
+		want     string
+	}{
+		{"", "email"},
+		{"phone", "phone"},
+		{"chat", "chat"},
+	}
+	for _, c := range cases {
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:              srv.URL,
+			MailboxID:        1,
+			CustomerEmail:    "fleet@example.com",
+			ConversationType: c.convType,
+		})
+		require.NoError(t, err)
+
+		_, created, err := client.CreateFreeScoutConversation(context.Background(), "subject", "message")
+		require.NoError(t, err)
+		require.True(t, created)
+		require.Equal(t, c.want, searchType)
+		require.Equal(t, c.want, payloadType)
+	}
+
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, ConversationType: "fax"})
+	require.ErrorContains(t, err, "invalid FreeScout conversation type")
+}
+
+func TestFreeScoutImported(t *testing.T) {
+	var conversations []freeScoutConversationPayload
+	var threads []freeScoutThreadPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if r.URL.Query().Get("subject") == "existing" {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var payload freeScoutConversationPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			conversations = append(conversations, payload)
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			var payload freeScoutThreadPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			threads = append(threads, payload)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, imported := range []bool{false, true} {
+		conversations, threads = nil, nil
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:           srv.URL,
+			MailboxID:     1,
+			CustomerEmail: "fleet@example.com",
+			Imported:      imported,
+		})
+		require.NoError(t, err)
+
+		_, _, err = client.CreateFreeScoutConversation(ctx, "new", "message")
+		require.NoError(t, err)
+		_, _, err = client.CreateFreeScoutConversation(ctx, "existing", "message")
+		require.NoError(t, err)
+
+		require.Len(t, conversations, 1)
+		require.Equal(t, imported, conversations[0].Imported)
+		require.Len(t, threads, 1)
+		require.Equal(t, imported, threads[0].Imported)
+	}
+}
+
+func TestFreeScoutListConversations(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		switch query.Get("page") {
+		case "1":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":1},{"id":2}]},"_page":{"size":2,"totalElements":3,"totalPages":2,"number":1}}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":3}]},"_page":{"size":2,"totalElements":3,"totalPages":2,"number":2}}`))
+		default:
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var all []int64
+	for page := 1; ; page++ {
+		ids, pageInfo, err := client.ListConversations(ctx, "", page, 2)
+		require.NoError(t, err)
+		require.Equal(t, page, pageInfo.Number)
+		require.Equal(t, 3, pageInfo.TotalElements)
+		require.Equal(t, 2, pageInfo.TotalPages)
+		require.Equal(t, strconv.Itoa(page), query.Get("page"))
+		require.Equal(t, "2", query.Get("pageSize"))
+		require.False(t, query.Has("subject"))
+		all = append(all, ids...)
+		if !pageInfo.HasNext() {
+			break
+		}
+	}
+	require.Equal(t, []int64{1, 2, 3}, all)
+
+	// a response without pagination metadata has no next page
+	ids, pageInfo, err := client.ListConversations(ctx, "subject", 3, 2)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.Equal(t, FreeScoutPage{}, pageInfo)
+	require.False(t, pageInfo.HasNext())
+	require.Equal(t, "subject", query.Get("subject"))
+}
+
+func TestFreeScoutListUsers(t *testing.T) {
+	var requests []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/users" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		query := r.URL.Query()
+		requests = append(requests, query)
+		switch query.Get("page") {
+		case "1":
+			_, _ = w.Write([]byte(`{"_embedded":{"users":[{"id":1,"firstName":"Ada","lastName":"Lovelace","email":"ada@example.com"},{"id":2,"firstName":"Alan","lastName":"","email":"alan@example.com"}]},"_page":{"size":2,"totalElements":3,"totalPages":2,"number":1}}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"_embedded":{"users":[{"id":3,"firstName":"Grace","lastName":"Hopper","email":"grace@example.com"}]},"_page":{"size":2,"totalElements":3,"totalPages":2,"number":2}}`))
+		default:
+			t.Errorf("unexpected page %q", query.Get("page"))
+		}
+	}))
+	defer srv.Close()
+
+	want := []FreeScoutUser{
+		{ID: 1, Name: "Ada Lovelace", Email: "ada@example.com"},
+		{ID: 2, Name: "Alan", Email: "alan@example.com"},
+		{ID: 3, Name: "Grace Hopper", Email: "grace@example.com"},
+	}
+	ctx := context.Background()
+
+	// all the pages are listed, and again on every call without cache
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+	users, err := client.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, users)
+	require.Len(t, requests, 2)
+	require.Equal(t, "1", requests[0].Get("page"))
+	require.Equal(t, "2", requests[1].Get("page"))
+	require.Equal(t, "100", requests[0].Get("pageSize"))
+	_, err = client.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, requests, 4)
+
+	// with a cache, the users are listed once per TTL
+	requests = nil
+	client, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", UsersCacheTTL: time.Minute})
+	require.NoError(t, err)
+	users, err = client.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, users)
+	require.Len(t, requests, 2)
+
+	// the cached users cannot be modified by the caller
+	users[0].Name = "changed"
+	users, err = client.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, users)
+	require.Len(t, requests, 2)
+
+	// expired
+	client.usersAt = time.Now().Add(-2 * time.Minute)
+	users, err = client.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, users)
+	require.Len(t, requests, 4)
+
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, UsersCacheTTL: -time.Second})
+	require.ErrorContains(t, err, "FreeScout users cache TTL must not be negative")
+}
+
+func TestFreeScoutListUsersError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "1":
+			_, _ = w.Write([]byte(`{"_embedded":{"users":[{"id":1,"email":"ada@example.com"}]},"_page":{"size":1,"totalElements":2,"totalPages":2,"number":1}}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", UsersCacheTTL: time.Minute})
+	require.NoError(t, err)
+	_, err = client.ListUsers(context.Background())
+	require.ErrorContains(t, err, "list users page 2")
+	// a failed listing is not cached
+	require.Nil(t, client.users)
+}
+
+func TestFreeScoutSource(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			bodies = append(bodies, body)
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, source := range []string{"", "Fleet Security"} {
+		bodies = nil
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:           srv.URL,
+			MailboxID:     1,
+			CustomerEmail: "fleet@example.com",
+			Source:        source,
+		})
+		require.NoError(t, err)
+
+		_, _, err = client.CreateFreeScoutConversation(ctx, "new", "message")
+		require.NoError(t, err)
+		require.Len(t, bodies, 1)
+		if source == "" {
+			require.NotContains(t, bodies[0], "source")
+		} else {
+			require.Equal(t, source, bodies[0]["source"])
+		}
+	}
+}
+
+func TestFreeScoutThreadTypes(t *testing.T) {
+	type customer struct {
+		Email string `json:"email"`
+	}
+	type thread struct {
+		Type     string    `json:"type"`
+		Customer *customer `json:"customer"`
+		User     int64     `json:"user"`
+	}
+	var existing bool
+	var conversationCustomer *customer
+	var threads []thread
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if existing {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var body struct {
+				Customer *customer `json:"customer"`
+				Threads  []thread  `json:"threads"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			conversationCustomer = body.Customer
+			threads = append(threads, body.Threads...)
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			var body thread
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			threads = append(threads, body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	run := func(opts FreeScoutOptions) (initial, appended thread) {
+		client, err := NewFreeScoutClient(&opts)
+		require.NoError(t, err)
+
+		threads, existing = nil, false
+		_, created, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+		require.NoError(t, err)
+		require.True(t, created)
+		// the conversation itself is always for the customer
+		require.Equal(t, &customer{Email: "fleet@example.com"}, conversationCustomer)
+
+		existing = true
+		_, created, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+		require.NoError(t, err)
+		require.False(t, created)
+		require.Len(t, threads, 2)
+		return threads[0], threads[1]
+	}
+	opts := FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"}
+	customerThread := thread{Type: "customer", Customer: &customer{Email: "fleet@example.com"}}
+	noteThread := thread{Type: "note", User: 5}
+
+	// customer threads by default
+	initial, appended := run(opts)
+	require.Equal(t, customerThread, initial)
+	require.Equal(t, customerThread, appended)
+
+	// an internal note first, customer follow-ups
+	hybrid := opts
+	hybrid.InitialThreadType = FreeScoutThreadTypeNote
+	hybrid.NoteUserID = 5
+	initial, appended = run(hybrid)
+	require.Equal(t, noteThread, initial)
+	require.Equal(t, customerThread, appended)
+
+	// and the other way around
+	reversed := opts
+	reversed.AppendThreadType = FreeScoutThreadTypeNote
+	reversed.NoteUserID = 5
+	initial, appended = run(reversed)
+	require.Equal(t, customerThread, initial)
+	require.Equal(t, noteThread, appended)
+
+	// notes require a user
+	invalid := opts
+	invalid.InitialThreadType = FreeScoutThreadTypeNote
+	_, err := NewFreeScoutClient(&invalid)
+	require.ErrorContains(t, err, "FreeScout note user ID is required for note threads")
+	invalid = opts
+	invalid.AppendThreadType = "message"
+	_, err = NewFreeScoutClient(&invalid)
+	require.ErrorContains(t, err, `invalid FreeScout thread type "message"`)
+}
+
+func TestFreeScoutCustomerEmail(t *testing.T) {
+	var query url.Values
+	var body map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			query = r.URL.Query()
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			body = nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// a valid email is the customer of the conversations
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.Equal(t, "fleet@example.com", query.Get("customerEmail"))
+	require.JSONEq(t, `{"email":"fleet@example.com"}`, string(body["customer"]))
+
+	for _, email := range []string{"fleet", "Fleet <fleet@example.com>", " fleet@example.com"} {
+		_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: email})
+		require.ErrorContains(t, err, fmt.Sprintf("invalid FreeScout customer email %q", email))
+	}
+
+	// notes do not need a customer, the conversations are created without one
+	notes := FreeScoutOptions{
+		URL:               srv.URL,
+		MailboxID:         1,
+		InitialThreadType: FreeScoutThreadTypeNote,
+		AppendThreadType:  FreeScoutThreadTypeNote,
+		NoteUserID:        5,
+	}
+	client, err = NewFreeScoutClient(&notes)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.False(t, query.Has("customerEmail"))
+	require.NotContains(t, body, "customer")
+
+	// but customer threads do, whether the first thread or the appended ones
+	invalid := notes
+	invalid.InitialThreadType = FreeScoutThreadTypeCustomer
+	_, err = NewFreeScoutClient(&invalid)
+	require.ErrorContains(t, err, "FreeScout customer email is required for customer threads")
+	invalid = notes
+	invalid.AppendThreadType = FreeScoutThreadTypeCustomer
+	_, err = NewFreeScoutClient(&invalid)
+	require.ErrorContains(t, err, "FreeScout customer email is required for customer threads")
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1})
+	require.ErrorContains(t, err, "FreeScout customer email is required for customer threads")
+}
+
+func TestFreeScoutConversationTag(t *testing.T) {
+	var searches []url.Values
+	var appended []string
+	tagged := map[string]int64{"fleet-cve:CVE-2024-1234": 7}
+	subjects := map[string]int64{"legacy subject": 8}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			query := r.URL.Query()
+			searches = append(searches, query)
+			id, ok := tagged[query.Get("tag")]
+			if !query.Has("tag") {
+				id, ok = subjects[query.Get("subject")]
+			}
+			if !ok {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"_embedded":{"conversations":[{"id":%d}]}}`, id)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/threads"):
+			appended = append(appended, r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", "9")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+
+	// without a tag, the conversation is searched by its subject only
+	id, created, err := client.CreateFreeScoutConversation(context.Background(), "legacy subject", "message")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.EqualValues(t, 8, id)
+	require.Len(t, searches, 1)
+	require.False(t, searches[0].Has("tag"))
+	require.Equal(t, "legacy subject", searches[0].Get("subject"))
+
+	// with a tag, the conversation is found by it whatever its subject
+	searches = nil
+	ctx := WithFreeScoutConversationTag(context.Background(), "fleet-cve:CVE-2024-1234")
+	id, created, err = client.CreateFreeScoutConversation(ctx, "new subject", "message")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.EqualValues(t, 7, id)
+	require.Len(t, searches, 1)
+	require.Equal(t, "fleet-cve:CVE-2024-1234", searches[0].Get("tag"))
+	require.False(t, searches[0].Has("subject"))
+	require.Equal(t, "fleet@example.com", searches[0].Get("customerEmail"))
+
+	// the conversations without the tag are still found by their subject
+	searches = nil
+	ctx = WithFreeScoutConversationTag(context.Background(), "fleet-policy:42")
+	id, created, err = client.CreateFreeScoutConversation(ctx, "legacy subject", "message")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.EqualValues(t, 8, id)
+	require.Len(t, searches, 2)
+	require.Equal(t, "fleet-policy:42", searches[0].Get("tag"))
+	require.Equal(t, "legacy subject", searches[1].Get("subject"))
+	require.Equal(t, []string{"/api/conversations/8/threads", "/api/conversations/7/threads", "/api/conversations/8/threads"}, appended)
+
+	// otherwise a new conversation is created
+	searches = nil
+	id, created, err = client.CreateFreeScoutConversation(ctx, "new subject", "message")
+	require.NoError(t, err)
+	require.True(t, created)
+	require.EqualValues(t, 9, id)
+	require.Len(t, searches, 2)
+
+	tag, ok := FreeScoutConversationTagFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, "fleet-policy:42", tag)
+	_, ok = FreeScoutConversationTagFromContext(context.Background())
+	require.False(t, ok)
+}
+
+func TestFreeScoutRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var retryAfter string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			mu.Lock()
+			bodies = append(bodies, string(body))
+			first := len(bodies) == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Retry-After", retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", RetryAfterBlockThreshold: 2 * time.Second})
+	require.NoError(t, err)
+
+	// a short Retry-After is waited for and the request retried with its body
+	bodies, retryAfter = nil, "1"
+	start := time.Now()
+	id, created, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.True(t, created)
+	require.EqualValues(t, 1, id)
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+	require.Len(t, bodies, 2)
+	require.Equal(t, bodies[0], bodies[1])
+	require.Contains(t, bodies[1], `"subject":"subject"`)
+
+	// a long one fails the request without waiting
+	bodies, retryAfter = nil, "120"
+	start = time.Now()
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	var retryErr *FreeScoutRetryAfterError
+	require.ErrorAs(t, err, &retryErr)
+	require.Equal(t, 2*time.Minute, retryErr.RetryAfter)
+	require.Less(t, time.Since(start), time.Second)
+	require.Len(t, bodies, 1)
+	var statusErr *freeScoutStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+
+	// Retry-After can also be a date
+	now := time.Now()
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", now.Add(5*time.Minute).UTC().Format(http.TimeFormat))
+	after, ok := freeScoutRetryAfter(resp, now)
+	require.True(t, ok)
+	require.InDelta(t, 5*time.Minute, after, float64(time.Second))
+	resp.Header.Set("Retry-After", "soon")
+	_, ok = freeScoutRetryAfter(resp, now)
+	require.False(t, ok)
+
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, RetryAfterBlockThreshold: -time.Second})
+	require.ErrorContains(t, err, "FreeScout retry after block threshold must not be negative")
+}
+
+func TestFreeScoutMaintenance(t *testing.T) {
+	var contentType, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+
+	// the maintenance page served during an upgrade
+	contentType = "text/html; charset=UTF-8"
+	body = "<!DOCTYPE html>\n<html><head><title>Maintenance</title></head><body><h1>Be right back.</h1></body></html>"
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.ErrorIs(t, err, ErrFreeScoutMaintenance)
+	var statusErr *freeScoutStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+
+	// an HTML page without a content type is recognized too
+	contentType = ""
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.ErrorIs(t, err, ErrFreeScoutMaintenance)
+
+	// other 503s are generic server errors
+	contentType = "application/json"
+	body = `{"message":"database unavailable"}`
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrFreeScoutMaintenance)
+	contentType = "text/html"
+	body = "<html><body>Service Unavailable</body></html>"
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrFreeScoutMaintenance)
+}
+
+func TestFreeScoutFromName(t *testing.T) {
+	type customer struct {
+		Email     string `json:"email"`
+		FirstName string `json:"firstName"`
+	}
+	type thread struct {
+		Customer *customer `json:"customer"`
+	}
+	var body struct {
+		Customer *customer `json:"customer"`
+		Threads  []thread  `json:"threads"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			body.Customer, body.Threads = nil, nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	opts := FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"}
+	client, err := NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	// no name is sent by default
+	require.Equal(t, &customer{Email: "fleet@example.com"}, body.Customer)
+	require.Len(t, body.Threads, 1)
+	require.Equal(t, &customer{Email: "fleet@example.com"}, body.Threads[0].Customer)
+
+	opts.FromName = "Fleet Security"
+	client, err = NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	want := &customer{Email: "fleet@example.com", FirstName: "Fleet Security"}
+	require.Equal(t, want, body.Customer)
+	require.Len(t, body.Threads, 1)
+	require.Equal(t, want, body.Threads[0].Customer)
+}
+
+func TestFreeScoutFolderID(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			body = nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	opts := FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"}
+	client, err := NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.NotContains(t, body, "folderId")
+
+	opts.FolderID = 12
+	client, err = NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.Equal(t, float64(12), body["folderId"])
+
+	opts.FolderID = -1
+	_, err = NewFreeScoutClient(&opts)
+	require.ErrorContains(t, err, "FreeScout folder ID must not be negative")
+}
+
+func TestFreeScoutAttachments(t *testing.T) {
+	type attachment struct {
+		FileName string `json:"fileName"`
+		MimeType string `json:"mimeType"`
+		Data     string `json:"data"`
+	}
+	type thread struct {
+		Attachments []attachment `json:"attachments"`
+	}
+	var existing bool
+	var threads []thread
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if existing {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var body struct {
+				Threads []thread `json:"threads"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			threads = append(threads, body.Threads...)
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			var body thread
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			threads = append(threads, body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", MaxAttachmentBytes: 64,
+	})
+	require.NoError(t, err)
+
+	content := []byte(`{"cve":"CVE-2024-1234","cvss_score":9.8}`)
+	meta := FreeScoutAttachment{FileName: "cve.json", MimeType: "application/json", Content: content}
+	want := attachment{FileName: "cve.json", MimeType: "application/json", Data: "eyJjdmUiOiJDVkUtMjAyNC0xMjM0IiwiY3Zzc19zY29yZSI6OS44fQ=="}
+
+	// the attachment is in the thread of a new conversation
+	_, created, err := client.CreateFreeScoutConversation(ctx, "subject", "message", meta)
+	require.NoError(t, err)
+	require.True(t, created)
+	require.Len(t, threads, 1)
+	require.Equal(t, []attachment{want}, threads[0].Attachments)
+	decoded, err := base64.StdEncoding.DecodeString(threads[0].Attachments[0].Data)
+	require.NoError(t, err)
+	require.Equal(t, content, decoded)
+
+	// and in the thread appended to an existing one
+	existing = true
+	_, created, err = client.CreateFreeScoutConversation(ctx, "subject", "message", meta)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Len(t, threads, 2)
+	require.Equal(t, []attachment{want}, threads[1].Attachments)
+
+	// no attachments are sent by default
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.Len(t, threads, 3)
+	require.Empty(t, threads[2].Attachments)
+
+	// invalid attachments are rejected without making any request
+	requests = 0
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message",
+		FreeScoutAttachment{FileName: "big.txt", MimeType: "text/plain", Content: make([]byte, 65)})
+	require.ErrorContains(t, err, `FreeScout attachment "big.txt" is 65 bytes, exceeds the maximum of 64 bytes`)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message", FreeScoutAttachment{FileName: "cve.json", Content: content})
+	require.ErrorContains(t, err, "FreeScout attachment requires a file name and a MIME type")
+	require.Zero(t, requests)
+
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MaxAttachmentBytes: -1})
+	require.ErrorContains(t, err, "FreeScout max attachment bytes must not be negative")
+}
+
+func TestFreeScoutCreateConversationWithoutID(t *testing.T) {
+	var searches int
+	var found bool
+	var badID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			searches++
+			require.Equal(t, "Vulnerability CVE-1234-5678", r.URL.Query().Get("subject"))
+			// the first search is the dedup check done before creating the
+			// conversation, it must not find anything.
+			if searches > 1 && found {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":42}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			// created, but with neither a Resource-ID nor a Location header,
+			// unless a malformed ID is set
+			if badID != "" {
+				w.Header().Set("Resource-ID", badID)
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// the ID is recovered by searching for the created conversation
+	found = true
+	id, created, err := client.CreateFreeScoutConversation(ctx, "Vulnerability CVE-1234-5678", "message")
+	require.NoError(t, err)
+	require.EqualValues(t, 42, id)
+	require.True(t, created)
+	require.Equal(t, 2, searches)
+
+	// the conversation cannot be found
+	found, searches = false, 0
+	_, _, err = client.CreateFreeScoutConversation(ctx, "Vulnerability CVE-1234-5678", "message")
+	require.True(t, errors.Is(err, ErrFreeScoutConversationIDNotFound))
+	require.Equal(t, 2, searches)
+	// a malformed ID fails the request instead of searching for it
+	badID, searches = "abc", 0
+	_, _, err = client.CreateFreeScoutConversation(ctx, "Vulnerability CVE-1234-5678", "message")
+	require.ErrorContains(t, err, `invalid freescout resource ID, Resource-ID "abc"`)
+	require.Equal(t, 1, searches)
+}
+
+func TestFreeScoutCreateConversationOrAppend(t *testing.T) {
+	var existingID int64
+	var created, appended []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if existingID > 0 {
+				_, _ = fmt.Fprintf(w, `{"_embedded":{"conversations":[{"id":%d}]}}`, existingID)
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			created = append(created, r.URL.Path)
+			w.Header().Set("Resource-ID", "7")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			appended = append(appended, r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// no existing conversation, a new one is created
+	id, wasCreated, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.EqualValues(t, 7, id)
+	require.True(t, wasCreated)
+	require.Len(t, created, 1)
+	require.Empty(t, appended)
+
+	// an existing conversation is found, the message is appended to it
+	existingID = 9
+	id, wasCreated, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.EqualValues(t, 9, id)
+	require.False(t, wasCreated)
+	require.Len(t, created, 1)
+	require.Len(t, appended, 1)
+}
+
+func TestFreeScoutReassignOnAppend(t *testing.T) {
+	var updates []freeScoutConversationUpdatePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/conversations/9":
+			var payload freeScoutConversationUpdatePayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			updates = append(updates, payload)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	opts := FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+		AssignTo:      5,
+	}
+
+	// disabled, the assignee is sticky
+	client, err := NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, created, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Empty(t, updates)
+
+	// enabled, the conversation is reassigned
+	opts.ReassignOnAppend = true
+	client, err = NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, created, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, []freeScoutConversationUpdatePayload{{ByUser: 5, AssignTo: 5}}, updates)
+
+	// enabled without an assignee, nothing to reassign
+	opts.AssignTo = 0
+	client, err = NewFreeScoutClient(&opts)
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+}
+
+func TestFreeScoutContextAssignTo(t *testing.T) {
+	var existing bool
+	var assignees []*int64
+	var updates []freeScoutConversationUpdatePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			if existing {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			var body struct {
+				AssignTo *int64 `json:"assignTo"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assignees = append(assignees, body.AssignTo)
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/conversations/9":
+			var payload freeScoutConversationUpdatePayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			updates = append(updates, payload)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:              srv.URL,
+		MailboxID:        1,
+		CustomerEmail:    "fleet@example.com",
+		AssignTo:         5,
+		ReassignOnAppend: true,
+	})
+	require.NoError(t, err)
+
+	_, ok := FreeScoutAssignToFromContext(ctx)
+	require.False(t, ok)
+	userID, ok := FreeScoutAssignToFromContext(WithFreeScoutAssignTo(ctx, 7))
+	require.True(t, ok)
+	require.EqualValues(t, 7, userID)
+
+	// the assignee of the context overrides the configured one, 0 leaves the
+	// conversation unassigned
+	for _, c := range []context.Context{ctx, WithFreeScoutAssignTo(ctx, 7), WithFreeScoutAssignTo(ctx, 0)} {
+		_, created, err := client.CreateFreeScoutConversation(c, "subject", "message")
+		require.NoError(t, err)
+		require.True(t, created)
+	}
+	require.Len(t, assignees, 3)
+	require.EqualValues(t, 5, *assignees[0])
+	require.EqualValues(t, 7, *assignees[1])
+	require.Nil(t, assignees[2])
+
+	// likewise when reassigning an existing conversation
+	existing = true
+	for _, c := range []context.Context{WithFreeScoutAssignTo(ctx, 7), WithFreeScoutAssignTo(ctx, 0)} {
+		_, created, err := client.CreateFreeScoutConversation(c, "subject", "message")
+		require.NoError(t, err)
+		require.False(t, created)
+	}
+	require.Equal(t, []freeScoutConversationUpdatePayload{{ByUser: 7, AssignTo: 7}}, updates)
+}
+
+func TestFreeScoutTagConversation(t *testing.T) {
+	var tags []freeScoutConversationTagsPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/api/conversations/9/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var payload freeScoutConversationTagsPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		tags = append(tags, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, client.TagConversation(ctx, 9, []string{"kev"}))
+	require.Equal(t, []freeScoutConversationTagsPayload{{Tags: []string{"kev"}}}, tags)
+
+	// unknown conversation
+	require.Error(t, client.TagConversation(ctx, 10, []string{"kev"}))
+}
+
+func TestFreeScoutCloseConversation(t *testing.T) {
+	var updates []freeScoutConversationStatusPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/api/conversations/9" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var payload freeScoutConversationStatusPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		updates = append(updates, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, client.CloseConversation(ctx, 9, 5))
+	require.Equal(t, []freeScoutConversationStatusPayload{{ByUser: 5, Status: "closed"}}, updates)
+
+	// unknown conversation
+	require.Error(t, client.CloseConversation(ctx, 10, 5))
+}
+
+func TestFreeScoutFindAndAppend(t *testing.T) {
+	var searches []url.Values
+	var threads []freeScoutThreadPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			searches = append(searches, r.URL.Query())
+			if r.URL.Query().Get("tag") == "fleet-cve:CVE-2024-1234" {
+				_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			var payload freeScoutThreadPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			threads = append(threads, payload)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+
+	// without a subject, the conversation is only searched by its tag
+	ctx := WithFreeScoutConversationTag(context.Background(), "fleet-cve:CVE-2024-1234")
+	id, err := client.FindFreeScoutConversation(ctx, "")
+	require.NoError(t, err)
+	require.EqualValues(t, 9, id)
+	ctx = WithFreeScoutConversationTag(context.Background(), "fleet-cve:CVE-2024-5678")
+	id, err = client.FindFreeScoutConversation(ctx, "")
+	require.NoError(t, err)
+	require.Zero(t, id)
+	require.Len(t, searches, 2)
+
+	require.NoError(t, client.AppendFreeScoutThread(ctx, 9, "resolved"))
+	require.Len(t, threads, 1)
+	require.Equal(t, "resolved", threads[0].Text)
+	require.Equal(t, FreeScoutThreadTypeCustomer, threads[0].Type)
+	require.Error(t, client.AppendFreeScoutThread(ctx, 10, "resolved"))
+}
+
+func TestParseResourceID(t *testing.T) {
+	cases := []struct {
+		resourceID string
+		location   string
+		want       int64
+		wantErr    string
+	}{
+		{"12", "", 12, ""},
+		{" 12 ", "", 12, ""},
+		{"12", "https://freescout.example.com/api/conversations/34", 12, ""},
+		{"", "https://freescout.example.com/api/conversations/34", 34, ""},
+		{"", "https://freescout.example.com/api/conversations/34/", 34, ""},
+		{"abc", "/api/conversations/34", 34, ""},
+		{"abc", "", 0, `invalid freescout resource ID, Resource-ID "abc", Location ""`},
+		{"-3", "", 0, `invalid freescout resource ID, Resource-ID "-3", Location ""`},
+		{"", "https://freescout.example.com/api/conversations", 0, `invalid freescout resource ID, Resource-ID "", Location "https://freescout.example.com/api/conversations"`},
+		{"0", "/api/conversations/new", 0, `invalid freescout resource ID, Resource-ID "0", Location "/api/conversations/new"`},
+	}
+	for _, c := range cases {
+		header := http.Header{}
+		header.Set("Resource-ID", c.resourceID)
+		header.Set("Location", c.location)
+		id, err := ParseResourceID(header)
+		if c.wantErr != "" {
+			require.EqualError(t, err, c.wantErr, "%+v", c)
+			require.False(t, errors.Is(err, ErrFreeScoutResourceIDMissing), "%+v", c)
+			continue
+		}
+		require.NoError(t, err, "%+v", c)
+		require.Equal(t, c.want, id, "%+v", c)
+	}
+
+	// missing headers
+	_, err := ParseResourceID(http.Header{})
+	require.ErrorIs(t, err, ErrFreeScoutResourceIDMissing)
+	header := http.Header{}
+	header.Set("Resource-ID", " ")
+	_, err = ParseResourceID(header)
+	require.ErrorIs(t, err, ErrFreeScoutResourceIDMissing)
+}
+
+func TestFreeScoutSendTestConversation(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", "5")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/conversations/5":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// created without any dedup search
+	conv, err := client.SendTestConversation(ctx, "message", false)
+	require.NoError(t, err)
+	require.Equal(t, &FreeScoutTestConversation{ID: 5, URL: srv.URL + "/conversation/5"}, conv)
+	require.Equal(t, []string{"POST /api/conversations"}, requests)
+
+	// created and deleted
+	requests = nil
+	conv, err = client.SendTestConversation(ctx, "message", true)
+	require.NoError(t, err)
+	require.True(t, conv.Deleted)
+	require.Equal(t, []string{"POST /api/conversations", "DELETE /api/conversations/5"}, requests)
+}
+
+func TestFreeScoutTransportOptions(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		client, err := NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", CustomerEmail: "fleet@example.com"})
+		require.NoError(t, err)
+		tr, ok := client.client.(*http.Client).Transport.(*http.Transport)
+		require.True(t, ok)
+		require.Equal(t, defaultFreeScoutMaxIdleConns, tr.MaxIdleConns)
+		require.Equal(t, defaultFreeScoutMaxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+		require.Zero(t, tr.MaxConnsPerHost)
+		require.Equal(t, defaultFreeScoutIdleConnTimeout, tr.IdleConnTimeout)
+		// other settings are inherited from the default transport
+		require.NotNil(t, tr.Proxy)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		opts := &FreeScoutOptions{
+			URL:           "https://freescout.example.com",
+			CustomerEmail: "fleet@example.com",
+			Transport: FreeScoutTransportOptions{
+				MaxIdleConns:        50,
+				MaxIdleConnsPerHost: 20,
+				MaxConnsPerHost:     30,
+				IdleConnTimeout:     time.Minute,
+			},
+		}
+		client, err := NewFreeScoutClient(opts)
+		require.NoError(t, err)
+		tr, ok := client.client.(*http.Client).Transport.(*http.Transport)
+		require.True(t, ok)
+		require.Equal(t, 50, tr.MaxIdleConns)
+		require.Equal(t, 20, tr.MaxIdleConnsPerHost)
+		require.Equal(t, 30, tr.MaxConnsPerHost)
+		require.Equal(t, time.Minute, tr.IdleConnTimeout)
+
+		// the transport options are not considered to match a configuration
+		require.True(t, client.FreeScoutConfigMatches(&FreeScoutOptions{URL: "https://freescout.example.com", CustomerEmail: "fleet@example.com"}))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:       "https://freescout.example.com",
+			Transport: FreeScoutTransportOptions{MaxConnsPerHost: -1},
+		})
+		require.ErrorContains(t, err, "must not be negative")
+	})
+}
+
+// recordingDoer is an HTTPDoer that records the requests it makes with the
+// wrapped client.
+type recordingDoer struct {
+	client   HTTPDoer
+	requests []string
+}
+
+func (d *recordingDoer) Do(req *http.Request) (*http.Response, error) {
+	d.requests = append(d.requests, req.Method+" "+req.URL.Path)
+	return d.client.Do(req)
+}
+
+// failingDoer is an HTTPDoer that fails the first requests with its errors,
+// as the transport would, and makes the others with the wrapped client.
+type failingDoer struct {
+	client   HTTPDoer
+	errs     []error
+	attempts int
+}
+
+func (d *failingDoer) Do(req *http.Request) (*http.Response, error) {
+	d.attempts++
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return nil, &url.Error{Op: req.Method, URL: req.URL.String(), Err: err}
+	}
+	return d.client.Do(req)
+}
+
+func TestFreeScoutTransportRetries(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			bodies = append(bodies, string(body))
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	dnsTemporary := &net.DNSError{Err: "server misbehaving", Name: "freescout.example.com", IsTemporary: true}
+	dnsNotFound := &net.DNSError{Err: "no such host", Name: "freescout.example.com", IsNotFound: true}
+
+	list := func(retries int, errs ...error) (*failingDoer, error) {
+		doer := &failingDoer{client: srv.Client(), errs: errs}
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL:              srv.URL,
+			MailboxID:        1,
+			CustomerEmail:    "fleet@example.com",
+			HTTPClient:       doer,
+			TransportRetries: retries,
+		})
+		require.NoError(t, err)
+		_, _, err = client.ListConversations(context.Background(), "subject", 1, 1)
+		return doer, err
+	}
+
+	// the transient errors are retried
+	doer, err := list(3, refused, reset, timeout)
+	require.NoError(t, err)
+	require.Equal(t, 4, doer.attempts)
+	doer, err = list(3, dnsTemporary)
+	require.NoError(t, err)
+	require.Equal(t, 2, doer.attempts)
+
+	// up to the configured number of retries
+	doer, err = list(1, refused, refused)
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.Equal(t, 2, doer.attempts)
+
+	// not by default
+	doer, err = list(0, reset)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	require.Equal(t, 1, doer.attempts)
+
+	// the permanent errors are not retried
+	doer, err = list(3, dnsNotFound)
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	require.True(t, dnsErr.IsNotFound)
+	require.Equal(t, 1, doer.attempts)
+	doer, err = list(3, errors.New("tls: failed to verify certificate"))
+	require.ErrorContains(t, err, "tls: failed to verify certificate")
+	require.Equal(t, 1, doer.attempts)
+
+	// the body of a retried request is sent again
+	doer = &failingDoer{client: srv.Client()}
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:              srv.URL,
+		MailboxID:        1,
+		CustomerEmail:    "fleet@example.com",
+		HTTPClient:       doer,
+		TransportRetries: 1,
+	})
+	require.NoError(t, err)
+	doer.errs = []error{refused}
+	id, err := client.createConversation(context.Background(), "subject", "message", nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, id)
+	require.Equal(t, 2, doer.attempts)
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], `"subject":"subject"`)
+
+	// but a POST that may have reached FreeScout is not retried, so that the
+	// conversation is not created twice
+	for _, sentErr := range []error{timeout, reset} {
+		doer.attempts = 0
+		doer.errs = []error{sentErr}
+		_, err = client.createConversation(context.Background(), "subject", "message", nil)
+		require.ErrorIs(t, err, sentErr.(*net.OpError).Err)
+		require.Equal(t, 1, doer.attempts)
+	}
+	require.Len(t, bodies, 1)
+
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, TransportRetries: -1})
+	require.ErrorContains(t, err, "FreeScout transport retries must not be negative")
+}
+
+func TestFreeScoutHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	doer := &recordingDoer{client: srv.Client()}
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL,
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+		HTTPClient:    doer,
+	})
+	require.NoError(t, err)
+	id, _, err := client.CreateFreeScoutConversation(context.Background(), "subject", "message")
+	require.NoError(t, err)
+	require.EqualValues(t, 1, id)
+	require.Equal(t, []string{"GET /api/conversations", "POST /api/conversations"}, doer.requests)
+
+	// the doer does not support closing idle connections, it is a no-op
+	client.CloseIdleConnections()
+
+	// the provided client does not change the configuration
+	require.True(t, client.FreeScoutConfigMatches(&FreeScoutOptions{
+		URL:           srv.URL,
+		MailboxID:     1,
+		CustomerEmail: "fleet@example.com",
+	}))
+}
+
+func TestDecodeFreeScoutConversations(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		shape string
+		ids   []int64
+		page  FreeScoutPage
+	}{
+		{
+			name:  "embedded",
+			body:  `{"_embedded":{"conversations":[{"id":1},{"id":2}]},"_page":{"size":2,"totalElements":3,"totalPages":2,"number":1}}`,
+			shape: freeScoutShapeEmbedded,
+			ids:   []int64{1, 2},
+			page:  FreeScoutPage{Number: 1, Size: 2, TotalElements: 3, TotalPages: 2},
+		},
+		{
+			name:  "embedded empty",
+			body:  `{"_embedded":{"conversations":[]}}`,
+			shape: freeScoutShapeEmbedded,
+		},
+		{
+			name:  "top-level",
+			body:  `{"conversations":[{"id":3}],"page":{"size":1,"totalElements":1,"totalPages":1,"number":1}}`,
+			shape: freeScoutShapeTopLevel,
+			ids:   []int64{3},
+			page:  FreeScoutPage{Number: 1, Size: 1, TotalElements: 1, TotalPages: 1},
+		},
+		{
+			name:  "top-level with hal page",
+			body:  `{"conversations":[{"id":3}],"_page":{"size":1,"totalElements":1,"totalPages":1,"number":1}}`,
+			shape: freeScoutShapeTopLevel,
+			ids:   []int64{3},
+			page:  FreeScoutPage{Number: 1, Size: 1, TotalElements: 1, TotalPages: 1},
+		},
+		{
+			name:  "paginator",
+			body:  `{"data":[{"id":4},{"id":5}],"current_page":2,"per_page":2,"total":5,"last_page":3}`,
+			shape: freeScoutShapePaginator,
+			ids:   []int64{4, 5},
+			page:  FreeScoutPage{Number: 2, Size: 2, TotalElements: 5, TotalPages: 3},
+		},
+		{
+			name:  "array",
+			body:  ` [{"id":6}]`,
+			shape: freeScoutShapeArray,
+			ids:   []int64{6},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conversations, page, shape, err := decodeFreeScoutConversations([]byte(c.body))
+			require.NoError(t, err)
+			require.Equal(t, c.shape, shape)
+			require.Equal(t, c.page, page)
+			var ids []int64
+			for _, conv := range conversations {
+				ids = append(ids, conv.ID)
+			}
+			require.Equal(t, c.ids, ids)
+		})
+	}
+
+	for _, body := range []string{`{"_embedded":{"mailboxes":[{"id":1}]}}`, `{"items":[{"id":1}]}`, `{}`} {
+		_, _, _, err := decodeFreeScoutConversations([]byte(body))
+		require.ErrorIs(t, err, errFreeScoutUnknownShape, body)
+	}
+
+	_, _, _, err := decodeFreeScoutConversations([]byte(`not json`))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errFreeScoutUnknownShape)
+}
+
+func TestFreeScoutListConversationsUnknownShape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items":[{"id":1}],"padding":"` + strings.Repeat("x", 500) + `"}`))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", Logger: kitlog.NewLogfmtLogger(&logs)})
+	require.NoError(t, err)
+
+	// the request does not fail, but the response is reported with a snippet
+	ids, _, err := client.ListConversations(context.Background(), "subject", 1, 1)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	require.Contains(t, logs.String(), "level=warn")
+	require.Contains(t, logs.String(), "unrecognized freescout conversations response")
+	require.Contains(t, logs.String(), `{\"items\":[{\"id\":1}]`)
+	require.NotContains(t, logs.String(), strings.Repeat("x", 300))
+}
+
+func TestFreeScoutCheckScopes(t *testing.T) {
+	cases := []struct {
+		name        string
+		mailboxes   string
+		mailboxCode int
+		listCode    int
+		createCode  int
+		wantMissing []string
+		wantErr     string
+	}{
+		{
+			name:       "all scopes",
+			mailboxes:  `{"_embedded":{"mailboxes":[{"id":2},{"id":1}]}}`,
+			listCode:   http.StatusOK,
+			createCode: http.StatusBadRequest,
+		},
+		{
+			name:       "validation error as unprocessable entity",
+			mailboxes:  `{"_embedded":{"mailboxes":[{"id":1}]}}`,
+			listCode:   http.StatusOK,
+			createCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "mailbox not visible",
+			mailboxes:   `{"_embedded":{"mailboxes":[{"id":2}]}}`,
+			listCode:    http.StatusOK,
+			createCode:  http.StatusBadRequest,
+			wantMissing: []string{FreeScoutScopeReadMailbox},
+		},
+		{
+			name:        "read only",
+			mailboxes:   `{"_embedded":{"mailboxes":[{"id":1}]}}`,
+			listCode:    http.StatusOK,
+			createCode:  http.StatusForbidden,
+			wantMissing: []string{FreeScoutScopeCreateConversations},
+		},
+		{
+			name:        "invalid token",
+			mailboxCode: http.StatusUnauthorized,
+			listCode:    http.StatusUnauthorized,
+			createCode:  http.StatusUnauthorized,
+			wantMissing: []string{FreeScoutScopeReadMailbox, FreeScoutScopeReadConversations, FreeScoutScopeCreateConversations},
+		},
+		{
+			name:       "server error",
+			mailboxes:  `{"_embedded":{"mailboxes":[{"id":1}]}}`,
+			listCode:   http.StatusInternalServerError,
+			createCode: http.StatusBadRequest,
+			wantErr:    "status 500",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var created bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/mailboxes":
+					if c.mailboxCode != 0 {
+						w.WriteHeader(c.mailboxCode)
+						return
+					}
+					_, _ = w.Write([]byte(c.mailboxes))
+				case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+					require.Equal(t, "1", r.URL.Query().Get("mailboxId"))
+					w.WriteHeader(c.listCode)
+					_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+				case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+					body, err := io.ReadAll(r.Body)
+					require.NoError(t, err)
+					require.JSONEq(t, `{}`, string(body))
+					if c.createCode/100 == 2 {
+						created = true
+					}
+					w.WriteHeader(c.createCode)
+				default:
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+			require.NoError(t, err)
+
+			err = client.CheckScopes(context.Background())
+			require.False(t, created)
+			switch {
+			case c.wantErr != "":
+				require.ErrorContains(t, err, c.wantErr)
+			case len(c.wantMissing) > 0:
+				var missingErr *FreeScoutMissingScopesError
+				require.ErrorAs(t, err, &missingErr)
+				require.Equal(t, c.wantMissing, missingErr.Scopes)
+				require.EqualValues(t, 1, missingErr.MailboxID)
+				for _, scope := range c.wantMissing {
+					require.ErrorContains(t, err, scope)
+				}
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFreeScoutCheckMailboxType(t *testing.T) {
+	cases := []struct {
+		name             string
+		mailbox          string
+		mailboxCode      int
+		conversationType string
+		wantTypeErr      string
+		wantErr          string
+	}{
+		{name: "email mailbox", mailbox: `{"id":1,"name":"Security","email":"security@example.com","type":"email"}`},
+		{name: "email mailbox with phone conversations", mailbox: `{"id":1,"type":"email"}`, conversationType: "phone"},
+		{name: "no type", mailbox: `{"id":1,"name":"Security","email":"security@example.com"}`},
+		{name: "chat mailbox", mailbox: `{"id":1,"type":"chat"}`, wantTypeErr: "chat"},
+		{name: "chat mailbox with chat conversations", mailbox: `{"id":1,"type":"Chat"}`, conversationType: "chat"},
+		{name: "not found", mailboxCode: http.StatusNotFound, wantErr: "get mailbox 1: "},
+		{name: "invalid body", mailbox: `<html>`, wantErr: "decode mailbox 1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/api/mailboxes/1" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+					return
+				}
+				if c.mailboxCode != 0 {
+					w.WriteHeader(c.mailboxCode)
+					return
+				}
+				_, _ = w.Write([]byte(c.mailbox))
+			}))
+			defer srv.Close()
+
+			client, err := NewFreeScoutClient(&FreeScoutOptions{
+				URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", ConversationType: c.conversationType,
+			})
+			require.NoError(t, err)
+
+			err = client.CheckMailboxType(context.Background())
+			switch {
+			case c.wantErr != "":
+				require.ErrorContains(t, err, c.wantErr)
+			case c.wantTypeErr != "":
+				var typeErr *FreeScoutMailboxTypeError
+				require.ErrorAs(t, err, &typeErr)
+				require.Equal(t, c.wantTypeErr, typeErr.MailboxType)
+				require.Equal(t, "email", typeErr.ConversationType)
+				require.EqualValues(t, 1, typeErr.MailboxID)
+				require.ErrorContains(t, err, `freescout mailbox 1 of type "chat" does not support conversations of type "email"`)
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", MailboxTypeCheck: "error"})
+	require.ErrorContains(t, err, `invalid FreeScout mailbox type check "error"`)
+}
+
+func TestFreeScoutSetConversationCustomField(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/api/conversations/9/custom_fields" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, APIToken: "token", MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+
+	require.NoError(t, client.SetConversationCustomField(ctx, 9, 3, "Acme Corp"))
+	require.Len(t, bodies, 1)
+	require.JSONEq(t, `{"customFields":[{"id":3,"value":"Acme Corp"}]}`, bodies[0])
+
+	// unknown conversation
+	require.Error(t, client.SetConversationCustomField(ctx, 10, 3, "Acme Corp"))
+}
+
+func TestFreeScoutSearchSortOrder(t *testing.T) {
+	type conversation struct {
+		ID        int64
+		CreatedAt string
+		UpdatedAt string
+	}
+	// conversations matching the subject, in creation order
+	conversations := []conversation{
+		{ID: 1, CreatedAt: "2024-01-01", UpdatedAt: "2024-03-01"},
+		{ID: 2, CreatedAt: "2024-01-02", UpdatedAt: "2024-01-05"},
+		{ID: 3, CreatedAt: "2024-01-03", UpdatedAt: "2024-02-01"},
+	}
+
+	var query url.Values
+	var appendedTo int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			query = r.URL.Query()
+			sorted := slices.Clone(conversations)
+			slices.SortFunc(sorted, func(a, b conversation) int {
+				c := strings.Compare(a.UpdatedAt, b.UpdatedAt)
+				if query.Get("sortField") == "createdAt" {
+					c = strings.Compare(a.CreatedAt, b.CreatedAt)
+				}
+				if query.Get("sortOrder") == "desc" {
+					c = -c
+				}
+				return c
+			})
+			_, _ = fmt.Fprintf(w, `{"_embedded":{"conversations":[{"id":%d}]}}`, sorted[0].ID)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/threads"):
+			id, err := strconv.ParseInt(strings.Split(r.URL.Path, "/")[3], 10, 64)
+			require.NoError(t, err)
+			appendedTo = id
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		field, order         string
+		wantField, wantOrder string
+		wantConversationID   int64
+	}{
+		{"", "", "updatedAt", "asc", 2},
+		{"updatedAt", "desc", "updatedAt", "desc", 1},
+		{"createdAt", "asc", "createdAt", "asc", 1},
+		{"createdAt", "desc", "createdAt", "desc", 3},
+	}
+	for _, c := range cases {
+		t.Run(c.wantField+" "+c.wantOrder, func(t *testing.T) {
+			client, err := NewFreeScoutClient(&FreeScoutOptions{
+				URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com",
+				SearchSortField: c.field, SearchSortOrder: c.order,
+			})
+			require.NoError(t, err)
+
+			id, created, err := client.CreateFreeScoutConversation(context.Background(), "subject", "message")
+			require.NoError(t, err)
+			require.False(t, created)
+			require.Equal(t, c.wantConversationID, id)
+			require.Equal(t, c.wantConversationID, appendedTo)
+			require.Equal(t, c.wantField, query.Get("sortField"))
+			require.Equal(t, c.wantOrder, query.Get("sortOrder"))
+		})
+	}
+
+	// invalid options
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, SearchSortField: "priority"})
+	require.ErrorContains(t, err, `invalid FreeScout search sort field "priority"`)
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, SearchSortOrder: "newest"})
+	require.ErrorContains(t, err, `invalid FreeScout search sort order "newest"`)
+}
+
+func TestFreeScoutJobID(t *testing.T) {
+	var headers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("X-Fleet-Job-ID"))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("boom"))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com", JobIDHeader: "X-Fleet-Job-ID",
+		Logger: level.NewFilter(kitlog.NewLogfmtLogger(&logs), level.AllowDebug()),
+	})
+	require.NoError(t, err)
+
+	// without a job ID in the context
+	_, _, err = client.CreateFreeScoutConversation(context.Background(), "subject", "message")
+	require.ErrorContains(t, err, "freescout request failed: status 500: boom")
+	require.NotContains(t, err.Error(), "job")
+	require.Equal(t, []string{""}, headers)
+	require.NotContains(t, logs.String(), "job_id")
+
+	// the job ID propagates into the error, the header and the logs
+	headers = nil
+	ctx := jobid.NewContext(context.Background(), 42)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.ErrorContains(t, err, "job 42: freescout request failed: status 500: boom")
+	var statusErr *freeScoutStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+	require.Equal(t, []string{"42"}, headers)
+	require.Contains(t, logs.String(), "job_id=42")
+
+	// the header cannot override the reserved ones
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, JobIDHeader: "content-type"})
+	require.ErrorContains(t, err, `FreeScout header "content-type" cannot be used for the job ID`)
+}
+
+func TestFreeScoutDumpPayloads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer srv.Close()
+
+	const token = "s3cr3t<token>"
+	newClient := func(t *testing.T, logs *bytes.Buffer, dump, redactEmail bool) *FreeScout {
+		client, err := NewFreeScoutClient(&FreeScoutOptions{
+			URL: srv.URL, APIToken: token, MailboxID: 1, CustomerEmail: "fleet@example.com",
+			DumpPayloads: dump, RedactCustomerEmail: redactEmail,
+			Logger: level.NewFilter(kitlog.NewJSONLogger(logs), level.AllowDebug()),
+		})
+		require.NoError(t, err)
+		return client
+	}
+	// the message leaks the token, raw and as escaped by json.Marshal
+	message := "token " + token + " in the message"
+	ctx := context.Background()
+
+	t.Run("off by default", func(t *testing.T) {
+		var logs bytes.Buffer
+		client := newClient(t, &logs, false, false)
+		_, _, err := client.CreateFreeScoutConversation(ctx, "subject", message)
+		require.ErrorContains(t, err, "status 422")
+		require.NotContains(t, logs.String(), "freescout request payload")
+	})
+
+	t.Run("token redacted", func(t *testing.T) {
+		var logs bytes.Buffer
+		client := newClient(t, &logs, true, false)
+		_, _, err := client.CreateFreeScoutConversation(ctx, "subject", message)
+		require.ErrorContains(t, err, "status 422")
+		require.ErrorContains(t, client.createFreeScoutThread(ctx, 9, message, nil), "status 422")
+
+		var payloads []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			if entry["msg"] == "freescout request payload" {
+				var payload map[string]any
+				require.NoError(t, json.Unmarshal([]byte(entry["payload"].(string)), &payload))
+				payloads = append(payloads, payload)
+			}
+		}
+		require.Len(t, payloads, 2)
+		require.Equal(t, "subject", payloads[0]["subject"])
+		require.Equal(t, "token [REDACTED] in the message", payloads[0]["threads"].([]any)[0].(map[string]any)["text"])
+		require.Equal(t, "token [REDACTED] in the message", payloads[1]["text"])
+		require.Equal(t, "fleet@example.com", payloads[1]["customer"].(map[string]any)["email"])
+		require.NotContains(t, logs.String(), "s3cr3t")
+	})
+
+	t.Run("customer email redacted", func(t *testing.T) {
+		var logs bytes.Buffer
+		client := newClient(t, &logs, true, true)
+		require.ErrorContains(t, client.createFreeScoutThread(ctx, 9, message, nil), "status 422")
+		require.Contains(t, logs.String(), "freescout request payload")
+		require.Contains(t, logs.String(), "in the message")
+		require.NotContains(t, logs.String(), "fleet@example.com")
+		require.NotContains(t, logs.String(), "s3cr3t")
+	})
+}
+
+func TestRedactFreeScoutPayload(t *testing.T) {
+	body := []byte(`{"text":"a<b> and a<b> and AB","email":"x@example.com"}`)
+	require.Equal(t,
+		`{"text":"[REDACTED] and [REDACTED] and AB","email":"[REDACTED]"}`,
+		string(redactFreeScoutPayload(body, "a<b>", "", "x@example.com")))
+	// the original body is not modified
+	require.Contains(t, string(body), "x@example.com")
+}
+
+func TestFreeScoutSearchDecodeErrorPolicy(t *testing.T) {
+	cases := []struct {
+		name       string
+		retries    int
+		policy     string
+		malformed  int // number of malformed search responses before a valid one
+		wantErr    string
+		wantID     int64
+		wantCreate bool
+		wantLogs   []string
+	}{
+		{name: "fail by default", malformed: 1, wantErr: "decode freescout conversations"},
+		{name: "fail after retries", retries: 2, policy: FreeScoutDecodeErrorFail, malformed: 3, wantErr: "decode freescout conversations", wantLogs: []string{"attempt=1", "attempt=2"}},
+		{name: "retry succeeds", retries: 2, malformed: 2, wantID: 9, wantLogs: []string{"malformed freescout search response, retrying", "attempt=2"}},
+		{name: "create", policy: FreeScoutDecodeErrorCreate, malformed: 1, wantID: 1, wantCreate: true, wantLogs: []string{"malformed freescout search response, creating a new conversation"}},
+		{name: "create after retries", retries: 1, policy: FreeScoutDecodeErrorCreate, malformed: 2, wantID: 1, wantCreate: true, wantLogs: []string{"attempt=1", "creating a new conversation"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var searches, created, appended int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+					searches++
+					if searches <= c.malformed {
+						// truncated body, as during a deployment
+						_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":`))
+						return
+					}
+					_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+				case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+					created++
+					w.Header().Set("Resource-ID", "1")
+					w.WriteHeader(http.StatusCreated)
+				case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+					appended++
+					w.WriteHeader(http.StatusCreated)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			var logs bytes.Buffer
+			client, err := NewFreeScoutClient(&FreeScoutOptions{
+				URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com",
+				SearchDecodeRetries: c.retries, SearchDecodeErrorPolicy: c.policy,
+				Logger: kitlog.NewLogfmtLogger(&logs),
+			})
+			require.NoError(t, err)
+
+			id, wasCreated, err := client.CreateFreeScoutConversation(context.Background(), "subject", "message")
+			if c.wantErr != "" {
+				require.ErrorContains(t, err, c.wantErr)
+				require.Equal(t, c.retries+1, searches)
+				require.Zero(t, created+appended)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.wantID, id)
+				require.Equal(t, c.wantCreate, wasCreated)
+				if c.wantCreate {
+					require.Equal(t, 1, created)
+				} else {
+					require.Equal(t, 1, appended)
+				}
+			}
+			for _, want := range c.wantLogs {
+				require.Contains(t, logs.String(), want)
+			}
+		})
+	}
+
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", SearchDecodeErrorPolicy: "ignore"})
+	require.ErrorContains(t, err, `invalid FreeScout search decode error policy "ignore"`)
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", SearchDecodeRetries: -1})
+	require.ErrorContains(t, err, "FreeScout search decode retries must not be negative")
+}
+
+func TestFreeScoutSearchIndexLag(t *testing.T) {
+	cases := []struct {
+		name        string
+		retries     int
+		lag         int // number of searches after the creation that do not find the conversation yet
+		wantCreated int
+		wantSearch  int
+	}{
+		{name: "no retries creates a duplicate", lag: 1, wantCreated: 2, wantSearch: 2},
+		{name: "retry finds the conversation", retries: 3, lag: 2, wantCreated: 1, wantSearch: 4},
+		{name: "retries exhausted", retries: 1, lag: 5, wantCreated: 2, wantSearch: 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var searches, created, appended, sinceCreated int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+					searches++
+					// the search index lags behind the creation
+					if created == 0 || sinceCreated < c.lag {
+						sinceCreated++
+						_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+						return
+					}
+					_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":1}]}}`))
+				case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+					created++
+					sinceCreated = 0
+					w.Header().Set("Resource-ID", strconv.Itoa(created))
+					w.WriteHeader(http.StatusCreated)
+				case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/1/threads":
+					appended++
+					w.WriteHeader(http.StatusCreated)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			client, err := NewFreeScoutClient(&FreeScoutOptions{
+				URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com",
+				SearchIndexRetries: c.retries, SearchIndexDelay: time.Millisecond,
+			})
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			id, wasCreated, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+			require.NoError(t, err)
+			require.True(t, wasCreated)
+			require.EqualValues(t, 1, id)
+
+			// a re-queued job for the same CVE, right after the creation
+			_, wasCreated, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+			require.NoError(t, err)
+			require.Equal(t, c.wantCreated == 2, wasCreated)
+			mu.Lock()
+			require.Equal(t, c.wantCreated, created)
+			require.Equal(t, 2-c.wantCreated, appended)
+			require.Equal(t, c.wantSearch, searches)
+			searches = 0
+			mu.Unlock()
+
+			// the search for a subject that the client did not create is not retried
+			_, _, err = client.CreateFreeScoutConversation(ctx, "other subject", "message")
+			require.NoError(t, err)
+			mu.Lock()
+			require.Equal(t, 1, searches)
+			mu.Unlock()
+		})
+	}
+
+	_, err := NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", SearchIndexRetries: -1})
+	require.ErrorContains(t, err, "FreeScout search index retries and delay must not be negative")
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", SearchIndexDelay: -time.Second})
+	require.ErrorContains(t, err, "FreeScout search index retries and delay must not be negative")
+}
+
+func TestFreeScoutDedupSearchURL(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		_, _ = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewFreeScoutClient(&FreeScoutOptions{
+		URL:           srv.URL + "/",
+		APIToken:      "secret-token",
+		MailboxID:     3,
+		CustomerEmail: "fleet@example.com",
+	})
+	require.NoError(t, err)
+
+	const subject = "Vulnerability CVE-2024-1234 detected on 2 hosts"
+	searchURL := client.DedupSearchURL(subject)
+	require.NotContains(t, searchURL, "secret-token")
+
+	u, err := url.Parse(searchURL)
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/api/conversations", u.Scheme+"://"+u.Host+u.Path)
+	params := u.Query()
+	require.Equal(t, subject, params.Get("subject"))
+	require.Equal(t, "3", params.Get("mailboxId"))
+	require.Equal(t, "fleet@example.com", params.Get("customerEmail"))
+	require.Equal(t, "active", params.Get("status"))
+	require.Equal(t, "published", params.Get("state"))
+	require.Equal(t, "1", params.Get("page"))
+	require.Equal(t, "1", params.Get("pageSize"))
+
+	// it is the URL of the actual search
+	_, _, err = client.ListConversations(context.Background(), subject, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, u.RequestURI(), requested)
+}
+
+func TestFreeScoutAppendNotFoundPolicy(t *testing.T) {
+	var created, appended int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, _ = w.Write([]byte(`{"_embedded":{"conversations":[{"id":9}]}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			created++
+			w.Header().Set("Resource-ID", "1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations/9/threads":
+			// the conversation was deleted since it was found
+			appended++
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Conversation not found"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// fails by default
+	client, err := NewFreeScoutClient(&FreeScoutOptions{URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com"})
+	require.NoError(t, err)
+	_, _, err = client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.ErrorContains(t, err, "status 404")
+	require.Equal(t, 1, appended)
+	require.Zero(t, created)
+
+	// creates a new conversation instead
+	var logs bytes.Buffer
+	client, err = NewFreeScoutClient(&FreeScoutOptions{
+		URL: srv.URL, MailboxID: 1, CustomerEmail: "fleet@example.com",
+		AppendNotFoundPolicy: FreeScoutAppendNotFoundCreate,
+		Logger:               kitlog.NewLogfmtLogger(&logs),
+	})
+	require.NoError(t, err)
+	id, wasCreated, err := client.CreateFreeScoutConversation(ctx, "subject", "message")
+	require.NoError(t, err)
+	require.True(t, wasCreated)
+	require.Equal(t, int64(1), id)
+	require.Equal(t, 2, appended)
+	require.Equal(t, 1, created)
+	require.Contains(t, logs.String(), "level=warn")
+	require.Contains(t, logs.String(), "existing freescout conversation not found, creating a new conversation")
+	require.Contains(t, logs.String(), "conversation_id=9")
+
+	_, err = NewFreeScoutClient(&FreeScoutOptions{URL: "https://freescout.example.com", AppendNotFoundPolicy: "ignore"})
+	require.ErrorContains(t, err, `invalid FreeScout append not found policy "ignore"`)
+}
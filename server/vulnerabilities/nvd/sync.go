@@ -221,6 +221,9 @@ func CVEMetaFromNVDFeedFiles(metaMap map[string]fleet.CVEMeta, vulnPath string,
 
 			if schema.Impact.BaseMetricV3 != nil {
 				meta.CVSSScore = &schema.Impact.BaseMetricV3.CVSSV3.BaseScore
+				if version := schema.Impact.BaseMetricV3.CVSSV3.Version; version != "" {
+					meta.CVSSVersion = &version
+				}
 			}
 
 			if published, err := time.Parse(publishedDateFmt, schema.PublishedDate); err != nil {
@@ -30,6 +30,7 @@ type WebhookPayload struct {
 	CVSSScore        *float64   `json:"cvss_score,omitempty"`
 	CISAKnownExploit *bool      `json:"cisa_known_exploit,omitempty"`
 	CVEPublished     *time.Time `json:"cve_published,omitempty"`
+	Severity         string     `json:"severity"`
 
 	Hosts []*hostPayloadPart `json:"hosts_affected"`
 }
@@ -81,6 +82,39 @@ func (m *Mapper) GetPayload(
 		CVSSScore:        meta.CVSSScore,
 		CISAKnownExploit: meta.CISAKnownExploit,
 		CVEPublished:     meta.Published,
+		Severity:         CVSSSeverity(meta.CVSSScore),
 		Hosts:            m.getHostPayloadPart(hostBaseURL, hosts),
 	}
 }
+
+// CVSS severity labels, as defined by the NVD for CVSS v3 base scores. See
+// https://nvd.nist.gov/vuln-metrics/cvss.
+const (
+	SeverityUnknown  = "unknown"
+	SeverityNone     = "none"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// CVSSSeverity returns the qualitative severity label corresponding to the
+// provided CVSS base score, using the standard NVD bands. It returns
+// SeverityUnknown if the score is nil.
+func CVSSSeverity(score *float64) string {
+	if score == nil {
+		return SeverityUnknown
+	}
+	switch s := *score; {
+	case s >= 9.0:
+		return SeverityCritical
+	case s >= 7.0:
+		return SeverityHigh
+	case s >= 4.0:
+		return SeverityMedium
+	case s > 0:
+		return SeverityLow
+	default:
+		return SeverityNone
+	}
+}
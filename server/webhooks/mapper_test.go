@@ -35,6 +35,29 @@ func TestGetPaylaod(t *testing.T) {
 		require.Equal(t, meta.EPSSProbability, result.EPSSProbability)
 		require.Equal(t, meta.CVSSScore, result.CVSSScore)
 		require.Equal(t, meta.Published, result.CVEPublished)
+		require.Equal(t, SeverityLow, result.Severity)
+	})
+
+	t.Run("severity is derived from the CVSS score", func(t *testing.T) {
+		cases := []struct {
+			score *float64
+			want  string
+		}{
+			{nil, SeverityUnknown},
+			{ptr.Float64(0), SeverityNone},
+			{ptr.Float64(0.1), SeverityLow},
+			{ptr.Float64(3.9), SeverityLow},
+			{ptr.Float64(4), SeverityMedium},
+			{ptr.Float64(6.9), SeverityMedium},
+			{ptr.Float64(7), SeverityHigh},
+			{ptr.Float64(8.9), SeverityHigh},
+			{ptr.Float64(9), SeverityCritical},
+			{ptr.Float64(10), SeverityCritical},
+		}
+		for _, c := range cases {
+			result := sut.GetPayload(serverURL, nil, vuln.CVE, fleet.CVEMeta{CVE: vuln.CVE, CVSSScore: c.score})
+			require.Equal(t, c.want, result.Severity)
+		}
 	})
 
 	t.Run("host payload only includes valid software paths", func(t *testing.T) {
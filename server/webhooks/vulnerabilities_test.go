@@ -96,9 +96,9 @@ func TestTriggerVulnerabilitiesWebhook(t *testing.T) {
 			"CVE-2012-1234",
 			"CVE-2012-4567",
 		}
-		jsonCVE1 := fmt.Sprintf(`{"timestamp":"%s","vulnerability":{"cve":%q,"details_link":"https://nvd.nist.gov/vuln/detail/%[2]s","hosts_affected":`,
+		jsonCVE1 := fmt.Sprintf(`{"timestamp":"%s","vulnerability":{"cve":%q,"details_link":"https://nvd.nist.gov/vuln/detail/%[2]s","severity":"unknown","hosts_affected":`,
 			now.Format(time.RFC3339Nano), cves[0])
-		jsonCVE2 := fmt.Sprintf(`{"timestamp":"%s","vulnerability":{"cve":%q,"details_link":"https://nvd.nist.gov/vuln/detail/%[2]s","hosts_affected":`,
+		jsonCVE2 := fmt.Sprintf(`{"timestamp":"%s","vulnerability":{"cve":%q,"details_link":"https://nvd.nist.gov/vuln/detail/%[2]s","severity":"unknown","hosts_affected":`,
 			now.Format(time.RFC3339Nano), cves[1])
 
 		cases := []struct {
@@ -67,11 +67,11 @@ func (f *TestAutomationFailer) CreateZendeskTicket(ctx context.Context, ticket *
 // CreateFreeScoutConversation implements the FreeScoutClient and introduces a forced failure if
 // required, otherwise it returns the result of calling
 // f.FreeScoutClient.CreateFreeScoutConversation with the provided arguments.
-func (f *TestAutomationFailer) CreateFreeScoutConversation(ctx context.Context, subject, message string) (int64, error) {
+func (f *TestAutomationFailer) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
 	if err := f.forceErr(subject); err != nil {
-		return 0, err
+		return 0, false, err
 	}
-	return f.FreeScoutClient.CreateFreeScoutConversation(ctx, subject, message)
+	return f.FreeScoutClient.CreateFreeScoutConversation(ctx, subject, message, attachments...)
 }
 
 func (f *TestAutomationFailer) JiraConfigMatches(opts *externalsvc.JiraOptions) bool {
@@ -86,6 +86,12 @@ func (f *TestAutomationFailer) FreeScoutConfigMatches(opts *externalsvc.FreeScou
 	return f.FreeScoutClient.FreeScoutConfigMatches(opts)
 }
 
+// CloseIdleConnections closes the idle connections of the wrapped FreeScout
+// client, if it supports it.
+func (f *TestAutomationFailer) CloseIdleConnections() {
+	closeFreeScoutClient(f.FreeScoutClient)
+}
+
 func (f *TestAutomationFailer) forceErr(testValue string) error {
 	f.callCounts++
 	for _, cve := range f.AlwaysFailCVEs {
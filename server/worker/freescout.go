@@ -3,111 +3,683 @@ package worker
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/fleetdm/fleet/v4/server/contexts/correlation"
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/service/externalsvc"
+	"github.com/fleetdm/fleet/v4/server/webhooks"
 	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
 )
 
 // freescoutName is the name of the job as registered in the worker.
 const freescoutName = "freescout"
 
+// defaultFreeScoutMaxCachedClients is the maximum number of FreeScout clients
+// kept in the job processor's cache when FreeScout.MaxCachedClients is not set.
+const defaultFreeScoutMaxCachedClients = 100
+
+// defaultFreeScoutMaxDescriptionBytes is the maximum size of a conversation's
+// description when the integration does not configure one. FreeScout stores
+// thread bodies in a MySQL TEXT column, which is limited to 64KB.
+const defaultFreeScoutMaxDescriptionBytes = 65535
+
+// defaultFreeScoutMaxSubjectLength is the maximum number of characters of a
+// conversation's subject when the integration does not configure one, as
+// enforced by FreeScout.
+const defaultFreeScoutMaxSubjectLength = 998
+
+// defaultFreeScoutJobDeadline is the maximum time spent processing a single
+// job when the integration does not configure one.
+const defaultFreeScoutJobDeadline = 5 * time.Minute
+
+// defaultFreeScoutMaxConcurrentJobs is the maximum number of jobs processed
+// concurrently against a FreeScout instance when the integration does not
+// configure one.
+const defaultFreeScoutMaxConcurrentJobs = 4
+
+// errFreeScoutJobDeadlineExceeded is the cause of the cancellation of a job's
+// context when it runs for longer than its deadline.
+var errFreeScoutJobDeadlineExceeded = errors.New("freescout job deadline exceeded")
+
+// errFreeScoutConfigChanged is returned when the configuration of the
+// integration changed while the job was processed, so that it is deferred
+// rather than acting on a configuration that may be in flux.
+var errFreeScoutConfigChanged = errors.New("freescout integration configuration changed")
+
+// errFreeScoutEmptyRender is returned when the summary or the description of
+// a conversation renders empty or as whitespace only, e.g. because of a
+// misconfigured template override, so that no empty conversation is created.
+var errFreeScoutEmptyRender = errors.New("freescout conversation rendered empty")
+
+// freeScoutConfigChangedRetryDelay is the delay after which a job deferred
+// because its integration's configuration changed is processed again.
+const freeScoutConfigChangedRetryDelay = 30 * time.Second
+
+// freeScoutMaintenanceRetryDelay is the delay after which a job deferred
+// because FreeScout was in maintenance mode, e.g. during an upgrade, is
+// processed again.
+const freeScoutMaintenanceRetryDelay = 15 * time.Minute
+
+// freeScoutIntgTypeDigest is the integration type of the FreeScout jobs that
+// report the pending digest events of a team.
+const freeScoutIntgTypeDigest = "digest"
+
+// defaultFreeScoutDigestInterval is the delay before retrying a digest job
+// that could not be processed while its integration has no digest interval.
+const defaultFreeScoutDigestInterval = 24 * time.Hour
+
+// freeScoutDigestMaxEvents is the maximum number of events reported in a
+// single digest conversation, the remaining events are reported in another
+// one immediately after.
+const freeScoutDigestMaxEvents = 1000
+
+// freeScoutMaxHostsInDescription is the maximum number of hosts listed in a
+// conversation's description. It must match the limit used in the templates.
+const freeScoutMaxHostsInDescription = 50
+
+// freeScoutMarkdownEscaper escapes the characters that have a special meaning
+// in inline markdown.
+var freeScoutMarkdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+	">", `\>`,
+	"~", `\~`,
+	"|", `\|`,
+)
+
+// freeScoutTableCellEscaper escapes the characters that would break a cell of
+// a markdown table, newlines are rendered as line breaks within the cell.
+var freeScoutTableCellEscaper = strings.NewReplacer(
+	"|", `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// freeScoutTplFuncs are the functions available to the FreeScout templates.
+var freeScoutTplFuncs = template.FuncMap{
+	// CISAKnownExploit is *bool, so any condition check on it in the template
+	// will test if nil or not, and not its actual boolean value. Hence, "deref".
+	"deref": func(b *bool) bool { return *b },
+
+	// urlpath escapes a value interpolated in the path of a link, values
+	// interpolated in query strings use the builtin urlquery.
+	"urlpath": url.PathEscape,
+
+	// md escapes the markdown special characters of a value, e.g. a host or
+	// policy name, so that it is rendered as-is.
+	"md": freeScoutMarkdownEscaper.Replace,
+
+	// mdCell escapes a value rendered as-is in a cell of a markdown table,
+	// e.g. an installed path, values that are also rendered as inline markdown
+	// use md, which escapes pipes too.
+	"mdCell": freeScoutTableCellEscaper.Replace,
+
+	// hostLabel returns the text of the link to a host, which is its label if
+	// it has a non-empty one in labels, or its display name otherwise.
+	"hostLabel": func(labels map[uint]string, id uint, displayName string) string {
+		if label := labels[id]; label != "" {
+			return label
+		}
+		return displayName
+	},
+
+	// platforms renders the comma-separated platforms targeted by a policy
+	// as a list, e.g. "darwin, windows".
+	"platforms": func(platforms string) string {
+		var list []string
+		for _, p := range strings.Split(platforms, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				list = append(list, p)
+			}
+		}
+		return strings.Join(list, ", ")
+	},
+
+	// t translates the static text of the built-in templates, it renders the
+	// English text as-is, see localizeFreeScoutTemplate.
+	"t": freeScoutTranslator(""),
+
+	// daysAgo returns the age of t relative to now in days, e.g. "412 days
+	// ago", or an empty string if t is nil.
+	"daysAgo": freeScoutDaysAgo(freeScoutTranslator("")),
+
+	// belowThreshold returns true if the value is known and less than the
+	// threshold, i.e. if a line rendering it is omitted.
+	"belowThreshold": func(v *float64, threshold float64) bool {
+		return v != nil && *v < threshold
+	},
+
+	// epssLabel returns the exploit likelihood label of the EPSS probability
+	// with the thresholds, or an empty string if the probability is nil.
+	"epssLabel": func(thresholds fleet.FreeScoutEPSSThresholds, p *float64) string {
+		if p == nil {
+			return ""
+		}
+		return thresholds.Label(*p)
+	},
+}
+
 var freeScoutTemplates = struct {
-	VulnSummary              *template.Template
-	VulnDescription          *template.Template
-	FailingPolicySummary     *template.Template
-	FailingPolicyDescription *template.Template
+	VulnSummary                *template.Template
+	VulnDescription            *template.Template
+	FailingPolicySummary       *template.Template
+	FailingPolicyDescription   *template.Template
+	FailingPoliciesSummary     *template.Template
+	FailingPoliciesDescription *template.Template
+	DigestSummary              *template.Template
+	DigestDescription          *template.Template
 }{
-	VulnSummary: template.Must(template.New("").Parse(
-		`Vulnerability {{ .CVE }} detected on {{ len .Hosts }} host(s)`,
+	VulnSummary: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ printf (t "Vulnerability %[1]s detected on %[2]d host(s)") .CVE .HostsCount }}`,
 	)),
 
 	// FreeScout supports markdown formatting.
-	VulnDescription: template.Must(template.New("").Funcs(template.FuncMap{
-		// CISAKnownExploit is *bool, so any condition check on it in the template
-		// will test if nil or not, and not its actual boolean value. Hence, "deref".
-		"deref": func(b *bool) bool { return *b },
-	}).Parse(
-		`See vulnerability (CVE) details in National Vulnerability Database (NVD) here: [{{ .CVE }}]({{ .NVDURL }}{{ .CVE }}).
-
-{{ if .EPSSProbability }}
-Probability of exploit (reported by [FIRST.org/epss](https://www.first.org/epss/)): {{ .EPSSProbability }}
+	VulnDescription: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ if .SummaryHeader }}**{{ t "Summary" }}**
+
+* CVE: {{ .CVE }}
+{{ if not (belowThreshold .CVSSScore .CVSSRenderThreshold) }}* {{ t "CVSS score" }}: {{ if .CVSSScore }}{{ .CVSSScore }}{{ with .CVSSVersion }} (v{{ . }}){{ end }}{{ else }}{{ t "Unknown" }}{{ end }}
+{{ end }}{{ if not (belowThreshold .EPSSProbability .EPSSRenderThreshold) }}* {{ t "Probability of exploit (EPSS)" }}: {{ if .EPSSProbability }}{{ if ne .EPSSLabelMode "replace" }}{{ .EPSSProbability }}{{ end }}{{ if eq .EPSSLabelMode "append" }} ({{ epssLabel .EPSSThresholds .EPSSProbability }}){{ else if eq .EPSSLabelMode "replace" }}{{ epssLabel .EPSSThresholds .EPSSProbability }}{{ end }}{{ else }}{{ t "Unknown" }}{{ end }}
+{{ end }}* {{ t "Known exploits (CISA KEV)" }}: {{ if .CISAKnownExploit }}{{ if deref .CISAKnownExploit }}{{ t "Yes" }}{{ else }}{{ t "No" }}{{ end }}{{ else }}{{ t "Unknown" }}{{ end }}
+* {{ t "Affected hosts" }}: {{ .HostsCount }}
+
+----
+
+{{ end }}{{ if .References }}**{{ t "References" }}**
+
+* [NVD]({{ .NVDURL }}{{ urlpath .CVE }})
+{{ range .References }}* [{{ md .Label }}]({{ .URL }})
+{{ end }}{{ else }}{{ t "See vulnerability (CVE) details in National Vulnerability Database (NVD) here:" }} [{{ .CVE }}]({{ .NVDURL }}{{ urlpath .CVE }}).
 {{ end }}
-{{ if .CVSSScore }}CVSS score (reported by [NVD](https://nvd.nist.gov/)): {{ .CVSSScore }}
+{{ if and .EPSSProbability (not (belowThreshold .EPSSProbability .EPSSRenderThreshold)) }}
+{{ t "Probability of exploit" }} ({{ t "reported by" }} [FIRST.org/epss](https://www.first.org/epss/)): {{ if ne .EPSSLabelMode "replace" }}{{ .EPSSProbability }}{{ end }}{{ if eq .EPSSLabelMode "append" }} ({{ epssLabel .EPSSThresholds .EPSSProbability }}){{ else if eq .EPSSLabelMode "replace" }}{{ epssLabel .EPSSThresholds .EPSSProbability }}{{ end }}
 {{ end }}
-{{ if .CVEPublished }}Published (reported by [NVD](https://nvd.nist.gov/)): {{ .CVEPublished }}
+{{ if and .CVSSScore (not (belowThreshold .CVSSScore .CVSSRenderThreshold)) }}{{ with .CVSSVersion }}{{ printf (t "CVSS v%s score") . }}{{ else }}{{ t "CVSS score" }}{{ end }} ({{ t "reported by" }} [NVD](https://nvd.nist.gov/)): {{ .CVSSScore }}
 {{ end }}
-{{ if .CISAKnownExploit }}Known exploits (reported by [CISA](https://www.cisa.gov/known-exploited-vulnerabilities-catalog)): {{ if deref .CISAKnownExploit }}Yes{{ else }}No{{ end }}
+{{ if .CVEPublished }}{{ t "Published" }} ({{ t "reported by" }} [NVD](https://nvd.nist.gov/)): {{ .CVEPublished }} ({{ daysAgo $.Now .CVEPublished }})
 {{ end }}
+{{ if .CISAKnownExploit }}{{ t "Known exploits" }} ({{ t "reported by" }} [CISA](https://www.cisa.gov/known-exploited-vulnerabilities-catalog)): {{ if deref .CISAKnownExploit }}{{ t "Yes" }}{{ else }}{{ t "No" }}{{ end }}
+{{ end }}
+{{ with .HostsDelta }}
+{{ printf (t "Previously reported: %d host(s). Newly affected: %d host(s).") .Previous .New }}
+{{ end }}
+{{ t "Affected hosts" }}:{{ with .HostsTrend }} {{ printf (t "%[1]d (was %[2]d last report)") $.HostsCount .Previous }}{{ if gt $.HostsCount .Previous }} ▲{{ else if lt $.HostsCount .Previous }} ▼{{ end }}{{ end }}
 
-Affected hosts:
-
-{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}
+{{ if .HostsTable }}{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}
+| {{ t "Host" }} | {{ t "Paths" }} | {{ t "Platform" }} |
+| --- | --- | --- |
+{{ range slice .Hosts 0 $end }}| [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }}) | {{ if and $.SoftwareNames .Software }}{{ range $i, $s := .Software }}{{ if $i }}<br>{{ end }}{{ md $s.Name }}{{ with $s.Version }} {{ mdCell . }}{{ end }}{{ with $s.InstalledPath }}: {{ mdCell . }}{{ end }}{{ end }}{{ else }}{{ range $i, $path := .SoftwareInstalledPaths }}{{ if $i }}<br>{{ end }}{{ mdCell $path }}{{ end }}{{ end }} | {{ mdCell (index $.HostPlatforms .ID) }} |
+{{ end }}{{ else if .PathGroups }}{{ range .PathGroups }}
+* {{ if .Paths }}{{ t "Hosts with" }} {{ range $i, $path := .Paths }}{{ if $i }}, {{ end }}{{ $path }}{{ end }}{{ else }}{{ t "Hosts without installed paths" }}{{ end }}: {{ range $i, $h := .Hosts }}{{ if $i }}, {{ end }}[{{ md (hostLabel $.HostLabels $h.ID $h.DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath $h.ID }}){{ end }}
+{{ end }}{{ else }}{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}
 {{ range slice .Hosts 0 $end }}
-* [{{ .DisplayName }}]({{ $.FleetURL }}/hosts/{{ .ID }})
-{{ range $path := .SoftwareInstalledPaths }}
+* [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }})
+{{ if and $.SoftwareNames .Software }}{{ range .Software }}
+    * {{ md .Name }}{{ with .Version }} {{ . }}{{ end }}{{ with .InstalledPath }}: {{ . }}{{ end }}
+{{ end }}{{ else }}{{ range $path := .SoftwareInstalledPaths }}
     * {{ $path }}
+{{ end }}{{ end }}
+{{ end }}{{ end }}
+{{ if and .TruncationNotice (lt .ListedHostsCount .HostsCount) }}
+{{ printf (t "Showing %[1]d of %[2]d hosts — view all: %[3]s") .ListedHostsCount .HostsCount (print .FleetURL (.Links.VulnHostsPath .CVE)) }}
+{{ end }}{{ if not .HideSoftwareInstructions }}
+{{ t "View the affected software and more affected hosts:" }}
+
+1. {{ printf (t "Go to the [Software](%s) page in Fleet.") (print .FleetURL "/software/manage") }}
+2. {{ printf (t "Above the list of software, in the **Search software** box, enter \"%s\".") .CVE }}
+3. {{ t "Hover over the affected software and select **View all hosts**." }}
 {{ end }}
-{{ end }}
+{{ if .Truncated }}{{ t "Some hosts or paths were omitted to fit the maximum size of a FreeScout conversation." }}
+
+{{ end }}----
 
-View the affected software and more affected hosts:
+{{ t "This conversation was created automatically by your Fleet FreeScout integration." }}
+`)),
+
+	FailingPolicySummary: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ with .TeamName }}[{{ . }}] {{ end }}{{ printf (t "%[1]s policy failed on %[2]d host(s)") .PolicyName (len .Hosts) }}`,
+	)),
 
-1. Go to the [Software]({{ .FleetURL }}/software/manage) page in Fleet.
-2. Above the list of software, in the **Search software** box, enter "{{ .CVE }}".
-3. Hover over the affected software and select **View all hosts**.
+	FailingPolicyDescription: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ if .PolicyCritical }}{{ t "This policy is marked as **Critical** in Fleet." }}
 
-----
+{{ end }}{{ with .PolicyPlatform }}{{ printf (t "Targeted platforms: %s") (platforms .) }}
 
-This conversation was created automatically by your Fleet FreeScout integration.
+{{ end }}{{ t "Hosts" }}:
+{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}{{ if $.HostsTable }}
+| {{ t "Host" }} | {{ t "Platform" }} |
+| --- | --- |
+{{ range slice .Hosts 0 $end }}| [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }}) | {{ mdCell (index $.HostPlatforms .ID) }} |
+{{ end }}{{ else }}
+{{ range slice .Hosts 0 $end }}
+* [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }})
+{{ end }}{{ end }}
+{{ if and .TruncationNotice (lt $end .HostsCount) }}
+{{ printf (t "Showing %[1]d of %[2]d hosts — view all: %[3]s") $end .HostsCount (print $.FleetURL ($.Links.PolicyHostsPath .TeamID .PolicyID)) }}
+{{ end }}
+{{ printf (t "View hosts that failed %[1]s on the [**Hosts**](%[2]s) page in Fleet.") (md .PolicyName) (print $.FleetURL ($.Links.PolicyHostsPath .TeamID .PolicyID)) }}
+
+{{ if .Truncated }}{{ t "Some hosts or paths were omitted to fit the maximum size of a FreeScout conversation." }}
+
+{{ end }}----
+
+{{ t "This conversation was created automatically by your Fleet FreeScout integration." }}
 `)),
 
-	FailingPolicySummary: template.Must(template.New("").Parse(
-		`{{ .PolicyName }} policy failed on {{ len .Hosts }} host(s)`,
+	FailingPoliciesSummary: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ printf (t "%[1]d policies failing on %[2]d hosts") (len .Policies) .HostsCount }}`,
 	)),
 
-	FailingPolicyDescription: template.Must(template.New("").Parse(
-		`{{ if .PolicyCritical }}This policy is marked as **Critical** in Fleet.
+	FailingPoliciesDescription: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ range .Policies }}## {{ md .PolicyName }}
+
+{{ if .PolicyCritical }}{{ t "This policy is marked as **Critical** in Fleet." }}
 
-{{ end }}Hosts:
-{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}
+{{ end }}{{ with .PolicyPlatform }}{{ printf (t "Targeted platforms: %s") (platforms .) }}
+
+{{ end }}{{ t "Hosts" }}:
+{{ $end := len .Hosts }}{{ if gt $end 50 }}{{ $end = 50 }}{{ end }}{{ if $.HostsTable }}
+| {{ t "Host" }} | {{ t "Platform" }} |
+| --- | --- |
+{{ range slice .Hosts 0 $end }}| [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }}) | {{ mdCell (index $.HostPlatforms .ID) }} |
+{{ end }}{{ else }}
 {{ range slice .Hosts 0 $end }}
-* [{{ .DisplayName }}]({{ $.FleetURL }}/hosts/{{ .ID }})
-{{ end }}
+* [{{ md (hostLabel $.HostLabels .ID .DisplayName) }}]({{ $.FleetURL }}{{ $.Links.HostPath .ID }})
+{{ end }}{{ end }}
 
-View hosts that failed {{ .PolicyName }} on the [**Hosts**]({{ $.FleetURL }}/hosts/manage/?order_key=hostname&order_direction=asc&{{ if .TeamID }}team_id={{ .TeamID }}&{{ end }}policy_id={{ .PolicyID }}&policy_response=failing) page in Fleet.
+{{ printf (t "View hosts that failed %[1]s on the [**Hosts**](%[2]s) page in Fleet.") (md .PolicyName) (print $.FleetURL ($.Links.PolicyHostsPath .TeamID .PolicyID)) }}
 
-----
+{{ end }}{{ if .Truncated }}{{ t "Some hosts or paths were omitted to fit the maximum size of a FreeScout conversation." }}
+
+{{ end }}----
+
+{{ t "This conversation was created automatically by your Fleet FreeScout integration." }}
+`)),
+
+	DigestSummary: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ with .TeamName }}[{{ . }}] {{ end }}{{ printf (t "Fleet digest since %[1]s: %[2]d vulnerabilities, %[3]d failing policies") .Since .VulnsCount .PoliciesCount }}`,
+	)),
+
+	DigestDescription: template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`{{ if .Severities }}## {{ t "Vulnerabilities" }}
+{{ range .Severities }}
+### {{ t .Label }} ({{ .Count }})
+{{ range .Vulns }}
+* [{{ .CVE }}]({{ $.FleetURL }}/software/vulnerabilities/{{ urlpath .CVE }}){{ with .CVSSScore }}, {{ t "CVSS score" }} {{ . }}{{ end }}{{ if .KnownExploit }}, **{{ t "known exploit" }}**{{ end }}
+{{ end }}{{ end }}
+{{ end }}{{ if .Policies }}## {{ t "Failing policies" }} ({{ .PoliciesCount }})
+{{ range .Policies }}
+* [{{ md .PolicyName }}]({{ $.FleetURL }}{{ $.Links.PolicyHostsPath .TeamID .PolicyID }}){{ if .PolicyCritical }} (**{{ t "Critical" }}**){{ end }}: {{ printf (t "failing on %d host(s)") .HostsCount }}
+{{ end }}
+{{ end }}{{ if .Truncated }}{{ t "Some vulnerabilities or policies were omitted to fit the maximum size of a FreeScout conversation." }}
 
-This conversation was created automatically by your Fleet FreeScout integration.
+{{ end }}----
+
+{{ t "This conversation was created automatically by your Fleet FreeScout integration." }}
 `)),
 }
 
+// freeScoutLinks renders the paths of the links to the hosts and to the
+// failing hosts of a policy in Fleet, with the templates configured by the
+// integration or the built-in ones if nil.
+type freeScoutLinks struct {
+	host        *template.Template
+	policyHosts *template.Template
+	vulnHosts   *template.Template
+}
+
+// The built-in templates of the paths of freeScoutLinks.
+var (
+	freeScoutDefaultHostPath        = template.Must(template.New("").Parse(`/hosts/{{ .ID }}`))
+	freeScoutDefaultPolicyHostsPath = template.Must(template.New("").Parse(
+		`/hosts/manage/?order_key=hostname&order_direction=asc&{{ if .TeamID }}team_id={{ urlquery .TeamID }}&{{ end }}policy_id={{ urlquery .PolicyID }}&policy_response=failing`,
+	))
+	freeScoutDefaultVulnHostsPath = template.Must(template.New("").Parse(
+		`/hosts/manage/?order_key=hostname&order_direction=asc&vulnerability={{ urlquery .CVE }}`,
+	))
+)
+
+// HostPath returns the path of the link to the host.
+func (l freeScoutLinks) HostPath(id uint) (string, error) {
+	tpl := l.host
+	if tpl == nil {
+		tpl = freeScoutDefaultHostPath
+	}
+	return renderFreeScoutTemplate(tpl, struct{ ID uint }{ID: id})
+}
+
+// VulnHostsPath returns the path of the link to the hosts affected by the CVE.
+func (l freeScoutLinks) VulnHostsPath(cve string) (string, error) {
+	tpl := l.vulnHosts
+	if tpl == nil {
+		tpl = freeScoutDefaultVulnHostsPath
+	}
+	return renderFreeScoutTemplate(tpl, struct{ CVE string }{CVE: cve})
+}
+
+// PolicyHostsPath returns the path of the link to the hosts failing the
+// policy, teamID is nil for global policies.
+func (l freeScoutLinks) PolicyHostsPath(teamID *uint, policyID uint) (string, error) {
+	tpl := l.policyHosts
+	if tpl == nil {
+		tpl = freeScoutDefaultPolicyHostsPath
+	}
+	return renderFreeScoutTemplate(tpl, struct {
+		TeamID   *uint
+		PolicyID uint
+	}{TeamID: teamID, PolicyID: policyID})
+}
+
 type freeScoutVulnTplArgs struct {
 	NVDURL   string
 	FleetURL string
+	Links    freeScoutLinks
 	CVE      string
 	Hosts    []fleet.HostVulnerabilitySummary
+	// HostsCount is the total number of affected hosts, which may be more than
+	// the number of listed hosts.
+	HostsCount int
 
-	// Optional CVE metadata.
+	// Optional CVE metadata, CVSSVersion is empty if it is unknown.
 	EPSSProbability  *float64
 	CVSSScore        *float64
+	CVSSVersion      string
 	CISAKnownExploit *bool
 	CVEPublished     *time.Time
+	// Now is the time relative to which the age of the CVE is rendered.
+	Now time.Time
+	// Severity is the severity band of CVSSScore, one of the webhooks.Severity*
+	// values, for the overridden summary templates.
+	Severity string
+
+	// References are the links to the CVE rendered after the NVD link in a
+	// "References" section.
+	References []FreeScoutReference
+
+	// EPSSLabelMode and EPSSThresholds render the exploit likelihood label of
+	// EPSSProbability, see fleet.FreeScoutIntegration.EPSSLabelMode.
+	EPSSLabelMode  string
+	EPSSThresholds fleet.FreeScoutEPSSThresholds
+
+	// EPSSRenderThreshold and CVSSRenderThreshold omit the lines of
+	// EPSSProbability and CVSSScore below them.
+	EPSSRenderThreshold float64
+	CVSSRenderThreshold float64
+
+	// HostLabels is the text of the hosts' links keyed by host ID, the display
+	// name is used for hosts without a label.
+	HostLabels map[uint]string
+
+	// PathGroups is set in compact mode, the listed hosts are then rendered
+	// grouped by installed paths instead of individually.
+	PathGroups []freeScoutPathGroup
+
+	// HostsTable renders the listed hosts as a table instead of a bulleted
+	// list, with their platform from HostPlatforms keyed by host ID. It takes
+	// precedence over PathGroups.
+	HostsTable    bool
+	HostPlatforms map[uint]string
+
+	// HostsDelta is set when the CVE was already reported.
+	HostsDelta *FreeScoutHostsDelta
+
+	// HostsTrend is set when the number of hosts of the CVE's last report is
+	// known.
+	HostsTrend *FreeScoutHostsTrend
+
+	// SummaryHeader renders the key facts of the CVE at the top.
+	SummaryHeader bool
+
+	// SoftwareNames renders the name and version of the vulnerable software
+	// of each host, when known, along with its installed paths.
+	SoftwareNames bool
+
+	// HideSoftwareInstructions omits the instructions to find the affected
+	// software in Fleet.
+	HideSoftwareInstructions bool
+
+	// TruncationNotice renders the number of listed hosts out of HostsCount
+	// with a link to all of them when some are not listed.
+	TruncationNotice bool
+
+	// Truncated is set when hosts or paths were removed to fit the maximum
+	// size of the description.
+	Truncated bool
+}
+
+// ListedHostsCount returns the number of hosts listed in the description.
+func (a *freeScoutVulnTplArgs) ListedHostsCount() int {
+	if a.PathGroups != nil && !a.HostsTable {
+		var n int
+		for _, g := range a.PathGroups {
+			n += len(g.Hosts)
+		}
+		return n
+	}
+	return min(len(a.Hosts), freeScoutMaxHostsInDescription)
+}
+
+// shrink implements freeScoutShrinker. It halves the number of listed hosts
+// down to a single one, and then halves the number of paths and software of
+// that host.
+func (a *freeScoutVulnTplArgs) shrink() bool {
+	hosts := a.Hosts
+	if len(hosts) > freeScoutMaxHostsInDescription {
+		hosts = hosts[:freeScoutMaxHostsInDescription]
+	}
+	switch {
+	case len(hosts) > 1:
+		a.Hosts = hosts[:len(hosts)/2]
+	case len(hosts) == 1 && (len(hosts[0].SoftwareInstalledPaths) > 0 || len(hosts[0].Software) > 0):
+		host := hosts[0]
+		host.SoftwareInstalledPaths = host.SoftwareInstalledPaths[:len(host.SoftwareInstalledPaths)/2]
+		host.Software = host.Software[:len(host.Software)/2]
+		a.Hosts = []fleet.HostVulnerabilitySummary{host}
+	default:
+		return false
+	}
+	if a.PathGroups != nil {
+		a.PathGroups = groupFreeScoutHostsByPaths(a.Hosts)
+	}
+	a.Truncated = true
+	return true
+}
+
+// freeScoutPathGroup is a group of hosts that have the exact same set of
+// vulnerable software installed paths.
+type freeScoutPathGroup struct {
+	Paths []string
+	Hosts []fleet.HostVulnerabilitySummary
+}
+
+type freeScoutFailingPolicyTplArgs struct {
+	*failingPoliciesTplArgs
+	Links         freeScoutLinks
+	TeamName      string
+	HostLabels    map[uint]string
+	HostsTable    bool
+	HostPlatforms map[uint]string
+	Truncated     bool
+
+	// HostsCount is the number of failing hosts, which may be more than the
+	// number of listed hosts, and TruncationNotice renders it with a link to
+	// all of them when some are not listed.
+	HostsCount       int
+	TruncationNotice bool
+}
+
+// shrink implements freeScoutShrinker. It halves the number of listed hosts
+// down to a single one.
+func (a *freeScoutFailingPolicyTplArgs) shrink() bool {
+	if !shrinkFreeScoutPolicyHosts(a.failingPoliciesTplArgs) {
+		return false
+	}
+	a.Truncated = true
+	return true
+}
+
+type freeScoutFailingPoliciesTplArgs struct {
+	FleetURL      string
+	Links         freeScoutLinks
+	Policies      []*failingPoliciesTplArgs
+	HostsCount    int
+	HostLabels    map[uint]string
+	HostsTable    bool
+	HostPlatforms map[uint]string
+	Truncated     bool
+}
+
+// shrink implements freeScoutShrinker. It halves the number of listed hosts of
+// every policy, down to a single one per policy.
+func (a *freeScoutFailingPoliciesTplArgs) shrink() bool {
+	var shrunk bool
+	for _, p := range a.Policies {
+		if shrinkFreeScoutPolicyHosts(p) {
+			shrunk = true
+		}
+	}
+	if shrunk {
+		a.Truncated = true
+	}
+	return shrunk
+}
+
+// shrinkFreeScoutPolicyHosts halves the number of listed hosts of the policy,
+// it returns false if there is at most one host listed.
+func shrinkFreeScoutPolicyHosts(p *failingPoliciesTplArgs) bool {
+	hosts := p.Hosts
+	if len(hosts) > freeScoutMaxHostsInDescription {
+		hosts = hosts[:freeScoutMaxHostsInDescription]
+	}
+	if len(hosts) <= 1 {
+		return false
+	}
+	p.Hosts = hosts[:len(hosts)/2]
+	return true
+}
+
+type freeScoutDigestTplArgs struct {
+	FleetURL      string
+	Links         freeScoutLinks
+	TeamName      string
+	Since         string
+	VulnsCount    int
+	Severities    []*freeScoutDigestSeverity
+	PoliciesCount int
+	Policies      []*freeScoutDigestPolicy
+	Truncated     bool
+}
+
+// freeScoutDigestSeverity lists the vulnerabilities of a digest in the same
+// CVSS severity band, Count is the number of vulnerabilities in that band
+// even if some are not listed.
+type freeScoutDigestSeverity struct {
+	Label string
+	Count int
+	Vulns []*freeScoutDigestVuln
+}
+
+type freeScoutDigestVuln struct {
+	CVE          string
+	CVSSScore    *float64
+	KnownExploit bool
+}
+
+type freeScoutDigestPolicy struct {
+	PolicyID       uint
+	PolicyName     string
+	PolicyCritical bool
+	TeamID         *uint
+	HostsCount     int
+}
+
+// shrink implements freeScoutShrinker. It halves the number of listed
+// vulnerabilities of every severity and the number of listed policies, down
+// to a single one for each.
+func (a *freeScoutDigestTplArgs) shrink() bool {
+	var shrunk bool
+	for _, s := range a.Severities {
+		if len(s.Vulns) > 1 {
+			s.Vulns = s.Vulns[:len(s.Vulns)/2]
+			shrunk = true
+		}
+	}
+	if len(a.Policies) > 1 {
+		a.Policies = a.Policies[:len(a.Policies)/2]
+		shrunk = true
+	}
+	if shrunk {
+		a.Truncated = true
+	}
+	return shrunk
+}
+
+// freeScoutHostCounter is implemented by the template arguments that list
+// hosts, to record the number of hosts reported and listed by each job.
+type freeScoutHostCounter interface {
+	// hostCounts returns the number of hosts reported and the number of hosts
+	// listed in the description as the arguments are.
+	hostCounts() (reported, listed int)
+}
+
+func (a *freeScoutVulnTplArgs) hostCounts() (reported, listed int) {
+	return a.HostsCount, min(len(a.Hosts), freeScoutMaxHostsInDescription)
+}
+
+func (a *freeScoutFailingPolicyTplArgs) hostCounts() (reported, listed int) {
+	return len(a.Hosts), min(len(a.Hosts), freeScoutMaxHostsInDescription)
+}
+
+// hostCounts implements freeScoutHostCounter, a host failing several
+// policies is counted once per policy.
+func (a *freeScoutFailingPoliciesTplArgs) hostCounts() (reported, listed int) {
+	for _, p := range a.Policies {
+		reported += len(p.Hosts)
+		listed += min(len(p.Hosts), freeScoutMaxHostsInDescription)
+	}
+	return reported, listed
+}
+
+// freeScoutShrinker is implemented by the template arguments that can be
+// reduced when the rendered description is too large.
+type freeScoutShrinker interface {
+	// shrink removes some of the listed content and flags the arguments as
+	// truncated. It returns false if nothing more can be removed.
+	shrink() bool
 }
 
 // FreeScoutClient defines the method required for the client that makes API calls
 // to FreeScout.
 type FreeScoutClient interface {
-	CreateFreeScoutConversation(ctx context.Context, subject, message string) (int64, error)
+	// CreateFreeScoutConversation returns the ID of the conversation and true if
+	// it was created, false if the message was appended to an existing one.
+	CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error)
 	FreeScoutConfigMatches(opts *externalsvc.FreeScoutOptions) bool
 }
 
@@ -118,239 +690,2851 @@ type FreeScout struct {
 	Log           kitlog.Logger
 	NewClientFunc func(*externalsvc.FreeScoutOptions) (FreeScoutClient, error)
 
+	// NoIntegrationBehavior is what is done with a job whose integration is
+	// no longer enabled, one of the FreeScoutNoIntegration* values. By
+	// default, the job is skipped silently.
+	NoIntegrationBehavior string
+	// NoIntegrationRetries is the number of times a job whose integration is
+	// no longer enabled is re-queued with FreeScoutNoIntegrationRequeue, in
+	// case the integration was disabled temporarily.
+	NoIntegrationRetries int
+
+	// MaxCachedClients is the maximum number of clients kept in the cache, the
+	// least recently used client is evicted when that number is exceeded. If
+	// <= 0, defaultFreeScoutMaxCachedClients is used.
+	MaxCachedClients int
+
+	// Now returns the current time of the time-dependent behaviors, such as
+	// the quiet hours, the report cooldowns and the age of the CVEs rendered
+	// in the conversations. It defaults to time.Now, tests set it to freeze
+	// the time.
+	Now func() time.Time
+
 	// mu protects concurrent access to clientsCache, so that the job processor
-	// can potentially be run concurrently.
-	mu sync.Mutex
-	// map of integration type + team ID to FreeScout client (empty team ID for
-	// global), e.g. "vuln:123", "failingPolicy:", etc.
-	clientsCache map[string]FreeScoutClient
+	// can potentially be run concurrently. The clients are looked up under the
+	// read lock, and cached or evicted under the write lock. They are created
+	// and verified without holding it.
+	mu sync.RWMutex
+	// cache of integration type + team ID + mailbox ID to FreeScout client
+	// (empty team ID for global), e.g. "vuln::1", "failingPolicy:123:2", etc.
+	clientsCache *freeScoutClientsCache
+
+	// templatesMu protects templates, the parsed template overrides keyed by
+	// their text.
+	templatesMu sync.Mutex
+	templates   map[string]*template.Template
+
+	// limitersMu protects limiters, the rate limiters of the conversations of
+	// each mailbox keyed by FreeScout URL and mailbox ID.
+	limitersMu sync.Mutex
+	limiters   map[string]*freeScoutRateLimiter
+
+	// instancesMu protects instances, the limiters of the concurrent jobs of
+	// each FreeScout instance keyed by URL.
+	instancesMu sync.Mutex
+	instances   map[string]*freeScoutInstanceLimiter
+
+	// number of jobs that resulted in a new conversation and in a thread
+	// appended to an existing conversation since the job processor started.
+	conversationsCreated atomic.Int64
+	threadsAppended      atomic.Int64
+
+	// number of jobs discarded without being processed because their
+	// integration is disabled and draining, see
+	// fleet.FreeScoutIntegration.Drain.
+	jobsDrained atomic.Int64
+}
+
+// Name returns the name of the job.
+func (f *FreeScout) Name() string {
+	return freescoutName
+}
+
+// now returns the current time according to the Now function, or time.Now if
+// it is not set.
+func (f *FreeScout) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// ConversationCounts returns the number of jobs that resulted in a new
+// conversation and the number of jobs that appended a thread to an existing
+// conversation since the job processor started.
+func (f *FreeScout) ConversationCounts() (created, appended int64) {
+	return f.conversationsCreated.Load(), f.threadsAppended.Load()
+}
+
+// conversationLogAttrs returns the log attributes describing the outcome of a
+// job along with the counts of created conversations and appended threads.
+func (f *FreeScout) conversationLogAttrs(conversationID int64, created bool) []interface{} {
+	totalCreated, totalAppended := f.ConversationCounts()
+	return []interface{}{
+		"conversation_id", conversationID,
+		"created", created,
+		"conversations_created", totalCreated,
+		"threads_appended", totalAppended,
+	}
+}
+
+// getClient returns the client to use for the job along with the FreeScout
+// integration configuration it was created from. It returns nil, nil, nil if
+// there is no integration enabled for that message.
+func (f *FreeScout) getClient(ctx context.Context, args freeScoutArgs) (FreeScoutClient, *fleet.FreeScoutIntegration, error) {
+	intgType := args.integrationType()
+	baseKey := intgType + ":"
+	if (intgType == intgTypeFailingPolicy || intgType == freeScoutIntgTypeDigest) && args.teamID() != nil {
+		baseKey += fmt.Sprint(*args.teamID())
+	}
+
+	// load the config that would be used to create the client first - it is
+	// needed to check if an existing client is configured the same or if its
+	// configuration has changed since it was created.
+	intg, err := f.jobIntegration(ctx, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var opts *externalsvc.FreeScoutOptions
+	if intg != nil {
+		opts = newFreeScoutOptions(intg)
+	}
+	cli, err := f.cachedClient(ctx, baseKey, opts)
+	if err != nil || cli == nil {
+		return nil, nil, err
+	}
+	return cli, intg, nil
+}
+
+// jobIntegration returns a copy of the FreeScout integration enabled for the
+// job, resolved for it: with the customer and assignee of its type, the
+// customer email of its team and its mailbox. It returns nil if there is no
+// integration enabled for the job.
+func (f *FreeScout) jobIntegration(ctx context.Context, args freeScoutArgs) (*fleet.FreeScoutIntegration, error) {
+	intgType := args.integrationType()
+	useTeamCfg := (intgType == intgTypeFailingPolicy || intgType == freeScoutIntgTypeDigest) && args.teamID() != nil
+
+	ac, err := f.Datastore.AppConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.setInstanceLimits(ac.Integrations.Freescout)
+
+	var intg *fleet.FreeScoutIntegration
+	if useTeamCfg {
+		tm, err := f.Datastore.TeamLite(ctx, *args.teamID())
+		if err != nil {
+			return nil, err
+		}
+
+		intgs, err := tm.Config.Integrations.MatchWithIntegrations(ac.Integrations)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, candidate := range intgs.Freescout {
+			// team digests only have failing policies.
+			if candidate.EnableFailingPolicies {
+				intg = candidate
+				break
+			}
+		}
+		if intg != nil {
+			// the resolved email is part of the client's options, so that the
+			// cached client is rebuilt when it changes.
+			intg = freeScoutIntegrationForType(intg, intgType)
+			email, err := intg.TeamCustomerEmail(tm.ID, tm.Name)
+			if err != nil {
+				return nil, ctxerr.Wrapf(ctx, err, "team %d customer email", tm.ID)
+			}
+			intg.CustomerEmail = email
+		}
+	} else {
+		// the digest does not require a digest interval, so that the events
+		// still pending when it is unset get reported.
+		for _, candidate := range ac.Integrations.Freescout {
+			if (intgType == intgTypeVuln && candidate.EnableSoftwareVulnerabilities) ||
+				(intgType == intgTypeFailingPolicy && candidate.EnableFailingPolicies) ||
+				(intgType == freeScoutIntgTypeDigest && (candidate.EnableSoftwareVulnerabilities || candidate.EnableFailingPolicies)) {
+				intg = candidate
+				break
+			}
+		}
+		if intg != nil {
+			intg = freeScoutIntegrationForType(intg, intgType)
+		}
+	}
+
+	if intg != nil {
+		intg.MailboxID = freeScoutMailboxID(intg, args)
+	}
+	return intg, nil
+}
+
+// freeScoutIntegrationForType returns a copy of the integration with its
+// customer email and assignee overridden by those configured for the
+// integration type, if any, so that e.g. the vulnerabilities and the failing
+// policies are reported to different customers.
+func freeScoutIntegrationForType(intg *fleet.FreeScoutIntegration, intgType string) *fleet.FreeScoutIntegration {
+	var email string
+	var assignTo int64
+	switch intgType {
+	case intgTypeVuln:
+		email, assignTo = intg.VulnCustomerEmail, intg.VulnAssignTo
+	case intgTypeFailingPolicy:
+		email, assignTo = intg.FailingPoliciesCustomerEmail, intg.FailingPoliciesAssignTo
+	}
+
+	typed := *intg
+	if email = strings.TrimSpace(email); email != "" {
+		typed.CustomerEmail = email
+	}
+	if assignTo > 0 {
+		typed.AssignTo = assignTo
+	}
+	return &typed
+}
+
+// freeScoutMailboxID returns the mailbox in which the job's conversation is
+// created: for vulnerabilities, the mailbox mapped to the severity band of the
+// CVE's CVSS score if there is one, otherwise the integration's mailbox.
+func freeScoutMailboxID(intg *fleet.FreeScoutIntegration, args freeScoutArgs) int64 {
+	if args.Vulnerability != nil {
+		severity := webhooks.CVSSSeverity(args.Vulnerability.CVSSScore)
+		if mailboxID := intg.SeverityMailboxes[severity]; mailboxID > 0 {
+			return mailboxID
+		}
+	}
+	return intg.MailboxID
+}
+
+// newFreeScoutOptions returns the client options for the provided
+// integration, or nil if intg is nil.
+func newFreeScoutOptions(intg *fleet.FreeScoutIntegration) *externalsvc.FreeScoutOptions {
+	if intg == nil {
+		return nil
+	}
+	return &externalsvc.FreeScoutOptions{
+		URL:                      intg.URL,
+		APIToken:                 intg.APIToken,
+		AuthMode:                 intg.AuthMode,
+		MailboxID:                intg.MailboxID,
+		CustomerEmail:            intg.CustomerEmail,
+		AssignTo:                 intg.AssignTo,
+		SearchStatus:             intg.SearchStatus,
+		SearchState:              intg.SearchState,
+		SearchSortField:          intg.SearchSortField,
+		SearchSortOrder:          intg.SearchSortOrder,
+		SearchDecodeRetries:      intg.SearchDecodeRetries,
+		SearchDecodeErrorPolicy:  intg.SearchDecodeErrorPolicy,
+		AppendNotFoundPolicy:     intg.AppendNotFoundPolicy,
+		SearchIndexRetries:       intg.SearchIndexRetries,
+		SearchIndexDelay:         intg.SearchIndexDelay.Duration,
+		RetryAfterBlockThreshold: intg.RetryAfterBlockThreshold.Duration,
+		TransportRetries:         intg.TransportRetries,
+		MaxAttachmentBytes:       intg.MaxAttachmentBytes,
+		ConversationType:         intg.ConversationType,
+		ReassignOnAppend:         intg.ReassignOnAppend,
+		Imported:                 intg.Imported,
+		InitialThreadType:        intg.InitialThreadType,
+		AppendThreadType:         intg.AppendThreadType,
+		NoteUserID:               intg.NoteUserID,
+		Source:                   intg.ConversationSource,
+		FromName:                 intg.FromName,
+		FolderID:                 intg.FolderID,
+		Headers:                  intg.Headers,
+		JobIDHeader:              intg.JobIDHeader,
+		DumpPayloads:             intg.DumpPayloads,
+		RedactCustomerEmail:      intg.RedactCustomerEmailInDumps,
+		VerifyScopes:             intg.VerifyTokenScopes,
+		MailboxTypeCheck:         intg.MailboxTypeCheck,
+		Transport:                intg.TransportOptions(),
+	}
+}
+
+// cachedClient returns the client cached for baseKey and the mailbox of opts
+// if it is still configured as required by opts, otherwise it creates and
+// caches a new one. If opts is nil, the clients cached for baseKey, whatever
+// their mailbox, are evicted and nil is returned.
+func (f *FreeScout) cachedClient(ctx context.Context, baseKey string, opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+	// reuse the cached client under the read lock if its configuration still
+	// matches, so that the jobs using the same client do not serialize.
+	if opts != nil {
+		f.mu.RLock()
+		var cli FreeScoutClient
+		if f.clientsCache != nil {
+			cli = f.clientsCache.get(baseKey + ":" + strconv.FormatInt(opts.MailboxID, 10))
+		}
+		f.mu.RUnlock()
+		if cli != nil && cli.FreeScoutConfigMatches(opts) {
+			return cli, nil
+		}
+	}
+
+	f.mu.Lock()
+	if f.clientsCache == nil {
+		maxSize := f.MaxCachedClients
+		if maxSize <= 0 {
+			maxSize = defaultFreeScoutMaxCachedClients
+		}
+		f.clientsCache = newFreeScoutClientsCache(maxSize)
+	}
+	if opts == nil {
+		// no integration configured, clear any existing one
+		for _, cli := range f.clientsCache.removePrefix(baseKey + ":") {
+			closeFreeScoutClient(cli)
+		}
+		f.mu.Unlock()
+		return nil, nil
+	}
+
+	key := baseKey + ":" + strconv.FormatInt(opts.MailboxID, 10)
+
+	// check if the existing one can be reused
+	if cli := f.clientsCache.get(key); cli != nil {
+		if cli.FreeScoutConfigMatches(opts) {
+			f.mu.Unlock()
+			return cli, nil
+		}
+		// the configuration changed since the client was created (e.g. the API
+		// token was rotated), evict it so that it never gets used again, even if
+		// creating the new client fails.
+		level.Debug(f.logger(ctx)).Log("msg", "freescout configuration changed, rebuilding client", "key", key)
+		closeFreeScoutClient(f.clientsCache.remove(key))
+	}
+	f.mu.Unlock()
+
+	// otherwise create a new one, outside of the lock as verifying it makes
+	// requests to FreeScout that must not block the lookups of the other jobs.
+	opts.Logger = f.Log
+	cli, err := f.NewClientFunc(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.VerifyScopes {
+		// verify the token on first use of the client, it is not cached if it
+		// fails so that the check is made again on the next job.
+		if checker, ok := cli.(interface{ CheckScopes(context.Context) error }); ok {
+			if err := checker.CheckScopes(ctx); err != nil {
+				closeFreeScoutClient(cli)
+				return nil, ctxerr.Wrap(ctx, err, "verify freescout API token scopes")
+			}
+		}
+	}
+	if opts.MailboxTypeCheck != "" {
+		// like the scopes, a failed check is made again on the next job.
+		if checker, ok := cli.(interface{ CheckMailboxType(context.Context) error }); ok {
+			if err := checker.CheckMailboxType(ctx); err != nil {
+				if opts.MailboxTypeCheck != externalsvc.FreeScoutMailboxTypeCheckWarn {
+					closeFreeScoutClient(cli)
+					return nil, ctxerr.Wrap(ctx, err, "check freescout mailbox type")
+				}
+				level.Warn(f.logger(ctx)).Log("msg", "freescout mailbox type check failed", "mailbox_id", opts.MailboxID, "err", err)
+			}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// a concurrent job may have cached a client for the same configuration
+	// while this one was verified, keep using the cached one.
+	if cached := f.clientsCache.get(key); cached != nil && cached != cli {
+		if cached.FreeScoutConfigMatches(opts) {
+			closeFreeScoutClient(cli)
+			return cached, nil
+		}
+		closeFreeScoutClient(f.clientsCache.remove(key))
+	}
+	if evictedKey, evicted := f.clientsCache.add(key, cli); evicted != nil {
+		level.Debug(f.logger(ctx)).Log("msg", "evicted least recently used freescout client", "key", evictedKey)
+		closeFreeScoutClient(evicted)
+	}
+	return cli, nil
+}
+
+// waitMailboxRateLimit waits until the rate limit of the integration's mailbox
+// allows a conversation, or until ctx is done.
+func (f *FreeScout) waitMailboxRateLimit(ctx context.Context, intg *fleet.FreeScoutIntegration) error {
+	if intg == nil {
+		return nil
+	}
+	limit := intg.MailboxRateLimitOf(intg.MailboxID)
+	if limit.Conversations <= 0 {
+		return nil
+	}
+	interval := limit.Interval.Duration
+	if interval <= 0 {
+		interval = defaultFreeScoutRateLimitInterval
+	}
+
+	key := intg.URL + "\n" + strconv.FormatInt(intg.MailboxID, 10)
+	f.limitersMu.Lock()
+	if f.limiters == nil {
+		f.limiters = make(map[string]*freeScoutRateLimiter)
+	}
+	limiter := f.limiters[key]
+	if limiter == nil || limiter.burst != limit.Conversations || limiter.interval != interval {
+		// the limit changed, start over with a full bucket.
+		limiter = newFreeScoutRateLimiter(limit.Conversations, interval)
+		f.limiters[key] = limiter
+	}
+	f.limitersMu.Unlock()
+
+	delay, err := limiter.wait(ctx)
+	if delay > 0 {
+		level.Debug(f.logger(ctx)).Log("msg", "waited for freescout mailbox rate limit", "mailbox_id", intg.MailboxID, "delay", delay)
+	}
+	return err
+}
+
+// setInstanceLimits sets the maximum number of concurrent jobs of each
+// FreeScout instance of intgs to the smallest MaxConcurrentJobs of its
+// integrations, so that the jobs of all the integrations of an instance are
+// limited together whatever their limits. The jobs in progress keep their
+// slots when a limit changes.
+func (f *FreeScout) setInstanceLimits(intgs []*fleet.FreeScoutIntegration) {
+	limits := make(map[string]int, len(intgs))
+	for _, intg := range intgs {
+		key := freeScoutInstanceKey(intg.URL)
+		limit := freeScoutMaxConcurrentJobs(intg)
+		if cur, ok := limits[key]; !ok || limit < cur {
+			limits[key] = limit
+		}
+	}
+
+	f.instancesMu.Lock()
+	defer f.instancesMu.Unlock()
+	if f.instances == nil {
+		f.instances = make(map[string]*freeScoutInstanceLimiter)
+	}
+	for key, limit := range limits {
+		if limiter := f.instances[key]; limiter != nil {
+			limiter.setLimit(limit)
+		} else {
+			f.instances[key] = newFreeScoutInstanceLimiter(limit)
+		}
+	}
+}
+
+// acquireInstance waits until fewer than the maximum number of concurrent
+// jobs of the integration's FreeScout instance are processed, or until ctx is
+// done. The returned function must be called once the job is processed. The
+// limit is the one set by setInstanceLimits when the configuration was
+// loaded, or else the integration's own.
+func (f *FreeScout) acquireInstance(ctx context.Context, intg *fleet.FreeScoutIntegration) (func(), error) {
+	key := freeScoutInstanceKey(intg.URL)
+	f.instancesMu.Lock()
+	if f.instances == nil {
+		f.instances = make(map[string]*freeScoutInstanceLimiter)
+	}
+	limiter := f.instances[key]
+	if limiter == nil {
+		limiter = newFreeScoutInstanceLimiter(freeScoutMaxConcurrentJobs(intg))
+		f.instances[key] = limiter
+	}
+	f.instancesMu.Unlock()
+
+	if err := limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return limiter.release, nil
+}
+
+// freeScoutInstanceKey returns the key of the FreeScout instance at url,
+// shared by the integrations with that URL.
+func freeScoutInstanceKey(url string) string {
+	return strings.TrimRight(url, "/")
+}
+
+// freeScoutMaxConcurrentJobs returns the maximum number of concurrent jobs of
+// the integration's instance, or the default if it is not set.
+func freeScoutMaxConcurrentJobs(intg *fleet.FreeScoutIntegration) int {
+	if intg.MaxConcurrentJobs <= 0 {
+		return defaultFreeScoutMaxConcurrentJobs
+	}
+	return intg.MaxConcurrentJobs
+}
+
+// freeScoutInstanceLimiter limits the number of jobs processed concurrently
+// against a FreeScout instance. Unlike a buffered channel, its limit can be
+// changed while jobs are in progress: a lower limit is enforced once enough
+// of them are released.
+type freeScoutInstanceLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	// changed is closed, and replaced, when a job is released or the limit
+	// is raised, to wake up the waiting jobs.
+	changed chan struct{}
+}
+
+func newFreeScoutInstanceLimiter(limit int) *freeScoutInstanceLimiter {
+	return &freeScoutInstanceLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire waits until fewer than the limit of jobs are in progress, or until
+// ctx is done.
+func (l *freeScoutInstanceLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// release releases the slot of a job acquired with acquire.
+func (l *freeScoutInstanceLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.notify()
+}
+
+// setLimit changes the limit, the jobs in progress keep their slots.
+func (l *freeScoutInstanceLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit > l.limit {
+		l.notify()
+	}
+	l.limit = limit
+}
+
+// notify wakes up the waiting jobs, l.mu must be held.
+func (l *freeScoutInstanceLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// defaultFreeScoutRateLimitInterval is the default interval of the mailbox
+// rate limits.
+const defaultFreeScoutRateLimitInterval = time.Minute
+
+// freeScoutRateLimiter is a token bucket allowing burst conversations per
+// interval, the tokens being refilled continuously.
+type freeScoutRateLimiter struct {
+	burst    int
+	interval time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newFreeScoutRateLimiter(burst int, interval time.Duration) *freeScoutRateLimiter {
+	return &freeScoutRateLimiter{
+		burst:    burst,
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait takes a token, waiting for one to be available if needed. It returns
+// how long it waited, and the cause of ctx being done if it is done before a
+// token is available.
+func (l *freeScoutRateLimiter) wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(float64(l.burst), l.tokens+float64(now.Sub(l.last))/float64(l.interval)*float64(l.burst))
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return now.Sub(start), nil
+		}
+		delay := time.Duration((1 - l.tokens) / float64(l.burst) * float64(l.interval))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), context.Cause(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// freeScoutClientsCache is a least-recently-used cache of FreeScout clients.
+// Only get can be called concurrently, the FreeScout job processor protects
+// it with its read-write mutex.
+type freeScoutClientsCache struct {
+	maxSize int
+	items   map[string]*freeScoutClientsCacheEntry
+	// clock is incremented on every use of a client, the entry with the
+	// lowest stamp is the least recently used.
+	clock atomic.Uint64
+}
+
+type freeScoutClientsCacheEntry struct {
+	key  string
+	cli  FreeScoutClient
+	used atomic.Uint64
+}
+
+func newFreeScoutClientsCache(maxSize int) *freeScoutClientsCache {
+	return &freeScoutClientsCache{
+		maxSize: maxSize,
+		items:   make(map[string]*freeScoutClientsCacheEntry),
+	}
+}
+
+// get returns the client cached for key, or nil if there is none, and marks
+// it as the most recently used. It does not modify the cache's map, so it is
+// safe to call concurrently under a read lock.
+func (c *freeScoutClientsCache) get(key string) FreeScoutClient {
+	entry, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry.used.Store(c.clock.Add(1))
+	return entry.cli
+}
+
+// add caches the client for key as the most recently used. If this makes the
+// cache exceed its maximum size, the least recently used client is evicted
+// and returned along with its key.
+func (c *freeScoutClientsCache) add(key string, cli FreeScoutClient) (evictedKey string, evicted FreeScoutClient) {
+	if entry, ok := c.items[key]; ok {
+		entry.cli = cli
+		entry.used.Store(c.clock.Add(1))
+		return "", nil
+	}
+	entry := &freeScoutClientsCacheEntry{key: key, cli: cli}
+	entry.used.Store(c.clock.Add(1))
+	c.items[key] = entry
+
+	if len(c.items) <= c.maxSize {
+		return "", nil
+	}
+	var oldest *freeScoutClientsCacheEntry
+	for _, e := range c.items {
+		if oldest == nil || e.used.Load() < oldest.used.Load() {
+			oldest = e
+		}
+	}
+	delete(c.items, oldest.key)
+	return oldest.key, oldest.cli
+}
+
+// remove removes and returns the client cached for key, or nil if there is
+// none.
+func (c *freeScoutClientsCache) remove(key string) FreeScoutClient {
+	entry, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	delete(c.items, key)
+	return entry.cli
+}
+
+// removePrefix removes and returns the clients cached for the keys that start
+// with prefix.
+func (c *freeScoutClientsCache) removePrefix(prefix string) []FreeScoutClient {
+	var removed []FreeScoutClient
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			removed = append(removed, c.remove(key))
+		}
+	}
+	return removed
+}
+
+// len returns the number of cached clients.
+func (c *freeScoutClientsCache) len() int {
+	return len(c.items)
+}
+
+// keys returns the keys of the cached clients, from the most to the least
+// recently used.
+func (c *freeScoutClientsCache) keys() []string {
+	entries := make([]*freeScoutClientsCacheEntry, 0, len(c.items))
+	for _, e := range c.items {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Load() > entries[j].used.Load()
+	})
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+// closeFreeScoutClient releases the idle connections held by the client's
+// transport, if it supports it. It is a no-op for a nil client.
+func closeFreeScoutClient(cli FreeScoutClient) {
+	if closer, ok := cli.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// freeScoutArgs are the arguments for the FreeScout integration job.
+type freeScoutArgs struct {
+	Vulnerability   *vulnArgs                     `json:"vulnerability,omitempty"`
+	FailingPolicy   *failingPolicyArgs            `json:"failing_policy,omitempty"`
+	FailingPolicies *freeScoutFailingPoliciesArgs `json:"failing_policies,omitempty"`
+	Digest          *freeScoutDigestArgs          `json:"digest,omitempty"`
+
+	// CorrelationID is set when the job is queued so that the log lines of
+	// all attempts to process it can be tied together.
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// NoIntegrationAttempts is the number of times the job was re-queued
+	// because its integration was not enabled.
+	NoIntegrationAttempts int `json:"no_integration_attempts,omitempty"`
+}
+
+// freeScoutFailingPoliciesArgs are the arguments for a FreeScout job that
+// reports multiple failing policies of the same team in a single
+// conversation.
+type freeScoutFailingPoliciesArgs struct {
+	TeamID   *uint               `json:"team_id,omitempty"`
+	Policies []failingPolicyArgs `json:"policies"`
+}
+
+// freeScoutDigestArgs are the arguments for a FreeScout job that reports the
+// pending digest events of a team (nil for the global scope) in a single
+// conversation.
+type freeScoutDigestArgs struct {
+	TeamID *uint `json:"team_id,omitempty"`
+}
+
+func (a *freeScoutArgs) integrationType() string {
+	if a.Digest != nil {
+		return freeScoutIntgTypeDigest
+	}
+	if a.FailingPolicy == nil && a.FailingPolicies == nil {
+		return intgTypeVuln
+	}
+	return intgTypeFailingPolicy
+}
+
+// teamID returns the team ID of the failing policies reported by the job, or
+// nil if there is none.
+func (a *freeScoutArgs) teamID() *uint {
+	switch {
+	case a.FailingPolicy != nil:
+		return a.FailingPolicy.TeamID
+	case a.FailingPolicies != nil:
+		return a.FailingPolicies.TeamID
+	case a.Digest != nil:
+		return a.Digest.TeamID
+	}
+	return nil
+}
+
+// The identifier tags of the conversations of a single CVE or policy, set
+// with FreeScoutIntegration.IdentifierTags: the prefix followed by the CVE,
+// e.g. "fleet-cve:CVE-2024-1234", or by the policy ID and, for a team policy,
+// FreeScoutTeamTagInfix and the team ID, e.g. "fleet-policy:42" or
+// "fleet-policy:42:team:7". They are stable across template and subject
+// changes, and are the key by which the existing conversation is searched.
+const (
+	FreeScoutCVETagPrefix    = "fleet-cve:"
+	FreeScoutPolicyTagPrefix = "fleet-policy:"
+	FreeScoutTeamTagInfix    = ":team:"
+)
+
+// conversationTag returns the identifier tag of the conversation of the job,
+// or an empty string for the jobs that report several policies or a digest,
+// which have no such identifier.
+func (a *freeScoutArgs) conversationTag() string {
+	switch {
+	case a.Digest != nil || a.FailingPolicies != nil:
+		return ""
+	case a.FailingPolicy != nil:
+		tag := FreeScoutPolicyTagPrefix + strconv.FormatUint(uint64(a.FailingPolicy.PolicyID), 10)
+		if a.FailingPolicy.TeamID != nil {
+			tag += FreeScoutTeamTagInfix + strconv.FormatUint(uint64(*a.FailingPolicy.TeamID), 10)
+		}
+		return tag
+	case a.Vulnerability != nil && a.Vulnerability.CVE != "":
+		return FreeScoutCVETagPrefix + a.Vulnerability.CVE
+	}
+	return ""
+}
+
+// Run executes the freescout job.
+func (f *FreeScout) Run(ctx context.Context, argsJSON json.RawMessage) error {
+	var args freeScoutArgs
+	if err := json.Unmarshal(argsJSON, &args); err != nil {
+		return ctxerr.Wrap(ctx, err, "unmarshal args")
+	}
+	ctx = correlation.NewContext(ctx, freeScoutCorrelationID(ctx, args.CorrelationID))
+
+	// the metadata is refreshed before getting the client, as the mailbox may
+	// depend on it.
+	f.refreshCVEMeta(ctx, args.Vulnerability)
+
+	cli, intg, err := f.getClient(ctx, args)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get FreeScout client")
+	}
+	if cli == nil {
+		// this message was queued when an integration was enabled, but since
+		// then it has been disabled.
+		return f.runWithoutIntegration(ctx, args)
+	}
+	if intg.Paused {
+		// the integration is paused, skip the job and mark it as processed.
+		level.Debug(f.logger(ctx)).Log("msg", "freescout integration is paused, skipping job", "type", args.integrationType())
+		if args.Digest != nil {
+			// the pending events are kept, try again at the next interval.
+			return queueFreeScoutDigestJob(ctx, f.Datastore, f.logger(ctx), args.Digest.TeamID, freeScoutDigestInterval(intg))
+		}
+		return nil
+	}
+
+	if args.Vulnerability != nil {
+		delay, err := freeScoutQuietHoursDelay(intg, args.Vulnerability, f.now())
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "check FreeScout quiet hours")
+		}
+		if delay > 0 {
+			// the job is processed again at the end of the quiet hours.
+			level.Debug(f.logger(ctx)).Log("msg", "freescout quiet hours, deferring vulnerability job", "cve", args.Vulnerability.CVE, "delay", delay)
+			if _, err := QueueJobWithDelay(ctx, f.Datastore, freescoutName, args, delay); err != nil {
+				return ctxerr.Wrap(ctx, err, "queue FreeScout job deferred by quiet hours")
+			}
+			return nil
+		}
+	}
+
+	// the wait for the instance is not part of the job's deadline.
+	release, err := f.acquireInstance(ctx, intg)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "wait for FreeScout instance")
+	}
+	defer release()
+
+	deadline := intg.JobDeadline.Duration
+	if deadline <= 0 {
+		deadline = defaultFreeScoutJobDeadline
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, deadline, errFreeScoutJobDeadlineExceeded)
+	defer cancel()
+
+	switch intgType := args.integrationType(); intgType {
+	case intgTypeVuln:
+		err = f.runVuln(ctx, cli, intg, args)
+	case intgTypeFailingPolicy:
+		if args.FailingPolicies != nil {
+			err = f.runFailingPolicies(ctx, cli, intg, args)
+		} else {
+			err = f.runFailingPolicy(ctx, cli, intg, args)
+		}
+	case freeScoutIntgTypeDigest:
+		err = f.runDigest(ctx, cli, intg, args)
+	default:
+		return ctxerr.Errorf(ctx, "unknown integration type: %v", intgType)
+	}
+	if errors.Is(err, errFreeScoutConfigChanged) {
+		// the job is processed again with the new configuration, without
+		// consuming one of its retries.
+		level.Info(f.logger(ctx)).Log("msg", "freescout integration configuration changed, deferring job", "type", args.integrationType(), "delay", freeScoutConfigChangedRetryDelay)
+		if _, err := QueueJobWithDelay(ctx, f.Datastore, freescoutName, args, freeScoutConfigChangedRetryDelay); err != nil {
+			return ctxerr.Wrap(ctx, err, "queue deferred FreeScout job")
+		}
+		return nil
+	}
+	var retryAfterErr *externalsvc.FreeScoutRetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		// FreeScout asked to wait longer than worth blocking the worker for,
+		// the job runs again after that without consuming one of its retries.
+		level.Info(f.logger(ctx)).Log("msg", "freescout rate limit, deferring job", "type", args.integrationType(), "delay", retryAfterErr.RetryAfter)
+		if _, err := QueueJobWithDelay(ctx, f.Datastore, freescoutName, args, retryAfterErr.RetryAfter); err != nil {
+			return ctxerr.Wrap(ctx, err, "queue deferred FreeScout job")
+		}
+		return nil
+	}
+	if errors.Is(err, externalsvc.ErrFreeScoutMaintenance) {
+		// FreeScout is being upgraded, the job runs again once it is likely
+		// done without consuming one of its retries.
+		level.Info(f.logger(ctx)).Log("msg", "freescout in maintenance mode, deferring job", "type", args.integrationType(), "delay", freeScoutMaintenanceRetryDelay)
+		if _, err := QueueJobWithDelay(ctx, f.Datastore, freescoutName, args, freeScoutMaintenanceRetryDelay); err != nil {
+			return ctxerr.Wrap(ctx, err, "queue deferred FreeScout job")
+		}
+		return nil
+	}
+	if err != nil && errors.Is(context.Cause(ctx), errFreeScoutJobDeadlineExceeded) {
+		// report the deadline as the reason of the failure rather than
+		// whatever error the interrupted request returned.
+		return ctxerr.Wrap(ctx, fmt.Errorf("%w after %s: %w", errFreeScoutJobDeadlineExceeded, deadline, err))
+	}
+	return err
+}
+
+// The behaviors of FreeScout.NoIntegrationBehavior for the jobs whose
+// integration is no longer enabled: skipped silently, skipped with an info
+// log, or re-queued up to FreeScout.NoIntegrationRetries times, after which
+// they fail.
+const (
+	FreeScoutNoIntegrationSkip    = "skip"
+	FreeScoutNoIntegrationLog     = "log"
+	FreeScoutNoIntegrationRequeue = "requeue"
+)
+
+var freeScoutNoIntegrationBehaviors = []string{FreeScoutNoIntegrationSkip, FreeScoutNoIntegrationLog, FreeScoutNoIntegrationRequeue}
+
+// freeScoutNoIntegrationRetryDelay is the delay before a job whose
+// integration is not enabled is processed again with
+// FreeScoutNoIntegrationRequeue.
+const freeScoutNoIntegrationRetryDelay = 5 * time.Minute
+
+// errFreeScoutNoIntegration is returned for a job whose integration is still
+// not enabled once its NoIntegrationRetries are exhausted.
+var errFreeScoutNoIntegration = errors.New("no freescout integration enabled for the job")
+
+// ValidateFreeScoutNoIntegrationBehavior returns an error if the behavior is
+// not empty nor one of the FreeScoutNoIntegration* values.
+func ValidateFreeScoutNoIntegrationBehavior(behavior string) error {
+	if behavior != "" && !slices.Contains(freeScoutNoIntegrationBehaviors, behavior) {
+		return fmt.Errorf("invalid FreeScout no integration behavior %q, must be one of %v", behavior, freeScoutNoIntegrationBehaviors)
+	}
+	return nil
+}
+
+// runWithoutIntegration handles a job for which no integration is enabled:
+// it is drained if a FreeScout integration is configured to, or else handled
+// as configured by NoIntegrationBehavior.
+func (f *FreeScout) runWithoutIntegration(ctx context.Context, args freeScoutArgs) error {
+	ac, err := f.Datastore.AppConfig(ctx)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "get app config")
+	}
+	if slices.ContainsFunc(ac.Integrations.Freescout, func(intg *fleet.FreeScoutIntegration) bool { return intg.Drain }) {
+		return f.drainJob(ctx, args)
+	}
+
+	switch f.NoIntegrationBehavior {
+	case FreeScoutNoIntegrationLog:
+		level.Info(f.logger(ctx)).Log("msg", "no freescout integration enabled, dropping job", "type", args.integrationType())
+		return nil
+	case FreeScoutNoIntegrationRequeue:
+		if args.NoIntegrationAttempts >= f.NoIntegrationRetries {
+			return ctxerr.Wrapf(ctx, errFreeScoutNoIntegration, "after %d attempts", args.NoIntegrationAttempts+1)
+		}
+		args.NoIntegrationAttempts++
+		level.Info(f.logger(ctx)).Log("msg", "no freescout integration enabled, deferring job", "type", args.integrationType(), "attempt", args.NoIntegrationAttempts, "delay", freeScoutNoIntegrationRetryDelay)
+		if _, err := QueueJobWithDelay(ctx, f.Datastore, freescoutName, args, freeScoutNoIntegrationRetryDelay); err != nil {
+			return ctxerr.Wrap(ctx, err, "queue FreeScout job without integration")
+		}
+		return nil
+	default:
+		// return success to mark the message as processed.
+		return nil
+	}
+}
+
+// drainJob discards the job without processing it, returning success to mark
+// it as processed. For a digest, the pending events are discarded too, so
+// that they are not reported once the integration is enabled again.
+func (f *FreeScout) drainJob(ctx context.Context, args freeScoutArgs) error {
+	var events int
+	if args.Digest != nil {
+		var teamID uint
+		if args.Digest.TeamID != nil {
+			teamID = *args.Digest.TeamID
+		}
+		for {
+			pending, err := f.Datastore.ListFreeScoutDigestEvents(ctx, teamID, freeScoutDigestMaxEvents)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "list drained digest events")
+			}
+			if len(pending) == 0 {
+				break
+			}
+			ids := make([]uint, 0, len(pending))
+			for _, e := range pending {
+				ids = append(ids, e.ID)
+			}
+			if err := f.Datastore.DeleteFreeScoutDigestEvents(ctx, ids); err != nil {
+				return ctxerr.Wrap(ctx, err, "delete drained digest events")
+			}
+			events += len(pending)
+			if len(pending) < freeScoutDigestMaxEvents {
+				break
+			}
+		}
+	}
+	drained := f.jobsDrained.Add(1)
+	level.Info(f.logger(ctx)).Log("msg", "freescout integration is draining, discarding job", "type", args.integrationType(), "digest_events_discarded", events, "jobs_drained", drained)
+	return nil
+}
+
+// DrainedJobs returns the number of jobs discarded without being processed
+// since the job processor started, see fleet.FreeScoutIntegration.Drain.
+func (f *FreeScout) DrainedJobs() int64 {
+	return f.jobsDrained.Load()
+}
+
+// freeScoutQuietHoursDelay returns the delay until the end of the quiet hours
+// of the integration if the vulnerability job is processed during them at
+// now, and 0 if it is not or if the vulnerability bypasses them.
+func freeScoutQuietHoursDelay(intg *fleet.FreeScoutIntegration, vargs *vulnArgs, now time.Time) (time.Duration, error) {
+	qh := intg.QuietHours
+	if !qh.Enabled() || qh.Bypass(vargs.CVSSScore, vargs.CISAKnownExploit) {
+		return 0, nil
+	}
+	end, ok, err := qh.WindowEnd(now)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return end.Sub(now), nil
+}
+
+// freeScoutCVEMetadataAttachment returns the JSON attachment of the metadata of
+// the vulnerability, as received by the job, for downstream automations.
+func freeScoutCVEMetadataAttachment(vargs *vulnArgs) (externalsvc.FreeScoutAttachment, error) {
+	content, err := json.Marshal(vargs)
+	if err != nil {
+		return externalsvc.FreeScoutAttachment{}, err
+	}
+	return externalsvc.FreeScoutAttachment{
+		FileName: vargs.CVE + ".json",
+		MimeType: "application/json",
+		Content:  content,
+	}, nil
+}
+
+// hasFreeScoutCVSSV3 returns true if the CVE has a CVSS score of version 3,
+// e.g. 3.0 or 3.1.
+func hasFreeScoutCVSSV3(vargs *vulnArgs) bool {
+	return vargs.CVSSScore != nil && vargs.CVSSVersion != nil && strings.HasPrefix(*vargs.CVSSVersion, "3")
+}
+
+// refreshCVEMeta sets the metadata of the CVE from the datastore when the job
+// was queued without any, e.g. by a version that did not include it in the
+// job payload. It is best effort: the job proceeds without metadata if it
+// cannot be loaded.
+func (f *FreeScout) refreshCVEMeta(ctx context.Context, vargs *vulnArgs) {
+	if vargs == nil || vargs.EPSSProbability != nil || vargs.CVSSScore != nil || vargs.CVSSVersion != nil ||
+		vargs.CISAKnownExploit != nil || vargs.CVEPublished != nil {
+		return
+	}
+
+	meta, err := f.Datastore.GetCVEMeta(ctx, vargs.CVE)
+	if err != nil {
+		if !fleet.IsNotFound(err) {
+			level.Info(f.logger(ctx)).Log("msg", "could not refresh cve metadata, proceeding without it", "cve", vargs.CVE, "err", err)
+		}
+		return
+	}
+	vargs.EPSSProbability = meta.EPSSProbability
+	vargs.CVSSScore = meta.CVSSScore
+	vargs.CVSSVersion = meta.CVSSVersion
+	vargs.CISAKnownExploit = meta.CISAKnownExploit
+	vargs.CVEPublished = meta.Published
+}
+
+func (f *FreeScout) runVuln(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, args freeScoutArgs) error {
+	vargs := args.Vulnerability
+	if vargs == nil {
+		return errors.New("invalid job args")
+	}
+	if intg != nil && intg.RequireCVSSV3 && !hasFreeScoutCVSSV3(vargs) {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, no cvss v3 score", "cve", vargs.CVE)
+		return nil
+	}
+	ctx = withFreeScoutSeverityAssignee(ctx, intg, vargs.CVSSScore)
+
+	cutoff, filterDetected := freeScoutDetectedCutoff(intg, vargs.CVEPublished, f.now())
+	affected, err := f.affectedHosts(ctx, intg, vargs, cutoff, filterDetected)
+	if err != nil {
+		return err
+	}
+	if intg != nil && intg.HostRiskWeights.Enabled() {
+		if affected.ids, err = f.sortHostsByRisk(ctx, intg.HostRiskWeights, affected.listed); err != nil {
+			return err
+		}
+	}
+	// the CVE is resolved only if it affects no host at all, not if the hosts
+	// it still affects are filtered out.
+	if affected.unfilteredCount == 0 && intg != nil && intg.VulnResolvedAction != "" {
+		return f.resolveVuln(ctx, cli, intg, args)
+	}
+	if filterDetected && affected.count == 0 {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, no host detected after cutoff", "cve", vargs.CVE, "cutoff", cutoff)
+		return nil
+	}
+	if freeScoutHostsScoped(intg) && affected.count == 0 {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, no affected host in the teams or labels of the integration", "cve", vargs.CVE)
+		return nil
+	}
+
+	reportKey := freeScoutVulnReportKey(vargs.CVE)
+	if inCooldown, err := f.inReportCooldown(ctx, reportKey, affected.ids, freeScoutReportCooldown(intg)); err != nil {
+		return err
+	} else if inCooldown {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping cve reported within cooldown with unchanged hosts", "cve", vargs.CVE)
+		return nil
+	}
+
+	hostLabels, err := f.hostLinkLabels(ctx, intg, affected.listedIDs())
+	if err != nil {
+		return err
+	}
+	hostPlatforms, err := f.hostPlatforms(ctx, intg, affected.listedIDs())
+	if err != nil {
+		return err
+	}
+
+	links, err := f.links(ctx, intg)
+	if err != nil {
+		return err
+	}
+
+	rargs := &FreeScoutVulnConversationArgs{
+		FleetURL:         f.linksFleetURL(intg),
+		HostPath:         links.host,
+		CVE:              vargs.CVE,
+		Hosts:            affected.listed,
+		HostsCount:       affected.count,
+		EPSSProbability:  vargs.EPSSProbability,
+		CVSSScore:        vargs.CVSSScore,
+		CVSSVersion:      vargs.CVSSVersion,
+		CISAKnownExploit: vargs.CISAKnownExploit,
+		CVEPublished:     vargs.CVEPublished,
+		Now:              f.now(),
+		HostLabels:       hostLabels,
+		CompactHostPaths: intg != nil && intg.CompactHostPaths,
+		HostsTable:       freeScoutHostsTable(intg),
+		HostPlatforms:    hostPlatforms,
+		SummaryHeader:    intg != nil && intg.VulnSummaryHeader,
+		SoftwareNames:    intg != nil && intg.VulnSoftwareNames,
+	}
+	if rargs.HostsDelta, err = f.reportedVulnHostsDelta(ctx, reportKey, affected); err != nil {
+		return err
+	}
+	if intg != nil {
+		rargs.EPSSLabelMode = intg.EPSSLabelMode
+		rargs.EPSSThresholds = intg.EPSSThresholds
+		rargs.EPSSRenderThreshold = intg.EPSSRenderThreshold
+		rargs.CVSSRenderThreshold = intg.CVSSRenderThreshold
+		rargs.HideSoftwareInstructions = intg.VulnHideSoftwareInstructions
+		rargs.TruncationNotice = intg.TruncationNotice
+		rargs.VulnHostsPath = links.vulnHosts
+		for _, ref := range intg.CVEReferences {
+			refURL, err := ref.URL(vargs.CVE)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "CVE reference")
+			}
+			rargs.References = append(rargs.References, FreeScoutReference{Label: ref.Label, URL: refURL})
+		}
+		if intg.VulnHostsTrend {
+			if rargs.HostsTrend, err = f.vulnHostsTrend(ctx, vargs.CVE); err != nil {
+				return err
+			}
+		}
+	}
+
+	var attachments []externalsvc.FreeScoutAttachment
+	if intg != nil && intg.AttachCVEMetadata {
+		attachment, err := freeScoutCVEMetadataAttachment(vargs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "CVE metadata attachment")
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	summaryTpl := freeScoutTemplates.VulnSummary
+	if intg != nil && intg.Templates != nil {
+		summaryTpl = f.overrideTemplate(ctx, intg.Templates.VulnSummary, summaryTpl)
+	}
+	conversationID, created, err := f.createTemplatedConversation(ctx, cli, intg, args, freeScoutVulnPriority(vargs.CISAKnownExploit), summaryTpl, freeScoutTemplates.VulnDescription, rargs.tplArgs(), attachments...)
+	if err != nil {
+		return err
+	}
+	f.setReportedVulnHosts(ctx, reportKey, affected)
+	if intg != nil && intg.VulnHostsTrend {
+		// the conversation was created, failing the job would report the CVE
+		// again.
+		if err := f.Datastore.SetFreeScoutVulnReportedHostsCount(ctx, vargs.CVE, affected.count); err != nil {
+			level.Error(f.logger(ctx)).Log("msg", "failed to record the reported hosts count of the cve", "cve", vargs.CVE, "err", err)
+		}
+	}
+
+	attrs := []interface{}{
+		"msg", "created freescout conversation for cve",
+		"cve", vargs.CVE,
+	}
+	if rargs.HostsDelta != nil {
+		attrs = append(attrs, "previously_reported_hosts", rargs.HostsDelta.Previous, "newly_affected_hosts", rargs.HostsDelta.New)
+	}
+	level.Debug(f.logger(ctx)).Log(append(attrs, f.conversationLogAttrs(conversationID, created)...)...)
+	return nil
+}
+
+// freeScoutVulnResolver is implemented by the clients that can update the
+// existing conversation of a CVE that no longer affects any host.
+type freeScoutVulnResolver interface {
+	FindFreeScoutConversation(ctx context.Context, subject string) (int64, error)
+	AppendFreeScoutThread(ctx context.Context, conversationID int64, message string) error
+	CloseConversation(ctx context.Context, conversationID, byUser int64) error
+}
+
+// resolveVuln appends a message stating that the CVE of the job is no longer
+// detected on any host to its existing conversation, found by its identifier
+// tag, and closes it if the integration's VulnResolvedAction is
+// fleet.FreeScoutVulnResolvedClose. The job is skipped if there is no such
+// conversation, or if it was already resolved since it was last reported,
+// whatever the report cooldown.
+func (f *FreeScout) resolveVuln(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, args freeScoutArgs) error {
+	cve := args.Vulnerability.CVE
+	resolver, ok := cli.(freeScoutVulnResolver)
+	tag := args.conversationTag()
+	if !ok || !intg.IdentifierTags || tag == "" {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, no host affected by the cve", "cve", cve)
+		return nil
+	}
+	reportKey := freeScoutVulnReportKey(cve)
+	if resolved, err := f.alreadyResolved(ctx, reportKey); err != nil {
+		return err
+	} else if resolved {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping cve already resolved", "cve", cve)
+		return nil
+	}
+
+	ctx = externalsvc.WithFreeScoutConversationTag(ctx, tag)
+	conversationID, err := resolver.FindFreeScoutConversation(ctx, "")
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "find conversation of resolved cve")
+	}
+	if conversationID == 0 {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, no host affected by the cve and no conversation to resolve", "cve", cve)
+		return nil
+	}
+
+	if err := f.waitMailboxRateLimit(ctx, intg); err != nil {
+		return ctxerr.Wrap(ctx, err, "wait for mailbox rate limit")
+	}
+	tr := freeScoutTranslator(intg.Locale)
+	message := fmt.Sprintf(tr("No longer detected on any host as of %s."), f.now().UTC().Format("2006-01-02 15:04 MST"))
+	if err := resolver.AppendFreeScoutThread(ctx, conversationID, message); err != nil {
+		return ctxerr.Wrapf(ctx, err, "append resolved message to conversation %d", conversationID)
+	}
+	f.threadsAppended.Add(1)
+	f.setReportedHosts(ctx, reportKey, nil)
+
+	closed := false
+	if intg.VulnResolvedAction == fleet.FreeScoutVulnResolvedClose {
+		byUser := intg.NoteUserID
+		if byUser <= 0 {
+			byUser = intg.AssignTo
+		}
+		// the message was appended, failing the job would append it again.
+		if err := resolver.CloseConversation(ctx, conversationID, byUser); err != nil {
+			level.Error(f.logger(ctx)).Log("msg", "failed to close the conversation of the resolved cve", "cve", cve, "conversation_id", conversationID, "err", err)
+		} else {
+			closed = true
+		}
+	}
+	level.Debug(f.logger(ctx)).Log("msg", "resolved freescout conversation for cve", "cve", cve, "conversation_id", conversationID, "closed", closed)
+	return nil
+}
+
+// alreadyResolved returns true if the last report under key was made without
+// any host, i.e. its resolution, so that it is not resolved again until it is
+// reported with hosts.
+func (f *FreeScout) alreadyResolved(ctx context.Context, key string) (bool, error) {
+	report, err := f.Datastore.GetFreeScoutReport(ctx, key)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return false, nil
+		}
+		return false, ctxerr.Wrap(ctx, err, "get last report")
+	}
+	return report.HostsFingerprint == freeScoutHostsFingerprint(nil), nil
+}
+
+// vulnHostsTrend returns the number of affected hosts of the CVE in its last
+// report, or nil if it was never reported.
+func (f *FreeScout) vulnHostsTrend(ctx context.Context, cve string) (*FreeScoutHostsTrend, error) {
+	count, err := f.Datastore.GetFreeScoutVulnReportedHostsCount(ctx, cve)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "get reported hosts count of cve")
+	}
+	return &FreeScoutHostsTrend{Previous: count}, nil
+}
+
+// freeScoutVulnPriority returns the priority of the conversation of a CVE,
+// high if it is in CISA's known exploited vulnerabilities catalog.
+func freeScoutVulnPriority(cisaKnownExploit *bool) string {
+	if cisaKnownExploit != nil && *cisaKnownExploit {
+		return fleet.FreeScoutPriorityHigh
+	}
+	return fleet.FreeScoutPriorityNormal
+}
+
+// annotateFreeScoutConversation tags the newly created conversation with its
+// identifier tag, if any, with the tag mapped to the priority by the
+// integration, if any, and with the organization name if it is configured to
+// go in a tag or a custom field, and sets the rendered custom fields. The tags
+// are set in a single request as it replaces the existing ones. It is a no-op
+// for the annotations that the client does not support.
+func annotateFreeScoutConversation(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, conversationID int64, priority, orgName, identifierTag string, customFields map[int64]string) error {
+	if intg == nil || conversationID == 0 {
+		return nil
+	}
+
+	var tags []string
+	if identifierTag != "" {
+		tags = append(tags, identifierTag)
+	}
+	if tag := intg.PriorityTags[priority]; tag != "" {
+		tags = append(tags, tag)
+	}
+	if orgName != "" && intg.OrgNameLocation == fleet.FreeScoutOrgNameInTag {
+		tags = append(tags, orgName)
+	}
+	if tagger, ok := cli.(interface {
+		TagConversation(ctx context.Context, conversationID int64, tags []string) error
+	}); ok && len(tags) > 0 {
+		if err := tagger.TagConversation(ctx, conversationID, tags); err != nil {
+			return ctxerr.Wrapf(ctx, err, "tag conversation %d", conversationID)
+		}
+	}
+
+	setter, ok := cli.(interface {
+		SetConversationCustomField(ctx context.Context, conversationID, fieldID int64, value string) error
+	})
+	if !ok {
+		return nil
+	}
+	if orgName != "" && intg.OrgNameLocation == fleet.FreeScoutOrgNameInCustomField {
+		if err := setter.SetConversationCustomField(ctx, conversationID, intg.OrgNameCustomFieldID, orgName); err != nil {
+			return ctxerr.Wrapf(ctx, err, "set org name custom field of conversation %d", conversationID)
+		}
+	}
+	fieldIDs := make([]int64, 0, len(customFields))
+	for fieldID := range customFields {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	slices.Sort(fieldIDs)
+	for _, fieldID := range fieldIDs {
+		if err := setter.SetConversationCustomField(ctx, conversationID, fieldID, customFields[fieldID]); err != nil {
+			return ctxerr.Wrapf(ctx, err, "set custom field %d of conversation %d", fieldID, conversationID)
+		}
+	}
+	return nil
+}
+
+// orgName returns the organization name with which the conversations are
+// annotated, or an empty string if the integration does not annotate them.
+func (f *FreeScout) orgName(ctx context.Context, intg *fleet.FreeScoutIntegration) (string, error) {
+	if intg == nil || intg.OrgNameLocation == "" {
+		return "", nil
+	}
+	ac, err := f.Datastore.AppConfig(ctx)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "get app config for org name")
+	}
+	return strings.TrimSpace(ac.OrgInfo.OrgName), nil
+}
+
+// freeScoutHostsPageSize is the number of hosts fetched per query when the
+// datastore supports listing the affected hosts page by page.
+const freeScoutHostsPageSize = 1000
+
+// freeScoutAffectedHosts are the hosts affected by a CVE that are reported in
+// its conversation.
+type freeScoutAffectedHosts struct {
+	// listed are the hosts to list in the conversation, or all the hosts if
+	// ids is set and the integration sorts them by risk, in the order of ids.
+	listed []fleet.HostVulnerabilitySummary
+	// ids are the IDs of all the hosts, nil if only the listed hosts were
+	// loaded.
+	ids []uint
+	// count is the number of hosts.
+	count int
+	// unfilteredCount is the number of hosts affected by the CVE before they
+	// are restricted to those on which it was detected after the cutoff and
+	// to those in the teams or labels of the integration.
+	unfilteredCount int
+	// pager is set if only the listed hosts were loaded, the hosts are then
+	// counted and recorded by the affected softwareIDs.
+	pager       fleet.HostVulnSummariesPager
+	softwareIDs []uint
+}
+
+// listedIDs returns the IDs of the hosts listed in the conversation.
+func (a *freeScoutAffectedHosts) listedIDs() []uint {
+	if a.ids != nil {
+		return freeScoutListedHostIDs(a.ids)
+	}
+	listed := a.listed[:min(len(a.listed), freeScoutMaxHostsInDescription)]
+	ids := make([]uint, 0, len(listed))
+	for _, h := range listed {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+// affectedHosts returns the hosts affected by the vulnerability. If
+// filterDetected is true, only hosts on which the CVE was detected after
+// cutoff are returned, and only the hosts in the teams or labels of the
+// integration, if any.
+//
+// When the datastore implements fleet.HostVulnSummariesPager, only the listed
+// hosts are loaded and the others are counted if the integration needs
+// neither to filter, to sort by risk, nor to compare them with those of the
+// last report for the cooldown. Otherwise, the hosts are fetched page by page
+// and only the summaries of the listed hosts are kept in memory, along with
+// the IDs of all hosts. Without a pager, all summaries are loaded and
+// returned.
+//
+// If no host has the affected software of the job, e.g. because the software
+// changed since the job was queued, the hosts are searched by CVE instead.
+func (f *FreeScout) affectedHosts(ctx context.Context, intg *fleet.FreeScoutIntegration, vargs *vulnArgs, cutoff time.Time, filterDetected bool) (*freeScoutAffectedHosts, error) {
+	if len(vargs.AffectedSoftwareIDs) == 0 {
+		// Default to deprecated method in case we are processing an 'old' job payload
+		// we are deprecating this because of performance reasons - querying by software_id should be
+		// way more efficient than by CVE.
+		return f.affectedHostsByCVE(ctx, intg, vargs, cutoff, filterDetected)
+	}
+
+	pager, ok := f.Datastore.(fleet.HostVulnSummariesPager)
+	if !ok {
+		hosts, err := f.Datastore.HostVulnSummariesBySoftwareIDs(ctx, vargs.AffectedSoftwareIDs)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "fetching hosts")
+		}
+		if len(hosts) == 0 {
+			f.warnNoAffectedSoftwareHosts(ctx, vargs)
+			return f.affectedHostsByCVE(ctx, intg, vargs, cutoff, filterDetected)
+		}
+		return f.filterAffectedHosts(ctx, intg, vargs, hosts, cutoff, filterDetected)
+	}
+
+	// all the hosts are needed to list the riskiest ones, otherwise only the
+	// listed ones are kept.
+	keepAll := intg != nil && intg.HostRiskWeights.Enabled()
+	if !keepAll && !filterDetected && !freeScoutHostsScoped(intg) && freeScoutReportCooldown(intg) <= 0 {
+		return f.countAffectedHosts(ctx, intg, pager, vargs)
+	}
+
+	affected := &freeScoutAffectedHosts{}
+	var afterID uint
+	var fetched int
+	for {
+		page, err := pager.HostVulnSummariesBySoftwareIDsPage(ctx, vargs.AffectedSoftwareIDs, afterID, freeScoutHostsPageSize)
+		if err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "fetching page of hosts")
+		}
+		if len(page) == 0 {
+			break
+		}
+		fetched += len(page)
+		afterID = page[len(page)-1].ID
+		full := len(page) == freeScoutHostsPageSize
+
+		if filterDetected {
+			if page, err = f.hostsDetectedAfter(ctx, vargs.CVE, page, cutoff); err != nil {
+				return nil, err
+			}
+		}
+		if page, err = f.hostsInScope(ctx, intg, page); err != nil {
+			return nil, err
+		}
+		for _, h := range page {
+			affected.ids = append(affected.ids, h.ID)
+			if keepAll || len(affected.listed) < freeScoutMaxHostsInDescription {
+				affected.listed = append(affected.listed, h)
+			}
+		}
+		if !full {
+			break
+		}
+	}
+	if fetched == 0 {
+		f.warnNoAffectedSoftwareHosts(ctx, vargs)
+		return f.affectedHostsByCVE(ctx, intg, vargs, cutoff, filterDetected)
+	}
+	affected.count = len(affected.ids)
+	affected.unfilteredCount = fetched
+	return affected, nil
+}
+
+// countAffectedHosts returns the hosts affected by the vulnerability, only
+// the listed ones being loaded, see affectedHosts.
+func (f *FreeScout) countAffectedHosts(ctx context.Context, intg *fleet.FreeScoutIntegration, pager fleet.HostVulnSummariesPager, vargs *vulnArgs) (*freeScoutAffectedHosts, error) {
+	count, err := pager.CountHostVulnSummariesBySoftwareIDs(ctx, vargs.AffectedSoftwareIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "counting hosts")
+	}
+	if count == 0 {
+		f.warnNoAffectedSoftwareHosts(ctx, vargs)
+		return f.affectedHostsByCVE(ctx, intg, vargs, time.Time{}, false)
+	}
+	listed, err := pager.HostVulnSummariesBySoftwareIDsPage(ctx, vargs.AffectedSoftwareIDs, 0, freeScoutMaxHostsInDescription)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "fetching listed hosts")
+	}
+	return &freeScoutAffectedHosts{
+		listed:          listed,
+		count:           count,
+		unfilteredCount: count,
+		pager:           pager,
+		softwareIDs:     vargs.AffectedSoftwareIDs,
+	}, nil
+}
+
+// affectedHostsByCVE returns the hosts affected by the vulnerability as found
+// by CVE, filtered as described in affectedHosts.
+func (f *FreeScout) affectedHostsByCVE(ctx context.Context, intg *fleet.FreeScoutIntegration, vargs *vulnArgs, cutoff time.Time, filterDetected bool) (*freeScoutAffectedHosts, error) {
+	hosts, err := f.Datastore.HostsByCVE(ctx, vargs.CVE)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "fetching hosts")
+	}
+	return f.filterAffectedHosts(ctx, intg, vargs, hosts, cutoff, filterDetected)
+}
+
+// filterAffectedHosts returns the hosts on which the CVE was detected after
+// cutoff if filterDetected is true, otherwise all hosts, restricted to the
+// teams or labels of the integration.
+func (f *FreeScout) filterAffectedHosts(ctx context.Context, intg *fleet.FreeScoutIntegration, vargs *vulnArgs, hosts []fleet.HostVulnerabilitySummary, cutoff time.Time, filterDetected bool) (*freeScoutAffectedHosts, error) {
+	unfilteredCount := len(hosts)
+	var err error
+	if filterDetected {
+		if hosts, err = f.hostsDetectedAfter(ctx, vargs.CVE, hosts, cutoff); err != nil {
+			return nil, err
+		}
+	}
+	if hosts, err = f.hostsInScope(ctx, intg, hosts); err != nil {
+		return nil, err
+	}
+
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
+	}
+	return &freeScoutAffectedHosts{listed: hosts, ids: hostIDs, count: len(hostIDs), unfilteredCount: unfilteredCount}, nil
+}
+
+// sortHostsByRisk sorts the hosts affected by a CVE by decreasing risk score
+// with the weights, the hosts of equal scores keeping their order, and
+// returns their IDs in that order.
+func (f *FreeScout) sortHostsByRisk(ctx context.Context, weights fleet.FreeScoutHostRiskWeights, hosts []fleet.HostVulnerabilitySummary) ([]uint, error) {
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
+	}
+
+	var issues map[uint]fleet.HostIssues
+	if weights.CriticalVulnerabilities != 0 || weights.FailingPolicies != 0 {
+		var err error
+		if issues, err = f.Datastore.HostIssuesByHostIDs(ctx, hostIDs); err != nil {
+			return nil, ctxerr.Wrap(ctx, err, "fetching host issues for risk scores")
+		}
+	}
+	scores := make(map[uint]float64, len(hosts))
+	for _, h := range hosts {
+		scores[h.ID] = freeScoutHostRiskScore(weights, h, issues[h.ID])
+	}
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return scores[hosts[i].ID] > scores[hosts[j].ID]
+	})
+
+	for i, h := range hosts {
+		hostIDs[i] = h.ID
+	}
+	return hostIDs, nil
+}
+
+// freeScoutHostRiskScore returns the risk score of a host affected by a CVE,
+// see fleet.FreeScoutHostRiskWeights.
+func freeScoutHostRiskScore(weights fleet.FreeScoutHostRiskWeights, host fleet.HostVulnerabilitySummary, issues fleet.HostIssues) float64 {
+	var criticalVulns uint64
+	if issues.CriticalVulnerabilitiesCount != nil {
+		criticalVulns = *issues.CriticalVulnerabilitiesCount
+	}
+	installs := max(len(host.Software), len(host.SoftwareInstalledPaths), 1)
+	return weights.CriticalVulnerabilities*float64(criticalVulns) +
+		weights.FailingPolicies*float64(issues.FailingPoliciesCount) +
+		weights.VulnerableSoftware*float64(installs)
+}
+
+// warnNoAffectedSoftwareHosts logs the discrepancy when no host has the
+// affected software of the job, before its hosts are searched by CVE instead.
+func (f *FreeScout) warnNoAffectedSoftwareHosts(ctx context.Context, vargs *vulnArgs) {
+	level.Warn(f.logger(ctx)).Log(
+		"msg", "no host found with the affected software of the cve, falling back to the hosts found by cve",
+		"cve", vargs.CVE,
+		"affected_software_ids", fmt.Sprint(vargs.AffectedSoftwareIDs),
+	)
+}
+
+// freeScoutDetectedCutoff returns the time after which the CVE must have been
+// detected on a host for that host to be reported, as configured by the
+// integration, and false if the hosts must not be filtered.
+func freeScoutDetectedCutoff(intg *fleet.FreeScoutIntegration, published *time.Time, now time.Time) (time.Time, bool) {
+	if intg == nil {
+		return time.Time{}, false
+	}
+	var cutoff time.Time
+	if intg.DetectedLookback.Duration > 0 {
+		cutoff = now.Add(-intg.DetectedLookback.Duration)
+	}
+	if intg.DetectedAfterPublished && published != nil && published.After(cutoff) {
+		cutoff = *published
+	}
+	return cutoff, !cutoff.IsZero()
+}
+
+// hostsDetectedAfter returns the hosts on which the CVE was detected after
+// cutoff.
+func (f *FreeScout) hostsDetectedAfter(ctx context.Context, cve string, hosts []fleet.HostVulnerabilitySummary, cutoff time.Time) ([]fleet.HostVulnerabilitySummary, error) {
+	if len(hosts) == 0 {
+		return hosts, nil
+	}
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
+	}
+	detectedAt, err := f.Datastore.CVEDetectedAtByHostIDs(ctx, cve, hostIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "fetching cve detected at")
+	}
+
+	filtered := make([]fleet.HostVulnerabilitySummary, 0, len(hosts))
+	for _, h := range hosts {
+		if at, ok := detectedAt[h.ID]; ok && at.After(cutoff) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered, nil
+}
+
+// withFreeScoutPolicyAssignee returns the context assigning the conversation
+// of failing policies to the assignee of their criticality configured by the
+// integration, or ctx if it has none.
+func withFreeScoutPolicyAssignee(ctx context.Context, intg *fleet.FreeScoutIntegration, critical bool) context.Context {
+	if intg == nil || intg.PolicyAssignees == nil {
+		return ctx
+	}
+	return externalsvc.WithFreeScoutAssignTo(ctx, intg.PolicyAssignees.AssignTo(critical))
+}
+
+// withFreeScoutSeverityAssignee returns the context assigning the
+// conversation of a vulnerability to the assignee of its CVSS severity band
+// configured by the integration, or ctx if that band is not mapped.
+func withFreeScoutSeverityAssignee(ctx context.Context, intg *fleet.FreeScoutIntegration, cvssScore *float64) context.Context {
+	if intg == nil {
+		return ctx
+	}
+	userID, ok := intg.SeverityAssignees[webhooks.CVSSSeverity(cvssScore)]
+	if !ok {
+		return ctx
+	}
+	return externalsvc.WithFreeScoutAssignTo(ctx, userID)
+}
+
+// freeScoutHostsScoped returns true if the integration restricts the hosts
+// reported in vulnerability conversations to some teams or labels.
+func freeScoutHostsScoped(intg *fleet.FreeScoutIntegration) bool {
+	return intg != nil && (len(intg.VulnHostTeamIDs) > 0 || len(intg.VulnHostLabelIDs) > 0)
+}
+
+// hostsInScope returns the hosts in the teams or labels of the integration,
+// or all hosts if it has none.
+func (f *FreeScout) hostsInScope(ctx context.Context, intg *fleet.FreeScoutIntegration, hosts []fleet.HostVulnerabilitySummary) ([]fleet.HostVulnerabilitySummary, error) {
+	if !freeScoutHostsScoped(intg) || len(hosts) == 0 {
+		return hosts, nil
+	}
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
+	}
+	inScope, err := f.Datastore.FilterHostIDsByTeamsOrLabels(ctx, hostIDs, intg.VulnHostTeamIDs, intg.VulnHostLabelIDs)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "filtering hosts by teams or labels")
+	}
+	keep := make(map[uint]struct{}, len(inScope))
+	for _, id := range inScope {
+		keep[id] = struct{}{}
+	}
+
+	filtered := make([]fleet.HostVulnerabilitySummary, 0, len(inScope))
+	for _, h := range hosts {
+		if _, ok := keep[h.ID]; ok {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered, nil
+}
+
+// freeScoutReportCooldown returns the integration's report cooldown, 0 if it
+// has none.
+func freeScoutReportCooldown(intg *fleet.FreeScoutIntegration) time.Duration {
+	if intg == nil {
+		return 0
+	}
+	return intg.ReportCooldown.Duration
+}
+
+// links returns the links rendering the paths configured by the integration,
+// the built-in paths being used for those it does not configure.
+func (f *FreeScout) links(ctx context.Context, intg *fleet.FreeScoutIntegration) (freeScoutLinks, error) {
+	var links freeScoutLinks
+	if intg == nil {
+		return links, nil
+	}
+	if intg.HostPathTemplate != "" {
+		tpl, err := f.parseTemplate(intg.HostPathTemplate)
+		if err != nil {
+			return links, ctxerr.Wrap(ctx, err, "parse host path template")
+		}
+		links.host = tpl
+	}
+	if intg.PolicyHostsPathTemplate != "" {
+		tpl, err := f.parseTemplate(intg.PolicyHostsPathTemplate)
+		if err != nil {
+			return links, ctxerr.Wrap(ctx, err, "parse policy hosts path template")
+		}
+		links.policyHosts = tpl
+	}
+	if intg.VulnHostsPathTemplate != "" {
+		tpl, err := f.parseTemplate(intg.VulnHostsPathTemplate)
+		if err != nil {
+			return links, ctxerr.Wrap(ctx, err, "parse vuln hosts path template")
+		}
+		links.vulnHosts = tpl
+	}
+	return links, nil
+}
+
+// linksFleetURL returns the Fleet URL used for the links in the conversations,
+// which is the integration's ExternalFleetURL if set, or FleetURL otherwise.
+func (f *FreeScout) linksFleetURL(intg *fleet.FreeScoutIntegration) string {
+	if intg != nil && intg.ExternalFleetURL != "" {
+		return strings.TrimRight(intg.ExternalFleetURL, "/")
+	}
+	return f.FleetURL
+}
+
+// freeScoutVulnReportKey returns the key of the reports of a CVE.
+func freeScoutVulnReportKey(cve string) string {
+	return intgTypeVuln + ":" + cve
+}
+
+// freeScoutPolicyReportKey returns the key of the reports of a failing
+// policy, which are tracked separately for each team.
+func freeScoutPolicyReportKey(teamID *uint, policyID uint) string {
+	var team string
+	if teamID != nil {
+		team = fmt.Sprint(*teamID)
+	}
+	return fmt.Sprintf("%s:%s:%d", intgTypeFailingPolicy, team, policyID)
+}
+
+// reportedHostsDelta returns the split of hostIDs between the hosts of the
+// last report under key and the newly affected ones, or nil if nothing was
+// ever reported under that key.
+func (f *FreeScout) reportedHostsDelta(ctx context.Context, key string, hostIDs []uint) (*FreeScoutHostsDelta, error) {
+	previous, err := f.Datastore.CountFreeScoutReportedHosts(ctx, key, uniqueFreeScoutHostIDs(hostIDs))
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "count reported hosts")
+	}
+	return &FreeScoutHostsDelta{Previous: previous, New: len(hostIDs) - previous}, nil
+}
+
+// reportedVulnHostsDelta is like reportedHostsDelta for the hosts affected by
+// a CVE, counted by their affected software if only the listed hosts were
+// loaded.
+func (f *FreeScout) reportedVulnHostsDelta(ctx context.Context, key string, affected *freeScoutAffectedHosts) (*FreeScoutHostsDelta, error) {
+	if affected.pager == nil {
+		return f.reportedHostsDelta(ctx, key, affected.ids)
+	}
+	previous, err := affected.pager.CountFreeScoutReportedHostsBySoftwareIDs(ctx, key, affected.softwareIDs)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, ctxerr.Wrap(ctx, err, "count reported hosts by software")
+	}
+	return &FreeScoutHostsDelta{Previous: previous, New: affected.count - previous}, nil
+}
+
+// setReportedVulnHosts is like setReportedHosts for the hosts affected by a
+// CVE, recorded by their affected software if only the listed hosts were
+// loaded. The report then has no hosts fingerprint, which is only needed by
+// the cooldown.
+func (f *FreeScout) setReportedVulnHosts(ctx context.Context, key string, affected *freeScoutAffectedHosts) {
+	if affected.pager == nil {
+		f.setReportedHosts(ctx, key, affected.ids)
+		return
+	}
+	report := &fleet.FreeScoutReport{Key: key, ReportedAt: f.now()}
+	// the conversation was updated, failing the job would report it again.
+	if err := affected.pager.SetFreeScoutReportBySoftwareIDs(ctx, report, affected.softwareIDs); err != nil {
+		level.Error(f.logger(ctx)).Log("msg", "failed to record the report", "report_key", key, "err", err)
+	}
+}
+
+// inReportCooldown returns true if the same set of hostIDs was reported under
+// key less than cooldown ago.
+func (f *FreeScout) inReportCooldown(ctx context.Context, key string, hostIDs []uint, cooldown time.Duration) (bool, error) {
+	if cooldown <= 0 {
+		return false, nil
+	}
+	report, err := f.Datastore.GetFreeScoutReport(ctx, key)
+	if err != nil {
+		if fleet.IsNotFound(err) {
+			return false, nil
+		}
+		return false, ctxerr.Wrap(ctx, err, "get last report")
+	}
+	return f.now().Sub(report.ReportedAt) < cooldown && report.HostsFingerprint == freeScoutHostsFingerprint(hostIDs), nil
+}
+
+// setReportedHosts persists hostIDs as the hosts of the last report under
+// key, made now, for the cooldown and the delta of the next report.
+func (f *FreeScout) setReportedHosts(ctx context.Context, key string, hostIDs []uint) {
+	hostIDs = uniqueFreeScoutHostIDs(hostIDs)
+	report := &fleet.FreeScoutReport{
+		Key:              key,
+		ReportedAt:       f.now(),
+		HostsFingerprint: freeScoutHostsFingerprint(hostIDs),
+	}
+	// the conversation was updated, failing the job would report it again.
+	if err := f.Datastore.SetFreeScoutReport(ctx, report, hostIDs); err != nil {
+		level.Error(f.logger(ctx)).Log("msg", "failed to record the report", "report_key", key, "err", err)
+	}
+}
+
+// freeScoutHostsFingerprint returns the hex-encoded SHA-256 of the sorted
+// unique hostIDs, which identifies the set of hosts whatever their order.
+func freeScoutHostsFingerprint(hostIDs []uint) string {
+	ids := uniqueFreeScoutHostIDs(hostIDs)
+	slices.Sort(ids)
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%d,", id)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (f *FreeScout) runFailingPolicy(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, args freeScoutArgs) error {
+	ctx = withFreeScoutPolicyAssignee(ctx, intg, args.FailingPolicy.PolicyCritical)
+	if err := f.loadPolicyHosts(ctx, args.FailingPolicy); err != nil {
+		return err
+	}
+	if len(args.FailingPolicy.Hosts) == 0 {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping, none of the failing hosts exists", "policy_id", args.FailingPolicy.PolicyID)
+		return nil
+	}
+	hostIDs := policyHostIDs(args.FailingPolicy.Hosts)
+	reportKey := freeScoutPolicyReportKey(args.FailingPolicy.TeamID, args.FailingPolicy.PolicyID)
+	if inCooldown, err := f.inReportCooldown(ctx, reportKey, hostIDs, freeScoutReportCooldown(intg)); err != nil {
+		return err
+	} else if inCooldown {
+		level.Debug(f.logger(ctx)).Log("msg", "skipping failing policy reported within cooldown with unchanged hosts", "policy_id", args.FailingPolicy.PolicyID)
+		return nil
+	}
+
+	hostLabels, err := f.hostLinkLabels(ctx, intg, freeScoutListedHostIDs(hostIDs))
+	if err != nil {
+		return err
+	}
+	hostPlatforms, err := f.hostPlatforms(ctx, intg, freeScoutListedHostIDs(hostIDs))
+	if err != nil {
+		return err
+	}
+	teamName, err := f.summaryTeamName(ctx, intg, args.FailingPolicy.TeamID)
+	if err != nil {
+		return err
+	}
+	links, err := f.links(ctx, intg)
+	if err != nil {
+		return err
+	}
+	rargs := &FreeScoutFailingPolicyConversationArgs{
+		FleetURL:        f.linksFleetURL(intg),
+		HostPath:        links.host,
+		PolicyHostsPath: links.policyHosts,
+		PolicyID:        args.FailingPolicy.PolicyID,
+		PolicyName:      args.FailingPolicy.PolicyName,
+		PolicyCritical:  args.FailingPolicy.PolicyCritical,
+		PolicyPlatform:  args.FailingPolicy.PolicyPlatform,
+		TeamID:          args.FailingPolicy.TeamID,
+		TeamName:        teamName,
+		Hosts:           args.FailingPolicy.Hosts,
+		HostLabels:      hostLabels,
+		HostsTable:      freeScoutHostsTable(intg),
+		HostPlatforms:   hostPlatforms,
+
+		TruncationNotice: intg != nil && intg.TruncationNotice,
+	}
+
+	summaryTpl, descTpl := f.failingPolicyTemplates(ctx, intg)
+	conversationID, created, err := f.createTemplatedConversation(ctx, cli, intg, args, "", summaryTpl, descTpl, rargs.tplArgs())
+	if err != nil {
+		return err
+	}
+	f.setReportedHosts(ctx, reportKey, hostIDs)
+
+	attrs := []interface{}{
+		"msg", "created freescout conversation for failing policy",
+		"policy_id", args.FailingPolicy.PolicyID,
+		"policy_name", args.FailingPolicy.PolicyName,
+	}
+	attrs = append(attrs, f.conversationLogAttrs(conversationID, created)...)
+	if args.FailingPolicy.TeamID != nil {
+		attrs = append(attrs, "team_id", *args.FailingPolicy.TeamID)
+	}
+	level.Debug(f.logger(ctx)).Log(attrs...)
+	return nil
+}
+
+// loadPolicyHosts sets the hosts of the failing policy args queued with only
+// their IDs, see QueueFreeScoutFailingPolicyJob, in the order of the IDs. The
+// hosts deleted since the job was queued are omitted. The args that have
+// their hosts, e.g. queued before the IDs were supported, are left unchanged.
+func (f *FreeScout) loadPolicyHosts(ctx context.Context, args *failingPolicyArgs) error {
+	if len(args.Hosts) > 0 || len(args.HostIDs) == 0 {
+		return nil
+	}
+	hosts, err := f.Datastore.ListHostsLiteByIDs(ctx, args.HostIDs)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list failing policy hosts")
+	}
+	byID := make(map[uint]*fleet.Host, len(hosts))
+	for _, h := range hosts {
+		byID[h.ID] = h
+	}
+	args.Hosts = make([]fleet.PolicySetHost, 0, len(args.HostIDs))
+	for _, id := range args.HostIDs {
+		if h, ok := byID[id]; ok {
+			args.Hosts = append(args.Hosts, fleet.PolicySetHost{ID: h.ID, Hostname: h.Hostname, DisplayName: h.DisplayName()})
+		}
+	}
+	return nil
+}
+
+// failingPolicyTemplates returns the summary and description templates of the
+// integration's failing policy conversations. The integration's overrides,
+// which for a team are layered over those of the global integration, take
+// precedence over the built-in templates.
+func (f *FreeScout) failingPolicyTemplates(ctx context.Context, intg *fleet.FreeScoutIntegration) (summary, description *template.Template) {
+	summary, description = freeScoutTemplates.FailingPolicySummary, freeScoutTemplates.FailingPolicyDescription
+	if intg == nil || intg.Templates == nil {
+		return summary, description
+	}
+	summary = f.overrideTemplate(ctx, intg.Templates.FailingPolicySummary, summary)
+	description = f.overrideTemplate(ctx, intg.Templates.FailingPolicyDescription, description)
+	return summary, description
+}
+
+// overrideTemplate returns the template parsed from text, or builtin if text
+// is empty or invalid.
+func (f *FreeScout) overrideTemplate(ctx context.Context, text string, builtin *template.Template) *template.Template {
+	if text == "" {
+		return builtin
+	}
+
+	tpl, err := f.parseTemplate(text)
+	if err != nil {
+		// the syntax is validated when the integration is saved, but not the
+		// functions used.
+		level.Error(f.logger(ctx)).Log("msg", "invalid freescout template override, using the built-in template", "err", err)
+		return builtin
+	}
+	return tpl
+}
+
+// parseTemplate parses the template configured by the integration, with the
+// same functions as the built-in templates. The parsed templates are cached by
+// text, so that the templates shared by teams are parsed once.
+func (f *FreeScout) parseTemplate(text string) (*template.Template, error) {
+	f.templatesMu.Lock()
+	defer f.templatesMu.Unlock()
+	if tpl := f.templates[text]; tpl != nil {
+		return tpl, nil
+	}
+	tpl, err := template.New("").Funcs(freeScoutTplFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if f.templates == nil {
+		f.templates = make(map[string]*template.Template)
+	}
+	f.templates[text] = tpl
+	return tpl, nil
+}
+
+// renderCustomFields renders the custom fields of the integration with the
+// args of the conversation's templates. The fields rendered empty are omitted.
+func (f *FreeScout) renderCustomFields(ctx context.Context, intg *fleet.FreeScoutIntegration, args interface{}) (map[int64]string, error) {
+	if intg == nil || len(intg.CustomFields) == 0 {
+		return nil, nil
+	}
+	fields := make(map[int64]string, len(intg.CustomFields))
+	for fieldID, text := range intg.CustomFields {
+		tpl, err := f.parseTemplate(text)
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "parse custom field %d template", fieldID)
+		}
+		value, err := renderFreeScoutTemplate(tpl, args)
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "render custom field %d", fieldID)
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			fields[fieldID] = value
+		}
+	}
+	return fields, nil
+}
+
+// summaryTeamName returns the team name to include in the summary of a
+// failing policy conversation, "Global" for a global policy. It returns an
+// empty string if the integration does not include it, i.e. if it neither
+// prefixes the built-in summary with it nor overrides the summary template.
+func (f *FreeScout) summaryTeamName(ctx context.Context, intg *fleet.FreeScoutIntegration, teamID *uint) (string, error) {
+	if intg == nil || (!intg.TeamNameInSummary && (intg.Templates == nil || intg.Templates.FailingPolicySummary == "")) {
+		return "", nil
+	}
+	if teamID == nil {
+		return "Global", nil
+	}
+	tm, err := f.Datastore.TeamLite(ctx, *teamID)
+	if err != nil {
+		return "", ctxerr.Wrap(ctx, err, "get policy team")
+	}
+	return tm.Name, nil
+}
+
+func (f *FreeScout) runFailingPolicies(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, args freeScoutArgs) error {
+	// only report the policies that are not in their cooldown.
+	cooldown := freeScoutReportCooldown(intg)
+	batch := &freeScoutFailingPoliciesArgs{TeamID: args.FailingPolicies.TeamID}
+	for _, p := range args.FailingPolicies.Policies {
+		inCooldown, err := f.inReportCooldown(ctx, freeScoutPolicyReportKey(batch.TeamID, p.PolicyID), policyHostIDs(p.Hosts), cooldown)
+		if err != nil {
+			return err
+		}
+		if inCooldown {
+			level.Debug(f.logger(ctx)).Log("msg", "skipping failing policy reported within cooldown with unchanged hosts", "policy_id", p.PolicyID)
+			continue
+		}
+		batch.Policies = append(batch.Policies, p)
+	}
+	if len(batch.Policies) == 0 {
+		return nil
+	}
+	args.FailingPolicies = batch
+	critical := slices.ContainsFunc(batch.Policies, func(p failingPolicyArgs) bool { return p.PolicyCritical })
+	ctx = withFreeScoutPolicyAssignee(ctx, intg, critical)
+
+	tplArgs := newFreeScoutFailingPoliciesTplArgs(f.linksFleetURL(intg), args.FailingPolicies)
+
+	var hostIDs []uint
+	for _, p := range args.FailingPolicies.Policies {
+		hostIDs = append(hostIDs, policySetHostIDs(p.Hosts)...)
+	}
+	hostLabels, err := f.hostLinkLabels(ctx, intg, hostIDs)
+	if err != nil {
+		return err
+	}
+	tplArgs.HostLabels = hostLabels
+	if tplArgs.HostPlatforms, err = f.hostPlatforms(ctx, intg, hostIDs); err != nil {
+		return err
+	}
+	tplArgs.HostsTable = freeScoutHostsTable(intg)
+	if tplArgs.Links, err = f.links(ctx, intg); err != nil {
+		return err
+	}
+
+	conversationID, created, err := f.createTemplatedConversation(ctx, cli, intg, args, "", freeScoutTemplates.FailingPoliciesSummary, freeScoutTemplates.FailingPoliciesDescription, tplArgs)
+	if err != nil {
+		return err
+	}
+
+	policyIDs := make([]uint, 0, len(args.FailingPolicies.Policies))
+	for _, p := range args.FailingPolicies.Policies {
+		policyIDs = append(policyIDs, p.PolicyID)
+		f.setReportedHosts(ctx, freeScoutPolicyReportKey(args.FailingPolicies.TeamID, p.PolicyID), policyHostIDs(p.Hosts))
+	}
+	attrs := []interface{}{
+		"msg", "created freescout conversation for failing policies",
+		"policy_ids", fmt.Sprintf("%v", policyIDs),
+	}
+	attrs = append(attrs, f.conversationLogAttrs(conversationID, created)...)
+	if args.FailingPolicies.TeamID != nil {
+		attrs = append(attrs, "team_id", *args.FailingPolicies.TeamID)
+	}
+	level.Debug(f.logger(ctx)).Log(attrs...)
+	return nil
+}
+
+func newFreeScoutFailingPoliciesTplArgs(fleetURL string, args *freeScoutFailingPoliciesArgs) *freeScoutFailingPoliciesTplArgs {
+	tplArgs := &freeScoutFailingPoliciesTplArgs{
+		FleetURL: fleetURL,
+		Policies: make([]*failingPoliciesTplArgs, 0, len(args.Policies)),
+	}
+	hostIDs := make(map[uint]struct{})
+	for i := range args.Policies {
+		p := &args.Policies[i]
+		tplArgs.Policies = append(tplArgs.Policies, newFailingPoliciesTplArgs(fleetURL, p))
+		for _, h := range p.Hosts {
+			hostIDs[h.ID] = struct{}{}
+		}
+	}
+	tplArgs.HostsCount = len(hostIDs)
+	return tplArgs
+}
+
+// runDigest reports the pending digest events of the job's team in a single
+// conversation, and deletes them once reported.
+func (f *FreeScout) runDigest(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, args freeScoutArgs) error {
+	var teamID uint
+	var teamName string
+	if args.Digest.TeamID != nil {
+		teamID = *args.Digest.TeamID
+		tm, err := f.Datastore.TeamLite(ctx, teamID)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "get digest team")
+		}
+		teamName = tm.Name
+	}
+
+	events, err := f.Datastore.ListFreeScoutDigestEvents(ctx, teamID, freeScoutDigestMaxEvents)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "list digest events")
+	}
+	if len(events) == 0 {
+		// already reported by another digest job.
+		return nil
+	}
+
+	tplArgs := f.newFreeScoutDigestTplArgs(ctx, intg, events)
+	tplArgs.TeamName = teamName
+	if tplArgs.Links, err = f.links(ctx, intg); err != nil {
+		return err
+	}
+	if tplArgs.VulnsCount > 0 || tplArgs.PoliciesCount > 0 {
+		conversationID, created, err := f.createTemplatedConversation(ctx, cli, intg, args, "", freeScoutTemplates.DigestSummary, freeScoutTemplates.DigestDescription, tplArgs)
+		if err != nil {
+			return err
+		}
+		attrs := []interface{}{
+			"msg", "created freescout digest conversation",
+			"events", len(events),
+			"vulns", tplArgs.VulnsCount,
+			"policies", tplArgs.PoliciesCount,
+		}
+		attrs = append(attrs, f.conversationLogAttrs(conversationID, created)...)
+		if args.Digest.TeamID != nil {
+			attrs = append(attrs, "team_id", teamID)
+		}
+		level.Debug(f.logger(ctx)).Log(attrs...)
+	}
+
+	ids := make([]uint, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	if err := f.Datastore.DeleteFreeScoutDigestEvents(ctx, ids); err != nil {
+		return ctxerr.Wrap(ctx, err, "delete digest events")
+	}
+
+	if len(events) == freeScoutDigestMaxEvents {
+		// there may be more pending events, report them right away.
+		return queueFreeScoutDigestJob(ctx, f.Datastore, f.logger(ctx), args.Digest.TeamID, 0)
+	}
+	return nil
+}
+
+// newFreeScoutDigestTplArgs returns the template arguments of the digest of
+// the events. The events of the same CVE or policy are merged, the latest
+// one taking precedence. Vulnerabilities are grouped by CVSS severity, from
+// critical to unknown, and sorted by score; critical policies are listed
+// first.
+func (f *FreeScout) newFreeScoutDigestTplArgs(ctx context.Context, intg *fleet.FreeScoutIntegration, events []*fleet.FreeScoutDigestEvent) *freeScoutDigestTplArgs {
+	tplArgs := &freeScoutDigestTplArgs{
+		FleetURL: f.linksFleetURL(intg),
+		Since:    events[0].CreatedAt.UTC().Format("2006-01-02 15:04 MST"),
+	}
+
+	vulns := make(map[string]*vulnArgs)
+	policies := make(map[uint]*failingPolicyArgs)
+	for _, e := range events {
+		var args freeScoutArgs
+		if err := json.Unmarshal(e.Args, &args); err != nil {
+			level.Info(f.logger(ctx)).Log("msg", "skipping invalid digest event", "event_id", e.ID, "err", err)
+			continue
+		}
+		switch {
+		case args.Vulnerability != nil:
+			if intg != nil && intg.RequireCVSSV3 && !hasFreeScoutCVSSV3(args.Vulnerability) {
+				continue
+			}
+			vulns[args.Vulnerability.CVE] = args.Vulnerability
+		case args.FailingPolicy != nil:
+			policies[args.FailingPolicy.PolicyID] = args.FailingPolicy
+		}
+	}
+
+	bySeverity := make(map[string][]*freeScoutDigestVuln)
+	for cve, v := range vulns {
+		severity := webhooks.CVSSSeverity(v.CVSSScore)
+		bySeverity[severity] = append(bySeverity[severity], &freeScoutDigestVuln{
+			CVE:          cve,
+			CVSSScore:    v.CVSSScore,
+			KnownExploit: v.CISAKnownExploit != nil && *v.CISAKnownExploit,
+		})
+	}
+	severities := []struct{ severity, label string }{
+		{webhooks.SeverityCritical, "Critical"},
+		{webhooks.SeverityHigh, "High"},
+		{webhooks.SeverityMedium, "Medium"},
+		{webhooks.SeverityLow, "Low"},
+		{webhooks.SeverityNone, "None"},
+		{webhooks.SeverityUnknown, "Unknown"},
+	}
+	for _, s := range severities {
+		list := bySeverity[s.severity]
+		if len(list) == 0 {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if si, sj := list[i].CVSSScore, list[j].CVSSScore; si != nil && sj != nil && *si != *sj {
+				return *si > *sj
+			}
+			return list[i].CVE < list[j].CVE
+		})
+		tplArgs.Severities = append(tplArgs.Severities, &freeScoutDigestSeverity{Label: s.label, Count: len(list), Vulns: list})
+	}
+	tplArgs.VulnsCount = len(vulns)
+
+	for _, p := range policies {
+		tplArgs.Policies = append(tplArgs.Policies, &freeScoutDigestPolicy{
+			PolicyID:       p.PolicyID,
+			PolicyName:     p.PolicyName,
+			PolicyCritical: p.PolicyCritical,
+			TeamID:         p.TeamID,
+			HostsCount:     len(p.Hosts),
+		})
+	}
+	sort.Slice(tplArgs.Policies, func(i, j int) bool {
+		pi, pj := tplArgs.Policies[i], tplArgs.Policies[j]
+		if pi.PolicyCritical != pj.PolicyCritical {
+			return pi.PolicyCritical
+		}
+		if pi.PolicyName != pj.PolicyName {
+			return pi.PolicyName < pj.PolicyName
+		}
+		return pi.PolicyID < pj.PolicyID
+	})
+	tplArgs.PoliciesCount = len(policies)
+	return tplArgs
+}
+
+// freeScoutDigestInterval returns the digest interval of the integration, or
+// defaultFreeScoutDigestInterval if it has none.
+func freeScoutDigestInterval(intg *fleet.FreeScoutIntegration) time.Duration {
+	if intg != nil && intg.DigestInterval.Duration > 0 {
+		return intg.DigestInterval.Duration
+	}
+	return defaultFreeScoutDigestInterval
+}
+
+// hostLinkLabels returns the text of the links to the provided hosts keyed by
+// host ID, as configured by the integration's HostLinkLabel. It returns nil
+// when the hosts' display names must be used. Callers should only provide the
+// hosts that are listed in the description, see freeScoutListedHostIDs.
+func (f *FreeScout) hostLinkLabels(ctx context.Context, intg *fleet.FreeScoutIntegration, hostIDs []uint) (map[uint]string, error) {
+	if intg == nil || intg.HostLinkLabel == "" || intg.HostLinkLabel == fleet.FreeScoutHostLinkLabelDisplayName {
+		return nil, nil
+	}
+
+	ids := uniqueFreeScoutHostIDs(hostIDs)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	hosts, err := f.Datastore.ListHostsLiteByIDs(ctx, ids)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "list hosts for link labels")
+	}
+
+	labels := make(map[uint]string, len(hosts))
+	for _, h := range hosts {
+		switch intg.HostLinkLabel {
+		case fleet.FreeScoutHostLinkLabelHostname:
+			labels[h.ID] = h.Hostname
+		case fleet.FreeScoutHostLinkLabelSerial:
+			labels[h.ID] = h.HardwareSerial
+		case fleet.FreeScoutHostLinkLabelUUID:
+			labels[h.ID] = h.UUID
+		}
+	}
+	return labels, nil
 }
 
-// Name returns the name of the job.
-func (f *FreeScout) Name() string {
-	return freescoutName
+// freeScoutHostsTable returns true if the integration renders the hosts of the
+// conversations as a table.
+func freeScoutHostsTable(intg *fleet.FreeScoutIntegration) bool {
+	return intg != nil && intg.HostsRenderStyle == fleet.FreeScoutHostsRenderStyleTable
 }
 
-// returns nil, nil if there is no integration enabled for that message.
-func (f *FreeScout) getClient(ctx context.Context, args freeScoutArgs) (FreeScoutClient, error) {
-	var teamID uint
-	var useTeamCfg bool
+// hostPlatforms returns the platform of the provided hosts keyed by host ID
+// when the integration renders the hosts as a table, and nil otherwise.
+// Callers should only provide the hosts that are listed in the description,
+// see freeScoutListedHostIDs.
+func (f *FreeScout) hostPlatforms(ctx context.Context, intg *fleet.FreeScoutIntegration, hostIDs []uint) (map[uint]string, error) {
+	if !freeScoutHostsTable(intg) {
+		return nil, nil
+	}
 
-	intgType := args.integrationType()
-	key := intgType + ":"
-	if intgType == intgTypeFailingPolicy && args.FailingPolicy.TeamID != nil {
-		teamID = *args.FailingPolicy.TeamID
-		useTeamCfg = true
-		key += fmt.Sprint(teamID)
+	ids := uniqueFreeScoutHostIDs(hostIDs)
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	ac, err := f.Datastore.AppConfig(ctx)
+	hosts, err := f.Datastore.ListHostsLiteByIDs(ctx, ids)
 	if err != nil {
-		return nil, err
+		return nil, ctxerr.Wrap(ctx, err, "list hosts for platforms")
 	}
 
-	// load the config that would be used to create the client first - it is
-	// needed to check if an existing client is configured the same or if its
-	// configuration has changed since it was created.
-	var opts *externalsvc.FreeScoutOptions
-	if useTeamCfg {
-		tm, err := f.Datastore.TeamLite(ctx, teamID)
-		if err != nil {
-			return nil, err
-		}
-
-		intgs, err := tm.Config.Integrations.MatchWithIntegrations(ac.Integrations)
-		if err != nil {
-			return nil, err
-		}
+	platforms := make(map[uint]string, len(hosts))
+	for _, h := range hosts {
+		platforms[h.ID] = h.Platform
+	}
+	return platforms, nil
+}
 
-		for _, intg := range intgs.Freescout {
-			if intgType == intgTypeFailingPolicy && intg.EnableFailingPolicies {
-				opts = &externalsvc.FreeScoutOptions{
-					URL:           intg.URL,
-					APIToken:      intg.APIToken,
-					MailboxID:     intg.MailboxID,
-					CustomerEmail: intg.CustomerEmail,
-					AssignTo:      intg.AssignTo,
-				}
-				break
-			}
-		}
-	} else {
-		for _, intg := range ac.Integrations.Freescout {
-			if (intgType == intgTypeVuln && intg.EnableSoftwareVulnerabilities) ||
-				(intgType == intgTypeFailingPolicy && intg.EnableFailingPolicies) {
-				opts = &externalsvc.FreeScoutOptions{
-					URL:           intg.URL,
-					APIToken:      intg.APIToken,
-					MailboxID:     intg.MailboxID,
-					CustomerEmail: intg.CustomerEmail,
-					AssignTo:      intg.AssignTo,
-				}
-				break
-			}
+// uniqueFreeScoutHostIDs returns the provided host IDs without duplicates, in
+// order of first appearance.
+func uniqueFreeScoutHostIDs(hostIDs []uint) []uint {
+	seen := make(map[uint]bool, len(hostIDs))
+	ids := make([]uint, 0, len(hostIDs))
+	for _, id := range hostIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
 		}
 	}
+	return ids
+}
 
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	if f.clientsCache == nil {
-		f.clientsCache = make(map[string]FreeScoutClient)
-	}
-	if opts == nil {
-		// no integration configured, clear any existing one
-		delete(f.clientsCache, key)
-		return nil, nil
+// groupFreeScoutHostsByPaths groups the hosts listed in a conversation's
+// description by their set of installed paths. Groups are ordered by the first
+// appearance of their set of paths in hosts, and hosts keep their order within
+// a group.
+func groupFreeScoutHostsByPaths(hosts []fleet.HostVulnerabilitySummary) []freeScoutPathGroup {
+	if len(hosts) > freeScoutMaxHostsInDescription {
+		hosts = hosts[:freeScoutMaxHostsInDescription]
 	}
 
-	// check if the existing one can be reused
-	if cli := f.clientsCache[key]; cli != nil && cli.FreeScoutConfigMatches(opts) {
-		return cli, nil
+	var groups []freeScoutPathGroup
+	groupIndex := make(map[string]int)
+	for _, h := range hosts {
+		paths := append([]string(nil), h.SoftwareInstalledPaths...)
+		sort.Strings(paths)
+		key := strings.Join(paths, "\x00")
+
+		ix, ok := groupIndex[key]
+		if !ok {
+			ix = len(groups)
+			groupIndex[key] = ix
+			groups = append(groups, freeScoutPathGroup{Paths: paths})
+		}
+		groups[ix].Hosts = append(groups[ix].Hosts, h)
 	}
+	return groups
+}
 
-	// otherwise create a new one
-	cli, err := f.NewClientFunc(opts)
-	if err != nil {
-		return nil, err
+// freeScoutListedHostIDs returns the IDs of the hosts that are listed in a
+// conversation's description, out of all the hostIDs reported in it.
+func freeScoutListedHostIDs(hostIDs []uint) []uint {
+	if len(hostIDs) > freeScoutMaxHostsInDescription {
+		return hostIDs[:freeScoutMaxHostsInDescription]
 	}
-	f.clientsCache[key] = cli
-	return cli, nil
+	return hostIDs
 }
 
-// freeScoutArgs are the arguments for the FreeScout integration job.
-type freeScoutArgs struct {
-	Vulnerability *vulnArgs          `json:"vulnerability,omitempty"`
-	FailingPolicy *failingPolicyArgs `json:"failing_policy,omitempty"`
+// policySetHostIDs returns the IDs of the failing policy hosts that are listed
+// in a conversation's description.
+func policySetHostIDs(hosts []fleet.PolicySetHost) []uint {
+	return freeScoutListedHostIDs(policyHostIDs(hosts))
 }
 
-func (a *freeScoutArgs) integrationType() string {
-	if a.FailingPolicy == nil {
-		return intgTypeVuln
+// policyHostIDs returns the IDs of all the hosts.
+func policyHostIDs(hosts []fleet.PolicySetHost) []uint {
+	hostIDs := make([]uint, 0, len(hosts))
+	for _, h := range hosts {
+		hostIDs = append(hostIDs, h.ID)
 	}
-	return intgTypeFailingPolicy
+	return hostIDs
 }
 
-// Run executes the freescout job.
-func (f *FreeScout) Run(ctx context.Context, argsJSON json.RawMessage) error {
-	var args freeScoutArgs
-	if err := json.Unmarshal(argsJSON, &args); err != nil {
-		return ctxerr.Wrap(ctx, err, "unmarshal args")
+// logger returns the job processor's logger, decorated with the correlation
+// ID of the context if there is one.
+func (f *FreeScout) logger(ctx context.Context) kitlog.Logger {
+	if id, ok := correlation.FromContext(ctx); ok {
+		return kitlog.With(f.Log, "correlation_id", id)
 	}
+	return f.Log
+}
 
-	cli, err := f.getClient(ctx, args)
-	if err != nil {
-		return ctxerr.Wrap(ctx, err, "get FreeScout client")
+// freeScoutCorrelationID returns the correlation ID to use for a job: the
+// provided id if not empty, otherwise the one stored in the context, if any,
+// otherwise a newly generated one.
+func freeScoutCorrelationID(ctx context.Context, id string) string {
+	if id != "" {
+		return id
 	}
-	if cli == nil {
-		// this message was queued when an integration was enabled, but since
-		// then it has been disabled, so return success to mark the message
-		// as processed.
-		return nil
+	if id, ok := correlation.FromContext(ctx); ok {
+		return id
 	}
+	return uuid.NewString()
+}
 
-	switch intgType := args.integrationType(); intgType {
-	case intgTypeVuln:
-		return f.runVuln(ctx, cli, args)
-	case intgTypeFailingPolicy:
-		return f.runFailingPolicy(ctx, cli, args)
-	default:
-		return ctxerr.Errorf(ctx, "unknown integration type: %v", intgType)
+// checkConfigGeneration returns errFreeScoutConfigChanged if the integration
+// of the job, as currently configured, is not the one intg was loaded from,
+// i.e. if it was modified, disabled or removed since then, including the
+// team's settings of the integration for the jobs of a team.
+func (f *FreeScout) checkConfigGeneration(ctx context.Context, intg *fleet.FreeScoutIntegration, job freeScoutArgs) error {
+	if intg == nil {
+		return nil
+	}
+	current, err := f.jobIntegration(ctx, job)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "check FreeScout integration generation")
+	}
+	if current == nil || current.URL != intg.URL || current.Generation != intg.Generation {
+		return ctxerr.Wrap(ctx, errFreeScoutConfigChanged)
 	}
+	return nil
 }
 
-func (f *FreeScout) runVuln(ctx context.Context, cli FreeScoutClient, args freeScoutArgs) error {
-	vargs := args.Vulnerability
-	if vargs == nil {
-		return errors.New("invalid job args")
+// createTemplatedConversation renders the templates with args and creates the
+// conversation, or appends to the existing one with the same subject, with the
+// optional attachments. A newly created conversation is annotated with the tag
+// of the priority, if not empty, and with the organization name as configured
+// by the integration. It returns errFreeScoutConfigChanged without creating
+// anything if the integration of the job changed since intg was loaded, and
+// errFreeScoutEmptyRender if the summary or the description renders empty,
+// unless the integration allows it.
+func (f *FreeScout) createTemplatedConversation(ctx context.Context, cli FreeScoutClient, intg *fleet.FreeScoutIntegration, job freeScoutArgs, priority string, summaryTpl, descTpl *template.Template, args interface{}, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
+	maxBytes := defaultFreeScoutMaxDescriptionBytes
+	if intg != nil && intg.MaxDescriptionBytes > 0 {
+		maxBytes = intg.MaxDescriptionBytes
 	}
-
-	var hosts []fleet.HostVulnerabilitySummary
-	var err error
-
-	// Default to deprecated method in case we are processing an 'old' job payload
-	// we are deprecating this because of performance reasons - querying by software_id should be
-	// way more efficient than by CVE.
-	if len(vargs.AffectedSoftwareIDs) == 0 {
-		hosts, err = f.Datastore.HostsByCVE(ctx, vargs.CVE)
-	} else {
-		hosts, err = f.Datastore.HostVulnSummariesBySoftwareIDs(ctx, vargs.AffectedSoftwareIDs)
+	if intg != nil && intg.Locale != "" {
+		var err error
+		if summaryTpl, descTpl, err = localizeFreeScoutTemplates(summaryTpl, descTpl, intg.Locale); err != nil {
+			return 0, false, ctxerr.Wrap(ctx, err, "localize conversation templates")
+		}
 	}
 
+	// the summary is rendered first, as shrinking the args for the
+	// description must not change it (e.g. the number of hosts).
+	summary, err := renderFreeScoutTemplate(summaryTpl, args)
 	if err != nil {
-		return ctxerr.Wrap(ctx, err, "fetching hosts")
+		return 0, false, ctxerr.Wrap(ctx, err, "render conversation summary")
+	}
+	allowEmpty := intg != nil && intg.AllowEmptyConversations
+	if !allowEmpty && strings.TrimSpace(summary) == "" {
+		return 0, false, ctxerr.Wrap(ctx, fmt.Errorf("%w: the summary is empty, check the summary template", errFreeScoutEmptyRender))
+	}
+	// likewise for the custom fields, which are also rendered before creating
+	// the conversation so that an invalid template fails the job without
+	// creating anything.
+	customFields, err := f.renderCustomFields(ctx, intg, args)
+	if err != nil {
+		return 0, false, err
+	}
+	counter, _ := args.(freeScoutHostCounter)
+	var hostsReported int
+	if counter != nil {
+		hostsReported, _ = counter.hostCounts()
+	}
+	description, err := renderFreeScoutDescription(descTpl, args, maxBytes)
+	if err != nil {
+		return 0, false, ctxerr.Wrap(ctx, err, "render conversation description")
+	}
+	if !allowEmpty && strings.TrimSpace(description) == "" {
+		return 0, false, ctxerr.Wrap(ctx, fmt.Errorf("%w: the description is empty, check the description template", errFreeScoutEmptyRender))
+	}
+	// the metrics of the rendered description, to anticipate when the jobs
+	// will reach the maximum size of a FreeScout conversation.
+	metrics := []interface{}{
+		"msg", "rendered freescout conversation",
+		"type", job.integrationType(),
+		"description_bytes", len(description),
+		"description_max_bytes", maxBytes,
 	}
+	if counter != nil {
+		_, hostsListed := counter.hostCounts()
+		metrics = append(metrics,
+			"hosts_reported", hostsReported,
+			"hosts_listed", hostsListed,
+			"hosts_truncated", hostsReported-hostsListed,
+		)
+	}
+	level.Info(f.logger(ctx)).Log(metrics...)
 
-	tplArgs := &freeScoutVulnTplArgs{
-		NVDURL:           nvdCVEURL,
-		FleetURL:         f.FleetURL,
-		CVE:              vargs.CVE,
-		Hosts:            hosts,
-		EPSSProbability:  vargs.EPSSProbability,
-		CVSSScore:        vargs.CVSSScore,
-		CISAKnownExploit: vargs.CISAKnownExploit,
-		CVEPublished:     vargs.CVEPublished,
+	orgName, err := f.orgName(ctx, intg)
+	if err != nil {
+		return 0, false, err
+	}
+	if orgName != "" && intg.OrgNameLocation == fleet.FreeScoutOrgNameInSubject {
+		summary = "[" + orgName + "] " + summary
 	}
+	// the truncated subject is used both to search for an existing
+	// conversation and to create a new one, so that it is still found.
+	maxSubject := defaultFreeScoutMaxSubjectLength
+	if intg != nil && intg.MaxSubjectLength > 0 {
+		maxSubject = intg.MaxSubjectLength
+	}
+	summary = truncateFreeScoutSubject(summary, maxSubject)
 
-	conversationID, err := f.createTemplatedConversation(ctx, cli, freeScoutTemplates.VulnSummary, freeScoutTemplates.VulnDescription, tplArgs)
+	if err := f.checkConfigGeneration(ctx, intg, job); err != nil {
+		return 0, false, err
+	}
+	if err := f.waitMailboxRateLimit(ctx, intg); err != nil {
+		return 0, false, ctxerr.Wrap(ctx, err, "wait for mailbox rate limit")
+	}
+	var tag string
+	if intg != nil && intg.IdentifierTags {
+		if tag = job.conversationTag(); tag != "" {
+			ctx = externalsvc.WithFreeScoutConversationTag(ctx, tag)
+		}
+	}
+	conversationID, created, err := cli.CreateFreeScoutConversation(ctx, summary, description, attachments...)
 	if err != nil {
-		return err
+		return 0, false, ctxerr.Wrap(ctx, err, "create conversation")
 	}
-	level.Debug(f.Log).Log(
-		"msg", "created freescout conversation for cve",
-		"cve", vargs.CVE,
-		"conversation_id", conversationID,
-	)
-	return nil
+	if created {
+		f.conversationsCreated.Add(1)
+		if err := annotateFreeScoutConversation(ctx, cli, intg, conversationID, priority, orgName, tag, customFields); err != nil {
+			return 0, false, err
+		}
+	} else {
+		f.threadsAppended.Add(1)
+	}
+	return conversationID, created, nil
 }
 
-func (f *FreeScout) runFailingPolicy(ctx context.Context, cli FreeScoutClient, args freeScoutArgs) error {
-	tplArgs := newFailingPoliciesTplArgs(f.FleetURL, args.FailingPolicy)
-
-	conversationID, err := f.createTemplatedConversation(ctx, cli, freeScoutTemplates.FailingPolicySummary, freeScoutTemplates.FailingPolicyDescription, tplArgs)
+// renderFreeScoutDescription renders the description template, shrinking the
+// args until the result fits in maxBytes if they support it. If it still does
+// not fit, the description is cut to maxBytes.
+func renderFreeScoutDescription(descTpl *template.Template, args interface{}, maxBytes int) (string, error) {
+	description, err := renderFreeScoutTemplate(descTpl, args)
 	if err != nil {
-		return err
+		return "", err
+	}
+	shrinker, _ := args.(freeScoutShrinker)
+	for len(description) > maxBytes && shrinker != nil && shrinker.shrink() {
+		if description, err = renderFreeScoutTemplate(descTpl, args); err != nil {
+			return "", err
+		}
 	}
+	if len(description) > maxBytes {
+		description = truncateFreeScoutDescription(description, maxBytes)
+	}
+	return description, nil
+}
 
-	attrs := []interface{}{
-		"msg", "created freescout conversation for failing policy",
-		"policy_id", args.FailingPolicy.PolicyID,
-		"policy_name", args.FailingPolicy.PolicyName,
-		"conversation_id", conversationID,
+// truncateFreeScoutSubject cuts the subject to maxChars characters, the last
+// one being an ellipsis, if it is longer than that.
+func truncateFreeScoutSubject(subject string, maxChars int) string {
+	if utf8.RuneCountInString(subject) <= maxChars {
+		return subject
 	}
-	if args.FailingPolicy.TeamID != nil {
-		attrs = append(attrs, "team_id", *args.FailingPolicy.TeamID)
+	runes := []rune(subject)
+	return strings.TrimRightFunc(string(runes[:maxChars-1]), unicode.IsSpace) + "…"
+}
+
+// truncateFreeScoutDescription cuts the description so that, with the note
+// added to indicate it, it fits in maxBytes, without splitting a UTF-8
+// character.
+func truncateFreeScoutDescription(description string, maxBytes int) string {
+	const note = "\n\n[This description was truncated to fit the maximum size of a FreeScout conversation.]"
+	cut := maxBytes - len(note)
+	if cut <= 0 {
+		return ""
 	}
-	level.Debug(f.Log).Log(attrs...)
-	return nil
+	for cut > 0 && !utf8.RuneStart(description[cut]) {
+		cut--
+	}
+	return description[:cut] + note
 }
 
-func (f *FreeScout) createTemplatedConversation(ctx context.Context, cli FreeScoutClient, summaryTpl, descTpl *template.Template, args interface{}) (int64, error) {
+func renderFreeScoutTemplate(tpl *template.Template, args interface{}) (string, error) {
 	var buf bytes.Buffer
-	if err := summaryTpl.Execute(&buf, args); err != nil {
-		return 0, ctxerr.Wrap(ctx, err, "execute summary template")
+	if err := tpl.Execute(&buf, args); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
 	}
-	summary := buf.String()
+	return buf.String(), nil
+}
 
-	buf.Reset() // reuse buffer
-	if err := descTpl.Execute(&buf, args); err != nil {
-		return 0, ctxerr.Wrap(ctx, err, "execute description template")
+func renderFreeScoutConversation(summaryTpl, descTpl *template.Template, locale string, args interface{}) (summary, description string, err error) {
+	if summaryTpl, descTpl, err = localizeFreeScoutTemplates(summaryTpl, descTpl, locale); err != nil {
+		return "", "", err
+	}
+	if summary, err = renderFreeScoutTemplate(summaryTpl, args); err != nil {
+		return "", "", fmt.Errorf("summary: %w", err)
+	}
+	if description, err = renderFreeScoutTemplate(descTpl, args); err != nil {
+		return "", "", fmt.Errorf("description: %w", err)
 	}
-	description := buf.String()
+	return summary, description, nil
+}
 
-	conversationID, err := cli.CreateFreeScoutConversation(ctx, summary, description)
-	if err != nil {
-		return 0, ctxerr.Wrap(ctx, err, "create conversation")
+// FreeScoutVulnConversationArgs are the arguments used to render the FreeScout
+// conversation of a vulnerability.
+type FreeScoutVulnConversationArgs struct {
+	FleetURL string
+	CVE      string
+	Hosts    []fleet.HostVulnerabilitySummary
+	// HostsCount is the optional total number of affected hosts, when only
+	// some of them are provided in Hosts. Defaults to the number of Hosts.
+	HostsCount int
+
+	// Optional CVE metadata.
+	EPSSProbability  *float64
+	CVSSScore        *float64
+	CVSSVersion      *string
+	CISAKnownExploit *bool
+	CVEPublished     *time.Time
+	// Now is the optional time relative to which the age of the CVE is
+	// rendered, the current time by default.
+	Now time.Time
+
+	// HostLabels is the optional text of the hosts' links keyed by host ID,
+	// the display name is used for hosts without a label.
+	HostLabels map[uint]string
+	// HostPath is the optional template of the path of the hosts' links, see
+	// fleet.FreeScoutIntegration.HostPathTemplate.
+	HostPath *template.Template
+	// TruncationNotice renders the number of listed hosts out of HostsCount
+	// with a link to all of them when some are not listed, the link's path
+	// being rendered with the optional VulnHostsPath template, see
+	// fleet.FreeScoutIntegration.TruncationNotice.
+	TruncationNotice bool
+	VulnHostsPath    *template.Template
+	// CompactHostPaths groups the hosts by installed paths, see
+	// fleet.FreeScoutIntegration.CompactHostPaths.
+	CompactHostPaths bool
+	// HostsTable renders the hosts as a table instead of a bulleted list, see
+	// fleet.FreeScoutIntegration.HostsRenderStyle. HostPlatforms is the
+	// optional platform of the hosts keyed by host ID, rendered in the table.
+	HostsTable    bool
+	HostPlatforms map[uint]string
+	// HostsDelta is the optional split of the hosts between those already
+	// reported for the CVE and those newly affected, it is rendered when the
+	// CVE was reported before.
+	HostsDelta *FreeScoutHostsDelta
+	// HostsTrend is the optional number of affected hosts of the CVE in its
+	// last report, rendered next to HostsCount, see
+	// fleet.FreeScoutIntegration.VulnHostsTrend.
+	HostsTrend *FreeScoutHostsTrend
+	// SummaryHeader renders a block of the key facts of the CVE at the top of
+	// the description, see fleet.FreeScoutIntegration.VulnSummaryHeader.
+	SummaryHeader bool
+	// SoftwareNames renders the name and version of the vulnerable software
+	// of the hosts, see fleet.FreeScoutIntegration.VulnSoftwareNames.
+	SoftwareNames bool
+	// HideSoftwareInstructions omits the instructions to find the affected
+	// software in Fleet, see
+	// fleet.FreeScoutIntegration.VulnHideSoftwareInstructions.
+	HideSoftwareInstructions bool
+	// EPSSLabelMode and EPSSThresholds render the exploit likelihood label of
+	// the EPSS probability, see fleet.FreeScoutIntegration.EPSSLabelMode.
+	EPSSLabelMode  string
+	EPSSThresholds fleet.FreeScoutEPSSThresholds
+	// EPSSRenderThreshold and CVSSRenderThreshold omit the EPSS probability
+	// and the CVSS score below them, see
+	// fleet.FreeScoutIntegration.EPSSRenderThreshold.
+	EPSSRenderThreshold float64
+	CVSSRenderThreshold float64
+	// References are the optional links to the CVE rendered after the NVD
+	// link in a "References" section, see
+	// fleet.FreeScoutIntegration.CVEReferences.
+	References []FreeScoutReference
+	// Locale is the optional language of the static text of the conversation,
+	// see fleet.FreeScoutIntegration.Locale.
+	Locale string
+}
+
+// FreeScoutReference is a link to a CVE, rendered in the references of its
+// conversation.
+type FreeScoutReference struct {
+	Label string
+	URL   string
+}
+
+// FreeScoutHostsTrend is the number of affected hosts of a CVE in its
+// previous report.
+type FreeScoutHostsTrend struct {
+	Previous int
+}
+
+// FreeScoutHostsDelta splits the affected hosts of a CVE between the hosts
+// that were already reported for that CVE and the newly affected ones.
+type FreeScoutHostsDelta struct {
+	Previous int
+	New      int
+}
+
+func (a *FreeScoutVulnConversationArgs) tplArgs() *freeScoutVulnTplArgs {
+	tplArgs := &freeScoutVulnTplArgs{
+		NVDURL:           nvdCVEURL,
+		FleetURL:         a.FleetURL,
+		CVE:              a.CVE,
+		Hosts:            a.Hosts,
+		HostsCount:       a.HostsCount,
+		EPSSProbability:  a.EPSSProbability,
+		CVSSScore:        a.CVSSScore,
+		Severity:         webhooks.CVSSSeverity(a.CVSSScore),
+		CISAKnownExploit: a.CISAKnownExploit,
+		CVEPublished:     a.CVEPublished,
+		Now:              a.Now,
+		HostLabels:       a.HostLabels,
+		Links:            freeScoutLinks{host: a.HostPath, vulnHosts: a.VulnHostsPath},
+		HostsDelta:       a.HostsDelta,
+		HostsTrend:       a.HostsTrend,
+		References:       a.References,
+		SummaryHeader:    a.SummaryHeader,
+		SoftwareNames:    a.SoftwareNames,
+		HostsTable:       a.HostsTable,
+		HostPlatforms:    a.HostPlatforms,
+		EPSSLabelMode:    a.EPSSLabelMode,
+		EPSSThresholds:   a.EPSSThresholds,
+
+		EPSSRenderThreshold: a.EPSSRenderThreshold,
+		CVSSRenderThreshold: a.CVSSRenderThreshold,
+
+		HideSoftwareInstructions: a.HideSoftwareInstructions,
+		TruncationNotice:         a.TruncationNotice,
+	}
+	if tplArgs.HostsCount == 0 {
+		tplArgs.HostsCount = len(a.Hosts)
+	}
+	if tplArgs.Now.IsZero() {
+		tplArgs.Now = time.Now()
+	}
+	if a.CVSSVersion != nil {
+		tplArgs.CVSSVersion = *a.CVSSVersion
+	}
+	if a.CompactHostPaths {
+		tplArgs.PathGroups = groupFreeScoutHostsByPaths(a.Hosts)
+	}
+	return tplArgs
+}
+
+// RenderFreeScoutVulnConversation returns the subject and description of the
+// FreeScout conversation created for a vulnerability, exactly as the worker
+// renders them.
+func RenderFreeScoutVulnConversation(args *FreeScoutVulnConversationArgs) (subject, description string, err error) {
+	return renderFreeScoutConversation(freeScoutTemplates.VulnSummary, freeScoutTemplates.VulnDescription, args.Locale, args.tplArgs())
+}
+
+// FreeScoutFailingPolicyConversationArgs are the arguments used to render the
+// FreeScout conversation of a failing policy.
+type FreeScoutFailingPolicyConversationArgs struct {
+	FleetURL       string
+	PolicyID       uint
+	PolicyName     string
+	PolicyCritical bool
+	TeamID         *uint
+	Hosts          []fleet.PolicySetHost
+
+	// PolicyPlatform is the optional comma-separated list of the platforms
+	// targeted by the policy, rendered when set.
+	PolicyPlatform string
+
+	// TeamName is the optional name of the policy's team, or "Global", that
+	// prefixes the summary, see fleet.FreeScoutIntegration.TeamNameInSummary.
+	// It is also set for the overridden summary templates.
+	TeamName string
+	// HostLabels is the optional text of the hosts' links keyed by host ID,
+	// the display name is used for hosts without a label.
+	HostLabels map[uint]string
+	// HostsTable renders the hosts as a table instead of a bulleted list, see
+	// fleet.FreeScoutIntegration.HostsRenderStyle. HostPlatforms is the
+	// optional platform of the hosts keyed by host ID, rendered in the table.
+	HostsTable    bool
+	HostPlatforms map[uint]string
+	// HostPath and PolicyHostsPath are the optional templates of the paths of
+	// the hosts' links and of the link to the policy's failing hosts, see
+	// fleet.FreeScoutIntegration.HostPathTemplate.
+	HostPath        *template.Template
+	PolicyHostsPath *template.Template
+	// TruncationNotice renders the number of listed hosts out of the failing
+	// hosts with a link to all of them when some are not listed, see
+	// fleet.FreeScoutIntegration.TruncationNotice.
+	TruncationNotice bool
+	// Locale is the optional language of the static text of the conversation,
+	// see fleet.FreeScoutIntegration.Locale.
+	Locale string
+}
+
+func (a *FreeScoutFailingPolicyConversationArgs) tplArgs() *freeScoutFailingPolicyTplArgs {
+	return &freeScoutFailingPolicyTplArgs{
+		failingPoliciesTplArgs: &failingPoliciesTplArgs{
+			FleetURL:       a.FleetURL,
+			PolicyID:       a.PolicyID,
+			PolicyName:     a.PolicyName,
+			PolicyCritical: a.PolicyCritical,
+			PolicyPlatform: a.PolicyPlatform,
+			TeamID:         a.TeamID,
+			Hosts:          a.Hosts,
+		},
+		TeamName:      a.TeamName,
+		HostLabels:    a.HostLabels,
+		HostsTable:    a.HostsTable,
+		HostPlatforms: a.HostPlatforms,
+		Links:         freeScoutLinks{host: a.HostPath, policyHosts: a.PolicyHostsPath},
+
+		HostsCount:       len(a.Hosts),
+		TruncationNotice: a.TruncationNotice,
 	}
-	return conversationID, nil
+}
+
+// RenderFreeScoutFailingPolicyConversation returns the subject and description
+// of the FreeScout conversation created for a failing policy, exactly as the
+// worker renders them.
+func RenderFreeScoutFailingPolicyConversation(args *FreeScoutFailingPolicyConversationArgs) (subject, description string, err error) {
+	return renderFreeScoutConversation(freeScoutTemplates.FailingPolicySummary, freeScoutTemplates.FailingPolicyDescription, args.Locale, args.tplArgs())
 }
 
 // QueueFreeScoutVulnJobs queues the FreeScout vulnerability jobs to process asynchronously
-// via the worker.
+// via the worker. The CVEs that are not allowed by the integration's CVE allowlist and
+// denylist are skipped, and the jobs are spread over the integration's spread window, if
+// any. If the integration has a digest interval, the CVEs are added to the pending
+// digest instead. intg may be nil, in which case all CVEs are queued to run immediately.
 func QueueFreeScoutVulnJobs(
 	ctx context.Context,
 	ds fleet.Datastore,
 	logger kitlog.Logger,
+	intg *fleet.FreeScoutIntegration,
 	recentVulns []fleet.SoftwareVulnerability,
 	cveMeta map[string]fleet.CVEMeta,
 ) error {
@@ -371,32 +3555,120 @@ func QueueFreeScoutVulnJobs(
 		cveGrouped[v.GetCVE()] = append(cveGrouped[v.GetCVE()], v.Affected())
 	}
 
-	for cve, sIDs := range cveGrouped {
-		args := vulnArgs{CVE: cve, AffectedSoftwareIDs: sIDs}
+	// the CVEs are processed in a deterministic order so that the jobs are
+	// spread evenly over the window, if any.
+	allowedCVEs := make([]string, 0, len(cveGrouped))
+	for cve := range cveGrouped {
+		if intg != nil && !intg.AllowsCVE(cve) {
+			level.Debug(logger).Log("msg", "skipping cve not allowed by freescout integration", "cve", cve)
+			continue
+		}
+		allowedCVEs = append(allowedCVEs, cve)
+	}
+	sort.Strings(allowedCVEs)
+
+	var window time.Duration
+	var distribution string
+	if intg != nil {
+		window = intg.VulnJobsSpreadWindow.Duration
+		distribution = intg.VulnJobsSpreadDistribution
+	}
+
+	digest := intg != nil && intg.DigestInterval.Duration > 0
+	var digestArgs []freeScoutArgs
+	for i, cve := range allowedCVEs {
+		args := vulnArgs{CVE: cve, AffectedSoftwareIDs: cveGrouped[cve]}
 		if meta, ok := cveMeta[cve]; ok {
 			args.EPSSProbability = meta.EPSSProbability
 			args.CVSSScore = meta.CVSSScore
+			args.CVSSVersion = meta.CVSSVersion
 			args.CISAKnownExploit = meta.CISAKnownExploit
 			args.CVEPublished = meta.Published
 		}
-		job, err := QueueJob(ctx, ds, freescoutName, freeScoutArgs{Vulnerability: &args})
+		if digest {
+			digestArgs = append(digestArgs, freeScoutArgs{Vulnerability: &args})
+			continue
+		}
+		// each CVE is its own job, so it gets its own correlation ID unless one
+		// is provided by the caller's context.
+		corrID := freeScoutCorrelationID(ctx, "")
+		delay := freeScoutJobDelay(i, len(allowedCVEs), window, distribution)
+		job, err := QueueJobWithDelay(ctx, ds, freescoutName, freeScoutArgs{Vulnerability: &args, CorrelationID: corrID}, delay)
 		if err != nil {
 			return ctxerr.Wrap(ctx, err, "queueing job")
 		}
-		level.Debug(logger).Log("job_id", job.ID)
+		level.Debug(logger).Log("job_id", job.ID, "cve", cve, "correlation_id", corrID, "delay", delay)
+	}
+	if len(digestArgs) > 0 {
+		return queueFreeScoutDigestEvents(ctx, ds, logger, nil, digestArgs, intg.DigestInterval.Duration)
 	}
 	return nil
 }
 
+// SendFreeScoutTestConversation checks that the FreeScout integration works end
+// to end: it renders a sample vulnerability conversation and creates it as a
+// test conversation with the integration's configuration. If cleanup is true,
+// the test conversation is deleted once created.
+func SendFreeScoutTestConversation(ctx context.Context, intg *fleet.FreeScoutIntegration, fleetURL string, cleanup bool) (*externalsvc.FreeScoutTestConversation, error) {
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: fleetURL,
+		CVE:      "CVE-0000-0000",
+		Hosts: []fleet.HostVulnerabilitySummary{
+			{
+				ID: 1, Hostname: "test-host", DisplayName: "test-host", SoftwareInstalledPaths: []string{"/path/to/software"},
+				Software: []fleet.HostVulnerableSoftware{{Name: "software", Version: "1.0.0", InstalledPath: "/path/to/software"}},
+			},
+		},
+		CompactHostPaths: intg.CompactHostPaths,
+		SummaryHeader:    intg.VulnSummaryHeader,
+		SoftwareNames:    intg.VulnSoftwareNames,
+		EPSSLabelMode:    intg.EPSSLabelMode,
+		EPSSThresholds:   intg.EPSSThresholds,
+	})
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "render test conversation")
+	}
+
+	// the sample is a vulnerability conversation, sent to its customer.
+	cli, err := externalsvc.NewFreeScoutClient(newFreeScoutOptions(freeScoutIntegrationForType(intg, intgTypeVuln)))
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "create FreeScout client")
+	}
+	defer cli.CloseIdleConnections()
+
+	conv, err := cli.SendTestConversation(ctx, description, cleanup)
+	if err != nil {
+		return conv, ctxerr.Wrap(ctx, err, "send test conversation")
+	}
+	return conv, nil
+}
+
+// freeScoutJobDelay returns the delay before the i-th of n jobs spread over
+// the window can run, according to the distribution: evenly spaced by
+// default, or uniformly random with fleet.FreeScoutSpreadRandom.
+func freeScoutJobDelay(i, n int, window time.Duration, distribution string) time.Duration {
+	if window <= 0 || n <= 0 {
+		return 0
+	}
+	if distribution == fleet.FreeScoutSpreadRandom {
+		return time.Duration(rand.Int64N(int64(window)))
+	}
+	return window * time.Duration(i) / time.Duration(n)
+}
+
 // QueueFreeScoutFailingPolicyJob queues a FreeScout job for a failing policy to
-// process asynchronously via the worker.
+// process asynchronously via the worker. The policy is skipped if it fails on
+// fewer than minHosts hosts. If hostIDsOnly is true, only the IDs of the hosts
+// are stored in the job, their names are fetched when it is processed.
 func QueueFreeScoutFailingPolicyJob(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
-	policy *fleet.Policy, hosts []fleet.PolicySetHost,
+	policy *fleet.Policy, hosts []fleet.PolicySetHost, minHosts int, hostIDsOnly bool,
 ) error {
+	corrID := freeScoutCorrelationID(ctx, "")
 	attrs := []interface{}{
 		"enabled", "true",
 		"failing_policy", policy.ID,
 		"hosts_count", len(hosts),
+		"correlation_id", corrID,
 	}
 	if policy.TeamID != nil {
 		attrs = append(attrs, "team_id", *policy.TeamID)
@@ -406,6 +3678,11 @@ func QueueFreeScoutFailingPolicyJob(ctx context.Context, ds fleet.Datastore, log
 		level.Debug(logger).Log(attrs...)
 		return nil
 	}
+	if len(hosts) < minHosts {
+		attrs = append(attrs, "msg", "skipping, fewer hosts than the minimum", "min_hosts", minHosts)
+		level.Debug(logger).Log(attrs...)
+		return nil
+	}
 
 	level.Info(logger).Log(attrs...)
 
@@ -413,13 +3690,140 @@ func QueueFreeScoutFailingPolicyJob(ctx context.Context, ds fleet.Datastore, log
 		PolicyID:       policy.ID,
 		PolicyName:     policy.Name,
 		PolicyCritical: policy.Critical,
+		PolicyPlatform: policy.Platform,
+		TeamID:         policy.TeamID,
+		Hosts:          hosts,
+	}
+	if hostIDsOnly {
+		args.Hosts, args.HostIDs = nil, policyHostIDs(hosts)
+	}
+	job, err := QueueJob(ctx, ds, freescoutName, freeScoutArgs{FailingPolicy: args, CorrelationID: corrID})
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "queueing job")
+	}
+	level.Debug(logger).Log("job_id", job.ID, "correlation_id", corrID)
+	return nil
+}
+
+// QueueFreeScoutFailingPolicyDigestEvent adds a failing policy to the pending
+// FreeScout digest of its team, to be reported with the other events of the
+// digest after interval. The policy is skipped if it fails on fewer than
+// minHosts hosts.
+func QueueFreeScoutFailingPolicyDigestEvent(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	policy *fleet.Policy, hosts []fleet.PolicySetHost, minHosts int, interval time.Duration,
+) error {
+	if len(hosts) == 0 || len(hosts) < minHosts {
+		level.Debug(logger).Log("msg", "skipping digest failing policy, fewer hosts than the minimum",
+			"failing_policy", policy.ID, "hosts_count", len(hosts), "min_hosts", minHosts)
+		return nil
+	}
+	args := &failingPolicyArgs{
+		PolicyID:       policy.ID,
+		PolicyName:     policy.Name,
+		PolicyCritical: policy.Critical,
+		PolicyPlatform: policy.Platform,
 		TeamID:         policy.TeamID,
 		Hosts:          hosts,
 	}
-	job, err := QueueJob(ctx, ds, freescoutName, freeScoutArgs{FailingPolicy: args})
+	return queueFreeScoutDigestEvents(ctx, ds, logger, policy.TeamID, []freeScoutArgs{{FailingPolicy: args}}, interval)
+}
+
+// queueFreeScoutDigestEvents adds the args of the jobs that would have
+// reported the events to the pending digest of the team, and queues the job
+// that reports that digest after interval if it had no pending event.
+func queueFreeScoutDigestEvents(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	teamID *uint, args []freeScoutArgs, interval time.Duration,
+) error {
+	events := make([]json.RawMessage, 0, len(args))
+	for _, a := range args {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "marshal digest event")
+		}
+		events = append(events, b)
+	}
+
+	var tmID uint
+	if teamID != nil {
+		tmID = *teamID
+	}
+	wasEmpty, err := ds.InsertFreeScoutDigestEvents(ctx, tmID, events)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "insert digest events")
+	}
+	level.Debug(logger).Log("msg", "added events to freescout digest", "events", len(events), "team_id", tmID)
+	if !wasEmpty {
+		// the digest job was queued with the first pending event.
+		return nil
+	}
+	return queueFreeScoutDigestJob(ctx, ds, logger, teamID, interval)
+}
+
+// queueFreeScoutDigestJob queues the job that reports the pending digest
+// events of the team after delay.
+func queueFreeScoutDigestJob(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger, teamID *uint, delay time.Duration) error {
+	corrID := freeScoutCorrelationID(ctx, "")
+	job, err := QueueJobWithDelay(ctx, ds, freescoutName, freeScoutArgs{Digest: &freeScoutDigestArgs{TeamID: teamID}, CorrelationID: corrID}, delay)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "queueing digest job")
+	}
+	level.Debug(logger).Log("job_id", job.ID, "correlation_id", corrID, "delay", delay)
+	return nil
+}
+
+// QueueFreeScoutFailingPoliciesJob queues a single FreeScout job for all the
+// failing policies of a team (nil for global policies) to process
+// asynchronously via the worker, so that they are reported in a single
+// conversation. The hosts failing each policy are provided in hostsByPolicy,
+// keyed by policy ID, policies without any failing host or failing on fewer
+// than minHosts hosts are skipped.
+func QueueFreeScoutFailingPoliciesJob(ctx context.Context, ds fleet.Datastore, logger kitlog.Logger,
+	teamID *uint, policies []*fleet.Policy, hostsByPolicy map[uint][]fleet.PolicySetHost, minHosts int,
+) error {
+	corrID := freeScoutCorrelationID(ctx, "")
+	args := &freeScoutFailingPoliciesArgs{TeamID: teamID}
+	policyIDs := make([]uint, 0, len(policies))
+	for _, policy := range policies {
+		hosts := hostsByPolicy[policy.ID]
+		if len(hosts) == 0 {
+			continue
+		}
+		if len(hosts) < minHosts {
+			level.Debug(logger).Log("msg", "skipping failing policy with fewer hosts than the minimum",
+				"failing_policy", policy.ID, "hosts_count", len(hosts), "min_hosts", minHosts, "correlation_id", corrID)
+			continue
+		}
+		args.Policies = append(args.Policies, failingPolicyArgs{
+			PolicyID:       policy.ID,
+			PolicyName:     policy.Name,
+			PolicyCritical: policy.Critical,
+			PolicyPlatform: policy.Platform,
+			TeamID:         policy.TeamID,
+			Hosts:          hosts,
+		})
+		policyIDs = append(policyIDs, policy.ID)
+	}
+
+	attrs := []interface{}{
+		"enabled", "true",
+		"failing_policies", fmt.Sprintf("%v", policyIDs),
+		"correlation_id", corrID,
+	}
+	if teamID != nil {
+		attrs = append(attrs, "team_id", *teamID)
+	}
+	if len(args.Policies) == 0 {
+		attrs = append(attrs, "msg", "skipping, no host")
+		level.Debug(logger).Log(attrs...)
+		return nil
+	}
+
+	level.Info(logger).Log(attrs...)
+
+	job, err := QueueJob(ctx, ds, freescoutName, freeScoutArgs{FailingPolicies: args, CorrelationID: corrID})
 	if err != nil {
 		return ctxerr.Wrap(ctx, err, "queueing job")
 	}
-	level.Debug(logger).Log("job_id", job.ID)
+	level.Debug(logger).Log("job_id", job.ID, "correlation_id", corrID)
 	return nil
 }
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// freeScoutTranslations are the translations of the static text of the
+// built-in FreeScout templates and messages, keyed by locale and then by the
+// English text, which is rendered when a locale or a text has no translation.
+// The texts that are formats take their arguments in the same order in every
+// locale, using explicit argument indexes where the order of the words
+// differs.
+var freeScoutTranslations = map[string]map[string]string{
+	"fr": {
+		"Vulnerability %[1]s detected on %[2]d host(s)": "Vulnérabilité %[1]s détectée sur %[2]d hôte(s)",
+		"Summary":                       "Résumé",
+		"CVSS score":                    "Score CVSS",
+		"CVSS v%s score":                "Score CVSS v%s",
+		"Unknown":                       "Inconnu",
+		"Yes":                           "Oui",
+		"No":                            "Non",
+		"Probability of exploit (EPSS)": "Probabilité d'exploitation (EPSS)",
+		"Known exploits (CISA KEV)":     "Exploits connus (CISA KEV)",
+		"Affected hosts":                "Hôtes affectés",
+		"%[1]d (was %[2]d last report)": "%[1]d (%[2]d lors du dernier signalement)",
+		"See vulnerability (CVE) details in National Vulnerability Database (NVD) here:": "Voir les détails de la vulnérabilité (CVE) dans la National Vulnerability Database (NVD) ici :",
+		"References":             "Références",
+		"Probability of exploit": "Probabilité d'exploitation",
+		"reported by":            "signalé par",
+		"Published":              "Publiée",
+		"Known exploits":         "Exploits connus",
+		"Previously reported: %d host(s). Newly affected: %d host(s).": "Précédemment signalés : %d hôte(s). Nouvellement affectés : %d hôte(s).",
+		"Host":                          "Hôte",
+		"Paths":                         "Chemins",
+		"Platform":                      "Plateforme",
+		"Hosts with":                    "Hôtes avec",
+		"Hosts without installed paths": "Hôtes sans chemin d'installation",
+		"View the affected software and more affected hosts:":                                   "Voir les logiciels affectés et plus d'hôtes affectés :",
+		"Go to the [Software](%s) page in Fleet.":                                               "Accédez à la page [Logiciels](%s) dans Fleet.",
+		"Above the list of software, in the **Search software** box, enter \"%s\".":             "Au-dessus de la liste des logiciels, dans le champ **Search software**, saisissez « %s ».",
+		"Hover over the affected software and select **View all hosts**.":                       "Survolez le logiciel affecté et sélectionnez **View all hosts**.",
+		"Some hosts or paths were omitted to fit the maximum size of a FreeScout conversation.": "Certains hôtes ou chemins ont été omis pour respecter la taille maximale d'une conversation FreeScout.",
+		"This conversation was created automatically by your Fleet FreeScout integration.":      "Cette conversation a été créée automatiquement par votre intégration Fleet FreeScout.",
+		"today":                                "aujourd'hui",
+		"1 day ago":                            "il y a 1 jour",
+		"%d days ago":                          "il y a %d jours",
+		"%[1]s policy failed on %[2]d host(s)": "La politique %[1]s a échoué sur %[2]d hôte(s)",
+		"This policy is marked as **Critical** in Fleet.": "Cette politique est marquée comme **Critique** dans Fleet.",
+		"Hosts": "Hôtes",
+		"View hosts that failed %[1]s on the [**Hosts**](%[2]s) page in Fleet.":   "Voir les hôtes en échec pour %[1]s sur la page [**Hosts**](%[2]s) dans Fleet.",
+		"%[1]d policies failing on %[2]d hosts":                                   "%[1]d politiques en échec sur %[2]d hôtes",
+		"No longer detected on any host as of %s.":                                "N'est plus détectée sur aucun hôte depuis le %s.",
+		"Targeted platforms: %s":                                                  "Plateformes ciblées : %s",
+		"Showing %[1]d of %[2]d hosts — view all: %[3]s":                          "%[1]d hôtes affichés sur %[2]d — tout voir : %[3]s",
+		"Fleet digest since %[1]s: %[2]d vulnerabilities, %[3]d failing policies": "Récapitulatif Fleet depuis le %[1]s : %[2]d vulnérabilités, %[3]d politiques en échec",
+		"Vulnerabilities":       "Vulnérabilités",
+		"known exploit":         "exploit connu",
+		"Failing policies":      "Politiques en échec",
+		"failing on %d host(s)": "en échec sur %d hôte(s)",
+		"Critical":              "Critique",
+		"High":                  "Élevée",
+		"Medium":                "Moyenne",
+		"Low":                   "Faible",
+		"None":                  "Aucune",
+		"Some vulnerabilities or policies were omitted to fit the maximum size of a FreeScout conversation.": "Certaines vulnérabilités ou politiques ont été omises pour respecter la taille maximale d'une conversation FreeScout.",
+	},
+	"de": {
+		"Vulnerability %[1]s detected on %[2]d host(s)": "Schwachstelle %[1]s auf %[2]d Host(s) erkannt",
+		"Summary":                       "Zusammenfassung",
+		"CVSS score":                    "CVSS-Score",
+		"CVSS v%s score":                "CVSS-v%s-Score",
+		"Unknown":                       "Unbekannt",
+		"Yes":                           "Ja",
+		"No":                            "Nein",
+		"Probability of exploit (EPSS)": "Ausnutzungswahrscheinlichkeit (EPSS)",
+		"Known exploits (CISA KEV)":     "Bekannte Exploits (CISA KEV)",
+		"Affected hosts":                "Betroffene Hosts",
+		"%[1]d (was %[2]d last report)": "%[1]d (beim letzten Bericht %[2]d)",
+		"See vulnerability (CVE) details in National Vulnerability Database (NVD) here:": "Details zur Schwachstelle (CVE) in der National Vulnerability Database (NVD):",
+		"References":             "Referenzen",
+		"Probability of exploit": "Ausnutzungswahrscheinlichkeit",
+		"reported by":            "gemeldet von",
+		"Published":              "Veröffentlicht",
+		"Known exploits":         "Bekannte Exploits",
+		"Previously reported: %d host(s). Newly affected: %d host(s).": "Bereits gemeldet: %d Host(s). Neu betroffen: %d Host(s).",
+		"Host":                          "Host",
+		"Paths":                         "Pfade",
+		"Platform":                      "Plattform",
+		"Hosts with":                    "Hosts mit",
+		"Hosts without installed paths": "Hosts ohne Installationspfade",
+		"View the affected software and more affected hosts:":                                   "Betroffene Software und weitere betroffene Hosts anzeigen:",
+		"Go to the [Software](%s) page in Fleet.":                                               "Öffnen Sie die Seite [Software](%s) in Fleet.",
+		"Above the list of software, in the **Search software** box, enter \"%s\".":             "Geben Sie über der Softwareliste im Feld **Search software** „%s“ ein.",
+		"Hover over the affected software and select **View all hosts**.":                       "Fahren Sie mit der Maus über die betroffene Software und wählen Sie **View all hosts**.",
+		"Some hosts or paths were omitted to fit the maximum size of a FreeScout conversation.": "Einige Hosts oder Pfade wurden ausgelassen, um die maximale Größe einer FreeScout-Konversation einzuhalten.",
+		"This conversation was created automatically by your Fleet FreeScout integration.":      "Diese Konversation wurde automatisch von Ihrer Fleet-FreeScout-Integration erstellt.",
+		"today":                                "heute",
+		"1 day ago":                            "vor 1 Tag",
+		"%d days ago":                          "vor %d Tagen",
+		"%[1]s policy failed on %[2]d host(s)": "Richtlinie %[1]s ist auf %[2]d Host(s) fehlgeschlagen",
+		"This policy is marked as **Critical** in Fleet.": "Diese Richtlinie ist in Fleet als **Kritisch** markiert.",
+		"Hosts": "Hosts",
+		"View hosts that failed %[1]s on the [**Hosts**](%[2]s) page in Fleet.":   "Hosts, bei denen %[1]s fehlgeschlagen ist, auf der Seite [**Hosts**](%[2]s) in Fleet anzeigen.",
+		"%[1]d policies failing on %[2]d hosts":                                   "%[1]d Richtlinien auf %[2]d Hosts fehlgeschlagen",
+		"No longer detected on any host as of %s.":                                "Seit %s auf keinem Host mehr erkannt.",
+		"Targeted platforms: %s":                                                  "Zielplattformen: %s",
+		"Showing %[1]d of %[2]d hosts — view all: %[3]s":                          "%[1]d von %[2]d Hosts angezeigt — alle ansehen: %[3]s",
+		"Fleet digest since %[1]s: %[2]d vulnerabilities, %[3]d failing policies": "Fleet-Übersicht seit %[1]s: %[2]d Schwachstellen, %[3]d fehlgeschlagene Richtlinien",
+		"Vulnerabilities":       "Schwachstellen",
+		"known exploit":         "bekannter Exploit",
+		"Failing policies":      "Fehlgeschlagene Richtlinien",
+		"failing on %d host(s)": "auf %d Host(s) fehlgeschlagen",
+		"Critical":              "Kritisch",
+		"High":                  "Hoch",
+		"Medium":                "Mittel",
+		"Low":                   "Niedrig",
+		"None":                  "Keine",
+		"Some vulnerabilities or policies were omitted to fit the maximum size of a FreeScout conversation.": "Einige Schwachstellen oder Richtlinien wurden ausgelassen, um die maximale Größe einer FreeScout-Konversation einzuhalten.",
+	},
+}
+
+// freeScoutLocale returns the locale of freeScoutTranslations that matches
+// locale, e.g. "fr" for "fr_CA" or "FR-ca", or an empty string for English or
+// a locale without translations.
+func freeScoutLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if _, ok := freeScoutTranslations[locale]; ok {
+		return locale
+	}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		if _, ok := freeScoutTranslations[lang]; ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// freeScoutTranslator returns the function that translates the static text of
+// the templates into locale, see freeScoutLocale.
+func freeScoutTranslator(locale string) func(string) string {
+	translations := freeScoutTranslations[freeScoutLocale(locale)]
+	return func(text string) string {
+		if tr, ok := translations[text]; ok {
+			return tr
+		}
+		return text
+	}
+}
+
+// freeScoutDaysAgo returns the daysAgo template function, translated with tr.
+// It returns the age of t relative to now in days, e.g. "412 days ago", or an
+// empty string if t is nil.
+func freeScoutDaysAgo(tr func(string) string) func(now time.Time, t *time.Time) string {
+	return func(now time.Time, t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		switch days := int(now.Sub(*t).Hours() / 24); {
+		case days <= 0:
+			return tr("today")
+		case days == 1:
+			return tr("1 day ago")
+		default:
+			return fmt.Sprintf(tr("%d days ago"), days)
+		}
+	}
+}
+
+// localizeFreeScoutTemplate returns a copy of tpl that renders its static
+// text in locale, or tpl itself for English. The templates that do not
+// translate their text, e.g. the overrides of the integration that do not use
+// the "t" function, are rendered unchanged.
+func localizeFreeScoutTemplate(tpl *template.Template, locale string) (*template.Template, error) {
+	locale = freeScoutLocale(locale)
+	if locale == "" {
+		return tpl, nil
+	}
+	localized, err := tpl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("clone template: %w", err)
+	}
+	tr := freeScoutTranslator(locale)
+	return localized.Funcs(template.FuncMap{
+		"t":       tr,
+		"daysAgo": freeScoutDaysAgo(tr),
+	}), nil
+}
+
+// localizeFreeScoutTemplates localizes the summary and description templates
+// of a conversation, see localizeFreeScoutTemplate.
+func localizeFreeScoutTemplates(summaryTpl, descTpl *template.Template, locale string) (summary, description *template.Template, err error) {
+	if summary, err = localizeFreeScoutTemplate(summaryTpl, locale); err != nil {
+		return nil, nil, fmt.Errorf("summary: %w", err)
+	}
+	if description, err = localizeFreeScoutTemplate(descTpl, locale); err != nil {
+		return nil, nil, fmt.Errorf("description: %w", err)
+	}
+	return summary, description, nil
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeScoutLocale(t *testing.T) {
+	cases := map[string]string{
+		"":       "",
+		"en":     "",
+		"en-US":  "",
+		"fr":     "fr",
+		"FR":     "fr",
+		"fr_CA":  "fr",
+		"fr-ca":  "fr",
+		" de ":   "de",
+		"de-CH":  "de",
+		"es":     "",
+		"french": "",
+	}
+	for locale, want := range cases {
+		require.Equal(t, want, freeScoutLocale(locale), locale)
+	}
+}
+
+func TestFreeScoutTranslations(t *testing.T) {
+	verbs := regexp.MustCompile(`%(\[\d+\])?[sd]`)
+	for locale, translations := range freeScoutTranslations {
+		for text, tr := range translations {
+			// the formats must have the same arguments in every locale.
+			require.ElementsMatch(t, verbs.FindAllString(text, -1), verbs.FindAllString(tr, -1), "%s: %s", locale, text)
+		}
+		// every locale translates the same texts.
+		require.Len(t, translations, len(freeScoutTranslations["fr"]), locale)
+		for text := range freeScoutTranslations["fr"] {
+			require.Contains(t, translations, text, locale)
+		}
+	}
+}
+
+func TestRenderFreeScoutVulnConversationLocale(t *testing.T) {
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	published := now.AddDate(0, 0, -3)
+	args := &FreeScoutVulnConversationArgs{
+		FleetURL:         "https://fleetdm.com",
+		CVE:              "CVE-1234-5678",
+		Hosts:            []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1", SoftwareInstalledPaths: []string{"/opt/foo"}}},
+		CVSSScore:        ptr.Float64(7.5),
+		CVSSVersion:      ptr.String("3.1"),
+		CISAKnownExploit: ptr.Bool(true),
+		CVEPublished:     &published,
+		Now:              now,
+	}
+
+	// English by default
+	subject, description, err := RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 1 host(s)", subject)
+	require.Contains(t, description, "CVSS v3.1 score (reported by [NVD](https://nvd.nist.gov/)): 7.5")
+	require.Contains(t, description, "(3 days ago)")
+	require.Contains(t, description, "Known exploits (reported by [CISA](https://www.cisa.gov/known-exploited-vulnerabilities-catalog)): Yes")
+	require.Contains(t, description, "Affected hosts:\n")
+	require.Contains(t, description, "1. Go to the [Software](https://fleetdm.com/software/manage) page in Fleet.\n")
+	require.Contains(t, description, `2. Above the list of software, in the **Search software** box, enter "CVE-1234-5678".`)
+	require.Contains(t, description, "This conversation was created automatically by your Fleet FreeScout integration.")
+
+	args.Locale = "fr_FR"
+	subject, description, err = RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "Vulnérabilité CVE-1234-5678 détectée sur 1 hôte(s)", subject)
+	require.Contains(t, description, "Score CVSS v3.1 (signalé par [NVD](https://nvd.nist.gov/)): 7.5")
+	require.Contains(t, description, "(il y a 3 jours)")
+	require.Contains(t, description, "Exploits connus (signalé par [CISA](https://www.cisa.gov/known-exploited-vulnerabilities-catalog)): Oui")
+	require.Contains(t, description, "Hôtes affectés:\n")
+	require.Contains(t, description, "* [h1](https://fleetdm.com/hosts/1)\n")
+	require.Contains(t, description, "1. Accédez à la page [Logiciels](https://fleetdm.com/software/manage) dans Fleet.\n")
+	require.Contains(t, description, "saisissez « CVE-1234-5678 ».")
+	require.Contains(t, description, "Cette conversation a été créée automatiquement par votre intégration Fleet FreeScout.")
+	require.NotContains(t, description, "Affected hosts")
+
+	args.Locale = "de"
+	args.SummaryHeader = true
+	subject, description, err = RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "Schwachstelle CVE-1234-5678 auf 1 Host(s) erkannt", subject)
+	require.Contains(t, description, "**Zusammenfassung**")
+	require.Contains(t, description, "* Bekannte Exploits (CISA KEV): Ja\n")
+	require.Contains(t, description, "* Ausnutzungswahrscheinlichkeit (EPSS): Unbekannt\n")
+	require.Contains(t, description, "CVSS-v3.1-Score (gemeldet von [NVD](https://nvd.nist.gov/)): 7.5")
+	require.Contains(t, description, "(vor 3 Tagen)")
+	require.Contains(t, description, "Betroffene Hosts:\n")
+	require.Contains(t, description, "Diese Konversation wurde automatisch von Ihrer Fleet-FreeScout-Integration erstellt.")
+
+	// falls back to English
+	args.Locale = "es"
+	subject, _, err = RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 1 host(s)", subject)
+}
+
+func TestRenderFreeScoutFailingPolicyConversationLocale(t *testing.T) {
+	args := &FreeScoutFailingPolicyConversationArgs{
+		FleetURL:       "https://fleetdm.com",
+		PolicyID:       3,
+		PolicyName:     "disk_encryption",
+		PolicyCritical: true,
+		TeamID:         ptr.Uint(4),
+		TeamName:       "Workstations",
+		Hosts:          []fleet.PolicySetHost{{ID: 1, DisplayName: "Host 1"}},
+		Locale:         "fr",
+	}
+	subject, description, err := RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "[Workstations] La politique disk_encryption a échoué sur 1 hôte(s)", subject)
+	require.Contains(t, description, "Cette politique est marquée comme **Critique** dans Fleet.")
+	require.Contains(t, description, "Hôtes:\n")
+	require.Contains(t, description, "Voir les hôtes en échec pour disk\\_encryption sur la page [**Hosts**](https://fleetdm.com/hosts/manage/?order_key=hostname&order_direction=asc&team_id=4&policy_id=3&policy_response=failing) dans Fleet.")
+
+	args.Locale = "de-AT"
+	args.HostsTable = true
+	args.HostPlatforms = map[uint]string{1: "windows"}
+	subject, description, err = RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "[Workstations] Richtlinie disk_encryption ist auf 1 Host(s) fehlgeschlagen", subject)
+	require.Contains(t, description, "Diese Richtlinie ist in Fleet als **Kritisch** markiert.")
+	require.Contains(t, description, "| Host | Plattform |\n| --- | --- |\n| [Host 1](https://fleetdm.com/hosts/1) | windows |\n")
+	require.Contains(t, description, "Hosts, bei denen disk\\_encryption fehlgeschlagen ist")
+}
@@ -0,0 +1,5359 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/correlation"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/fleetdm/fleet/v4/server/mock"
+	"github.com/fleetdm/fleet/v4/server/ptr"
+	"github.com/fleetdm/fleet/v4/server/service/externalsvc"
+	kitlog "github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freeScoutTestServer is a minimal fake of the FreeScout API that records the
+// requests it receives. The dedup search never finds an existing conversation
+// and created conversations get an increasing Resource-ID.
+type freeScoutTestServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []freeScoutTestRequest
+	nextID   int64
+}
+
+type freeScoutTestRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   string
+}
+
+func newFreeScoutTestServer(t *testing.T) *freeScoutTestServer {
+	srv := &freeScoutTestServer{nextID: 1}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		srv.requests = append(srv.requests, freeScoutTestRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+			Body:   string(body),
+		})
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/conversations":
+			_, err = w.Write([]byte(`{"_embedded":{"conversations":[]}}`))
+			require.NoError(t, err)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/conversations":
+			w.Header().Set("Resource-ID", strconv.FormatInt(srv.nextID, 10))
+			srv.nextID++
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/conversations/"):
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/conversations/"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *freeScoutTestServer) created() []freeScoutTestRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reqs []freeScoutTestRequest
+	for _, r := range s.requests {
+		if r.Method == http.MethodPost && r.Path == "/api/conversations" {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+func newFreeScoutTestJob(ds fleet.Datastore, logger kitlog.Logger) *FreeScout {
+	return &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       logger,
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			client, err := externalsvc.NewFreeScoutClient(opts)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		},
+	}
+}
+
+// mockFreeScoutReports keeps the FreeScout reports of the mock datastore in
+// memory, and returns their host IDs keyed by report key.
+func mockFreeScoutReports(ds *mock.Store) map[string][]uint {
+	var mu sync.Mutex
+	reports := make(map[string][]uint)
+	ds.CountFreeScoutReportedHostsFunc = func(ctx context.Context, key string, hostIDs []uint) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported, ok := reports[key]
+		if !ok {
+			return 0, &mock.Error{Message: "not found"}
+		}
+		var count int
+		for _, id := range hostIDs {
+			if slices.Contains(reported, id) {
+				count++
+			}
+		}
+		return count, nil
+	}
+	lastReports := make(map[string]fleet.FreeScoutReport)
+	ds.GetFreeScoutReportFunc = func(ctx context.Context, key string) (*fleet.FreeScoutReport, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		report, ok := lastReports[key]
+		if !ok {
+			return nil, &mock.Error{Message: "not found"}
+		}
+		return &report, nil
+	}
+	ds.SetFreeScoutReportFunc = func(ctx context.Context, report *fleet.FreeScoutReport, hostIDs []uint) error {
+		mu.Lock()
+		defer mu.Unlock()
+		lastReports[report.Key] = *report
+		reports[report.Key] = slices.Clone(hostIDs)
+		return nil
+	}
+	return reports
+}
+
+func TestFreeScoutRun(t *testing.T) {
+	srv := newFreeScoutTestServer(t)
+
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{
+			{
+				ID:          1,
+				Hostname:    "test",
+				DisplayName: "test",
+				SoftwareInstalledPaths: []string{
+					"/some/path/1",
+					"/some/path/2",
+				},
+			},
+		}, nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           srv.URL,
+					APIToken:                      "token",
+					MailboxID:                     1,
+					CustomerEmail:                 "fleet@example.com",
+					EnableSoftwareVulnerabilities: true,
+					EnableFailingPolicies:         true,
+				},
+			},
+		}}, nil
+	}
+
+	cases := []struct {
+		desc                     string
+		payload                  string
+		expectedSubject          string
+		expectedDescription      []string
+		expectedNotInDescription string
+	}{
+		{
+			"vuln",
+			`{"vulnerability":{"cve":"CVE-1234-5678"}}`,
+			`"subject":"Vulnerability CVE-1234-5678 detected on 1 host(s)"`,
+			[]string{"/some/path/1", "/some/path/2", `"mailboxId":1`},
+			"Probability of exploit",
+		},
+		{
+			"vuln with scores",
+			`{"vulnerability":{"cve":"CVE-1234-5678","epss_probability":3.4,"cvss_score":50,"cisa_known_exploit":true}}`,
+			`"subject":"Vulnerability CVE-1234-5678 detected on 1 host(s)"`,
+			[]string{"Probability of exploit", "CVSS score", "Known exploits"},
+			"",
+		},
+		{
+			"failing global policy",
+			`{"failing_policy":{"policy_id": 1, "policy_name": "test-policy", "hosts": [{"id": 123, "hostname": "host-123"}]}}`,
+			`"subject":"test-policy policy failed on 1 host(s)"`,
+			[]string{"\\u0026policy_id=1\\u0026policy_response=failing"},
+			"\\u0026team_id=",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			before := len(srv.created())
+
+			job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+			err := job.Run(context.Background(), json.RawMessage(c.payload))
+			require.NoError(t, err)
+
+			created := srv.created()
+			require.Len(t, created, before+1)
+			body := created[len(created)-1].Body
+			require.Contains(t, body, c.expectedSubject)
+			for _, s := range c.expectedDescription {
+				require.Contains(t, body, s)
+			}
+			if c.expectedNotInDescription != "" {
+				require.NotContains(t, body, c.expectedNotInDescription)
+			}
+		})
+	}
+}
+
+func TestFreeScoutCorrelationID(t *testing.T) {
+	srv := newFreeScoutTestServer(t)
+
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                   srv.URL,
+					APIToken:              "token",
+					MailboxID:             1,
+					CustomerEmail:         "fleet@example.com",
+					EnableFailingPolicies: true,
+				},
+			},
+		}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	policy := &fleet.Policy{PolicyData: fleet.PolicyData{ID: 1, Name: "p1"}}
+	hosts := []fleet.PolicySetHost{{ID: 1, Hostname: "h1", DisplayName: "h1"}}
+
+	t.Run("generated on queue and reused by every attempt", func(t *testing.T) {
+		queued = nil
+		var buf bytes.Buffer
+		logger := kitlog.NewLogfmtLogger(&buf)
+
+		err := QueueFreeScoutFailingPolicyJob(context.Background(), ds, logger, policy, hosts, 0, false)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+		require.NotEmpty(t, args.CorrelationID)
+
+		// run the job twice, as would happen with a retry
+		job := newFreeScoutTestJob(ds, logger)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, job.Run(context.Background(), *queued[0].Args))
+		}
+
+		out := buf.String()
+		require.Contains(t, out, "sending freescout request")
+		require.Contains(t, out, "received freescout response")
+		require.Contains(t, out, "created freescout conversation for failing policy")
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			require.Contains(t, line, "correlation_id="+args.CorrelationID)
+		}
+	})
+
+	t.Run("propagated from the context", func(t *testing.T) {
+		queued = nil
+		var buf bytes.Buffer
+		logger := kitlog.NewLogfmtLogger(&buf)
+
+		ctx := correlation.NewContext(context.Background(), "abc-123")
+		err := QueueFreeScoutFailingPolicyJob(ctx, ds, logger, policy, hosts, 0, false)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+		require.Equal(t, "abc-123", args.CorrelationID)
+
+		// a job without a stored correlation ID uses the one from the context
+		job := newFreeScoutTestJob(ds, logger)
+		err = job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`))
+		require.NoError(t, err)
+
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			require.Contains(t, line, "correlation_id=abc-123")
+		}
+	})
+}
+
+func TestFreeScoutQueueFailingPolicyJobMinHosts(t *testing.T) {
+	ds := new(mock.Store)
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+	ctx := context.Background()
+	policy := &fleet.Policy{PolicyData: fleet.PolicyData{ID: 1, Name: "p1"}}
+	hosts := []fleet.PolicySetHost{{ID: 1, Hostname: "h1"}, {ID: 2, Hostname: "h2"}}
+
+	// below the minimum, skipped
+	var buf bytes.Buffer
+	err := QueueFreeScoutFailingPolicyJob(ctx, ds, kitlog.NewLogfmtLogger(&buf), policy, hosts, 3, false)
+	require.NoError(t, err)
+	require.Empty(t, queued)
+	require.Contains(t, buf.String(), `msg="skipping, fewer hosts than the minimum"`)
+	require.Contains(t, buf.String(), "hosts_count=2")
+	require.Contains(t, buf.String(), "min_hosts=3")
+
+	// at the minimum, queued
+	err = QueueFreeScoutFailingPolicyJob(ctx, ds, kitlog.NewNopLogger(), policy, hosts, 2, false)
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+
+	// above the minimum, queued
+	err = QueueFreeScoutFailingPolicyJob(ctx, ds, kitlog.NewNopLogger(), policy, hosts, 1, false)
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+}
+
+func TestFreeScoutFailingPolicyHostIDsOnly(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true},
+			},
+		}}, nil
+	}
+	ds.ListHostsLiteByIDsFunc = func(ctx context.Context, ids []uint) ([]*fleet.Host, error) {
+		// host 2 was deleted since the job was queued
+		return []*fleet.Host{
+			{ID: 3, Hostname: "h3"},
+			{ID: 1, Hostname: "h1", ComputerName: "Alice's Mac"},
+		}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	policy := &fleet.Policy{PolicyData: fleet.PolicyData{ID: 1, Name: "p1", Platform: "darwin"}}
+	hosts := []fleet.PolicySetHost{
+		{ID: 1, Hostname: "h1", DisplayName: "h1"},
+		{ID: 2, Hostname: "h2", DisplayName: "h2"},
+		{ID: 3, Hostname: "h3", DisplayName: "h3"},
+	}
+
+	// only the IDs are stored, the hosts are fetched when the job is run
+	require.NoError(t, QueueFreeScoutFailingPolicyJob(ctx, ds, kitlog.NewNopLogger(), policy, hosts, 0, true))
+	require.Len(t, queued, 1)
+	var args freeScoutArgs
+	require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+	require.Empty(t, args.FailingPolicy.Hosts)
+	require.Equal(t, []uint{1, 2, 3}, args.FailingPolicy.HostIDs)
+	require.Equal(t, "darwin", args.FailingPolicy.PolicyPlatform)
+	require.NotContains(t, string(*queued[0].Args), "h1")
+
+	require.NoError(t, job.Run(ctx, *queued[0].Args))
+	require.True(t, ds.ListHostsLiteByIDsFuncInvoked)
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, "p1 policy failed on 2 host(s)", client.conversations[0].Subject)
+	require.Contains(t, client.conversations[0].Message, "[Alice's Mac](https://fleetdm.com/hosts/1)")
+	require.Contains(t, client.conversations[0].Message, "Targeted platforms: darwin")
+	require.Contains(t, client.conversations[0].Message, "[h3](https://fleetdm.com/hosts/3)")
+	require.NotContains(t, client.conversations[0].Message, "hosts/2")
+
+	// the legacy payloads with the hosts are run as they are
+	ds.ListHostsLiteByIDsFuncInvoked = false
+	queued = nil
+	require.NoError(t, QueueFreeScoutFailingPolicyJob(ctx, ds, kitlog.NewNopLogger(), policy, hosts, 0, false))
+	require.Len(t, queued, 1)
+	args = freeScoutArgs{}
+	require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+	require.Equal(t, hosts, args.FailingPolicy.Hosts)
+	require.Empty(t, args.FailingPolicy.HostIDs)
+
+	require.NoError(t, job.Run(ctx, *queued[0].Args))
+	require.False(t, ds.ListHostsLiteByIDsFuncInvoked)
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, "p1 policy failed on 3 host(s)", client.conversations[1].Subject)
+	require.Contains(t, client.conversations[1].Message, "[h2](https://fleetdm.com/hosts/2)")
+
+	// a job whose hosts were all deleted is skipped
+	ds.ListHostsLiteByIDsFunc = func(ctx context.Context, ids []uint) ([]*fleet.Host, error) {
+		return nil, nil
+	}
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "host_ids": [1, 2]}}`)))
+	require.Len(t, client.conversations, 2)
+}
+
+type mockFreeScoutClient struct {
+	opts          externalsvc.FreeScoutOptions
+	conversations []mockFreeScoutConversation
+	closed        bool
+}
+
+type mockFreeScoutConversation struct {
+	Subject      string
+	Message      string
+	Tags         []string
+	CustomFields map[int64]string
+	Attachments  []externalsvc.FreeScoutAttachment
+	// AssignTo is the assignee of the context if it has one, otherwise the
+	// one of the options.
+	AssignTo int64
+	// ClosedBy is the user who closed the conversation of the first message
+	// recorded for it, if it was closed.
+	ClosedBy int64
+}
+
+// CreateFreeScoutConversation records the message, it is reported as appended
+// to an existing conversation if one was already recorded with the identifier
+// tag of the context, or else with that subject.
+func (c *mockFreeScoutClient) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
+	assignTo, ok := externalsvc.FreeScoutAssignToFromContext(ctx)
+	if !ok {
+		assignTo = c.opts.AssignTo
+	}
+	tag, _ := externalsvc.FreeScoutConversationTagFromContext(ctx)
+	for i, conv := range c.conversations {
+		if tag != "" && slices.Contains(conv.Tags, tag) {
+			c.conversations = append(c.conversations, mockFreeScoutConversation{Subject: subject, Message: message, Attachments: attachments, AssignTo: assignTo})
+			return int64(i + 1), false, nil
+		}
+	}
+	for i, conv := range c.conversations {
+		if conv.Subject == subject {
+			c.conversations = append(c.conversations, mockFreeScoutConversation{Subject: subject, Message: message, Attachments: attachments, AssignTo: assignTo})
+			return int64(i + 1), false, nil
+		}
+	}
+	c.conversations = append(c.conversations, mockFreeScoutConversation{Subject: subject, Message: message, Attachments: attachments, AssignTo: assignTo})
+	return int64(len(c.conversations)), true, nil
+}
+
+// FindFreeScoutConversation returns the ID of the conversation to which
+// CreateFreeScoutConversation would append the message, or 0 if there is
+// none.
+func (c *mockFreeScoutClient) FindFreeScoutConversation(ctx context.Context, subject string) (int64, error) {
+	tag, _ := externalsvc.FreeScoutConversationTagFromContext(ctx)
+	for i, conv := range c.conversations {
+		if (tag != "" && slices.Contains(conv.Tags, tag)) || (subject != "" && conv.Subject == subject) {
+			return int64(i + 1), nil
+		}
+	}
+	return 0, nil
+}
+
+// AppendFreeScoutThread records the message with the subject of the first
+// message recorded for the conversation.
+func (c *mockFreeScoutClient) AppendFreeScoutThread(ctx context.Context, conversationID int64, message string) error {
+	if conversationID < 1 || int(conversationID) > len(c.conversations) {
+		return fmt.Errorf("conversation %d not found", conversationID)
+	}
+	c.conversations = append(c.conversations, mockFreeScoutConversation{Subject: c.conversations[conversationID-1].Subject, Message: message})
+	return nil
+}
+
+// CloseConversation records the user who closed the conversation on its first
+// message.
+func (c *mockFreeScoutClient) CloseConversation(ctx context.Context, conversationID, byUser int64) error {
+	if conversationID < 1 || int(conversationID) > len(c.conversations) {
+		return fmt.Errorf("conversation %d not found", conversationID)
+	}
+	c.conversations[conversationID-1].ClosedBy = byUser
+	return nil
+}
+
+// TagConversation sets the tags of the first message recorded for the
+// conversation.
+func (c *mockFreeScoutClient) TagConversation(ctx context.Context, conversationID int64, tags []string) error {
+	if conversationID < 1 || int(conversationID) > len(c.conversations) {
+		return fmt.Errorf("conversation %d not found", conversationID)
+	}
+	c.conversations[conversationID-1].Tags = tags
+	return nil
+}
+
+// SetConversationCustomField sets the custom field of the first message
+// recorded for the conversation.
+func (c *mockFreeScoutClient) SetConversationCustomField(ctx context.Context, conversationID, fieldID int64, value string) error {
+	if conversationID < 1 || int(conversationID) > len(c.conversations) {
+		return fmt.Errorf("conversation %d not found", conversationID)
+	}
+	conv := &c.conversations[conversationID-1]
+	if conv.CustomFields == nil {
+		conv.CustomFields = make(map[int64]string)
+	}
+	conv.CustomFields[fieldID] = value
+	return nil
+}
+
+func (c *mockFreeScoutClient) CloseIdleConnections() {
+	c.closed = true
+}
+
+func (c *mockFreeScoutClient) FreeScoutConfigMatches(opts *externalsvc.FreeScoutOptions) bool {
+	cur, other := c.opts, *opts
+	cur.Logger, other.Logger = nil, nil
+	return reflect.DeepEqual(cur, other)
+}
+
+func TestFreeScoutRunClientUpdate(t *testing.T) {
+	ds := new(mock.Store)
+
+	token := "token-1"
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					APIToken:                      token,
+					MailboxID:                     1,
+					CustomerEmail:                 "fleet@example.com",
+					EnableSoftwareVulnerabilities: true,
+				},
+			},
+		}}, nil
+	}
+
+	var tokens []string
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			tokens = append(tokens, opts.APIToken)
+			return &mockFreeScoutClient{opts: *opts}, nil
+		},
+	}
+
+	ctx := context.Background()
+	args := freeScoutArgs{Vulnerability: &vulnArgs{CVE: "CVE-1234-5678"}}
+
+	cli1, _, err := job.getClient(ctx, args)
+	require.NoError(t, err)
+
+	// unchanged configuration, the cached client is reused
+	cli2, _, err := job.getClient(ctx, args)
+	require.NoError(t, err)
+	require.Same(t, cli1, cli2)
+
+	// rotate the API token, a new client must be created
+	token = "token-2"
+	cli3, _, err := job.getClient(ctx, args)
+	require.NoError(t, err)
+	require.NotSame(t, cli1, cli3)
+	require.Equal(t, "token-2", cli3.(*mockFreeScoutClient).opts.APIToken)
+
+	require.Equal(t, []string{"token-1", "token-2"}, tokens)
+	require.Equal(t, 1, job.clientsCache.len())
+	require.Same(t, cli3, job.clientsCache.get(intgTypeVuln+"::1"))
+}
+
+func TestFreeScoutRunSeverityMailboxes(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					MailboxID:                     1,
+					EnableSoftwareVulnerabilities: true,
+					EnableFailingPolicies:         true,
+					SeverityMailboxes: map[string]int64{
+						"critical": 10,
+						"high":     10,
+						"low":      20,
+					},
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	clients := make(map[int64]*mockFreeScoutClient)
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			c := &mockFreeScoutClient{opts: *opts}
+			clients[opts.MailboxID] = c
+			return c, nil
+		},
+	}
+	ctx := context.Background()
+
+	cases := []struct {
+		desc    string
+		args    string
+		mailbox int64
+	}{
+		{"critical", `{"vulnerability":{"cve":"CVE-0001","cvss_score":9.8}}`, 10},
+		{"high", `{"vulnerability":{"cve":"CVE-0002","cvss_score":7.5}}`, 10},
+		{"medium is not mapped", `{"vulnerability":{"cve":"CVE-0003","cvss_score":5}}`, 1},
+		{"low", `{"vulnerability":{"cve":"CVE-0004","cvss_score":2.1}}`, 20},
+		{"unknown is not mapped", `{"vulnerability":{"cve":"CVE-0005"}}`, 1},
+		{"failing policies use the default mailbox", `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			for _, cli := range clients {
+				cli.conversations = nil
+			}
+			err := job.Run(ctx, json.RawMessage(c.args))
+			require.NoError(t, err)
+
+			for mailboxID, cli := range clients {
+				if mailboxID == c.mailbox {
+					require.Len(t, cli.conversations, 1)
+				} else {
+					require.Empty(t, cli.conversations)
+				}
+			}
+		})
+	}
+
+	// a single client is cached per integration type and mailbox
+	require.ElementsMatch(t, []string{"vuln::10", "vuln::20", "vuln::1", "failingPolicy::1"}, job.clientsCache.keys())
+}
+
+func TestFreeScoutRunMailboxRateLimits(t *testing.T) {
+	const interval = 400 * time.Millisecond
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		SeverityMailboxes:             map[string]int64{"critical": 10},
+		// a single conversation per interval in the default mailbox, three
+		// in the critical one.
+		MailboxRateLimit: fleet.FreeScoutRateLimit{Conversations: 1, Interval: fleet.Duration{Duration: interval}},
+		MailboxRateLimits: map[int64]fleet.FreeScoutRateLimit{
+			10: {Conversations: 3, Interval: fleet.Duration{Duration: interval}},
+		},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	clients := make(map[int64]*mockFreeScoutClient)
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		c := &mockFreeScoutClient{opts: *opts}
+		clients[opts.MailboxID] = c
+		return c, nil
+	}
+	ctx := context.Background()
+
+	const (
+		defaultMailboxArgs  = `{"vulnerability":{"cve":"CVE-0001","cvss_score":5}}`
+		criticalMailboxArgs = `{"vulnerability":{"cve":"CVE-0002","cvss_score":9.8}}`
+	)
+	run := func(args string) time.Duration {
+		start := time.Now()
+		require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+		return time.Since(start)
+	}
+
+	// the default mailbox's limit is reached by its first conversation, but
+	// the critical mailbox is not starved by it.
+	require.Less(t, run(defaultMailboxArgs), interval/2)
+	for range 3 {
+		require.Less(t, run(criticalMailboxArgs), interval/2)
+	}
+	// the next conversations wait for a token of their own mailbox, a third
+	// of the interval for the critical one.
+	require.GreaterOrEqual(t, run(criticalMailboxArgs), interval/6)
+	require.GreaterOrEqual(t, run(defaultMailboxArgs), interval/4)
+	require.Len(t, clients[1].conversations, 2)
+	require.Len(t, clients[10].conversations, 4)
+
+	// the job gives up waiting when its context is done, without creating
+	// the conversation
+	intg.MailboxRateLimit.Interval = fleet.Duration{Duration: time.Hour}
+	require.NoError(t, job.Run(ctx, json.RawMessage(defaultMailboxArgs)))
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := job.Run(cctx, json.RawMessage(defaultMailboxArgs))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "wait for mailbox rate limit")
+	require.Len(t, clients[1].conversations, 3)
+}
+
+func TestFreeScoutRunAttachCVEMetadata(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const args = `{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":9.8,"epss_probability":0.42}}`
+
+	// not attached by default
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, client.conversations[0].Attachments)
+
+	intg.AttachCVEMetadata = true
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Len(t, client.conversations, 1)
+	require.Len(t, client.conversations[0].Attachments, 1)
+	attachment := client.conversations[0].Attachments[0]
+	require.Equal(t, "CVE-2024-1234.json", attachment.FileName)
+	require.Equal(t, "application/json", attachment.MimeType)
+	require.JSONEq(t, `{"cve":"CVE-2024-1234","cvss_score":9.8,"epss_probability":0.42}`, string(attachment.Content))
+}
+
+func TestFreeScoutRunConfigChanged(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		Generation:                    1,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	var changeConfig func()
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		// the configuration is modified after it was read to get the client,
+		// and before the conversation is created.
+		if changeConfig != nil {
+			changeConfig()
+		}
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		client.opts = *opts
+		return client, nil
+	}
+	ctx := context.Background()
+	const args = `{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":9.8}}`
+
+	// the generation does not change, the conversation is created
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, queued)
+
+	// the generation changes, the job is deferred without creating anything
+	client.conversations = nil
+	changeConfig = func() {
+		updated := *intg
+		updated.Generation++
+		intg = &updated
+	}
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 1)
+	require.Equal(t, freescoutName, queued[0].Name)
+	var queuedArgs freeScoutArgs
+	require.NoError(t, json.Unmarshal(*queued[0].Args, &queuedArgs))
+	require.NotNil(t, queuedArgs.Vulnerability)
+	require.Equal(t, "CVE-2024-1234", queuedArgs.Vulnerability.CVE)
+	require.True(t, queued[0].NotBefore.After(time.Now()))
+
+	// the integration is disabled, the job is deferred as well
+	queued = nil
+	changeConfig = func() {
+		updated := *intg
+		updated.EnableSoftwareVulnerabilities = false
+		intg = &updated
+	}
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 1)
+
+	// the deferred job is processed with the new configuration
+	queued = nil
+	changeConfig = nil
+	intg.EnableSoftwareVulnerabilities = true
+	require.NoError(t, job.Run(ctx, json.RawMessage(args)))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, queued)
+}
+
+func TestFreeScoutQuietHoursDelay(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 10, hour, min, 0, 0, time.UTC)
+	}
+	score := func(v float64) *float64 { return &v }
+	kev := true
+
+	cases := []struct {
+		desc  string
+		qh    fleet.FreeScoutQuietHours
+		vargs vulnArgs
+		now   time.Time
+		want  time.Duration
+	}{
+		{"disabled", fleet.FreeScoutQuietHours{}, vulnArgs{}, at(3, 0), 0},
+		{"before window", fleet.FreeScoutQuietHours{Start: "12:00", End: "14:00"}, vulnArgs{}, at(11, 59), 0},
+		{"window start", fleet.FreeScoutQuietHours{Start: "12:00", End: "14:00"}, vulnArgs{}, at(12, 0), 2 * time.Hour},
+		{"window end", fleet.FreeScoutQuietHours{Start: "12:00", End: "14:00"}, vulnArgs{}, at(14, 0), 0},
+		{"overnight evening", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{}, at(23, 0), 8*time.Hour + 30*time.Minute},
+		{"overnight morning", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{}, at(6, 0), 90 * time.Minute},
+		{"overnight day", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{}, at(12, 0), 0},
+		{"sub-threshold score", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{CVSSScore: score(8.9)}, at(23, 0), 8*time.Hour + 30*time.Minute},
+		{"critical score", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{CVSSScore: score(9.0)}, at(23, 0), 0},
+		{"custom bypass score", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30", BypassCVSSScore: 7}, vulnArgs{CVSSScore: score(7.5)}, at(23, 0), 0},
+		{"known exploit", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30"}, vulnArgs{CISAKnownExploit: &kev}, at(23, 0), 0},
+		{"deferred known exploit", fleet.FreeScoutQuietHours{Start: "22:00", End: "07:30", DeferKnownExploited: true}, vulnArgs{CISAKnownExploit: &kev}, at(23, 0), 8*time.Hour + 30*time.Minute},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			intg := &fleet.FreeScoutIntegration{QuietHours: c.qh}
+			got, err := freeScoutQuietHoursDelay(intg, &c.vargs, c.now)
+			require.NoError(t, err)
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestFreeScoutRunQuietHours(t *testing.T) {
+	// the quiet hours are set around the current time.
+	now := time.Now().UTC()
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		QuietHours: fleet.FreeScoutQuietHours{
+			Start: now.Add(-time.Hour).Format("15:04"),
+			End:   now.Add(time.Hour).Format("15:04"),
+		},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// a non-critical vulnerability is deferred to the end of the window
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":5.0}}`)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 1)
+	require.Equal(t, freescoutName, queued[0].Name)
+	require.WithinDuration(t, now.Add(time.Hour).Truncate(time.Minute), queued[0].NotBefore, time.Minute)
+	var queuedArgs freeScoutArgs
+	require.NoError(t, json.Unmarshal(*queued[0].Args, &queuedArgs))
+	require.Equal(t, "CVE-2024-1234", queuedArgs.Vulnerability.CVE)
+
+	// critical and known exploited vulnerabilities bypass the quiet hours
+	queued = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1235","cvss_score":9.8}}`)))
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1236","cvss_score":5.0,"cisa_known_exploit":true}}`)))
+	require.Len(t, client.conversations, 2)
+	require.Empty(t, queued)
+
+	// unless the bypass is configured otherwise
+	intg.QuietHours.BypassCVSSScore = 10
+	intg.QuietHours.DeferKnownExploited = true
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1235","cvss_score":9.8}}`)))
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1236","cvss_score":5.0,"cisa_known_exploit":true}}`)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 2)
+
+	// failing policies are not subject to the quiet hours
+	intg.EnableFailingPolicies = true
+	queued = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, queued)
+}
+
+func TestFreeScoutRunVulnHostScope(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		VulnHostTeamIDs:               []uint{1},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{
+			{ID: 1, DisplayName: "h1"},
+			{ID: 2, DisplayName: "h2"},
+			{ID: 3, DisplayName: "h3"},
+		}, nil
+	}
+	hostTeams := map[uint]uint{1: 1, 2: 2, 3: 1}
+	ds.FilterHostIDsByTeamsOrLabelsFunc = func(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error) {
+		require.Empty(t, labelIDs)
+		var ids []uint
+		for _, id := range hostIDs {
+			if slices.Contains(teamIDs, hostTeams[id]) {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const payload = `{"vulnerability":{"cve":"CVE-2024-1234"}}`
+
+	// only the hosts of the team are listed and counted
+	require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+	require.True(t, ds.FilterHostIDsByTeamsOrLabelsFuncInvoked)
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, "Vulnerability CVE-2024-1234 detected on 2 host(s)", client.conversations[0].Subject)
+	require.Contains(t, client.conversations[0].Message, "h1")
+	require.Contains(t, client.conversations[0].Message, "h3")
+	require.NotContains(t, client.conversations[0].Message, "h2")
+
+	// no conversation is created if no host is in scope
+	intg.VulnHostTeamIDs = []uint{3}
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+	require.Empty(t, client.conversations)
+
+	// all hosts are reported without a scope
+	intg.VulnHostTeamIDs = nil
+	ds.FilterHostIDsByTeamsOrLabelsFuncInvoked = false
+	require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+	require.False(t, ds.FilterHostIDsByTeamsOrLabelsFuncInvoked)
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, "Vulnerability CVE-2024-1234 detected on 3 host(s)", client.conversations[0].Subject)
+}
+
+func TestFreeScoutRunPriorityTags(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		PriorityTags: map[string]string{
+			fleet.FreeScoutPriorityHigh:   "priority-high",
+			fleet.FreeScoutPriorityNormal: "priority-normal",
+		},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// known exploited
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0001","cisa_known_exploit":true}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, []string{"priority-high"}, client.conversations[0].Tags)
+
+	// not known exploited
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0002","cisa_known_exploit":false}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, []string{"priority-normal"}, client.conversations[1].Tags)
+
+	// unknown is normal, and unmapped priorities are not tagged
+	delete(intg.PriorityTags, fleet.FreeScoutPriorityNormal)
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0003"}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 3)
+	require.Empty(t, client.conversations[2].Tags)
+
+	// appending to an existing conversation keeps its tags
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0002","cisa_known_exploit":true}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 4)
+	require.Equal(t, []string{"priority-normal"}, client.conversations[1].Tags)
+	require.Empty(t, client.conversations[3].Tags)
+}
+
+func TestFreeScoutRunIdentifierTags(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		EnableFailingPolicies:         true,
+		PriorityTags:                  map[string]string{fleet.FreeScoutPriorityNormal: "priority-normal"},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return hosts, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const vuln = `{"vulnerability":{"cve":"CVE-2024-1234"}}`
+
+	// without identifier tags, a new subject creates a new conversation
+	require.NoError(t, job.Run(ctx, json.RawMessage(vuln)))
+	hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: 2, DisplayName: "h2"})
+	require.NoError(t, job.Run(ctx, json.RawMessage(vuln)))
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, []string{"priority-normal"}, client.conversations[0].Tags)
+	require.Equal(t, []string{"priority-normal"}, client.conversations[1].Tags)
+
+	// the identifier tag is attached on creation, with the other tags
+	client.conversations = nil
+	hosts = hosts[:1]
+	intg.IdentifierTags = true
+	require.NoError(t, job.Run(ctx, json.RawMessage(vuln)))
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, "Vulnerability CVE-2024-1234 detected on 1 host(s)", client.conversations[0].Subject)
+	require.Equal(t, []string{"fleet-cve:CVE-2024-1234", "priority-normal"}, client.conversations[0].Tags)
+
+	// and the conversation is found by it, whatever its subject
+	hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: 2, DisplayName: "h2"})
+	require.NoError(t, job.Run(ctx, json.RawMessage(vuln)))
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, "Vulnerability CVE-2024-1234 detected on 2 host(s)", client.conversations[1].Subject)
+	require.Empty(t, client.conversations[1].Tags)
+	require.Equal(t, []string{"fleet-cve:CVE-2024-1234", "priority-normal"}, client.conversations[0].Tags)
+
+	// likewise for the failing policies
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 42, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)))
+	require.Len(t, client.conversations, 3)
+	require.Equal(t, []string{"fleet-policy:42"}, client.conversations[2].Tags)
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 42, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}, {"id": 2, "hostname": "h2"}]}}`)))
+	require.Len(t, client.conversations, 4)
+	require.Empty(t, client.conversations[3].Tags)
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 43, "policy_name": "p2", "hosts": [{"id": 1, "hostname": "h1"}]}}`)))
+	require.Len(t, client.conversations, 5)
+	require.Equal(t, []string{"fleet-policy:43"}, client.conversations[4].Tags)
+}
+
+func TestFreeScoutRunVulnResolved(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		IdentifierTags:                true,
+		AssignTo:                      3,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	var hosts []fleet.HostVulnerabilitySummary
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return hosts, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	run := func(cve string) {
+		require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"`+cve+`"}}`)))
+	}
+
+	hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+	run("CVE-2024-0001")
+	require.Len(t, client.conversations, 1)
+
+	t.Run("note", func(t *testing.T) {
+		intg.VulnResolvedAction = fleet.FreeScoutVulnResolvedNote
+		hosts = nil
+		run("CVE-2024-0001")
+		require.Len(t, client.conversations, 2)
+		resolved := client.conversations[1]
+		require.Equal(t, client.conversations[0].Subject, resolved.Subject)
+		require.Regexp(t, `^No longer detected on any host as of \d{4}-\d{2}-\d{2} \d{2}:\d{2} UTC\.$`, resolved.Message)
+		require.Zero(t, client.conversations[0].ClosedBy)
+
+		// not resolved again while it affects no host, even without a report cooldown
+		require.Zero(t, intg.ReportCooldown.Duration)
+		run("CVE-2024-0001")
+		require.Len(t, client.conversations, 2)
+
+		// a CVE without a conversation is skipped
+		run("CVE-2024-0002")
+		require.Len(t, client.conversations, 2)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		client.conversations = nil
+		hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+		run("CVE-2024-0003")
+		require.Len(t, client.conversations, 1)
+
+		intg.VulnResolvedAction = fleet.FreeScoutVulnResolvedClose
+		intg.Locale = "fr"
+		hosts = nil
+		run("CVE-2024-0003")
+		require.Len(t, client.conversations, 2)
+		require.Contains(t, client.conversations[1].Message, "N'est plus détectée sur aucun hôte depuis le ")
+		// closed on behalf of the note user, or else of the assignee
+		require.EqualValues(t, 3, client.conversations[0].ClosedBy)
+
+		// resolved again once it was reported again
+		intg.NoteUserID = 5
+		hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+		run("CVE-2024-0003")
+		n := len(client.conversations)
+		hosts = nil
+		run("CVE-2024-0003")
+		require.Len(t, client.conversations, n+1)
+		require.EqualValues(t, 5, client.conversations[0].ClosedBy)
+	})
+
+	t.Run("out of scope", func(t *testing.T) {
+		client.conversations = nil
+		hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+		run("CVE-2024-0005")
+		require.Len(t, client.conversations, 1)
+
+		// the CVE is still detected on a host out of the teams of the
+		// integration, it is neither resolved nor reported
+		intg.VulnResolvedAction = fleet.FreeScoutVulnResolvedClose
+		intg.VulnHostTeamIDs = []uint{1}
+		ds.FilterHostIDsByTeamsOrLabelsFunc = func(ctx context.Context, hostIDs []uint, teamIDs []uint, labelIDs []uint) ([]uint, error) {
+			return nil, nil
+		}
+		run("CVE-2024-0005")
+		require.True(t, ds.FilterHostIDsByTeamsOrLabelsFuncInvoked)
+		require.Len(t, client.conversations, 1)
+		require.Zero(t, client.conversations[0].ClosedBy)
+
+		// and it is resolved once it affects no host at all
+		hosts = nil
+		run("CVE-2024-0005")
+		require.Len(t, client.conversations, 2)
+		require.NotZero(t, client.conversations[0].ClosedBy)
+		intg.VulnHostTeamIDs = nil
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		// without identifier tags, the conversation cannot be found
+		client.conversations = nil
+		hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+		run("CVE-2024-0004")
+		intg.IdentifierTags = false
+		hosts = nil
+		run("CVE-2024-0004")
+		require.Len(t, client.conversations, 1)
+		require.Zero(t, client.conversations[0].ClosedBy)
+	})
+}
+
+func TestFreeScoutConversationTag(t *testing.T) {
+	cases := []struct {
+		args freeScoutArgs
+		want string
+	}{
+		{freeScoutArgs{Vulnerability: &vulnArgs{CVE: "CVE-2024-1234"}}, "fleet-cve:CVE-2024-1234"},
+		{freeScoutArgs{Vulnerability: &vulnArgs{}}, ""},
+		{freeScoutArgs{FailingPolicy: &failingPolicyArgs{PolicyID: 42}}, "fleet-policy:42"},
+		{freeScoutArgs{FailingPolicy: &failingPolicyArgs{PolicyID: 42, TeamID: ptr.Uint(7)}}, "fleet-policy:42:team:7"},
+		{freeScoutArgs{FailingPolicies: &freeScoutFailingPoliciesArgs{TeamID: ptr.Uint(7), Policies: []failingPolicyArgs{{PolicyID: 42}}}}, ""},
+		{freeScoutArgs{Digest: &freeScoutDigestArgs{}}, ""},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, c.args.conversationTag())
+	}
+}
+
+func TestFreeScoutRunRefreshCVEMeta(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true},
+			},
+		}}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	t.Run("refresh succeeds", func(t *testing.T) {
+		client.conversations = nil
+		ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+			require.Equal(t, "CVE-0001", cve)
+			return &fleet.CVEMeta{CVE: cve, CVSSScore: ptr.Float64(9.8), EPSSProbability: ptr.Float64(0.5), CISAKnownExploit: ptr.Bool(true)}, nil
+		}
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0001"}}`))
+		require.NoError(t, err)
+		require.True(t, ds.GetCVEMetaFuncInvoked)
+		require.Len(t, client.conversations, 1)
+		require.Contains(t, client.conversations[0].Message, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 9.8")
+		require.Contains(t, client.conversations[0].Message, "Known exploits (reported by [CISA](https://www.cisa.gov/known-exploited-vulnerabilities-catalog)): Yes")
+	})
+
+	t.Run("refresh fails", func(t *testing.T) {
+		client.conversations = nil
+		ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+			return nil, errors.New("boom")
+		}
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0002"}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.NotContains(t, client.conversations[0].Message, "CVSS score")
+		require.NotContains(t, client.conversations[0].Message, "Known exploits")
+	})
+
+	t.Run("job with metadata is not refreshed", func(t *testing.T) {
+		client.conversations = nil
+		ds.GetCVEMetaFuncInvoked = false
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-0003","cvss_score":5.5}}`))
+		require.NoError(t, err)
+		require.False(t, ds.GetCVEMetaFuncInvoked)
+		require.Len(t, client.conversations, 1)
+		require.Contains(t, client.conversations[0].Message, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 5.5")
+	})
+}
+
+func TestFreeScoutRunConversationCounts(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, EnableFailingPolicies: true},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	var buf bytes.Buffer
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewLogfmtLogger(&buf),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+
+	run := func(payload string) string {
+		buf.Reset()
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	out := run(`{"vulnerability":{"cve":"CVE-1234-5678"}}`)
+	require.Contains(t, out, "created=true conversations_created=1 threads_appended=0")
+
+	// same CVE, the message is appended to the existing conversation
+	out = run(`{"vulnerability":{"cve":"CVE-1234-5678"}}`)
+	require.Contains(t, out, "created=false conversations_created=1 threads_appended=1")
+
+	out = run(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Contains(t, out, "created=true conversations_created=2 threads_appended=1")
+
+	created, appended := job.ConversationCounts()
+	require.EqualValues(t, 2, created)
+	require.EqualValues(t, 1, appended)
+}
+
+func TestFreeScoutRunPaused(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, Paused: true}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	var buf bytes.Buffer
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewLogfmtLogger(&buf),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+
+	// paused, the job succeeds without creating a conversation
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.False(t, ds.HostsByCVEFuncInvoked)
+	require.Empty(t, client.conversations)
+	require.Contains(t, buf.String(), "freescout integration is paused, skipping job")
+
+	// resumed, the conversation is created
+	intg.Paused = false
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.True(t, ds.HostsByCVEFuncInvoked)
+	require.Len(t, client.conversations, 1)
+}
+
+// slowFreeScoutClient simulates a client that retries its requests, each
+// attempt taking delay unless the context is done first.
+type slowFreeScoutClient struct {
+	mockFreeScoutClient
+	attempts int
+	delay    time.Duration
+	calls    int
+}
+
+func (c *slowFreeScoutClient) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
+	for i := 0; i < c.attempts; i++ {
+		c.calls++
+		select {
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		case <-time.After(c.delay):
+		}
+	}
+	return c.mockFreeScoutClient.CreateFreeScoutConversation(ctx, subject, message, attachments...)
+}
+
+func TestFreeScoutRunRenderMetrics(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                   "https://freescout.example.com",
+		MailboxID:             1,
+		EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	policy := failingPolicyArgs{PolicyID: 1, PolicyName: "p1"}
+	for i := 1; i <= 60; i++ {
+		policy.Hosts = append(policy.Hosts, fleet.PolicySetHost{ID: uint(i), Hostname: fmt.Sprintf("h%d", i), DisplayName: fmt.Sprintf("h%d", i)})
+	}
+	payload, err := json.Marshal(freeScoutArgs{FailingPolicy: &policy})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&buf))
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	metricsLine := func() string {
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(line, `msg="rendered freescout conversation"`) {
+				return line
+			}
+		}
+		t.Fatal("render metrics not logged")
+		return ""
+	}
+
+	// all hosts are reported, only the maximum number is listed
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 1)
+	line := metricsLine()
+	require.Contains(t, line, "type=failingPolicy")
+	require.Contains(t, line, "hosts_reported=60 hosts_listed=50 hosts_truncated=10")
+	require.Contains(t, line, fmt.Sprintf("description_bytes=%d description_max_bytes=%d", len(client.conversations[0].Message), defaultFreeScoutMaxDescriptionBytes))
+
+	// shrinking the description to its maximum size lists fewer hosts
+	intg.MaxDescriptionBytes = 1000
+	buf.Reset()
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 1)
+	line = metricsLine()
+	var reported, listed, truncated int
+	_, err = fmt.Sscanf(line[strings.Index(line, "hosts_reported="):], "hosts_reported=%d hosts_listed=%d hosts_truncated=%d", &reported, &listed, &truncated)
+	require.NoError(t, err)
+	require.Equal(t, 60, reported)
+	require.Less(t, listed, 50)
+	require.Equal(t, reported-listed, truncated)
+	require.Equal(t, listed, strings.Count(client.conversations[0].Message, "\n* [h"))
+	require.Contains(t, line, fmt.Sprintf("description_bytes=%d description_max_bytes=1000", len(client.conversations[0].Message)))
+}
+
+func TestFreeScoutRunPolicyAssignees(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                     "https://freescout.example.com",
+		MailboxID:               1,
+		EnableFailingPolicies:   true,
+		AssignTo:                3,
+		FailingPoliciesAssignTo: 4,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	var client *mockFreeScoutClient
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		client = &mockFreeScoutClient{opts: *opts}
+		return client, nil
+	}
+	ctx := context.Background()
+
+	run := func(payload string) int64 {
+		require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+		require.NotEmpty(t, client.conversations)
+		return client.conversations[len(client.conversations)-1].AssignTo
+	}
+	const (
+		critical    = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "policy_critical": true, "hosts": [{"id": 1, "hostname": "h1"}]}}`
+		normal      = `{"failing_policy":{"policy_id": 2, "policy_name": "p2", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+		batchMixed  = `{"failing_policies":{"policies":[{"policy_id": 1, "policy_name": "p1", "policy_critical": true, "hosts": [{"id": 1, "hostname": "h1"}]}, {"policy_id": 2, "policy_name": "p2", "hosts": [{"id": 1, "hostname": "h1"}]}]}}`
+		batchNormal = `{"failing_policies":{"policies":[{"policy_id": 2, "policy_name": "p2", "hosts": [{"id": 1, "hostname": "h1"}]}, {"policy_id": 3, "policy_name": "p3", "hosts": [{"id": 1, "hostname": "h1"}]}]}}`
+	)
+
+	// without a mapping, the assignee of the failing policies is used
+	require.EqualValues(t, 4, run(critical))
+	require.EqualValues(t, 4, run(normal))
+
+	// with a mapping, the assignee depends on the criticality
+	intg.PolicyAssignees = &fleet.FreeScoutPolicyAssignees{Critical: 7, Normal: 8}
+	require.EqualValues(t, 7, run(critical))
+	require.EqualValues(t, 8, run(normal))
+	require.EqualValues(t, 7, run(batchMixed))
+	require.EqualValues(t, 8, run(batchNormal))
+
+	// the non-critical policies can be left unassigned
+	intg.PolicyAssignees = &fleet.FreeScoutPolicyAssignees{Critical: 7}
+	require.EqualValues(t, 7, run(critical))
+	require.Zero(t, run(normal))
+}
+
+func TestFreeScoutRunSeverityAssignees(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		AssignTo:                      3,
+		VulnAssignTo:                  4,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	var client *mockFreeScoutClient
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		client = &mockFreeScoutClient{opts: *opts}
+		return client, nil
+	}
+	ctx := context.Background()
+
+	run := func(payload string) int64 {
+		require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+		require.NotEmpty(t, client.conversations)
+		return client.conversations[len(client.conversations)-1].AssignTo
+	}
+	const (
+		critical = `{"vulnerability":{"cve":"CVE-0001","cvss_score":9.8}}`
+		high     = `{"vulnerability":{"cve":"CVE-0002","cvss_score":7.5}}`
+		medium   = `{"vulnerability":{"cve":"CVE-0003","cvss_score":5}}`
+		low      = `{"vulnerability":{"cve":"CVE-0004","cvss_score":2.1}}`
+		none     = `{"vulnerability":{"cve":"CVE-0005","cvss_score":0}}`
+		unknown  = `{"vulnerability":{"cve":"CVE-0006"}}`
+	)
+
+	// without a mapping, the assignee of the vulnerabilities is used
+	require.EqualValues(t, 4, run(critical))
+	require.EqualValues(t, 4, run(unknown))
+
+	// with a mapping, the assignee depends on the severity band
+	intg.SeverityAssignees = map[string]int64{
+		"critical": 7,
+		"high":     7,
+		"medium":   8,
+		"low":      0,
+		"none":     9,
+		"unknown":  10,
+	}
+	require.EqualValues(t, 7, run(critical))
+	require.EqualValues(t, 7, run(high))
+	require.EqualValues(t, 8, run(medium))
+	require.Zero(t, run(low))
+	require.EqualValues(t, 9, run(none))
+	require.EqualValues(t, 10, run(unknown))
+
+	// the bands that are not mapped use the assignee of the vulnerabilities,
+	// including the CVEs without a CVSS score
+	intg.SeverityAssignees = map[string]int64{"critical": 7}
+	require.EqualValues(t, 7, run(critical))
+	require.EqualValues(t, 4, run(medium))
+	require.EqualValues(t, 4, run(unknown))
+}
+
+func TestFreeScoutRunNoIntegration(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+	ctx := context.Background()
+	const payload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	run := func(behavior string, retries int, args json.RawMessage) (string, error) {
+		var buf bytes.Buffer
+		job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&buf))
+		job.NoIntegrationBehavior = behavior
+		job.NoIntegrationRetries = retries
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			t.Fatal("no client must be created")
+			return nil, nil
+		}
+		err := job.Run(ctx, args)
+		return buf.String(), err
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		queued = nil
+		for _, behavior := range []string{"", FreeScoutNoIntegrationSkip} {
+			out, err := run(behavior, 0, json.RawMessage(payload))
+			require.NoError(t, err)
+			require.NotContains(t, out, "no freescout integration enabled")
+		}
+		require.Empty(t, queued)
+	})
+
+	t.Run("log", func(t *testing.T) {
+		queued = nil
+		out, err := run(FreeScoutNoIntegrationLog, 0, json.RawMessage(payload))
+		require.NoError(t, err)
+		require.Contains(t, out, `msg="no freescout integration enabled, dropping job"`)
+		require.Contains(t, out, "type=failingPolicy")
+		require.Empty(t, queued)
+	})
+
+	t.Run("requeue", func(t *testing.T) {
+		queued = nil
+		args := json.RawMessage(payload)
+		for i := 1; i <= 2; i++ {
+			out, err := run(FreeScoutNoIntegrationRequeue, 2, args)
+			require.NoError(t, err)
+			require.Contains(t, out, `msg="no freescout integration enabled, deferring job"`)
+			require.Len(t, queued, i)
+			require.WithinDuration(t, time.Now().Add(freeScoutNoIntegrationRetryDelay), queued[i-1].NotBefore, 10*time.Second)
+
+			var queuedArgs freeScoutArgs
+			require.NoError(t, json.Unmarshal(*queued[i-1].Args, &queuedArgs))
+			require.Equal(t, i, queuedArgs.NoIntegrationAttempts)
+			require.NotNil(t, queuedArgs.FailingPolicy)
+			args = *queued[i-1].Args
+		}
+
+		// the job fails once the retries are exhausted
+		_, err := run(FreeScoutNoIntegrationRequeue, 2, args)
+		require.ErrorIs(t, err, errFreeScoutNoIntegration)
+		require.Len(t, queued, 2)
+	})
+
+	require.NoError(t, ValidateFreeScoutNoIntegrationBehavior(""))
+	require.NoError(t, ValidateFreeScoutNoIntegrationBehavior(FreeScoutNoIntegrationRequeue))
+	require.ErrorContains(t, ValidateFreeScoutNoIntegrationBehavior("drop"), `invalid FreeScout no integration behavior "drop"`)
+}
+
+func TestFreeScoutRunDrain(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+	pending := make([]*fleet.FreeScoutDigestEvent, freeScoutDigestMaxEvents+3)
+	for i := range pending {
+		pending[i] = &fleet.FreeScoutDigestEvent{ID: uint(i + 1)}
+	}
+	ds.ListFreeScoutDigestEventsFunc = func(ctx context.Context, teamID uint, limit int) ([]*fleet.FreeScoutDigestEvent, error) {
+		return pending[:min(limit, len(pending))], nil
+	}
+	var deleted []uint
+	ds.DeleteFreeScoutDigestEventsFunc = func(ctx context.Context, ids []uint) error {
+		deleted = append(deleted, ids...)
+		pending = pending[len(ids):]
+		return nil
+	}
+
+	client := &mockFreeScoutClient{}
+	var buf bytes.Buffer
+	job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&buf))
+	job.NoIntegrationBehavior = FreeScoutNoIntegrationRequeue
+	job.NoIntegrationRetries = 3
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const policy = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+	const vuln = `{"vulnerability":{"cve":"CVE-2024-1234"}}`
+
+	// a disabled integration type without drain is handled as configured,
+	// here re-queued
+	require.NoError(t, job.Run(ctx, json.RawMessage(policy)))
+	require.Len(t, queued, 1)
+	require.Zero(t, job.DrainedJobs())
+
+	// with drain, the jobs of the disabled integration types are discarded
+	queued = nil
+	intg.Drain = true
+	for i := 1; i <= 2; i++ {
+		buf.Reset()
+		require.NoError(t, job.Run(ctx, json.RawMessage(policy)))
+		require.Contains(t, buf.String(), `msg="freescout integration is draining, discarding job"`)
+		require.Contains(t, buf.String(), "type=failingPolicy")
+		require.Contains(t, buf.String(), fmt.Sprintf("jobs_drained=%d", i))
+		require.EqualValues(t, i, job.DrainedJobs())
+	}
+	require.Empty(t, queued)
+	require.Empty(t, client.conversations)
+
+	// along with the pending events of a digest, once all the integration
+	// types are disabled
+	intg.EnableSoftwareVulnerabilities = false
+	buf.Reset()
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"digest":{}}`)))
+	require.Empty(t, pending)
+	require.Len(t, deleted, freeScoutDigestMaxEvents+3)
+	require.Contains(t, buf.String(), fmt.Sprintf("digest_events_discarded=%d", freeScoutDigestMaxEvents+3))
+	require.EqualValues(t, 3, job.DrainedJobs())
+	require.Empty(t, queued)
+	require.Empty(t, client.conversations)
+
+	// the enabled integration types are still processed
+	intg.EnableSoftwareVulnerabilities = true
+	require.NoError(t, job.Run(ctx, json.RawMessage(vuln)))
+	require.Len(t, client.conversations, 1)
+	require.EqualValues(t, 3, job.DrainedJobs())
+}
+
+// rateLimitedFreeScoutClient fails the creation of the first conversation as
+// rate limited for retryAfter.
+type rateLimitedFreeScoutClient struct {
+	mockFreeScoutClient
+	retryAfter time.Duration
+	limited    bool
+}
+
+func (c *rateLimitedFreeScoutClient) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
+	if !c.limited {
+		c.limited = true
+		return 0, false, &externalsvc.FreeScoutRetryAfterError{RetryAfter: c.retryAfter, Err: errors.New("status 429")}
+	}
+	return c.mockFreeScoutClient.CreateFreeScoutConversation(ctx, subject, message, attachments...)
+}
+
+func TestFreeScoutRunRetryAfter(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                   "https://freescout.example.com",
+		MailboxID:             1,
+		EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	client := &rateLimitedFreeScoutClient{retryAfter: 2 * time.Minute}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const payload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	// the rate limited job succeeds and is re-queued after the Retry-After
+	require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 1)
+	require.Equal(t, freescoutName, queued[0].Name)
+	require.WithinDuration(t, time.Now().Add(2*time.Minute), queued[0].NotBefore, 10*time.Second)
+	var queuedArgs freeScoutArgs
+	require.NoError(t, json.Unmarshal(*queued[0].Args, &queuedArgs))
+	require.NotNil(t, queuedArgs.FailingPolicy)
+	require.EqualValues(t, 1, queuedArgs.FailingPolicy.PolicyID)
+
+	// and creates the conversation when it runs again
+	require.NoError(t, job.Run(ctx, *queued[0].Args))
+	require.Len(t, client.conversations, 1)
+	require.Len(t, queued, 1)
+}
+
+// maintenanceFreeScoutClient fails the creation of the first conversation as
+// FreeScout being in maintenance mode.
+type maintenanceFreeScoutClient struct {
+	mockFreeScoutClient
+	failed bool
+}
+
+func (c *maintenanceFreeScoutClient) CreateFreeScoutConversation(ctx context.Context, subject, message string, attachments ...externalsvc.FreeScoutAttachment) (int64, bool, error) {
+	if !c.failed {
+		c.failed = true
+		return 0, false, fmt.Errorf("%w: status 503", externalsvc.ErrFreeScoutMaintenance)
+	}
+	return c.mockFreeScoutClient.CreateFreeScoutConversation(ctx, subject, message, attachments...)
+}
+
+func TestFreeScoutRunMaintenance(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                   "https://freescout.example.com",
+		MailboxID:             1,
+		EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	client := &maintenanceFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const payload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	// the job succeeds and is re-queued after the maintenance delay
+	require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+	require.Empty(t, client.conversations)
+	require.Len(t, queued, 1)
+	require.Equal(t, freescoutName, queued[0].Name)
+	require.WithinDuration(t, time.Now().Add(freeScoutMaintenanceRetryDelay), queued[0].NotBefore, 10*time.Second)
+
+	// and creates the conversation when it runs again
+	require.NoError(t, job.Run(ctx, *queued[0].Args))
+	require.Len(t, client.conversations, 1)
+	require.Len(t, queued, 1)
+}
+
+func TestFreeScoutRunJobDeadline(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		JobDeadline:                   fleet.Duration{Duration: 100 * time.Millisecond},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &slowFreeScoutClient{attempts: 3, delay: 20 * time.Millisecond}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// the attempts fit in the deadline
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 1)
+
+	// cumulative attempts exceed the deadline
+	client.attempts, client.calls = 10, 0
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.ErrorIs(t, err, errFreeScoutJobDeadlineExceeded)
+	require.ErrorContains(t, err, "freescout job deadline exceeded")
+	require.Less(t, client.calls, 10)
+	require.Len(t, client.conversations, 1)
+}
+
+func TestFreeScoutRunReportedHostsDelta(t *testing.T) {
+	var hosts []fleet.HostVulnerabilitySummary
+	ds := new(mock.Store)
+	reports := mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return hosts, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	newJob := func() *FreeScout {
+		job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		}
+		return job
+	}
+	ctx := context.Background()
+
+	// first run, all hosts are new and no counts are rendered
+	hosts = []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}, {ID: 2, DisplayName: "h2"}}
+	err := newJob().Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 1)
+	require.NotContains(t, client.conversations[0].Message, "Previously reported")
+	require.Equal(t, []uint{1, 2}, reports[freeScoutVulnReportKey("CVE-1234-5678")])
+
+	// subsequent run of another job processor, e.g. after a restart, one host
+	// is still affected and two are new
+	hosts = []fleet.HostVulnerabilitySummary{{ID: 2, DisplayName: "h2"}, {ID: 3, DisplayName: "h3"}, {ID: 4, DisplayName: "h4"}}
+	err = newJob().Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 2)
+	require.Contains(t, client.conversations[1].Message, "Previously reported: 1 host(s). Newly affected: 2 host(s).")
+
+	// the reported hosts are now those of the last run
+	require.Equal(t, []uint{2, 3, 4}, reports[freeScoutVulnReportKey("CVE-1234-5678")])
+
+	// other CVEs are tracked separately
+	delta, err := newJob().reportedHostsDelta(ctx, freeScoutVulnReportKey("CVE-1234-0000"), []uint{1})
+	require.NoError(t, err)
+	require.Nil(t, delta)
+
+	// failing to load the reported hosts fails the job before reporting
+	ds.CountFreeScoutReportedHostsFunc = func(ctx context.Context, key string, hostIDs []uint) (int, error) {
+		return 0, errors.New("db error")
+	}
+	err = newJob().Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.ErrorContains(t, err, "db error")
+	require.Len(t, client.conversations, 2)
+}
+
+// pagedHostsStore is a datastore that implements
+// fleet.HostVulnSummariesPager over a synthetic set of affected hosts with IDs
+// 1 to total.
+type pagedHostsStore struct {
+	*mock.Store
+	total  int
+	pages  int
+	counts int
+}
+
+func (s *pagedHostsStore) CountHostVulnSummariesBySoftwareIDs(ctx context.Context, softwareIDs []uint) (int, error) {
+	s.counts++
+	return s.total, nil
+}
+
+func (s *pagedHostsStore) CountFreeScoutReportedHostsBySoftwareIDs(ctx context.Context, key string, softwareIDs []uint) (int, error) {
+	return s.Store.CountFreeScoutReportedHosts(ctx, key, s.hostIDs())
+}
+
+func (s *pagedHostsStore) SetFreeScoutReportBySoftwareIDs(ctx context.Context, report *fleet.FreeScoutReport, softwareIDs []uint) error {
+	return s.Store.SetFreeScoutReport(ctx, report, s.hostIDs())
+}
+
+func (s *pagedHostsStore) hostIDs() []uint {
+	ids := make([]uint, 0, s.total)
+	for id := 1; id <= s.total; id++ {
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+func (s *pagedHostsStore) HostVulnSummariesBySoftwareIDsPage(ctx context.Context, softwareIDs []uint, afterHostID uint, limit int) ([]fleet.HostVulnerabilitySummary, error) {
+	s.pages++
+	var page []fleet.HostVulnerabilitySummary
+	for id := afterHostID + 1; id <= uint(s.total) && len(page) < limit; id++ {
+		page = append(page, fleet.HostVulnerabilitySummary{
+			ID:                     id,
+			DisplayName:            fmt.Sprintf("h%d", id),
+			SoftwareInstalledPaths: []string{"/some/path"},
+		})
+	}
+	return page, nil
+}
+
+func syntheticFreeScoutHosts(n int) []fleet.HostVulnerabilitySummary {
+	hosts := make([]fleet.HostVulnerabilitySummary, 0, n)
+	for i := 1; i <= n; i++ {
+		hosts = append(hosts, fleet.HostVulnerabilitySummary{
+			ID:                     uint(i),
+			DisplayName:            fmt.Sprintf("h%d", i),
+			SoftwareInstalledPaths: []string{"/some/path"},
+		})
+	}
+	return hosts
+}
+
+func TestFreeScoutRunPagedHosts(t *testing.T) {
+	const total = 10*freeScoutHostsPageSize + 1
+
+	newStore := func(cooldown time.Duration) *mock.Store {
+		ds := new(mock.Store)
+		mockFreeScoutReports(ds)
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{Integrations: fleet.Integrations{
+				Freescout: []*fleet.FreeScoutIntegration{
+					{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, ReportCooldown: fleet.Duration{Duration: cooldown}},
+				},
+			}}, nil
+		}
+		ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+			return &fleet.CVEMeta{CVE: cve}, nil
+		}
+		ds.HostVulnSummariesBySoftwareIDsFunc = func(ctx context.Context, softwareIDs []uint) ([]fleet.HostVulnerabilitySummary, error) {
+			return syntheticFreeScoutHosts(total), nil
+		}
+		return ds
+	}
+	args := json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678","affected_software":[1]}}`)
+
+	run := func(t *testing.T, ds fleet.Datastore) (*mockFreeScoutClient, *FreeScout) {
+		client := &mockFreeScoutClient{}
+		job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		}
+		err := job.Run(context.Background(), args)
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		return client, job
+	}
+
+	t.Run("paged", func(t *testing.T) {
+		ms := newStore(0)
+		ds := &pagedHostsStore{Store: ms, total: total}
+		client, job := run(t, ds)
+		require.False(t, ms.HostVulnSummariesBySoftwareIDsFuncInvoked)
+
+		// only the listed hosts are loaded, the others are counted
+		require.Equal(t, 1, ds.pages)
+		require.Equal(t, 1, ds.counts)
+
+		conv := client.conversations[0]
+		require.Equal(t, fmt.Sprintf("Vulnerability CVE-1234-5678 detected on %d host(s)", total), conv.Subject)
+		require.Contains(t, conv.Message, "/hosts/50)")
+		require.NotContains(t, conv.Message, "/hosts/51)")
+
+		// all hosts are tracked, not only the listed ones
+		delta, err := job.reportedHostsDelta(context.Background(), freeScoutVulnReportKey("CVE-1234-5678"), []uint{1, total, total + 1})
+		require.NoError(t, err)
+		require.Equal(t, &FreeScoutHostsDelta{Previous: 2, New: 1}, delta)
+
+		// and counted by software on the next report
+		client, _ = run(t, ds)
+		require.Contains(t, client.conversations[0].Message, fmt.Sprintf("Previously reported: %d host(s). Newly affected: 0 host(s).", total))
+	})
+
+	t.Run("paged with cooldown", func(t *testing.T) {
+		ms := newStore(time.Hour)
+		ds := &pagedHostsStore{Store: ms, total: total}
+		client, job := run(t, ds)
+		require.False(t, ms.HostVulnSummariesBySoftwareIDsFuncInvoked)
+
+		// all hosts are paged through to compare them with the last report
+		require.Equal(t, 11, ds.pages)
+		require.Zero(t, ds.counts)
+
+		conv := client.conversations[0]
+		require.Equal(t, fmt.Sprintf("Vulnerability CVE-1234-5678 detected on %d host(s)", total), conv.Subject)
+		require.Contains(t, conv.Message, "/hosts/50)")
+		require.NotContains(t, conv.Message, "/hosts/51)")
+
+		delta, err := job.reportedHostsDelta(context.Background(), freeScoutVulnReportKey("CVE-1234-5678"), []uint{1, total, total + 1})
+		require.NoError(t, err)
+		require.Equal(t, &FreeScoutHostsDelta{Previous: 2, New: 1}, delta)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		ds := newStore(0)
+		client, _ := run(t, ds)
+		require.True(t, ds.HostVulnSummariesBySoftwareIDsFuncInvoked)
+
+		conv := client.conversations[0]
+		require.Equal(t, fmt.Sprintf("Vulnerability CVE-1234-5678 detected on %d host(s)", total), conv.Subject)
+		require.Contains(t, conv.Message, "/hosts/50)")
+		require.NotContains(t, conv.Message, "/hosts/51)")
+	})
+}
+
+func TestFreeScoutRunAffectedSoftwareFallback(t *testing.T) {
+	newStore := func(bySoftware []fleet.HostVulnerabilitySummary) *mock.Store {
+		ds := new(mock.Store)
+		mockFreeScoutReports(ds)
+		ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+			return &fleet.AppConfig{Integrations: fleet.Integrations{
+				Freescout: []*fleet.FreeScoutIntegration{
+					{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true},
+				},
+			}}, nil
+		}
+		ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+			return &fleet.CVEMeta{CVE: cve}, nil
+		}
+		ds.HostVulnSummariesBySoftwareIDsFunc = func(ctx context.Context, softwareIDs []uint) ([]fleet.HostVulnerabilitySummary, error) {
+			return bySoftware, nil
+		}
+		ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+			return syntheticFreeScoutHosts(3), nil
+		}
+		return ds
+	}
+	args := json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678","affected_software":[1,2]}}`)
+
+	run := func(t *testing.T, ds fleet.Datastore) (mockFreeScoutConversation, string) {
+		var logs bytes.Buffer
+		client := &mockFreeScoutClient{}
+		job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&logs))
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		}
+		err := job.Run(context.Background(), args)
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		return client.conversations[0], logs.String()
+	}
+	const warning = "no host found with the affected software of the cve"
+
+	t.Run("hosts found by software", func(t *testing.T) {
+		ds := newStore(syntheticFreeScoutHosts(1))
+		conv, logs := run(t, ds)
+		require.True(t, ds.HostVulnSummariesBySoftwareIDsFuncInvoked)
+		require.False(t, ds.HostsByCVEFuncInvoked)
+		require.Equal(t, "Vulnerability CVE-1234-5678 detected on 1 host(s)", conv.Subject)
+		require.NotContains(t, logs, warning)
+	})
+
+	t.Run("no hosts found by software", func(t *testing.T) {
+		ds := newStore(nil)
+		conv, logs := run(t, ds)
+		require.True(t, ds.HostVulnSummariesBySoftwareIDsFuncInvoked)
+		require.True(t, ds.HostsByCVEFuncInvoked)
+		require.Equal(t, "Vulnerability CVE-1234-5678 detected on 3 host(s)", conv.Subject)
+		require.Contains(t, logs, "level=warn")
+		require.Contains(t, logs, warning)
+		require.Contains(t, logs, `affected_software_ids="[1 2]"`)
+	})
+
+	t.Run("no hosts found by software pages", func(t *testing.T) {
+		ms := newStore(nil)
+		ds := &pagedHostsStore{Store: ms, total: 0}
+		conv, logs := run(t, ds)
+		require.Equal(t, 1, ds.counts)
+		require.Zero(t, ds.pages)
+		require.False(t, ms.HostVulnSummariesBySoftwareIDsFuncInvoked)
+		require.True(t, ms.HostsByCVEFuncInvoked)
+		require.Equal(t, "Vulnerability CVE-1234-5678 detected on 3 host(s)", conv.Subject)
+		require.Contains(t, logs, warning)
+	})
+
+	t.Run("hosts found by software pages", func(t *testing.T) {
+		ms := newStore(nil)
+		ds := &pagedHostsStore{Store: ms, total: 2}
+		conv, logs := run(t, ds)
+		require.False(t, ms.HostsByCVEFuncInvoked)
+		require.Equal(t, "Vulnerability CVE-1234-5678 detected on 2 host(s)", conv.Subject)
+		require.NotContains(t, logs, warning)
+	})
+}
+
+func BenchmarkFreeScoutAffectedHosts(b *testing.B) {
+	const total = 100_000
+	vargs := &vulnArgs{CVE: "CVE-1234-5678", AffectedSoftwareIDs: []uint{1}}
+
+	b.Run("paged", func(b *testing.B) {
+		job := newFreeScoutTestJob(&pagedHostsStore{Store: new(mock.Store), total: total}, kitlog.NewNopLogger())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := job.affectedHosts(context.Background(), nil, vargs, time.Time{}, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("paged all", func(b *testing.B) {
+		// the cooldown needs the IDs of all hosts
+		intg := &fleet.FreeScoutIntegration{ReportCooldown: fleet.Duration{Duration: time.Hour}}
+		job := newFreeScoutTestJob(&pagedHostsStore{Store: new(mock.Store), total: total}, kitlog.NewNopLogger())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := job.affectedHosts(context.Background(), intg, vargs, time.Time{}, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("full", func(b *testing.B) {
+		ds := new(mock.Store)
+		ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+			return &fleet.CVEMeta{CVE: cve}, nil
+		}
+		ds.HostVulnSummariesBySoftwareIDsFunc = func(ctx context.Context, softwareIDs []uint) ([]fleet.HostVulnerabilitySummary, error) {
+			return syntheticFreeScoutHosts(total), nil
+		}
+		job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := job.affectedHosts(context.Background(), nil, vargs, time.Time{}, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestFreeScoutRunExternalFleetURL(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, EnableFailingPolicies: true}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.FleetURL = "http://fleet.internal:8080"
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// not set, links use the FleetURL
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.Contains(t, client.conversations[0].Message, "(http://fleet.internal:8080/hosts/1)")
+
+	// set, links use the external URL
+	intg.ExternalFleetURL = "https://fleet.example.com/"
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-0000"}}`))
+	require.NoError(t, err)
+	require.Contains(t, client.conversations[1].Message, "(https://fleet.example.com/hosts/1)")
+	require.Contains(t, client.conversations[1].Message, "(https://fleet.example.com/software/manage)")
+	require.NotContains(t, client.conversations[1].Message, "fleet.internal")
+
+	err = job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 2, "hostname": "h2"}]}}`))
+	require.NoError(t, err)
+	require.Contains(t, client.conversations[2].Message, "(https://fleet.example.com/hosts/2)")
+	require.Contains(t, client.conversations[2].Message, "(https://fleet.example.com/hosts/manage/?")
+	require.NotContains(t, client.conversations[2].Message, "fleet.internal")
+}
+
+func TestFreeScoutRunReportCooldown(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{{
+				URL:                           "https://freescout.example.com",
+				MailboxID:                     1,
+				EnableSoftwareVulnerabilities: true,
+				EnableFailingPolicies:         true,
+				ReportCooldown:                fleet.Duration{Duration: 24 * time.Hour},
+			}},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return hosts, nil
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{
+			ID: tid,
+			Config: fleet.TeamConfigLite{
+				Integrations: fleet.TeamIntegrations{
+					Freescout: []*fleet.TeamFreeScoutIntegration{
+						{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true},
+					},
+				},
+			},
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	client := &mockFreeScoutClient{}
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// each run uses a new job, the cooldown must survive restarts
+	run := func(payload string) {
+		job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&buf))
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		}
+		job.Now = func() time.Time { return now }
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+	}
+	vulnPayload := `{"vulnerability":{"cve":"CVE-1234-5678"}}`
+	policyPayload := `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "team_id": 2, "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	run(vulnPayload)
+	run(policyPayload)
+	require.Len(t, client.conversations, 2)
+
+	// within the cooldown with the same hosts, skipped
+	run(vulnPayload)
+	run(policyPayload)
+	require.Len(t, client.conversations, 2)
+	require.Contains(t, buf.String(), "skipping cve reported within cooldown with unchanged hosts")
+	require.Contains(t, buf.String(), "skipping failing policy reported within cooldown with unchanged hosts")
+
+	// the same policy in another team is tracked separately
+	run(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Len(t, client.conversations, 3)
+
+	// within the cooldown with different hosts, reported
+	hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: 2, DisplayName: "h2"})
+	run(vulnPayload)
+	require.Len(t, client.conversations, 4)
+
+	// still within the cooldown just before it ends
+	now = now.Add(24*time.Hour - time.Second)
+	run(vulnPayload)
+	run(policyPayload)
+	require.Len(t, client.conversations, 4)
+
+	// outside the cooldown with the same hosts, reported
+	now = now.Add(time.Second)
+	run(vulnPayload)
+	run(policyPayload)
+	require.Len(t, client.conversations, 6)
+
+	// a failure to look up the last report fails the job
+	ds.GetFreeScoutReportFunc = func(ctx context.Context, key string) (*fleet.FreeScoutReport, error) {
+		return nil, errors.New("db error")
+	}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	err := job.Run(ctx, json.RawMessage(vulnPayload))
+	require.ErrorContains(t, err, "db error")
+	require.Len(t, client.conversations, 6)
+}
+
+func TestFreeScoutRunHostRiskWeights(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		hosts := make([]fleet.HostVulnerabilitySummary, 60)
+		for i := range hosts {
+			hosts[i] = fleet.HostVulnerabilitySummary{ID: uint(i + 1), DisplayName: fmt.Sprintf("h%d", i+1)}
+		}
+		hosts[57].SoftwareInstalledPaths = []string{"/a", "/b", "/c"}
+		return hosts, nil
+	}
+	ds.HostIssuesByHostIDsFunc = func(ctx context.Context, hostIDs []uint) (map[uint]fleet.HostIssues, error) {
+		require.Len(t, hostIDs, 60)
+		return map[uint]fleet.HostIssues{
+			60: {CriticalVulnerabilitiesCount: ptr.T(uint64(5))},
+			55: {FailingPoliciesCount: 3},
+		}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	payload := json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`)
+
+	// the first hosts are listed by default
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 1)
+	require.Contains(t, client.conversations[0].Message, "[h50]")
+	require.NotContains(t, client.conversations[0].Message, "[h60]")
+	require.False(t, ds.HostIssuesByHostIDsFuncInvoked)
+
+	// the riskiest hosts are listed first and survive the truncation
+	intg.HostRiskWeights = fleet.FreeScoutHostRiskWeights{CriticalVulnerabilities: 10, FailingPolicies: 2, VulnerableSoftware: 1}
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 2)
+	msg := client.conversations[1].Message
+	var positions []int
+	for _, name := range []string{"[h60]", "[h55]", "[h58]", "[h1]", "[h47]"} {
+		pos := strings.Index(msg, name)
+		require.NotEqual(t, -1, pos, name)
+		positions = append(positions, pos)
+	}
+	require.IsIncreasing(t, positions)
+	for _, name := range []string{"[h48]", "[h49]", "[h50]"} {
+		require.NotContains(t, msg, name)
+	}
+
+	// the host issues are not needed to weight the vulnerable software only
+	ds.HostIssuesByHostIDsFuncInvoked = false
+	intg.HostRiskWeights = fleet.FreeScoutHostRiskWeights{VulnerableSoftware: 1}
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 3)
+	require.False(t, ds.HostIssuesByHostIDsFuncInvoked)
+	require.Less(t, strings.Index(client.conversations[2].Message, "[h58]"), strings.Index(client.conversations[2].Message, "[h1]"))
+}
+
+func TestFreeScoutRunFrozenTime(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	published := time.Date(2024, 6, 7, 8, 0, 0, 0, time.UTC)
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve, Published: &published}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	job.Now = func() time.Time { return time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC) }
+
+	// the age of the CVE is rendered relative to the frozen time
+	require.NoError(t, job.Run(context.Background(), json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`)))
+	require.Len(t, client.conversations, 1)
+	require.Contains(t, client.conversations[0].Message, "(3 days ago)")
+}
+
+func TestFreeScoutRunDetectedAfter(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}, {ID: 2, DisplayName: "h2"}, {ID: 3, DisplayName: "h3"}}, nil
+	}
+	now := time.Now()
+	ds.CVEDetectedAtByHostIDsFunc = func(ctx context.Context, cve string, hostIDs []uint) (map[uint]time.Time, error) {
+		require.Equal(t, []uint{1, 2, 3}, hostIDs)
+		return map[uint]time.Time{
+			1: now.Add(-48 * time.Hour),
+			2: now.Add(-time.Hour),
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewLogfmtLogger(&buf))
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// no cutoff configured, all hosts are reported
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.False(t, ds.CVEDetectedAtByHostIDsFuncInvoked)
+	require.Len(t, client.conversations, 1)
+	require.Contains(t, client.conversations[0].Subject, "detected on 3 host(s)")
+
+	// lookback, only the host detected within it is reported
+	intg.DetectedLookback = fleet.Duration{Duration: 24 * time.Hour}
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+	require.NoError(t, err)
+	require.True(t, ds.CVEDetectedAtByHostIDsFuncInvoked)
+	require.Len(t, client.conversations, 2)
+	require.Contains(t, client.conversations[1].Subject, "detected on 1 host(s)")
+	require.Contains(t, client.conversations[1].Message, "/hosts/2)")
+
+	// published after all detections, no host left so the job is skipped
+	intg.DetectedAfterPublished = true
+	published := now.Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	err = job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678","cve_published":"`+published+`"}}`))
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 2)
+	require.Contains(t, buf.String(), "skipping, no host detected after cutoff")
+}
+
+func TestFreeScoutDetectedCutoff(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	published := now.Add(-2 * time.Hour)
+
+	_, ok := freeScoutDetectedCutoff(nil, &published, now)
+	require.False(t, ok)
+	_, ok = freeScoutDetectedCutoff(&fleet.FreeScoutIntegration{}, &published, now)
+	require.False(t, ok)
+	_, ok = freeScoutDetectedCutoff(&fleet.FreeScoutIntegration{DetectedAfterPublished: true}, nil, now)
+	require.False(t, ok)
+
+	cutoff, ok := freeScoutDetectedCutoff(&fleet.FreeScoutIntegration{DetectedAfterPublished: true}, &published, now)
+	require.True(t, ok)
+	require.Equal(t, published, cutoff)
+
+	lookback := &fleet.FreeScoutIntegration{DetectedLookback: fleet.Duration{Duration: time.Hour}}
+	cutoff, ok = freeScoutDetectedCutoff(lookback, &published, now)
+	require.True(t, ok)
+	require.Equal(t, now.Add(-time.Hour), cutoff)
+
+	// the latest cutoff applies
+	lookback.DetectedAfterPublished = true
+	cutoff, ok = freeScoutDetectedCutoff(lookback, &published, now)
+	require.True(t, ok)
+	require.Equal(t, now.Add(-time.Hour), cutoff)
+	lookback.DetectedLookback.Duration = 3 * time.Hour
+	cutoff, ok = freeScoutDetectedCutoff(lookback, &published, now)
+	require.True(t, ok)
+	require.Equal(t, published, cutoff)
+}
+
+func TestFreeScoutClientsCacheEviction(t *testing.T) {
+	ds := new(mock.Store)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true},
+			},
+		}}, nil
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{
+			ID: tid,
+			Config: fleet.TeamConfigLite{
+				Integrations: fleet.TeamIntegrations{
+					Freescout: []*fleet.TeamFreeScoutIntegration{
+						{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true},
+					},
+				},
+			},
+		}, nil
+	}
+
+	clients := make(map[string]*mockFreeScoutClient)
+	job := &FreeScout{
+		FleetURL:         "https://fleetdm.com",
+		Datastore:        ds,
+		Log:              kitlog.NewNopLogger(),
+		MaxCachedClients: 2,
+	}
+
+	ctx := context.Background()
+	getTeamClient := func(teamID uint) *mockFreeScoutClient {
+		job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			c := &mockFreeScoutClient{opts: *opts}
+			clients[fmt.Sprint(teamID)] = c
+			return c, nil
+		}
+		cli, _, err := job.getClient(ctx, freeScoutArgs{FailingPolicy: &failingPolicyArgs{TeamID: &teamID}})
+		require.NoError(t, err)
+		return cli.(*mockFreeScoutClient)
+	}
+
+	getTeamClient(1)
+	getTeamClient(2)
+	require.Equal(t, []string{"failingPolicy:2:1", "failingPolicy:1:1"}, job.clientsCache.keys())
+
+	// using team 1 again makes it the most recently used
+	getTeamClient(1)
+	require.Equal(t, []string{"failingPolicy:1:1", "failingPolicy:2:1"}, job.clientsCache.keys())
+
+	// adding team 3 evicts team 2, the least recently used
+	getTeamClient(3)
+	require.Equal(t, 2, job.clientsCache.len())
+	require.Equal(t, []string{"failingPolicy:3:1", "failingPolicy:1:1"}, job.clientsCache.keys())
+	require.True(t, clients["2"].closed)
+	require.False(t, clients["1"].closed)
+	require.False(t, clients["3"].closed)
+
+	// adding team 4 evicts team 1
+	getTeamClient(4)
+	require.Equal(t, []string{"failingPolicy:4:1", "failingPolicy:3:1"}, job.clientsCache.keys())
+	require.True(t, clients["1"].closed)
+
+	// team 2 was evicted, so a new client is created for it
+	oldTeam2 := clients["2"]
+	newTeam2 := getTeamClient(2)
+	require.NotSame(t, oldTeam2, newTeam2)
+	require.Equal(t, []string{"failingPolicy:2:1", "failingPolicy:4:1"}, job.clientsCache.keys())
+}
+
+func TestFreeScoutCachedClientConcurrent(t *testing.T) {
+	job := &FreeScout{Log: kitlog.NewNopLogger()}
+	var created atomic.Int64
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		created.Add(1)
+		return &mockFreeScoutClient{opts: *opts}, nil
+	}
+	ctx := context.Background()
+	opts := externalsvc.FreeScoutOptions{URL: "https://freescout.example.com", MailboxID: 1}
+
+	first, err := job.cachedClient(ctx, intgTypeVuln+":", &opts)
+	require.NoError(t, err)
+
+	// the cached client is reused by concurrent lookups, and keeps being
+	// used by the concurrent lookups of other keys.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			o := opts
+			if i%10 == 0 {
+				o.MailboxID = int64(i + 2)
+			}
+			cli, err := job.cachedClient(ctx, intgTypeVuln+":", &o)
+			assert.NoError(t, err)
+			if o.MailboxID == 1 {
+				assert.Same(t, first, cli)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.EqualValues(t, 6, created.Load())
+	require.Equal(t, 6, job.clientsCache.len())
+}
+
+// blockingCheckFreeScoutClient blocks its verification until release is
+// closed, after closing started.
+type blockingCheckFreeScoutClient struct {
+	*mockFreeScoutClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCheckFreeScoutClient) wait(ctx context.Context) error {
+	close(c.started)
+	select {
+	case <-c.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *blockingCheckFreeScoutClient) CheckScopes(ctx context.Context) error {
+	return c.wait(ctx)
+}
+
+func (c *blockingCheckFreeScoutClient) CheckMailboxType(ctx context.Context) error {
+	return c.wait(ctx)
+}
+
+func TestFreeScoutCachedClientVerifyWithoutLock(t *testing.T) {
+	cases := []struct {
+		name string
+		opts externalsvc.FreeScoutOptions
+	}{
+		{"scopes", externalsvc.FreeScoutOptions{URL: "https://slow.example.com", MailboxID: 1, VerifyScopes: true}},
+		{"mailbox type", externalsvc.FreeScoutOptions{URL: "https://slow.example.com", MailboxID: 1, MailboxTypeCheck: externalsvc.FreeScoutMailboxTypeCheckFail}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			slow := &blockingCheckFreeScoutClient{
+				mockFreeScoutClient: &mockFreeScoutClient{},
+				started:             make(chan struct{}),
+				release:             make(chan struct{}),
+			}
+			job := &FreeScout{Log: kitlog.NewNopLogger()}
+			job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+				if opts.URL == "https://slow.example.com" {
+					slow.opts = *opts
+					return slow, nil
+				}
+				return &mockFreeScoutClient{opts: *opts}, nil
+			}
+			ctx := context.Background()
+
+			done := make(chan error, 1)
+			go func() {
+				opts := c.opts
+				_, err := job.cachedClient(ctx, intgTypeVuln+":", &opts)
+				done <- err
+			}()
+			<-slow.started
+
+			// the clients of the other integrations are created while the slow
+			// one is verified.
+			cli, err := job.cachedClient(ctx, intgTypeFailingPolicy+":", &externalsvc.FreeScoutOptions{
+				URL: "https://freescout.example.com", MailboxID: 1,
+			})
+			require.NoError(t, err)
+			require.NotNil(t, cli)
+			require.Equal(t, []string{"failingPolicy::1"}, job.clientsCache.keys())
+
+			// and the slow one is only cached once verified
+			close(slow.release)
+			require.NoError(t, <-done)
+			require.Equal(t, []string{"vuln::1", "failingPolicy::1"}, job.clientsCache.keys())
+		})
+	}
+}
+
+func TestFreeScoutAcquireInstance(t *testing.T) {
+	job := &FreeScout{Log: kitlog.NewNopLogger()}
+	ctx := context.Background()
+
+	// two tenants of the same instance, with different mailboxes
+	tenants := []*fleet.FreeScoutIntegration{
+		{URL: "https://freescout.example.com", MailboxID: 1, MaxConcurrentJobs: 2},
+		{URL: "https://freescout.example.com/", MailboxID: 2, MaxConcurrentJobs: 2},
+	}
+	var inFlight, maxInFlight atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := job.acquireInstance(ctx, tenants[i%2])
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer release()
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			inFlight.Add(-1)
+		}(i)
+	}
+	wg.Wait()
+	require.EqualValues(t, 2, maxInFlight.Load())
+
+	// the instance is full, other instances are not limited by it
+	release1, err := job.acquireInstance(ctx, tenants[0])
+	require.NoError(t, err)
+	release2, err := job.acquireInstance(ctx, tenants[1])
+	require.NoError(t, err)
+	other, err := job.acquireInstance(ctx, &fleet.FreeScoutIntegration{URL: "https://other.example.com", MaxConcurrentJobs: 2})
+	require.NoError(t, err)
+	other()
+
+	// and the wait ends with the context
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = job.acquireInstance(waitCtx, tenants[0])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release1()
+	release2()
+	release, err := job.acquireInstance(ctx, tenants[0])
+	require.NoError(t, err)
+	release()
+
+	// the default limit applies if it is not configured
+	var releases []func()
+	for i := 0; i < defaultFreeScoutMaxConcurrentJobs; i++ {
+		release, err := job.acquireInstance(ctx, &fleet.FreeScoutIntegration{URL: "https://default.example.com"})
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+	waitCtx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = job.acquireInstance(waitCtx, &fleet.FreeScoutIntegration{URL: "https://default.example.com"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	for _, release := range releases {
+		release()
+	}
+}
+
+func TestFreeScoutInstanceLimits(t *testing.T) {
+	job := &FreeScout{Log: kitlog.NewNopLogger()}
+	ctx := context.Background()
+
+	// two tenants of the same instance with different limits, the smallest
+	// applies to both
+	tenants := []*fleet.FreeScoutIntegration{
+		{URL: "https://freescout.example.com", MailboxID: 1, MaxConcurrentJobs: 3},
+		{URL: "https://freescout.example.com/", MailboxID: 2, MaxConcurrentJobs: 2},
+	}
+	job.setInstanceLimits(tenants)
+
+	var inFlight, maxInFlight atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := job.acquireInstance(ctx, tenants[i%2])
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer release()
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			inFlight.Add(-1)
+		}(i)
+	}
+	wg.Wait()
+	require.EqualValues(t, 2, maxInFlight.Load())
+
+	tryAcquire := func(intg *fleet.FreeScoutIntegration) (func(), error) {
+		waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		return job.acquireInstance(waitCtx, intg)
+	}
+
+	release1, err := job.acquireInstance(ctx, tenants[0])
+	require.NoError(t, err)
+	release2, err := job.acquireInstance(ctx, tenants[1])
+	require.NoError(t, err)
+	_, err = tryAcquire(tenants[0])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a higher limit applies to the waiting jobs
+	waited := make(chan func())
+	go func() {
+		release, err := job.acquireInstance(ctx, tenants[0])
+		assert.NoError(t, err)
+		waited <- release
+	}()
+	tenants[1].MaxConcurrentJobs = 3
+	job.setInstanceLimits(tenants)
+	release3 := <-waited
+
+	// a lower limit keeps the jobs in progress, and applies once they are
+	// released
+	tenants[0].MaxConcurrentJobs = 1
+	job.setInstanceLimits(tenants)
+	release1()
+	release2()
+	_, err = tryAcquire(tenants[1])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	release3()
+	release, err := tryAcquire(tenants[1])
+	require.NoError(t, err)
+	_, err = tryAcquire(tenants[0])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+}
+
+func BenchmarkFreeScoutCachedClient(b *testing.B) {
+	job := &FreeScout{
+		Log: kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return &mockFreeScoutClient{opts: *opts}, nil
+		},
+	}
+	ctx := context.Background()
+	opts := externalsvc.FreeScoutOptions{URL: "https://freescout.example.com", MailboxID: 1}
+
+	b.RunParallel(func(pb *testing.PB) {
+		o := opts
+		for pb.Next() {
+			if _, err := job.cachedClient(ctx, intgTypeVuln+":", &o); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestFreeScoutRunFailingPoliciesBatch(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true},
+			},
+		}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("single policy", func(t *testing.T) {
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1", "display_name": "h1"}]}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Equal(t, "p1 policy failed on 1 host(s)", client.conversations[0].Subject)
+		require.NotContains(t, client.conversations[0].Message, "## p1")
+	})
+
+	t.Run("batched policies", func(t *testing.T) {
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(`{"failing_policies":{"policies": [
+			{"policy_id": 1, "policy_name": "p1", "policy_critical": true, "hosts": [{"id": 1, "hostname": "h1", "display_name": "h1"}, {"id": 2, "hostname": "h2", "display_name": "h2"}]},
+			{"policy_id": 2, "policy_name": "p2", "hosts": [{"id": 2, "hostname": "h2", "display_name": "h2"}, {"id": 3, "hostname": "h3", "display_name": "h3"}]}
+		]}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+
+		conv := client.conversations[0]
+		require.Equal(t, "2 policies failing on 3 hosts", conv.Subject)
+		require.Contains(t, conv.Message, "## p1")
+		require.Contains(t, conv.Message, "## p2")
+		require.Equal(t, 1, strings.Count(conv.Message, "marked as **Critical**"))
+		require.Contains(t, conv.Message, "policy_id=1&policy_response=failing")
+		require.Contains(t, conv.Message, "policy_id=2&policy_response=failing")
+		require.Contains(t, conv.Message, "[h3](https://fleetdm.com/hosts/3)")
+		require.Less(t, strings.Index(conv.Message, "## p1"), strings.Index(conv.Message, "## p2"))
+	})
+}
+
+func TestFreeScoutRunHostLinkLabel(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	var hostLinkLabel string
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					MailboxID:                     1,
+					EnableSoftwareVulnerabilities: true,
+					EnableFailingPolicies:         true,
+					HostLinkLabel:                 hostLinkLabel,
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, Hostname: "h1.local", DisplayName: "Host One"}}, nil
+	}
+	ds.ListHostsLiteByIDsFunc = func(ctx context.Context, ids []uint) ([]*fleet.Host, error) {
+		require.Equal(t, []uint{1}, ids)
+		return []*fleet.Host{{ID: 1, Hostname: "h1.local", HardwareSerial: "C02XYZ", UUID: "uuid-1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+
+	cases := []struct {
+		label string
+		want  string
+	}{
+		{"", "[Host One](https://fleetdm.com/hosts/1)"},
+		{fleet.FreeScoutHostLinkLabelDisplayName, "[Host One](https://fleetdm.com/hosts/1)"},
+		{fleet.FreeScoutHostLinkLabelHostname, "[h1.local](https://fleetdm.com/hosts/1)"},
+		{fleet.FreeScoutHostLinkLabelSerial, "[C02XYZ](https://fleetdm.com/hosts/1)"},
+		{fleet.FreeScoutHostLinkLabelUUID, "[uuid-1](https://fleetdm.com/hosts/1)"},
+	}
+	for _, c := range cases {
+		t.Run(c.label, func(t *testing.T) {
+			hostLinkLabel = c.label
+			ds.ListHostsLiteByIDsFuncInvoked = false
+			client.conversations = nil
+
+			err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+			require.NoError(t, err)
+			err = job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1.local", "display_name": "Host One"}]}}`))
+			require.NoError(t, err)
+			err = job.Run(ctx, json.RawMessage(`{"failing_policies":{"policies": [{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1.local", "display_name": "Host One"}]}]}}`))
+			require.NoError(t, err)
+
+			require.Len(t, client.conversations, 3)
+			for _, conv := range client.conversations {
+				require.Contains(t, conv.Message, c.want)
+			}
+			usesLookup := c.label != "" && c.label != fleet.FreeScoutHostLinkLabelDisplayName
+			require.Equal(t, usesLookup, ds.ListHostsLiteByIDsFuncInvoked)
+		})
+	}
+
+	t.Run("missing serial falls back to display name", func(t *testing.T) {
+		hostLinkLabel = fleet.FreeScoutHostLinkLabelSerial
+		ds.ListHostsLiteByIDsFunc = func(ctx context.Context, ids []uint) ([]*fleet.Host, error) {
+			return []*fleet.Host{{ID: 1, Hostname: "h1.local"}}, nil
+		}
+		client.conversations = nil
+
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Contains(t, client.conversations[0].Message, "[Host One](https://fleetdm.com/hosts/1)")
+	})
+}
+
+func TestFreeScoutRunVulnHostsTrend(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					MailboxID:                     1,
+					EnableSoftwareVulnerabilities: true,
+					VulnHostsTrend:                true,
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	var hostsCount int
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		hosts := make([]fleet.HostVulnerabilitySummary, 0, hostsCount)
+		for i := 1; i <= hostsCount; i++ {
+			hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: uint(i), DisplayName: fmt.Sprintf("h%d", i)})
+		}
+		return hosts, nil
+	}
+	reported := make(map[string]int)
+	ds.GetFreeScoutVulnReportedHostsCountFunc = func(ctx context.Context, cve string) (int, error) {
+		count, ok := reported[cve]
+		if !ok {
+			return 0, &mock.Error{Message: "not found"}
+		}
+		return count, nil
+	}
+	ds.SetFreeScoutVulnReportedHostsCountFunc = func(ctx context.Context, cve string, count int) error {
+		reported[cve] = count
+		return nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	cases := []struct {
+		desc       string
+		hostsCount int
+		want       string
+	}{
+		{"first run", 50, "Affected hosts:\n"},
+		{"decreasing", 42, "Affected hosts: 42 (was 50 last report) ▼\n"},
+		{"increasing", 45, "Affected hosts: 45 (was 42 last report) ▲\n"},
+		{"unchanged", 45, "Affected hosts: 45 (was 45 last report)\n"},
+	}
+	for _, c := range cases {
+		hostsCount = c.hostsCount
+		client.conversations = nil
+
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+		require.NoError(t, err, c.desc)
+		require.Len(t, client.conversations, 1, c.desc)
+		require.Contains(t, client.conversations[0].Message, c.want, c.desc)
+		require.Equal(t, c.hostsCount, reported["CVE-1234-5678"], c.desc)
+	}
+	require.NotContains(t, client.conversations[0].Message, "▲")
+	require.NotContains(t, client.conversations[0].Message, "▼")
+}
+
+func TestFreeScoutRunCompactHostPaths(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	var compact bool
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					MailboxID:                     1,
+					EnableSoftwareVulnerabilities: true,
+					CompactHostPaths:              compact,
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostVulnSummariesBySoftwareIDsFunc = func(ctx context.Context, softwareIDs []uint) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{
+			{ID: 1, DisplayName: "h1", SoftwareInstalledPaths: []string{"/usr/bin/a", "/usr/lib/b"}},
+			{ID: 2, DisplayName: "h2", SoftwareInstalledPaths: []string{"/usr/lib/b", "/usr/bin/a"}},
+			{ID: 3, DisplayName: "h3", SoftwareInstalledPaths: []string{"/opt/c"}},
+			{ID: 4, DisplayName: "h4"},
+		}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+	argsJSON := json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678","affected_software":[1]}}`)
+
+	err := job.Run(ctx, argsJSON)
+	require.NoError(t, err)
+	compact = true
+	err = job.Run(ctx, argsJSON)
+	require.NoError(t, err)
+	require.Len(t, client.conversations, 2)
+
+	normal, compacted := client.conversations[0].Message, client.conversations[1].Message
+	require.Equal(t, 2, strings.Count(normal, "* /usr/bin/a"))
+	require.Contains(t, normal, "* [h4](https://fleetdm.com/hosts/4)")
+	require.NotContains(t, normal, "Hosts with")
+
+	require.Contains(t, compacted, "* Hosts with /usr/bin/a, /usr/lib/b: [h1](https://fleetdm.com/hosts/1), [h2](https://fleetdm.com/hosts/2)")
+	require.Contains(t, compacted, "* Hosts with /opt/c: [h3](https://fleetdm.com/hosts/3)")
+	require.Contains(t, compacted, "* Hosts without installed paths: [h4](https://fleetdm.com/hosts/4)")
+	require.Equal(t, 1, strings.Count(compacted, "/usr/bin/a"))
+	require.Less(t, len(compacted), len(normal))
+}
+
+func TestRenderFreeScoutVulnConversation(t *testing.T) {
+	subject, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com",
+		CVE:      "CVE-1234-5678",
+		Hosts: []fleet.HostVulnerabilitySummary{
+			{ID: 1, DisplayName: "h1", SoftwareInstalledPaths: []string{"/usr/bin/a"}},
+			{ID: 2, DisplayName: "h2"},
+		},
+		CVSSScore:  ptr.Float64(7.5),
+		HostLabels: map[uint]string{2: "SERIAL2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 2 host(s)", subject)
+	require.Contains(t, description, "[CVE-1234-5678](https://nvd.nist.gov/vuln/detail/CVE-1234-5678)")
+	require.Contains(t, description, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 7.5")
+	require.Contains(t, description, "* [h1](https://fleetdm.com/hosts/1)")
+	require.Contains(t, description, "* /usr/bin/a")
+	require.Contains(t, description, "* [SERIAL2](https://fleetdm.com/hosts/2)")
+	require.NotContains(t, description, "EPSS")
+}
+
+func TestRenderFreeScoutVulnConversationReferences(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+
+	// only the NVD link by default
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "See vulnerability (CVE) details in National Vulnerability Database (NVD) here: [CVE-1234-5678](https://nvd.nist.gov/vuln/detail/CVE-1234-5678).\n")
+	require.NotContains(t, description, "References")
+
+	// the references are listed after it
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts,
+		References: []FreeScoutReference{
+			{Label: "GitHub Security Advisories", URL: "https://github.com/advisories?query=CVE-1234-5678"},
+			{Label: "Internal_wiki", URL: "https://wiki.example.com/security/CVE-1234-5678"},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, `**References**
+
+* [NVD](https://nvd.nist.gov/vuln/detail/CVE-1234-5678)
+* [GitHub Security Advisories](https://github.com/advisories?query=CVE-1234-5678)
+* [Internal\_wiki](https://wiki.example.com/security/CVE-1234-5678)
+`)
+	require.NotContains(t, description, "National Vulnerability Database")
+
+	// and the section title is translated
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, Locale: "fr",
+		References: []FreeScoutReference{{Label: "Vendor", URL: "https://vendor.example.com/CVE-1234-5678"}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "**Références**\n\n* [NVD](https://nvd.nist.gov/vuln/detail/CVE-1234-5678)\n* [Vendor](https://vendor.example.com/CVE-1234-5678)\n")
+}
+
+func TestFreeScoutRunCVEReferences(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		CVEReferences: []fleet.FreeScoutCVEReference{
+			{Label: "GHSA", URLTemplate: "https://github.com/advisories?query={{ urlquery .CVE }}"},
+			{Label: "Vendor", URLTemplate: "https://vendor.example.com/advisories/{{ .CVE }}"},
+		},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1234"}}`)))
+	require.Len(t, client.conversations, 1)
+	require.Contains(t, client.conversations[0].Message, "* [NVD](https://nvd.nist.gov/vuln/detail/CVE-2024-1234)\n* [GHSA](https://github.com/advisories?query=CVE-2024-1234)\n* [Vendor](https://vendor.example.com/advisories/CVE-2024-1234)\n")
+
+	// a reference that does not render a URL fails the job
+	intg.CVEReferences = []fleet.FreeScoutCVEReference{{Label: "Wiki", URLTemplate: "{{ .CVE }}"}}
+	err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-5678"}}`))
+	require.ErrorContains(t, err, `CVE reference "Wiki" URL template must render an absolute HTTP(S) URL, got "CVE-2024-5678"`)
+	require.Len(t, client.conversations, 1)
+}
+
+func TestRenderFreeScoutVulnConversationCVSSVersion(t *testing.T) {
+	cases := []struct {
+		version *string
+		want    string
+	}{
+		{ptr.String("3.1"), "CVSS v3.1 score (reported by [NVD](https://nvd.nist.gov/)): 7.5"},
+		{ptr.String("2.0"), "CVSS v2.0 score (reported by [NVD](https://nvd.nist.gov/)): 7.5"},
+		{nil, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 7.5"},
+	}
+	for _, c := range cases {
+		_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+			FleetURL:    "https://fleetdm.com",
+			CVE:         "CVE-1234-5678",
+			Hosts:       []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+			CVSSScore:   ptr.Float64(7.5),
+			CVSSVersion: c.version,
+		})
+		require.NoError(t, err)
+		require.Contains(t, description, c.want)
+	}
+}
+
+func TestRenderFreeScoutVulnConversationHostsCount(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}, {ID: 2, DisplayName: "h2"}}
+
+	// the summary reflects the total number of hosts, not only the listed ones
+	summary, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, HostsCount: 120,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 120 host(s)", summary)
+	require.Contains(t, description, "h1")
+	require.Contains(t, description, "h2")
+
+	// it defaults to the number of listed hosts
+	summary, _, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 2 host(s)", summary)
+}
+
+func TestRenderFreeScoutConversationLinkPaths(t *testing.T) {
+	hostPath := template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(`/fleet/hosts/{{ .ID }}/details`))
+	policyHostsPath := template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(
+		`/fleet/hosts?policy={{ urlquery .PolicyID }}{{ with .TeamID }}&team={{ . }}{{ end }}`))
+
+	// the built-in paths by default
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleet.example.com", CVE: "CVE-1234-5678",
+		Hosts: []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "[h1](https://fleet.example.com/hosts/1)")
+
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleet.example.com", CVE: "CVE-1234-5678",
+		Hosts:    []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+		HostPath: hostPath,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "[h1](https://fleet.example.com/fleet/hosts/1/details)")
+
+	// in compact mode too
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleet.example.com", CVE: "CVE-1234-5678",
+		Hosts:            []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+		HostPath:         hostPath,
+		CompactHostPaths: true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "[h1](https://fleet.example.com/fleet/hosts/1/details)")
+
+	teamID := uint(3)
+	policyArgs := FreeScoutFailingPolicyConversationArgs{
+		FleetURL: "https://fleet.example.com", PolicyID: 7, PolicyName: "p1", TeamID: &teamID,
+		Hosts: []fleet.PolicySetHost{{ID: 1, Hostname: "h1", DisplayName: "h1"}},
+	}
+	_, description, err = RenderFreeScoutFailingPolicyConversation(&policyArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "[h1](https://fleet.example.com/hosts/1)")
+	require.Contains(t, description, "(https://fleet.example.com/hosts/manage/?order_key=hostname&order_direction=asc&team_id=3&policy_id=7&policy_response=failing)")
+
+	policyArgs.HostPath = hostPath
+	policyArgs.PolicyHostsPath = policyHostsPath
+	_, description, err = RenderFreeScoutFailingPolicyConversation(&policyArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "[h1](https://fleet.example.com/fleet/hosts/1/details)")
+	require.Contains(t, description, "(https://fleet.example.com/fleet/hosts?policy=7&team=3)")
+	require.NotContains(t, description, "/hosts/manage/")
+
+	// global policy
+	policyArgs.TeamID = nil
+	_, description, err = RenderFreeScoutFailingPolicyConversation(&policyArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "(https://fleet.example.com/fleet/hosts?policy=7)")
+}
+
+func TestRenderFreeScoutConversationTruncationNotice(t *testing.T) {
+	vulnHosts := make([]fleet.HostVulnerabilitySummary, 60)
+	policyHosts := make([]fleet.PolicySetHost, 60)
+	for i := range vulnHosts {
+		vulnHosts[i] = fleet.HostVulnerabilitySummary{ID: uint(i + 1), DisplayName: fmt.Sprintf("h%d", i+1)}
+		policyHosts[i] = fleet.PolicySetHost{ID: uint(i + 1), Hostname: fmt.Sprintf("h%d", i+1), DisplayName: fmt.Sprintf("h%d", i+1)}
+	}
+
+	vulnArgs := &FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: vulnHosts, HostsCount: 120, TruncationNotice: true,
+	}
+	_, description, err := RenderFreeScoutVulnConversation(vulnArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "Showing 50 of 120 hosts — view all: https://fleetdm.com/hosts/manage/?order_key=hostname&order_direction=asc&vulnerability=CVE-1234-5678\n")
+
+	// with a custom path, and in compact mode where the listed hosts are grouped
+	vulnArgs.VulnHostsPath = template.Must(template.New("").Funcs(freeScoutTplFuncs).Parse(`/fleet/software/vulnerabilities/{{ urlpath .CVE }}`))
+	vulnArgs.Hosts, vulnArgs.CompactHostPaths = vulnHosts[:3], true
+	_, description, err = RenderFreeScoutVulnConversation(vulnArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "Showing 3 of 120 hosts — view all: https://fleetdm.com/fleet/software/vulnerabilities/CVE-1234-5678\n")
+
+	// not rendered when all the hosts are listed, or when disabled
+	vulnArgs.Hosts, vulnArgs.HostsCount = vulnHosts[:3], 3
+	_, description, err = RenderFreeScoutVulnConversation(vulnArgs)
+	require.NoError(t, err)
+	require.NotContains(t, description, "Showing")
+	vulnArgs.Hosts, vulnArgs.HostsCount, vulnArgs.TruncationNotice = vulnHosts, 120, false
+	_, description, err = RenderFreeScoutVulnConversation(vulnArgs)
+	require.NoError(t, err)
+	require.NotContains(t, description, "Showing")
+
+	policyArgs := &FreeScoutFailingPolicyConversationArgs{
+		FleetURL: "https://fleetdm.com", PolicyID: 7, PolicyName: "p1", TeamID: ptr.Uint(3), Hosts: policyHosts, TruncationNotice: true,
+	}
+	_, description, err = RenderFreeScoutFailingPolicyConversation(policyArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "* [h50](https://fleetdm.com/hosts/50)\n\n"+
+		"Showing 50 of 60 hosts — view all: https://fleetdm.com/hosts/manage/?order_key=hostname&order_direction=asc&team_id=3&policy_id=7&policy_response=failing\n\n"+
+		"View hosts that failed p1")
+	require.NotContains(t, description, "h51")
+
+	policyArgs.Locale = "fr"
+	_, description, err = RenderFreeScoutFailingPolicyConversation(policyArgs)
+	require.NoError(t, err)
+	require.Contains(t, description, "50 hôtes affichés sur 60 — tout voir : https://fleetdm.com/hosts/manage/")
+
+	policyArgs.Locale, policyArgs.Hosts = "", policyHosts[:50]
+	_, description, err = RenderFreeScoutFailingPolicyConversation(policyArgs)
+	require.NoError(t, err)
+	require.NotContains(t, description, "Showing")
+}
+
+func TestRenderFreeScoutVulnConversationCVEAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	render := func(published *time.Time) string {
+		_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+			FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678",
+			Hosts:        []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+			CVEPublished: published,
+			Now:          now,
+		})
+		require.NoError(t, err)
+		return description
+	}
+
+	cases := []struct {
+		published time.Time
+		want      string
+	}{
+		{time.Date(2023, 4, 16, 12, 0, 0, 0, time.UTC), "(412 days ago)"},
+		{time.Date(2024, 5, 31, 6, 0, 0, 0, time.UTC), "(1 day ago)"},
+		{time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), "(today)"},
+		// published dates after now, e.g. with clock skew
+		{time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), "(today)"},
+	}
+	for _, c := range cases {
+		description := render(&c.published)
+		require.Contains(t, description, fmt.Sprintf("Published (reported by [NVD](https://nvd.nist.gov/)): %s %s\n", c.published, c.want))
+	}
+
+	// omitted without a published date
+	description := render(nil)
+	require.NotContains(t, description, "Published")
+	require.NotContains(t, description, "ago")
+}
+
+func TestRenderFreeScoutVulnConversationSummaryHeader(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}, {ID: 2, DisplayName: "h2"}}
+
+	// disabled by default
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, CVSSScore: ptr.Float64(7.5),
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(description, "See vulnerability (CVE) details"), description)
+	require.NotContains(t, description, "**Summary**")
+
+	// with metadata
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL:         "https://fleetdm.com",
+		CVE:              "CVE-1234-5678",
+		Hosts:            hosts,
+		HostsCount:       12,
+		EPSSProbability:  ptr.Float64(0.42),
+		CVSSScore:        ptr.Float64(9.8),
+		CVSSVersion:      ptr.String("3.1"),
+		CISAKnownExploit: ptr.Bool(false),
+		SummaryHeader:    true,
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(description, `**Summary**
+
+* CVE: CVE-1234-5678
+* CVSS score: 9.8 (v3.1)
+* Probability of exploit (EPSS): 0.42
+* Known exploits (CISA KEV): No
+* Affected hosts: 12
+
+----
+
+See vulnerability (CVE) details`), description)
+	// the detailed sections are still rendered
+	require.Contains(t, description, "CVSS v3.1 score (reported by [NVD](https://nvd.nist.gov/)): 9.8")
+
+	// without metadata
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, SummaryHeader: true,
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(description, `**Summary**
+
+* CVE: CVE-1234-5678
+* CVSS score: Unknown
+* Probability of exploit (EPSS): Unknown
+* Known exploits (CISA KEV): Unknown
+* Affected hosts: 2
+
+----
+
+See vulnerability (CVE) details`), description)
+}
+
+func TestRenderFreeScoutVulnConversationSoftwareNames(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{
+		{
+			ID: 1, DisplayName: "h1",
+			SoftwareInstalledPaths: []string{"/Applications/Foo.app", "/Users/me/Foo.app"},
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "Foo.app", Version: "1.2.3", InstalledPath: "/Applications/Foo.app"},
+				{Name: "Foo.app", Version: "1.2.0", InstalledPath: "/Users/me/Foo.app"},
+			},
+		},
+		{
+			ID: 2, DisplayName: "h2",
+			Software: []fleet.HostVulnerableSoftware{{Name: "foo_bar", Version: "2.0"}},
+		},
+		{
+			// software unknown, only the paths are rendered
+			ID: 3, DisplayName: "h3",
+			SoftwareInstalledPaths: []string{"/opt/foo"},
+		},
+	}
+
+	// disabled by default, only the paths are rendered
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "    * /Applications/Foo.app\n")
+	require.Contains(t, description, "    * /opt/foo\n")
+	require.NotContains(t, description, "1.2.3")
+
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, SoftwareNames: true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "    * Foo.app 1.2.3: /Applications/Foo.app\n")
+	require.Contains(t, description, "    * Foo.app 1.2.0: /Users/me/Foo.app\n")
+	require.Contains(t, description, "    * foo\\_bar 2.0\n")
+	require.Contains(t, description, "    * /opt/foo\n")
+	require.NotContains(t, description, "    * /Applications/Foo.app\n")
+}
+
+func TestRenderFreeScoutVulnConversationHostsTable(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{
+		{
+			ID: 1, DisplayName: "h|1",
+			SoftwareInstalledPaths: []string{"/opt/a|b", "/opt/c\nd"},
+		},
+		{ID: 2, DisplayName: "h2"},
+	}
+	platforms := map[uint]string{1: "darwin"}
+
+	// list by default, the platforms are not rendered
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, HostPlatforms: platforms,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "* [h\\|1](https://fleetdm.com/hosts/1)\n")
+	require.NotContains(t, description, "| Host |")
+	require.NotContains(t, description, "darwin")
+
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, HostPlatforms: platforms, HostsTable: true,
+		// the table takes precedence over the compact paths
+		CompactHostPaths: true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "| Host | Paths | Platform |\n| --- | --- | --- |\n"+
+		"| [h\\|1](https://fleetdm.com/hosts/1) | /opt/a\\|b<br>/opt/c<br>d | darwin |\n"+
+		"| [h2](https://fleetdm.com/hosts/2) |  |  |\n")
+	require.NotContains(t, description, "* [")
+	require.NotContains(t, description, "Hosts with")
+
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", HostsTable: true, SoftwareNames: true,
+		Hosts: []fleet.HostVulnerabilitySummary{{
+			ID: 1, DisplayName: "h1",
+			Software: []fleet.HostVulnerableSoftware{
+				{Name: "foo_bar", Version: "1.0", InstalledPath: "/opt/x|y"},
+				{Name: "baz", Version: "2.0"},
+			},
+		}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "| [h1](https://fleetdm.com/hosts/1) | foo\\_bar 1.0: /opt/x\\|y<br>baz 2.0 |  |\n")
+}
+
+func TestRenderFreeScoutVulnConversationHideSoftwareInstructions(t *testing.T) {
+	args := &FreeScoutVulnConversationArgs{
+		FleetURL:  "https://fleetdm.com",
+		CVE:       "CVE-1234-5678",
+		Hosts:     []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+		CVSSScore: ptr.Float64(7.5),
+	}
+
+	// rendered by default
+	_, description, err := RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.Contains(t, description, "View the affected software and more affected hosts:")
+	require.Contains(t, description, `enter "CVE-1234-5678"`)
+
+	args.HideSoftwareInstructions = true
+	_, description, err = RenderFreeScoutVulnConversation(args)
+	require.NoError(t, err)
+	require.NotContains(t, description, "View the affected software and more affected hosts:")
+	require.NotContains(t, description, "/software/manage")
+	require.Contains(t, description, "https://nvd.nist.gov/vuln/detail/CVE-1234-5678")
+	require.Contains(t, description, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 7.5")
+	require.Contains(t, description, "* [h1](https://fleetdm.com/hosts/1)\n")
+	require.Contains(t, description, "\n\n----\n")
+}
+
+func TestRenderFreeScoutVulnConversationEPSSLabel(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+	custom := fleet.FreeScoutEPSSThresholds{VeryHigh: 0.9, High: 0.6, Moderate: 0.3}
+
+	cases := []struct {
+		epss       float64
+		thresholds fleet.FreeScoutEPSSThresholds
+		want       string
+	}{
+		{0, fleet.FreeScoutEPSSThresholds{}, "Low"},
+		{0.0099, fleet.FreeScoutEPSSThresholds{}, "Low"},
+		{0.01, fleet.FreeScoutEPSSThresholds{}, "Moderate"},
+		{0.0999, fleet.FreeScoutEPSSThresholds{}, "Moderate"},
+		{0.1, fleet.FreeScoutEPSSThresholds{}, "High"},
+		{0.4999, fleet.FreeScoutEPSSThresholds{}, "High"},
+		{0.5, fleet.FreeScoutEPSSThresholds{}, "Very High"},
+		{1, fleet.FreeScoutEPSSThresholds{}, "Very High"},
+		{0.29, custom, "Low"},
+		{0.3, custom, "Moderate"},
+		{0.6, custom, "High"},
+		{0.89, custom, "High"},
+		{0.9, custom, "Very High"},
+		// only the moderate threshold is set, the others use the defaults
+		{0.04, fleet.FreeScoutEPSSThresholds{Moderate: 0.05}, "Low"},
+		{0.2, fleet.FreeScoutEPSSThresholds{Moderate: 0.05}, "High"},
+	}
+	for _, c := range cases {
+		_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+			FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, EPSSProbability: ptr.Float64(c.epss),
+			EPSSLabelMode: fleet.FreeScoutEPSSLabelAppend, EPSSThresholds: c.thresholds,
+		})
+		require.NoError(t, err)
+		require.Contains(t, description, fmt.Sprintf("(https://www.first.org/epss/)): %v (%s)\n", c.epss, c.want), "epss %v", c.epss)
+	}
+
+	// no label by default
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, EPSSProbability: ptr.Float64(0.42), SummaryHeader: true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "* Probability of exploit (EPSS): 0.42\n")
+	require.Contains(t, description, "(https://www.first.org/epss/)): 0.42\n")
+
+	// the label replaces the probability
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, EPSSProbability: ptr.Float64(0.42), SummaryHeader: true,
+		EPSSLabelMode: fleet.FreeScoutEPSSLabelReplace,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "* Probability of exploit (EPSS): High\n")
+	require.Contains(t, description, "(https://www.first.org/epss/)): High\n")
+	require.NotContains(t, description, "0.42")
+
+	// the label is omitted without a probability
+	for _, mode := range []string{fleet.FreeScoutEPSSLabelAppend, fleet.FreeScoutEPSSLabelReplace} {
+		_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+			FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, SummaryHeader: true, EPSSLabelMode: mode,
+		})
+		require.NoError(t, err)
+		require.Contains(t, description, "* Probability of exploit (EPSS): Unknown\n")
+		require.NotContains(t, description, "FIRST.org/epss")
+		for _, label := range []string{"Very High", "High", "Moderate", "Low"} {
+			require.NotContains(t, description, label)
+		}
+	}
+}
+
+func TestRenderFreeScoutVulnConversationRenderThresholds(t *testing.T) {
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+
+	cases := []struct {
+		epss, cvss float64
+		wantEPSS   bool
+		wantCVSS   bool
+	}{
+		{0.0099, 6.9, false, false},
+		{0.01, 7, true, true},
+		{0.0101, 7.1, true, true},
+		{0.0099, 7, false, true},
+		{0.01, 6.9, true, false},
+		{0, 0, false, false},
+		{1, 10, true, true},
+	}
+	for _, c := range cases {
+		_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+			FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, SummaryHeader: true,
+			EPSSProbability: ptr.Float64(c.epss), CVSSScore: ptr.Float64(c.cvss),
+			EPSSRenderThreshold: 0.01, CVSSRenderThreshold: 7,
+		})
+		require.NoError(t, err)
+		epssLines := []string{
+			fmt.Sprintf("* Probability of exploit (EPSS): %v\n", c.epss),
+			fmt.Sprintf("(https://www.first.org/epss/)): %v\n", c.epss),
+		}
+		cvssLines := []string{
+			fmt.Sprintf("* CVSS score: %v\n", c.cvss),
+			fmt.Sprintf("CVSS score (reported by [NVD](https://nvd.nist.gov/)): %v\n", c.cvss),
+		}
+		for _, line := range epssLines {
+			if c.wantEPSS {
+				require.Contains(t, description, line, "epss %v", c.epss)
+			} else {
+				require.NotContains(t, description, line, "epss %v", c.epss)
+			}
+		}
+		for _, line := range cvssLines {
+			if c.wantCVSS {
+				require.Contains(t, description, line, "cvss %v", c.cvss)
+			} else {
+				require.NotContains(t, description, line, "cvss %v", c.cvss)
+			}
+		}
+		if !c.wantEPSS {
+			require.NotContains(t, description, "Probability of exploit")
+		}
+		if !c.wantCVSS {
+			require.NotContains(t, description, "CVSS score")
+		}
+		// the other facts of the summary are still rendered
+		require.Contains(t, description, "* Known exploits (CISA KEV): Unknown\n")
+	}
+
+	// the unknown values are still rendered as such in the summary
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts, SummaryHeader: true,
+		EPSSRenderThreshold: 0.01, CVSSRenderThreshold: 7,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "* CVSS score: Unknown\n")
+	require.Contains(t, description, "* Probability of exploit (EPSS): Unknown\n")
+
+	// all the values are rendered without thresholds
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com", CVE: "CVE-1234-5678", Hosts: hosts,
+		EPSSProbability: ptr.Float64(0.0001), CVSSScore: ptr.Float64(0.1),
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "(https://www.first.org/epss/)): 0.0001\n")
+	require.Contains(t, description, "CVSS score (reported by [NVD](https://nvd.nist.gov/)): 0.1\n")
+}
+
+func TestFreeScoutRunRequireCVSSV3(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, RequireCVSSV3: true},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	payloads := []string{
+		`{"vulnerability":{"cve":"CVE-0001"}}`,
+		`{"vulnerability":{"cve":"CVE-0002","cvss_score":7.5,"cvss_version":"2.0"}}`,
+		`{"vulnerability":{"cve":"CVE-0003","cvss_score":7.5}}`,
+		`{"vulnerability":{"cve":"CVE-0004","cvss_score":7.5,"cvss_version":"3.1"}}`,
+	}
+	for _, payload := range payloads {
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+	}
+	require.Len(t, client.conversations, 1)
+	require.Contains(t, client.conversations[0].Subject, "CVE-0004")
+	require.Contains(t, client.conversations[0].Message, "CVSS v3.1 score")
+}
+
+func TestRenderFreeScoutFailingPolicyConversation(t *testing.T) {
+	subject, description, err := RenderFreeScoutFailingPolicyConversation(&FreeScoutFailingPolicyConversationArgs{
+		FleetURL:       "https://fleetdm.com",
+		PolicyID:       3,
+		PolicyName:     "disk encryption",
+		PolicyCritical: true,
+		TeamID:         ptr.Uint(4),
+		Hosts:          []fleet.PolicySetHost{{ID: 1, Hostname: "h1", DisplayName: "Host 1"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "disk encryption policy failed on 1 host(s)", subject)
+	require.Contains(t, description, "This policy is marked as **Critical** in Fleet.")
+	require.Contains(t, description, "* [Host 1](https://fleetdm.com/hosts/1)")
+	require.Contains(t, description, "team_id=4&policy_id=3&policy_response=failing")
+}
+
+func TestRenderFreeScoutFailingPolicyConversationPlatform(t *testing.T) {
+	args := &FreeScoutFailingPolicyConversationArgs{
+		FleetURL:       "https://fleetdm.com",
+		PolicyID:       3,
+		PolicyName:     "disk encryption",
+		PolicyPlatform: "darwin, windows,",
+		Hosts:          []fleet.PolicySetHost{{ID: 1, DisplayName: "Host 1"}},
+	}
+	_, description, err := RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Contains(t, description, "Targeted platforms: darwin, windows\n\nHosts:")
+
+	args.Locale = "fr"
+	_, description, err = RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Contains(t, description, "Plateformes ciblées : darwin, windows")
+
+	// a policy targeting all platforms
+	args.Locale, args.PolicyPlatform = "", ""
+	_, description, err = RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.NotContains(t, description, "Targeted platforms")
+	require.True(t, strings.HasPrefix(description, "Hosts:"), description)
+}
+
+func TestRenderFreeScoutFailingPolicyConversationHostsTable(t *testing.T) {
+	_, description, err := RenderFreeScoutFailingPolicyConversation(&FreeScoutFailingPolicyConversationArgs{
+		FleetURL:      "https://fleetdm.com",
+		PolicyID:      3,
+		PolicyName:    "disk encryption",
+		Hosts:         []fleet.PolicySetHost{{ID: 1, DisplayName: "Host 1"}, {ID: 2, DisplayName: "Host|2"}},
+		HostsTable:    true,
+		HostPlatforms: map[uint]string{1: "windows", 2: "ubuntu\n|22.04"},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "| Host | Platform |\n| --- | --- |\n"+
+		"| [Host 1](https://fleetdm.com/hosts/1) | windows |\n"+
+		"| [Host\\|2](https://fleetdm.com/hosts/2) | ubuntu<br>\\|22.04 |\n")
+	require.NotContains(t, description, "* [")
+}
+
+func TestRenderFreeScoutFailingPolicyConversationTeamName(t *testing.T) {
+	args := &FreeScoutFailingPolicyConversationArgs{
+		FleetURL:   "https://fleetdm.com",
+		PolicyID:   3,
+		PolicyName: "disk encryption",
+		TeamID:     ptr.Uint(4),
+		TeamName:   "Workstations",
+		Hosts:      []fleet.PolicySetHost{{ID: 1, Hostname: "h1", DisplayName: "Host 1"}},
+	}
+	subject, _, err := RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "[Workstations] disk encryption policy failed on 1 host(s)", subject)
+
+	args.TeamID, args.TeamName = nil, "Global"
+	subject, _, err = RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "[Global] disk encryption policy failed on 1 host(s)", subject)
+
+	args.TeamName = ""
+	subject, _, err = RenderFreeScoutFailingPolicyConversation(args)
+	require.NoError(t, err)
+	require.Equal(t, "disk encryption policy failed on 1 host(s)", subject)
+}
+
+func TestFreeScoutRunTeamNameInSummary(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true, TeamNameInSummary: true}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Name: fmt.Sprintf("team%d", tid), Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true},
+				},
+			},
+		}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// the same policy name in the global and team scopes is reported in
+	// distinct conversations.
+	payloads := []string{
+		`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`,
+		`{"failing_policy":{"policy_id": 2, "policy_name": "p1", "team_id": 2, "hosts": [{"id": 1, "hostname": "h1"}]}}`,
+		`{"failing_policy":{"policy_id": 3, "policy_name": "p1", "team_id": 3, "hosts": [{"id": 1, "hostname": "h1"}]}}`,
+	}
+	for _, payload := range payloads {
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+	}
+	require.Len(t, client.conversations, 3)
+	require.Equal(t, "[Global] p1 policy failed on 1 host(s)", client.conversations[0].Subject)
+	require.Equal(t, "[team2] p1 policy failed on 1 host(s)", client.conversations[1].Subject)
+	require.Equal(t, "[team3] p1 policy failed on 1 host(s)", client.conversations[2].Subject)
+}
+
+func TestFreeScoutQueueVulnJobsCVEFilter(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	var queued []string
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*job.Args, &args))
+		queued = append(queued, args.Vulnerability.CVE)
+		return job, nil
+	}
+
+	vulns := []fleet.SoftwareVulnerability{
+		{CVE: "CVE-2019-0001", SoftwareID: 1},
+		{CVE: "CVE-2019-0002", SoftwareID: 1},
+		{CVE: "CVE-2023-0001", SoftwareID: 2},
+		{CVE: "CVE-2024-0001", SoftwareID: 3},
+	}
+
+	cases := []struct {
+		desc string
+		intg *fleet.FreeScoutIntegration
+		want []string
+	}{
+		{
+			desc: "no integration config",
+			want: []string{"CVE-2019-0001", "CVE-2019-0002", "CVE-2023-0001", "CVE-2024-0001"},
+		},
+		{
+			desc: "no lists",
+			intg: &fleet.FreeScoutIntegration{},
+			want: []string{"CVE-2019-0001", "CVE-2019-0002", "CVE-2023-0001", "CVE-2024-0001"},
+		},
+		{
+			desc: "allow only",
+			intg: &fleet.FreeScoutIntegration{CVEAllowlist: []string{"CVE-2019-*", "cve-2024-0001"}},
+			want: []string{"CVE-2019-0001", "CVE-2019-0002", "CVE-2024-0001"},
+		},
+		{
+			desc: "deny only",
+			intg: &fleet.FreeScoutIntegration{CVEDenylist: []string{"CVE-2019-*"}},
+			want: []string{"CVE-2023-0001", "CVE-2024-0001"},
+		},
+		{
+			desc: "deny wins over allow",
+			intg: &fleet.FreeScoutIntegration{
+				CVEAllowlist: []string{"CVE-2019-*", "CVE-2023-0001"},
+				CVEDenylist:  []string{"CVE-2019-0002", "CVE-2023-*"},
+			},
+			want: []string{"CVE-2019-0001"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			queued = nil
+			err := QueueFreeScoutVulnJobs(ctx, ds, logger, c.intg, vulns, nil)
+			require.NoError(t, err)
+			require.ElementsMatch(t, c.want, queued)
+		})
+	}
+}
+
+func TestFreeScoutRunMaxDescriptionBytes(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	var maxBytes int
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{
+					URL:                           "https://freescout.example.com",
+					MailboxID:                     1,
+					EnableSoftwareVulnerabilities: true,
+					MaxDescriptionBytes:           maxBytes,
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		hosts := make([]fleet.HostVulnerabilitySummary, 0, 60)
+		for i := 1; i <= 60; i++ {
+			paths := make([]string, 0, 10)
+			for j := 0; j < 10; j++ {
+				paths = append(paths, fmt.Sprintf("/very/long/path/to/the/vulnerable/software/installation/%d/%d", i, j))
+			}
+			hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: uint(i), DisplayName: fmt.Sprintf("host-%d", i), SoftwareInstalledPaths: paths})
+		}
+		return hosts, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := &FreeScout{
+		FleetURL:  "https://fleetdm.com",
+		Datastore: ds,
+		Log:       kitlog.NewNopLogger(),
+		NewClientFunc: func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+			return client, nil
+		},
+	}
+	ctx := context.Background()
+	run := func() mockFreeScoutConversation {
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-1234-5678"}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		return client.conversations[0]
+	}
+
+	// the default maximum is large enough for the 50 listed hosts
+	conv := run()
+	require.Contains(t, conv.Message, "[host-50]")
+	require.NotContains(t, conv.Message, "were omitted")
+
+	// hosts are omitted to fit under the configured maximum
+	maxBytes = 8000
+	conv = run()
+	require.LessOrEqual(t, len(conv.Message), maxBytes)
+	require.Equal(t, "Vulnerability CVE-1234-5678 detected on 60 host(s)", conv.Subject)
+	require.Contains(t, conv.Message, "Some hosts or paths were omitted")
+	require.Contains(t, conv.Message, "[host-1]")
+	require.NotContains(t, conv.Message, "[host-50]")
+	require.Contains(t, conv.Message, "This conversation was created automatically")
+	// deterministic
+	require.Equal(t, conv, run())
+
+	// then paths are omitted
+	maxBytes = 1200
+	conv = run()
+	require.LessOrEqual(t, len(conv.Message), maxBytes)
+	require.Contains(t, conv.Message, "[host-1]")
+	require.NotContains(t, conv.Message, "[host-2]")
+	require.Contains(t, conv.Message, "/installation/1/0")
+	require.NotContains(t, conv.Message, "/installation/1/9")
+
+	// and finally the description is cut
+	maxBytes = 500
+	conv = run()
+	require.LessOrEqual(t, len(conv.Message), maxBytes)
+	require.True(t, strings.HasSuffix(conv.Message, "[This description was truncated to fit the maximum size of a FreeScout conversation.]"))
+}
+
+func TestTruncateFreeScoutDescription(t *testing.T) {
+	note := "\n\n[This description was truncated to fit the maximum size of a FreeScout conversation.]"
+	desc := strings.Repeat("é", 100) // 2 bytes each
+	got := truncateFreeScoutDescription(desc, len(note)+5)
+	require.Equal(t, strings.Repeat("é", 2)+note, got)
+	require.Empty(t, truncateFreeScoutDescription(desc, 10))
+}
+
+func TestFreeScoutQueueVulnJobsSpread(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	notBefore := make(map[string]time.Time)
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*job.Args, &args))
+		notBefore[args.Vulnerability.CVE] = job.NotBefore
+		return job, nil
+	}
+
+	vulns := []fleet.SoftwareVulnerability{
+		{CVE: "CVE-0003", SoftwareID: 1},
+		{CVE: "CVE-0001", SoftwareID: 1},
+		{CVE: "CVE-0004", SoftwareID: 2},
+		{CVE: "CVE-0002", SoftwareID: 3},
+	}
+	window := 8 * time.Minute
+
+	t.Run("no window", func(t *testing.T) {
+		clear(notBefore)
+		err := QueueFreeScoutVulnJobs(ctx, ds, logger, &fleet.FreeScoutIntegration{}, vulns, nil)
+		require.NoError(t, err)
+		require.Len(t, notBefore, 4)
+		for _, nb := range notBefore {
+			require.True(t, nb.IsZero())
+		}
+	})
+
+	t.Run("even", func(t *testing.T) {
+		clear(notBefore)
+		start := time.Now().UTC()
+		err := QueueFreeScoutVulnJobs(ctx, ds, logger, &fleet.FreeScoutIntegration{
+			VulnJobsSpreadWindow: fleet.Duration{Duration: window},
+		}, vulns, nil)
+		require.NoError(t, err)
+		require.Len(t, notBefore, 4)
+
+		// the first job runs immediately, the others are 2 minutes apart, in
+		// CVE order.
+		require.True(t, notBefore["CVE-0001"].IsZero())
+		for i, cve := range []string{"CVE-0002", "CVE-0003", "CVE-0004"} {
+			want := start.Add(time.Duration(i+1) * 2 * time.Minute)
+			require.WithinDuration(t, want, notBefore[cve], 5*time.Second, cve)
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		clear(notBefore)
+		start := time.Now().UTC()
+		err := QueueFreeScoutVulnJobs(ctx, ds, logger, &fleet.FreeScoutIntegration{
+			VulnJobsSpreadWindow:       fleet.Duration{Duration: window},
+			VulnJobsSpreadDistribution: fleet.FreeScoutSpreadRandom,
+		}, vulns, nil)
+		require.NoError(t, err)
+		require.Len(t, notBefore, 4)
+		for cve, nb := range notBefore {
+			if nb.IsZero() {
+				continue
+			}
+			require.False(t, nb.Before(start), cve)
+			require.True(t, nb.Before(start.Add(window+5*time.Second)), cve)
+		}
+	})
+}
+
+func TestFreeScoutJobDelay(t *testing.T) {
+	require.Zero(t, freeScoutJobDelay(3, 4, 0, ""))
+	require.Zero(t, freeScoutJobDelay(0, 4, time.Hour, fleet.FreeScoutSpreadEven))
+	require.Equal(t, 15*time.Minute, freeScoutJobDelay(1, 4, time.Hour, fleet.FreeScoutSpreadEven))
+	require.Equal(t, 45*time.Minute, freeScoutJobDelay(3, 4, time.Hour, ""))
+	for i := 0; i < 100; i++ {
+		d := freeScoutJobDelay(i, 100, time.Hour, fleet.FreeScoutSpreadRandom)
+		require.GreaterOrEqual(t, d, time.Duration(0))
+		require.Less(t, d, time.Hour)
+	}
+}
+
+func TestSendFreeScoutTestConversation(t *testing.T) {
+	srv := newFreeScoutTestServer(t)
+	intg := &fleet.FreeScoutIntegration{
+		URL:           srv.URL,
+		APIToken:      "token",
+		MailboxID:     3,
+		CustomerEmail: "fleet@example.com",
+	}
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		conv, err := SendFreeScoutTestConversation(ctx, intg, "https://fleetdm.com", false)
+		require.NoError(t, err)
+		require.Positive(t, conv.ID)
+		require.Equal(t, fmt.Sprintf("%s/conversation/%d", srv.URL, conv.ID), conv.URL)
+		require.False(t, conv.Deleted)
+
+		created := srv.created()
+		body := created[len(created)-1].Body
+		require.Contains(t, body, `"subject":"[TEST] Fleet FreeScout integration check"`)
+		require.Contains(t, body, `"mailboxId":3`)
+		require.Contains(t, body, `"email":"fleet@example.com"`)
+		require.Contains(t, body, "CVE-0000-0000")
+		require.Contains(t, body, "[test-host](https://fleetdm.com/hosts/1)")
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		conv, err := SendFreeScoutTestConversation(ctx, intg, "https://fleetdm.com", true)
+		require.NoError(t, err)
+		require.True(t, conv.Deleted)
+
+		srv.mu.Lock()
+		last := srv.requests[len(srv.requests)-1]
+		srv.mu.Unlock()
+		require.Equal(t, http.MethodDelete, last.Method)
+		require.Equal(t, fmt.Sprintf("/api/conversations/%d", conv.ID), last.Path)
+		require.Equal(t, "token", last.Header.Get("X-FreeScout-API-Key"))
+	})
+}
+
+func TestRenderFreeScoutConversationEscapesLinks(t *testing.T) {
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com",
+		CVE:      "CVE-1234 & 5678",
+		Hosts:    []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "(https://nvd.nist.gov/vuln/detail/CVE-1234%20&%205678)")
+	require.NotContains(t, description, "detail/CVE-1234 & 5678")
+
+	_, description, err = RenderFreeScoutFailingPolicyConversation(&FreeScoutFailingPolicyConversationArgs{
+		FleetURL:   "https://fleetdm.com",
+		PolicyID:   3,
+		PolicyName: "a & b",
+		TeamID:     ptr.Uint(4),
+		Hosts:      []fleet.PolicySetHost{{ID: 1, DisplayName: "h1"}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, "&team_id=4&policy_id=3&policy_response=failing)")
+}
+
+func TestRenderFreeScoutConversationEscapesMarkdown(t *testing.T) {
+	_, description, err := RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL: "https://fleetdm.com",
+		CVE:      "CVE-1234-5678",
+		Hosts:    []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "My_Host [prod]*"}},
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, `* [My\_Host \[prod\]\*](https://fleetdm.com/hosts/1)`)
+
+	// host labels are escaped too
+	_, description, err = RenderFreeScoutVulnConversation(&FreeScoutVulnConversationArgs{
+		FleetURL:         "https://fleetdm.com",
+		CVE:              "CVE-1234-5678",
+		Hosts:            []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1", SoftwareInstalledPaths: []string{"/a"}}},
+		HostLabels:       map[uint]string{1: "serial_1"},
+		CompactHostPaths: true,
+	})
+	require.NoError(t, err)
+	require.Contains(t, description, `[serial\_1](https://fleetdm.com/hosts/1)`)
+
+	subject, description, err := RenderFreeScoutFailingPolicyConversation(&FreeScoutFailingPolicyConversationArgs{
+		FleetURL:   "https://fleetdm.com",
+		PolicyID:   3,
+		PolicyName: "No *root* login",
+		Hosts:      []fleet.PolicySetHost{{ID: 1, DisplayName: "My_Host [prod]*"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "No *root* login policy failed on 1 host(s)", subject)
+	require.Contains(t, description, `* [My\_Host \[prod\]\*](https://fleetdm.com/hosts/1)`)
+	require.Contains(t, description, `View hosts that failed No \*root\* login on`)
+}
+
+func TestFreeScoutQueueFailingPoliciesJob(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	var queued []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		queued = append(queued, job)
+		return job, nil
+	}
+
+	teamID := ptr.Uint(2)
+	policies := []*fleet.Policy{
+		{PolicyData: fleet.PolicyData{ID: 1, Name: "p1", TeamID: teamID}},
+		{PolicyData: fleet.PolicyData{ID: 2, Name: "p2", TeamID: teamID}},
+		{PolicyData: fleet.PolicyData{ID: 3, Name: "p3", TeamID: teamID}},
+	}
+
+	t.Run("policies without hosts are skipped", func(t *testing.T) {
+		queued = nil
+		err := QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, teamID, policies, map[uint][]fleet.PolicySetHost{
+			1: {{ID: 1, Hostname: "h1"}},
+			3: {{ID: 1, Hostname: "h1"}, {ID: 2, Hostname: "h2"}},
+		}, 0)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+		require.Nil(t, args.FailingPolicy)
+		require.NotNil(t, args.FailingPolicies)
+		require.Equal(t, teamID, args.FailingPolicies.TeamID)
+		require.Len(t, args.FailingPolicies.Policies, 2)
+		require.Equal(t, uint(1), args.FailingPolicies.Policies[0].PolicyID)
+		require.Equal(t, uint(3), args.FailingPolicies.Policies[1].PolicyID)
+		require.Len(t, args.FailingPolicies.Policies[1].Hosts, 2)
+	})
+
+	t.Run("policies below the minimum hosts are skipped", func(t *testing.T) {
+		queued = nil
+		hostsByPolicy := map[uint][]fleet.PolicySetHost{
+			1: {{ID: 1, Hostname: "h1"}},
+			3: {{ID: 1, Hostname: "h1"}, {ID: 2, Hostname: "h2"}},
+		}
+		err := QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, teamID, policies, hostsByPolicy, 2)
+		require.NoError(t, err)
+		require.Len(t, queued, 1)
+
+		var args freeScoutArgs
+		require.NoError(t, json.Unmarshal(*queued[0].Args, &args))
+		require.Len(t, args.FailingPolicies.Policies, 1)
+		require.Equal(t, uint(3), args.FailingPolicies.Policies[0].PolicyID)
+
+		// none at the minimum, nothing is queued
+		queued = nil
+		err = QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, teamID, policies, hostsByPolicy, 3)
+		require.NoError(t, err)
+		require.Empty(t, queued)
+	})
+
+	t.Run("no host", func(t *testing.T) {
+		queued = nil
+		err := QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, teamID, policies, nil, 0)
+		require.NoError(t, err)
+		require.Empty(t, queued)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+			return nil, io.EOF
+		}
+		err := QueueFreeScoutFailingPoliciesJob(ctx, ds, logger, nil, policies[:1], map[uint][]fleet.PolicySetHost{
+			1: {{ID: 1, Hostname: "h1"}},
+		}, 0)
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestFreeScoutQueueDigestEvents(t *testing.T) {
+	ds := new(mock.Store)
+	ctx := context.Background()
+	logger := kitlog.NewNopLogger()
+
+	// the pending events per team, 0 for the global scope
+	pending := make(map[uint][]freeScoutArgs)
+	ds.InsertFreeScoutDigestEventsFunc = func(ctx context.Context, teamID uint, args []json.RawMessage) (bool, error) {
+		wasEmpty := len(pending[teamID]) == 0
+		for _, a := range args {
+			var fargs freeScoutArgs
+			require.NoError(t, json.Unmarshal(a, &fargs))
+			pending[teamID] = append(pending[teamID], fargs)
+		}
+		return wasEmpty, nil
+	}
+	var jobs []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		jobs = append(jobs, job)
+		return job, nil
+	}
+
+	intg := &fleet.FreeScoutIntegration{DigestInterval: fleet.Duration{Duration: 24 * time.Hour}}
+	start := time.Now().UTC()
+
+	// the vulnerabilities are accumulated, a single digest job is queued
+	err := QueueFreeScoutVulnJobs(ctx, ds, logger, intg, []fleet.SoftwareVulnerability{
+		{CVE: "CVE-0001", SoftwareID: 1},
+		{CVE: "CVE-0002", SoftwareID: 2},
+	}, map[string]fleet.CVEMeta{"CVE-0001": {CVE: "CVE-0001", CVSSScore: ptr.Float64(9.8)}})
+	require.NoError(t, err)
+	err = QueueFreeScoutVulnJobs(ctx, ds, logger, intg, []fleet.SoftwareVulnerability{{CVE: "CVE-0003", SoftwareID: 3}}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, pending[0], 3)
+	require.Equal(t, "CVE-0001", pending[0][0].Vulnerability.CVE)
+	require.Equal(t, ptr.Float64(9.8), pending[0][0].Vulnerability.CVSSScore)
+	require.Equal(t, "CVE-0003", pending[0][2].Vulnerability.CVE)
+
+	require.Len(t, jobs, 1)
+	var args freeScoutArgs
+	require.NoError(t, json.Unmarshal(*jobs[0].Args, &args))
+	require.NotNil(t, args.Digest)
+	require.Nil(t, args.Digest.TeamID)
+	require.WithinDuration(t, start.Add(24*time.Hour), jobs[0].NotBefore, 5*time.Second)
+
+	// global failing policies go to the same digest, team ones to their own
+	hosts := []fleet.PolicySetHost{{ID: 1, Hostname: "h1"}, {ID: 2, Hostname: "h2"}}
+	err = QueueFreeScoutFailingPolicyDigestEvent(ctx, ds, logger, &fleet.Policy{PolicyData: fleet.PolicyData{ID: 1, Name: "p1"}}, hosts, 0, time.Hour)
+	require.NoError(t, err)
+	err = QueueFreeScoutFailingPolicyDigestEvent(ctx, ds, logger, &fleet.Policy{PolicyData: fleet.PolicyData{ID: 2, Name: "p2", TeamID: ptr.Uint(2)}}, hosts, 0, time.Hour)
+	require.NoError(t, err)
+	// skipped, fewer hosts than the minimum
+	err = QueueFreeScoutFailingPolicyDigestEvent(ctx, ds, logger, &fleet.Policy{PolicyData: fleet.PolicyData{ID: 3, Name: "p3", TeamID: ptr.Uint(2)}}, hosts, 3, time.Hour)
+	require.NoError(t, err)
+
+	require.Len(t, pending[0], 4)
+	require.Equal(t, uint(1), pending[0][3].FailingPolicy.PolicyID)
+	require.Len(t, pending[2], 1)
+	require.Equal(t, uint(2), pending[2][0].FailingPolicy.PolicyID)
+	require.Len(t, pending[2][0].FailingPolicy.Hosts, 2)
+
+	require.Len(t, jobs, 2)
+	args = freeScoutArgs{}
+	require.NoError(t, json.Unmarshal(*jobs[1].Args, &args))
+	require.NotNil(t, args.Digest)
+	require.Equal(t, ptr.Uint(2), args.Digest.TeamID)
+	require.WithinDuration(t, start.Add(time.Hour), jobs[1].NotBefore, 5*time.Second)
+}
+
+func TestFreeScoutRunDigest(t *testing.T) {
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	intg := &fleet.FreeScoutIntegration{URL: "https://freescout.example.com", MailboxID: 1, EnableSoftwareVulnerabilities: true, EnableFailingPolicies: true, DigestInterval: fleet.Duration{Duration: 24 * time.Hour}}
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Name: fmt.Sprintf("team%d", tid), Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true},
+				},
+			},
+		}}, nil
+	}
+
+	since := time.Date(2026, 10, 15, 8, 30, 0, 0, time.UTC)
+	pending := make(map[uint][]*fleet.FreeScoutDigestEvent)
+	addEvent := func(teamID uint, args string) {
+		pending[teamID] = append(pending[teamID], &fleet.FreeScoutDigestEvent{
+			ID:        uint(len(pending[0]) + len(pending[2]) + 1),
+			TeamID:    teamID,
+			Args:      json.RawMessage(args),
+			CreatedAt: since.Add(time.Duration(len(pending[teamID])) * time.Minute),
+		})
+	}
+	ds.ListFreeScoutDigestEventsFunc = func(ctx context.Context, teamID uint, limit int) ([]*fleet.FreeScoutDigestEvent, error) {
+		events := pending[teamID]
+		if len(events) > limit {
+			events = events[:limit]
+		}
+		return events, nil
+	}
+	var deleted []uint
+	ds.DeleteFreeScoutDigestEventsFunc = func(ctx context.Context, ids []uint) error {
+		deleted = append(deleted, ids...)
+		for tm, events := range pending {
+			pending[tm] = slices.DeleteFunc(events, func(e *fleet.FreeScoutDigestEvent) bool {
+				return slices.Contains(ids, e.ID)
+			})
+		}
+		return nil
+	}
+	var jobs []*fleet.Job
+	ds.NewJobFunc = func(ctx context.Context, job *fleet.Job) (*fleet.Job, error) {
+		jobs = append(jobs, job)
+		return job, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	t.Run("global", func(t *testing.T) {
+		client.conversations, deleted = nil, nil
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0001","cvss_score":7.5}}`)
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0002","cvss_score":9.8,"cisa_known_exploit":true}}`)
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0003"}}`)
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0004","cvss_score":8.1}}`)
+		addEvent(0, `{"failing_policy":{"policy_id":1,"policy_name":"p1","hosts":[{"id":1,"hostname":"h1"}]}}`)
+		addEvent(0, `{"failing_policy":{"policy_id":2,"policy_name":"p2","policy_critical":true,"hosts":[{"id":1,"hostname":"h1"}]}}`)
+		// the latest event of the same CVE or policy is reported
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0001","cvss_score":9.1}}`)
+		addEvent(0, `{"failing_policy":{"policy_id":1,"policy_name":"p1","hosts":[{"id":1,"hostname":"h1"},{"id":2,"hostname":"h2"}]}}`)
+
+		err := job.Run(ctx, json.RawMessage(`{"digest":{}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Equal(t, "Fleet digest since 2026-10-15 08:30 UTC: 4 vulnerabilities, 2 failing policies", client.conversations[0].Subject)
+
+		msg := client.conversations[0].Message
+		require.Contains(t, msg, "### Critical (2)")
+		require.Contains(t, msg, "### High (1)")
+		require.Contains(t, msg, "### Unknown (1)")
+		require.NotContains(t, msg, "### Medium")
+		require.Contains(t, msg, "* [CVE-0002](https://fleetdm.com/software/vulnerabilities/CVE-0002), CVSS score 9.8, **known exploit**")
+		require.Contains(t, msg, "* [CVE-0001](https://fleetdm.com/software/vulnerabilities/CVE-0001), CVSS score 9.1\n")
+		require.Contains(t, msg, "* [CVE-0003](https://fleetdm.com/software/vulnerabilities/CVE-0003)\n")
+		// ordered by severity, then score
+		require.Less(t, strings.Index(msg, "CVE-0002"), strings.Index(msg, "CVE-0001"))
+		require.Less(t, strings.Index(msg, "CVE-0001"), strings.Index(msg, "CVE-0004"))
+		require.Less(t, strings.Index(msg, "CVE-0004"), strings.Index(msg, "CVE-0003"))
+
+		require.Contains(t, msg, "## Failing policies (2)")
+		require.Contains(t, msg, "* [p2](https://fleetdm.com/hosts/manage/?order_key=hostname&order_direction=asc&policy_id=2&policy_response=failing) (**Critical**): failing on 1 host(s)")
+		require.Contains(t, msg, "* [p1](https://fleetdm.com/hosts/manage/?order_key=hostname&order_direction=asc&policy_id=1&policy_response=failing): failing on 2 host(s)")
+		require.Less(t, strings.Index(msg, "[p2]"), strings.Index(msg, "[p1]"))
+
+		require.Len(t, deleted, 8)
+		require.Empty(t, pending[0])
+
+		// a digest job without pending events does nothing
+		err = job.Run(ctx, json.RawMessage(`{"digest":{}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+	})
+
+	t.Run("team", func(t *testing.T) {
+		client.conversations, deleted = nil, nil
+		addEvent(2, `{"failing_policy":{"policy_id":3,"policy_name":"p3","team_id":2,"hosts":[{"id":1,"hostname":"h1"}]}}`)
+
+		err := job.Run(ctx, json.RawMessage(`{"digest":{"team_id":2}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Equal(t, "[team2] Fleet digest since 2026-10-15 08:30 UTC: 0 vulnerabilities, 1 failing policies", client.conversations[0].Subject)
+		require.NotContains(t, client.conversations[0].Message, "## Vulnerabilities")
+		require.Contains(t, client.conversations[0].Message, "team_id=2&policy_id=3")
+		require.Len(t, deleted, 1)
+	})
+
+	t.Run("more than the maximum events", func(t *testing.T) {
+		client.conversations, deleted, jobs = nil, nil, nil
+		for i := range freeScoutDigestMaxEvents + 1 {
+			addEvent(0, fmt.Sprintf(`{"vulnerability":{"cve":"CVE-%04d"}}`, i))
+		}
+
+		err := job.Run(ctx, json.RawMessage(`{"digest":{}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Len(t, deleted, freeScoutDigestMaxEvents)
+		require.Len(t, pending[0], 1)
+
+		// the remaining events are reported right away
+		require.Len(t, jobs, 1)
+		require.True(t, jobs[0].NotBefore.IsZero())
+		pending[0] = nil
+	})
+
+	t.Run("localized", func(t *testing.T) {
+		client.conversations, deleted, jobs = nil, nil, nil
+		intg.Locale = "fr"
+		defer func() { intg.Locale = "" }()
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0001","cvss_score":9.1,"cisa_known_exploit":true}}`)
+		addEvent(0, `{"failing_policy":{"policy_id":2,"policy_name":"p2","policy_critical":true,"hosts":[{"id":1,"hostname":"h1"}]}}`)
+
+		err := job.Run(ctx, json.RawMessage(`{"digest":{}}`))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		require.Equal(t, "Récapitulatif Fleet depuis le 2026-10-15 08:30 UTC : 1 vulnérabilités, 1 politiques en échec", client.conversations[0].Subject)
+
+		msg := client.conversations[0].Message
+		require.Contains(t, msg, "## Vulnérabilités\n")
+		require.Contains(t, msg, "### Critique (1)")
+		require.Contains(t, msg, "* [CVE-0001](https://fleetdm.com/software/vulnerabilities/CVE-0001), Score CVSS 9.1, **exploit connu**")
+		require.Contains(t, msg, "## Politiques en échec (1)")
+		require.Contains(t, msg, " (**Critique**): en échec sur 1 hôte(s)")
+		require.Contains(t, msg, "Cette conversation a été créée automatiquement par votre intégration Fleet FreeScout.")
+	})
+
+	t.Run("paused", func(t *testing.T) {
+		client.conversations, deleted, jobs = nil, nil, nil
+		intg.Paused = true
+		defer func() { intg.Paused = false }()
+		addEvent(0, `{"vulnerability":{"cve":"CVE-0001"}}`)
+
+		start := time.Now().UTC()
+		err := job.Run(ctx, json.RawMessage(`{"digest":{}}`))
+		require.NoError(t, err)
+		require.Empty(t, client.conversations)
+		require.Empty(t, deleted)
+
+		// the events are kept and reported at the next interval
+		require.Len(t, jobs, 1)
+		require.WithinDuration(t, start.Add(24*time.Hour), jobs[0].NotBefore, 5*time.Second)
+	})
+}
+
+func TestFreeScoutRunTemplateOverrides(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+		Templates: &fleet.FreeScoutTemplates{FailingPolicySummary: `Global: {{ .PolicyName }}`},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	teamTemplates := map[uint]*fleet.FreeScoutTemplates{
+		1: {FailingPolicySummary: `Team A: {{ .PolicyName }}`, FailingPolicyDescription: `Runbook A for {{ md .PolicyName }}`},
+		2: {FailingPolicyDescription: `Runbook B`},
+		3: nil,
+		4: {FailingPolicyDescription: `{{ unknownFunc .PolicyName }}`},
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true, Templates: teamTemplates[tid]},
+				},
+			},
+		}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	run := func(payload string) mockFreeScoutConversation {
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		return client.conversations[0]
+	}
+
+	// global policy, global summary override and built-in description
+	conv := run(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Equal(t, "Global: p1", conv.Subject)
+	require.Contains(t, conv.Message, "This conversation was created automatically by your Fleet FreeScout integration.")
+
+	// team with both templates overridden
+	conv = run(`{"failing_policy":{"policy_id": 2, "policy_name": "p_2", "team_id": 1, "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Equal(t, "Team A: p_2", conv.Subject)
+	require.Equal(t, `Runbook A for p\_2`, conv.Message)
+
+	// team overriding the description only, inheriting the global summary
+	conv = run(`{"failing_policy":{"policy_id": 3, "policy_name": "p3", "team_id": 2, "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Equal(t, "Global: p3", conv.Subject)
+	require.Equal(t, "Runbook B", conv.Message)
+
+	// team without overrides, inheriting the global ones
+	conv = run(`{"failing_policy":{"policy_id": 4, "policy_name": "p4", "team_id": 3, "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Equal(t, "Global: p4", conv.Subject)
+	require.Contains(t, conv.Message, "This conversation was created automatically by your Fleet FreeScout integration.")
+
+	// invalid override, the built-in template is used
+	conv = run(`{"failing_policy":{"policy_id": 5, "policy_name": "p5", "team_id": 4, "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	require.Equal(t, "Global: p5", conv.Subject)
+	require.Contains(t, conv.Message, "This conversation was created automatically by your Fleet FreeScout integration.")
+
+	// the global templates are not modified by the teams' overrides
+	require.Equal(t, &fleet.FreeScoutTemplates{FailingPolicySummary: `Global: {{ .PolicyName }}`}, intg.Templates)
+}
+
+func TestFreeScoutRunSummaryTemplateOverrides(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL:                           "https://freescout.example.com",
+		MailboxID:                     1,
+		EnableSoftwareVulnerabilities: true,
+		EnableFailingPolicies:         true,
+		IdentifierTags:                true,
+		Templates: &fleet.FreeScoutTemplates{
+			VulnSummary:          `[{{ .Severity }}] {{ .CVE }} on {{ .HostsCount }} host(s)`,
+			FailingPolicySummary: `[{{ .TeamName }}] {{ .PolicyName }} on {{ .HostsCount }} host(s)`,
+		},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Name: "Servers", Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true},
+				},
+			},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	hosts := []fleet.HostVulnerabilitySummary{{ID: 1, DisplayName: "h1"}}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return hosts, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	// the custom subject has access to the severity band and the number of
+	// hosts
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":9.8}}`)))
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, "[critical] CVE-2024-1234 on 1 host(s)", client.conversations[0].Subject)
+	require.Equal(t, []string{"fleet-cve:CVE-2024-1234"}, client.conversations[0].Tags)
+	require.Contains(t, client.conversations[0].Message, "This conversation was created automatically by your Fleet FreeScout integration.")
+
+	// the subject changes with the number of hosts, the existing conversation
+	// is still found by its identifier tag
+	hosts = append(hosts, fleet.HostVulnerabilitySummary{ID: 2, DisplayName: "h2"})
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":9.8}}`)))
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, "[critical] CVE-2024-1234 on 2 host(s)", client.conversations[1].Subject)
+	require.Empty(t, client.conversations[1].Tags)
+
+	// without a CVSS score the severity is unknown, and a different CVE has
+	// its own conversation
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-5678"}}`)))
+	require.Len(t, client.conversations, 3)
+	require.Equal(t, "[unknown] CVE-2024-5678 on 2 host(s)", client.conversations[2].Subject)
+	require.Equal(t, []string{"fleet-cve:CVE-2024-5678"}, client.conversations[2].Tags)
+
+	// the custom failing policy subject has access to the team name even if
+	// it does not prefix the built-in summary
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "team_id": 7, "hosts": [{"id": 1, "hostname": "h1"}]}}`)))
+	require.Len(t, client.conversations, 4)
+	require.Equal(t, "[Servers] p1 on 1 host(s)", client.conversations[3].Subject)
+	require.Equal(t, []string{"fleet-policy:1:team:7"}, client.conversations[3].Tags)
+	require.NoError(t, job.Run(ctx, json.RawMessage(`{"failing_policy":{"policy_id": 2, "policy_name": "p2", "hosts": [{"id": 1, "hostname": "h1"}, {"id": 2, "hostname": "h2"}]}}`)))
+	require.Len(t, client.conversations, 5)
+	require.Equal(t, "[Global] p2 on 2 host(s)", client.conversations[4].Subject)
+}
+
+func TestFreeScoutRunEmptyRender(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	payload := json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+
+	// a description rendering as whitespace only fails the job
+	intg.Templates = &fleet.FreeScoutTemplates{FailingPolicyDescription: "{{ if .PolicyCritical }}critical{{ end }} \n\t"}
+	err := job.Run(ctx, payload)
+	require.ErrorIs(t, err, errFreeScoutEmptyRender)
+	require.ErrorContains(t, err, "the description is empty")
+	require.Empty(t, client.conversations)
+
+	// likewise for an empty summary
+	intg.Templates = &fleet.FreeScoutTemplates{FailingPolicySummary: "{{ if .PolicyCritical }}{{ .PolicyName }}{{ end }}"}
+	err = job.Run(ctx, payload)
+	require.ErrorIs(t, err, errFreeScoutEmptyRender)
+	require.ErrorContains(t, err, "the summary is empty")
+	require.Empty(t, client.conversations)
+
+	// the conversation is created if the integration allows it
+	intg.AllowEmptyConversations = true
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, client.conversations[0].Subject)
+
+	// the built-in templates are not affected
+	intg.AllowEmptyConversations = false
+	intg.Templates = nil
+	require.NoError(t, job.Run(ctx, payload))
+	require.Len(t, client.conversations, 2)
+	require.Equal(t, "p1 policy failed on 1 host(s)", client.conversations[1].Subject)
+}
+
+type scopeCheckingFreeScoutClient struct {
+	*mockFreeScoutClient
+	err    error
+	checks int
+}
+
+func (c *scopeCheckingFreeScoutClient) CheckScopes(ctx context.Context) error {
+	c.checks++
+	return c.err
+}
+
+func TestFreeScoutRunVerifyTokenScopes(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	client := &scopeCheckingFreeScoutClient{mockFreeScoutClient: &mockFreeScoutClient{}}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		client.opts = *opts
+		return client, nil
+	}
+	ctx := context.Background()
+	payload := json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+
+	// not verified unless enabled
+	require.NoError(t, job.Run(ctx, payload))
+	require.Zero(t, client.checks)
+	require.Len(t, client.conversations, 1)
+
+	// insufficient scopes fail the job without creating a conversation, and
+	// the check is made again on the next job
+	intg.VerifyTokenScopes = true
+	client.conversations = nil
+	client.err = &externalsvc.FreeScoutMissingScopesError{MailboxID: 1, Scopes: []string{externalsvc.FreeScoutScopeCreateConversations}}
+	for i := 1; i <= 2; i++ {
+		err := job.Run(ctx, payload)
+		require.ErrorContains(t, err, "missing scopes for mailbox 1: create conversations")
+		require.Equal(t, i, client.checks)
+		require.Empty(t, client.conversations)
+	}
+
+	// sufficient scopes are only verified on the first use of the client
+	client.err = nil
+	for i := 0; i < 2; i++ {
+		require.NoError(t, job.Run(ctx, payload))
+	}
+	require.Equal(t, 3, client.checks)
+	require.Len(t, client.conversations, 2)
+}
+
+type mailboxTypeCheckingFreeScoutClient struct {
+	*mockFreeScoutClient
+	err    error
+	checks int
+}
+
+func (c *mailboxTypeCheckingFreeScoutClient) CheckMailboxType(ctx context.Context) error {
+	c.checks++
+	return c.err
+}
+
+func TestFreeScoutRunMailboxTypeCheck(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	client := &mailboxTypeCheckingFreeScoutClient{mockFreeScoutClient: &mockFreeScoutClient{}}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		client.opts = *opts
+		return client, nil
+	}
+	ctx := context.Background()
+	payload := json.RawMessage(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`)
+	typeErr := &externalsvc.FreeScoutMailboxTypeError{MailboxID: 1, MailboxType: "chat", ConversationType: "email"}
+
+	// not checked unless enabled
+	client.err = typeErr
+	require.NoError(t, job.Run(ctx, payload))
+	require.Zero(t, client.checks)
+	require.Len(t, client.conversations, 1)
+
+	// an incompatible mailbox fails the job without creating a conversation,
+	// and the check is made again on the next job
+	intg.MailboxTypeCheck = externalsvc.FreeScoutMailboxTypeCheckFail
+	client.conversations = nil
+	for i := 1; i <= 2; i++ {
+		err := job.Run(ctx, payload)
+		require.ErrorContains(t, err, `freescout mailbox 1 of type "chat" does not support conversations of type "email"`)
+		require.Equal(t, i, client.checks)
+		require.Empty(t, client.conversations)
+	}
+
+	// with a warning, the conversations are created anyway and the check is
+	// only made on the first use of the client
+	intg.MailboxTypeCheck = externalsvc.FreeScoutMailboxTypeCheckWarn
+	for i := 0; i < 2; i++ {
+		require.NoError(t, job.Run(ctx, payload))
+	}
+	require.Equal(t, 3, client.checks)
+	require.Len(t, client.conversations, 2)
+}
+
+func TestFreeScoutRunOrgName(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+		EnableSoftwareVulnerabilities: true, PriorityTags: map[string]string{fleet.FreeScoutPriorityHigh: "urgent"},
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{
+			OrgInfo:      fleet.OrgInfo{OrgName: "Acme Corp"},
+			Integrations: fleet.Integrations{Freescout: []*fleet.FreeScoutIntegration{intg}},
+		}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, Hostname: "h1", DisplayName: "h1"}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+
+	run := func(payload string) mockFreeScoutConversation {
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(payload))
+		require.NoError(t, err)
+		require.Len(t, client.conversations, 1)
+		return client.conversations[0]
+	}
+	const policyPayload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	// not annotated by default
+	conv := run(policyPayload)
+	require.NotContains(t, conv.Subject, "Acme Corp")
+	require.Empty(t, conv.Tags)
+	require.Empty(t, conv.CustomFields)
+
+	intg.OrgNameLocation = fleet.FreeScoutOrgNameInSubject
+	conv = run(policyPayload)
+	require.True(t, strings.HasPrefix(conv.Subject, "[Acme Corp] "), conv.Subject)
+	require.Empty(t, conv.Tags)
+	require.Empty(t, conv.CustomFields)
+
+	intg.OrgNameLocation = fleet.FreeScoutOrgNameInTag
+	conv = run(policyPayload)
+	require.NotContains(t, conv.Subject, "Acme Corp")
+	require.Equal(t, []string{"Acme Corp"}, conv.Tags)
+	require.Empty(t, conv.CustomFields)
+
+	// the org name tag is set along with the priority tag
+	conv = run(`{"vulnerability":{"cve":"CVE-2024-1234", "cisa_known_exploit": true}}`)
+	require.Equal(t, []string{"urgent", "Acme Corp"}, conv.Tags)
+
+	intg.OrgNameLocation = fleet.FreeScoutOrgNameInCustomField
+	intg.OrgNameCustomFieldID = 7
+	conv = run(policyPayload)
+	require.NotContains(t, conv.Subject, "Acme Corp")
+	require.Empty(t, conv.Tags)
+	require.Equal(t, map[int64]string{7: "Acme Corp"}, conv.CustomFields)
+}
+
+func TestFreeScoutRunCustomFields(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+		EnableSoftwareVulnerabilities: true,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{
+			{ID: 1, Hostname: "h1", DisplayName: "h1"},
+			{ID: 2, Hostname: "h2", DisplayName: "h2"},
+		}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const vulnPayload = `{"vulnerability":{"cve":"CVE-2024-1234","cvss_score":9.8}}`
+	const policyPayload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 1, "hostname": "h1"}]}}`
+
+	// not set by default
+	require.NoError(t, job.Run(ctx, json.RawMessage(vulnPayload)))
+	require.Len(t, client.conversations, 1)
+	require.Empty(t, client.conversations[0].CustomFields)
+
+	// static and templated values, the empty ones are not set
+	intg.CustomFields = map[int64]string{
+		1: "fleet",
+		2: "{{ .CVE }}",
+		3: "{{ .HostsCount }}",
+		4: "{{ with .CVSSScore }}{{ . }}{{ end }}",
+		5: "{{ if false }}never{{ end }}",
+	}
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(vulnPayload)))
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, map[int64]string{1: "fleet", 2: "CVE-2024-1234", 3: "2", 4: "9.8"}, client.conversations[0].CustomFields)
+
+	// the failing policy conversations are rendered with their own args
+	intg.CustomFields = map[int64]string{1: "{{ .PolicyName }}", 2: "{{ len .Hosts }}"}
+	client.conversations = nil
+	require.NoError(t, job.Run(ctx, json.RawMessage(policyPayload)))
+	require.Len(t, client.conversations, 1)
+	require.Equal(t, map[int64]string{1: "p1", 2: "1"}, client.conversations[0].CustomFields)
+
+	// invalid templates fail the job without creating the conversation
+	for _, text := range []string{"{{ .CVE", "{{ unknownFunc }}", "{{ .NoSuchField }}"} {
+		intg.CustomFields = map[int64]string{9: text}
+		client.conversations = nil
+		err := job.Run(ctx, json.RawMessage(vulnPayload))
+		require.ErrorContains(t, err, "custom field 9", text)
+		require.Empty(t, client.conversations, text)
+	}
+}
+
+func TestFreeScoutRunLinkPaths(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+		HostPathTemplate:        "/fleet/hosts/{{ .ID }}",
+		PolicyHostsPathTemplate: "/fleet/policies/{{ .PolicyID }}/hosts",
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+
+	client := &mockFreeScoutClient{}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.FleetURL = "https://fleet.example.com"
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		return client, nil
+	}
+	ctx := context.Background()
+	const policyPayload = `{"failing_policy":{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 2, "hostname": "h2"}]}}`
+	const batchPayload = `{"failing_policies":{"policies":[{"policy_id": 1, "policy_name": "p1", "hosts": [{"id": 2, "hostname": "h2"}]}]}}`
+
+	for _, payload := range []string{policyPayload, batchPayload} {
+		client.conversations = nil
+		require.NoError(t, job.Run(ctx, json.RawMessage(payload)))
+		require.Len(t, client.conversations, 1)
+		require.Contains(t, client.conversations[0].Message, "(https://fleet.example.com/fleet/hosts/2)", payload)
+		require.Contains(t, client.conversations[0].Message, "(https://fleet.example.com/fleet/policies/1/hosts)", payload)
+	}
+
+	// an invalid template fails the job without creating the conversation
+	intg.HostPathTemplate = "{{ unknownFunc .ID }}"
+	client.conversations = nil
+	err := job.Run(ctx, json.RawMessage(policyPayload))
+	require.ErrorContains(t, err, "parse host path template")
+	require.Empty(t, client.conversations)
+}
+
+func TestFreeScoutRunCustomerEmail(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true,
+		CustomerEmail: "fleet@example.com",
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	teamEmails := map[uint]string{1: "team-one@example.com"}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Name: fmt.Sprintf("team%d", tid), Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true, CustomerEmail: teamEmails[tid]},
+				},
+			},
+		}}, nil
+	}
+
+	var emails []string
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		emails = append(emails, opts.CustomerEmail)
+		return &mockFreeScoutClient{opts: *opts}, nil
+	}
+	ctx := context.Background()
+
+	run := func(teamID uint) error {
+		team := "null"
+		if teamID > 0 {
+			team = fmt.Sprint(teamID)
+		}
+		return job.Run(ctx, json.RawMessage(fmt.Sprintf(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "team_id": %s, "hosts": [{"id": 1, "hostname": "h1"}]}}`, team)))
+	}
+
+	// static global email, for global policies and teams without their own
+	require.NoError(t, run(0))
+	require.NoError(t, run(2))
+	require.Equal(t, []string{"fleet@example.com", "fleet@example.com"}, emails)
+
+	// per-team email
+	emails = nil
+	require.NoError(t, run(1))
+	require.Equal(t, []string{"team-one@example.com"}, emails)
+
+	// templated email for the teams without their own, the cached client of
+	// team 2 is rebuilt as its resolved email changed while the one of team 1
+	// is reused
+	emails = nil
+	intg.TeamCustomerEmailTemplate = "{{ .TeamName }}@support.example.com"
+	require.NoError(t, run(2))
+	require.NoError(t, run(1))
+	require.NoError(t, run(0))
+	require.Equal(t, []string{"team2@support.example.com"}, emails)
+
+	// an invalid rendered email fails the job
+	intg.TeamCustomerEmailTemplate = "{{ .TeamName }}"
+	err := run(2)
+	require.ErrorContains(t, err, `invalid customer email "team2"`)
+}
+
+func TestFreeScoutRunCustomerPerType(t *testing.T) {
+	intg := &fleet.FreeScoutIntegration{
+		URL: "https://freescout.example.com", MailboxID: 1, EnableFailingPolicies: true, EnableSoftwareVulnerabilities: true,
+		CustomerEmail: "fleet@example.com", AssignTo: 3,
+	}
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{intg},
+		}}, nil
+	}
+	ds.GetCVEMetaFunc = func(ctx context.Context, cve string) (*fleet.CVEMeta, error) {
+		return &fleet.CVEMeta{CVE: cve}, nil
+	}
+	ds.HostsByCVEFunc = func(ctx context.Context, cve string) ([]fleet.HostVulnerabilitySummary, error) {
+		return []fleet.HostVulnerabilitySummary{{ID: 1, Hostname: "h1", DisplayName: "h1"}}, nil
+	}
+	teamEmails := map[uint]string{1: "team-one@example.com"}
+	ds.TeamLiteFunc = func(ctx context.Context, tid uint) (*fleet.TeamLite, error) {
+		return &fleet.TeamLite{ID: tid, Name: fmt.Sprintf("team%d", tid), Config: fleet.TeamConfigLite{
+			Integrations: fleet.TeamIntegrations{
+				Freescout: []*fleet.TeamFreeScoutIntegration{
+					{URL: intg.URL, MailboxID: intg.MailboxID, EnableFailingPolicies: true, CustomerEmail: teamEmails[tid]},
+				},
+			},
+		}}, nil
+	}
+
+	type customer struct {
+		email    string
+		assignTo int64
+	}
+	var customers []customer
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+	job.NewClientFunc = func(opts *externalsvc.FreeScoutOptions) (FreeScoutClient, error) {
+		customers = append(customers, customer{opts.CustomerEmail, opts.AssignTo})
+		return &mockFreeScoutClient{opts: *opts}, nil
+	}
+	ctx := context.Background()
+
+	runVuln := func() {
+		err := job.Run(ctx, json.RawMessage(`{"vulnerability":{"cve":"CVE-2024-1234"}}`))
+		require.NoError(t, err)
+	}
+	runPolicy := func(teamID uint) {
+		team := "null"
+		if teamID > 0 {
+			team = fmt.Sprint(teamID)
+		}
+		err := job.Run(ctx, json.RawMessage(fmt.Sprintf(`{"failing_policy":{"policy_id": 1, "policy_name": "p1", "team_id": %s, "hosts": [{"id": 1, "hostname": "h1"}]}}`, team)))
+		require.NoError(t, err)
+	}
+
+	// both types share the customer by default
+	runVuln()
+	runPolicy(0)
+	require.Equal(t, []customer{{"fleet@example.com", 3}, {"fleet@example.com", 3}}, customers)
+
+	// distinct customers per type, the cached clients are rebuilt
+	customers = nil
+	intg.VulnCustomerEmail = "security@example.com"
+	intg.VulnAssignTo = 5
+	intg.FailingPoliciesCustomerEmail = "it-ops@example.com"
+	intg.FailingPoliciesAssignTo = 7
+	runVuln()
+	runPolicy(0)
+	runPolicy(2)
+	require.Equal(t, []customer{
+		{"security@example.com", 5},
+		{"it-ops@example.com", 7},
+		{"it-ops@example.com", 7},
+	}, customers)
+
+	// the team's own email takes precedence, but not over its assignee
+	customers = nil
+	runPolicy(1)
+	require.Equal(t, []customer{{"team-one@example.com", 7}}, customers)
+
+	// the global configuration is left untouched
+	require.Equal(t, "fleet@example.com", intg.CustomerEmail)
+	require.EqualValues(t, 3, intg.AssignTo)
+}
+
+func TestTruncateFreeScoutSubject(t *testing.T) {
+	require.Equal(t, "short", truncateFreeScoutSubject("short", 5))
+	require.Equal(t, "shor…", truncateFreeScoutSubject("shorter", 5))
+	// trailing spaces are not kept before the ellipsis
+	require.Equal(t, "ab…", truncateFreeScoutSubject("ab  cdef", 4))
+	// characters are counted, not bytes
+	require.Equal(t, "héé…", truncateFreeScoutSubject("héééé", 4))
+}
+
+func TestFreeScoutRunSubjectTruncation(t *testing.T) {
+	srv := newFreeScoutTestServer(t)
+	ds := new(mock.Store)
+	mockFreeScoutReports(ds)
+	ds.AppConfigFunc = func(ctx context.Context) (*fleet.AppConfig, error) {
+		return &fleet.AppConfig{Integrations: fleet.Integrations{
+			Freescout: []*fleet.FreeScoutIntegration{
+				{URL: srv.URL, APIToken: "token", MailboxID: 1, CustomerEmail: "fleet@example.com", EnableFailingPolicies: true, MaxSubjectLength: 40},
+			},
+		}}, nil
+	}
+	job := newFreeScoutTestJob(ds, kitlog.NewNopLogger())
+
+	policyName := strings.Repeat("very long policy name ", 10)
+	err := job.Run(context.Background(), json.RawMessage(fmt.Sprintf(`{"failing_policy":{"policy_id": 1, "policy_name": %q, "hosts": [{"id": 1, "hostname": "h1"}]}}`, policyName)))
+	require.NoError(t, err)
+
+	var searched []string
+	for _, r := range srv.requests {
+		if r.Method == http.MethodGet && r.Path == "/api/conversations" {
+			q, err := url.ParseQuery(r.Query)
+			require.NoError(t, err)
+			searched = append(searched, q.Get("subject"))
+		}
+	}
+	created := srv.created()
+	require.Len(t, searched, 1)
+	require.Len(t, created, 1)
+	var payload struct {
+		Subject string `json:"subject"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(created[0].Body), &payload))
+
+	// the same truncated subject is searched for and created
+	require.Equal(t, "very long policy name very long policy…", payload.Subject)
+	require.Equal(t, payload.Subject, searched[0])
+	require.LessOrEqual(t, utf8.RuneCountInString(payload.Subject), 40)
+}
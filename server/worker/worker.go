@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/contexts/jobid"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -46,6 +47,12 @@ type failingPolicyArgs struct {
 	PolicyCritical bool                  `json:"policy_critical"`
 	Hosts          []fleet.PolicySetHost `json:"hosts"`
 	TeamID         *uint                 `json:"team_id,omitempty"`
+	// PolicyPlatform is the comma-separated list of the platforms targeted by
+	// the policy, empty if it targets all platforms.
+	PolicyPlatform string `json:"policy_platform,omitempty"`
+	// HostIDs are the IDs of the failing hosts, set instead of Hosts by the
+	// integrations that fetch the hosts when the job is processed.
+	HostIDs []uint `json:"host_ids,omitempty"`
 }
 
 // vulnArgs are the args common to all integrations that can process
@@ -55,6 +62,7 @@ type vulnArgs struct {
 	AffectedSoftwareIDs []uint     `json:"affected_software,omitempty"`
 	EPSSProbability     *float64   `json:"epss_probability,omitempty"`
 	CVSSScore           *float64   `json:"cvss_score,omitempty"`
+	CVSSVersion         *string    `json:"cvss_version,omitempty"`
 	CISAKnownExploit    *bool      `json:"cisa_known_exploit,omitempty"`
 	CVEPublished        *time.Time `json:"cve_published,omitempty"`
 }
@@ -230,6 +238,7 @@ func (w *Worker) processJob(ctx context.Context, job *fleet.Job) error {
 		args = *job.Args
 	}
 
+	ctx = jobid.NewContext(ctx, job.ID)
 	err := j.Run(ctx, args)
 	if err != nil {
 		span.RecordError(err)
@@ -242,6 +251,7 @@ type failingPoliciesTplArgs struct {
 	PolicyID       uint
 	PolicyName     string
 	PolicyCritical bool
+	PolicyPlatform string
 	TeamID         *uint
 	Hosts          []fleet.PolicySetHost
 }
@@ -252,6 +262,7 @@ func newFailingPoliciesTplArgs(fleetURL string, args *failingPolicyArgs) *failin
 		PolicyName:     args.PolicyName,
 		PolicyID:       args.PolicyID,
 		PolicyCritical: args.PolicyCritical,
+		PolicyPlatform: args.PolicyPlatform,
 		TeamID:         args.TeamID,
 		Hosts:          args.Hosts,
 	}
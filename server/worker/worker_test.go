@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fleetdm/fleet/v4/server/contexts/jobid"
 	"github.com/fleetdm/fleet/v4/server/datastore/mysql"
 	"github.com/fleetdm/fleet/v4/server/fleet"
 	"github.com/fleetdm/fleet/v4/server/mock"
@@ -67,6 +68,9 @@ func TestWorker(t *testing.T) {
 			jobCalled = true
 
 			assert.Equal(t, json.RawMessage(`{"arg1":"foo"}`), argsJSON)
+			id, ok := jobid.FromContext(ctx)
+			assert.True(t, ok)
+			assert.EqualValues(t, 1, id)
 			return nil
 		},
 	}